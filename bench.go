@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// benchCommand groups the "w2vgrep --bench ..." subcommands. The benchmarks
+// themselves are Go benchmarks of the packages, run by go test -bench.
+type benchCommand struct {
+	Compare benchCompareCommand `command:"compare" description:"Compare the go test -bench results of two source trees, or two saved outputs"`
+}
+
+// benchCompareCommand implements "w2vgrep --bench compare".
+type benchCompareCommand struct {
+	Bench         string  `long:"bench" default:"." description:"Run only the benchmarks matching this regular expression, as go test -bench"`
+	Count         int     `long:"count" default:"1" description:"Run each benchmark this many times"`
+	Packages      string  `long:"packages" default:"./modules/..." description:"Packages whose benchmarks are run"`
+	MaxRegression float64 `long:"max-regression" description:"Fail when a benchmark is slower by more than this percentage (0 only reports)"`
+	Args          struct {
+		Old string `positional-arg-name:"OLD" description:"Source tree of the baseline, or a file with the output of go test -bench"`
+		New string `positional-arg-name:"NEW" description:"Source tree to compare, or a file with the output of go test -bench"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// benchStats are the mean results of the runs of a benchmark.
+type benchStats struct {
+	nsPerOp, bytesPerOp, allocsPerOp float64
+	runs                             int
+}
+
+// Execute gets the results of both and prints a comparison table.
+func (c *benchCompareCommand) Execute(args []string) error {
+	if c.MaxRegression < 0 {
+		return fmt.Errorf("--max-regression must not be negative")
+	}
+	if c.Count < 1 {
+		return fmt.Errorf("--count must be at least 1")
+	}
+	if _, err := regexp.Compile(c.Bench); err != nil {
+		return fmt.Errorf("invalid --bench: %v", err)
+	}
+
+	oldStats, err := c.results(c.Args.Old)
+	if err != nil {
+		return err
+	}
+	newStats, err := c.results(c.Args.New)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for name := range oldStats {
+		if _, ok := newStats[name]; ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return fmt.Errorf("no benchmarks in common")
+	}
+
+	regressions := 0
+	fmt.Printf("%-44s %14s %14s %9s %12s %12s\n", "name", "old ns/op", "new ns/op", "delta", "old allocs", "new allocs")
+	for _, name := range names {
+		o, n := oldStats[name], newStats[name]
+		delta := (n.nsPerOp - o.nsPerOp) / o.nsPerOp * 100
+		marker := ""
+		if c.MaxRegression > 0 && delta > c.MaxRegression {
+			marker = "  REGRESSION"
+			regressions++
+		}
+		fmt.Printf("%-44s %14.1f %14.1f %+8.1f%% %12.0f %12.0f%s\n", name, o.nsPerOp, n.nsPerOp, delta, o.allocsPerOp, n.allocsPerOp, marker)
+	}
+
+	if regressions > 0 {
+		return fmt.Errorf("%d benchmark(s) slower by more than %.1f%%", regressions, c.MaxRegression)
+	}
+	return nil
+}
+
+// results returns the benchmark results of the source tree at path, running
+// go test -bench in it, or read from a file of saved results.
+func (c *benchCompareCommand) results(path string) (map[string]benchStats, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		output, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return parseBenchResults(output)
+	}
+
+	cmd := exec.Command("go", "test", "-run", "^$", "-bench", c.Bench, "-benchmem", "-count", strconv.Itoa(c.Count), c.Packages)
+	cmd.Dir = path
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running the benchmarks of %s: %v", path, err)
+	}
+	return parseBenchResults(output)
+}
+
+// benchLine matches a result line such as
+// "BenchmarkSearch-8  1000  1234 ns/op  12.3 MB/s  456 B/op  7 allocs/op".
+var benchLine = regexp.MustCompile(`^Benchmark(\S+?)(-\d+)?\s+\d+\s+(.*)$`)
+
+// parseBenchResults averages the results of each benchmark in the output of
+// go test -bench.
+func parseBenchResults(output []byte) (map[string]benchStats, error) {
+	stats := map[string]benchStats{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		match := benchLine.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+
+		s := stats[match[1]]
+		fields := strings.Fields(match[3])
+		for i := 0; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid benchmark result %q", scanner.Text())
+			}
+			switch fields[i+1] {
+			case "ns/op":
+				s.nsPerOp += value
+			case "B/op":
+				s.bytesPerOp += value
+			case "allocs/op":
+				s.allocsPerOp += value
+			}
+		}
+		s.runs++
+		stats[match[1]] = s
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for name, s := range stats {
+		n := float64(s.runs)
+		stats[name] = benchStats{s.nsPerOp / n, s.bytesPerOp / n, s.allocsPerOp / n, s.runs}
+	}
+	return stats, nil
+}
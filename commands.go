@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// command is a subcommand of w2vgrep. It is run by giving its name with a
+// leading "--" as the first argument, e.g. w2vgrep --serve, so that no query
+// word is ever taken for a subcommand.
+type command struct {
+	name        string
+	description string
+	data        func() interface{}
+}
+
+// commands are the subcommands of w2vgrep.
+var commands = []command{
+	{"model", "Inspect and manage word embedding models", func() interface{} { return &modelCommand{} }},
+	{"graph", "Export the semantic neighborhood of a query as a DOT or GraphML graph", func() interface{} { return &graphCommand{} }},
+	{"lexicon", "Build a word list from seed words by accepting or rejecting their neighbors", func() interface{} { return &lexiconCommand{} }},
+	{"similar", "Rank files by their similarity to an example document", func() interface{} { return &similarCommand{} }},
+	{"bench", "Compare the results of the Go benchmarks of two versions of w2vgrep", func() interface{} { return &benchCommand{} }},
+	{"selftest", "Check tokenization and matching on this platform by searching a built-in multilingual corpus", func() interface{} { return &selftestCommand{} }},
+	{"serve", "Load the model once and answer searches, neighbor and embedding requests over HTTP", func() interface{} { return &serveCommand{} }},
+	{"daemon", "Hold the model in memory for the searches of this user, which then start at once", func() interface{} { return &daemonCommand{} }},
+}
+
+// daemonProgram is the name under which w2vgrep runs as the daemon, as
+// w2vgrep --daemon, e.g. through a symbolic link.
+const daemonProgram = "w2vgrepd"
+
+// modelCommand groups the "w2vgrep --model ..." subcommands.
+type modelCommand struct {
+	Histogram histogramCommand     `command:"histogram" description:"Plot the distribution of similarities between a query and the whole vocabulary"`
+	Projector projectorCommand     `command:"projector" description:"Export words and their vectors as TSV files for the TensorFlow Embedding Projector"`
+	Download  modelDownloadCommand `command:"download" description:"Download a known model into the model cache, converting it to the binary format"`
+	List      modelListCommand     `command:"list" description:"List the known models and the downloaded ones"`
+	Remove    modelRemoveCommand   `command:"remove" description:"Remove downloaded models from the model cache"`
+	Subset    modelSubsetCommand   `command:"subset" description:"Extract the words of a pattern file and their neighborhoods into a small model"`
+	Convert   modelConvertCommand  `command:"convert" description:"Rewrite a model in the 32-bit or the half precision (.f16.bin) format"`
+	Check     modelCheckCommand    `command:"check" description:"Check a model file for truncation and encoding problems, and show sample words"`
+}
+
+// findCommand returns the subcommand that the first argument arg names, as
+// --NAME.
+func findCommand(arg string) (command, bool) {
+	name, ok := strings.CutPrefix(arg, "--")
+	if !ok {
+		return command{}, false
+	}
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// programCommand returns the subcommand that the program runs by its name
+// alone, the daemon when it is w2vgrepd.
+func programCommand(program string) (command, bool) {
+	name := strings.TrimSuffix(filepath.Base(program), ".exe")
+	if name != daemonProgram {
+		return command{}, false
+	}
+	return findCommand("--daemon")
+}
+
+// runCommand runs the subcommand c, invoked as program, with the arguments
+// that follow its name, and returns the exit status.
+func runCommand(program string, c command, args []string) int {
+	data := c.data()
+	parser := flags.NewNamedParser(program, flags.Default)
+	parser.LongDescription = c.description
+	if _, err := parser.AddGroup("Options", "", data); err != nil {
+		errorf("%v", err)
+		return exitError
+	}
+
+	rest, err := parser.ParseArgs(args)
+	if err != nil {
+		// The parser has already printed the error or help message
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			return exitMatch
+		}
+		return exitError
+	}
+	// The parser runs the chosen subcommand of a group such as --model;
+	// a subcommand without its own runs here
+	if commander, ok := data.(flags.Commander); ok && parser.Active == nil {
+		if err := commander.Execute(rest); err != nil {
+			logf(logQuiet, "", "%v", err)
+			return exitError
+		}
+	}
+	return exitMatch
+}
+
+// histogramCommand implements "w2vgrep --model histogram". It shows how the
+// similarities of all vocabulary words to the query are distributed, so the
+// threshold can be chosen with the model's actual score range in mind.
+type histogramCommand struct {
+	ModelPath  string  `short:"m" long:"model_path" description:"Path to the Word2Vec model file"`
+	Query      string  `long:"query" required:"true" description:"Word to compare with the vocabulary"`
+	Threshold  float64 `short:"t" long:"threshold" default:"0.7" description:"Similarity threshold to mark in the plot"`
+	Bins       int     `long:"bins" default:"20" description:"Number of bins between -1 and 1"`
+	Width      int     `long:"width" default:"50" description:"Width of the longest bar"`
+	Log        bool    `long:"log" description:"Scale bars logarithmically so that the sparse tails stay visible"`
+	IgnoreCase bool    `short:"i" long:"ignore-case" description:"Look up the query in lowercase"`
+}
+
+// Execute prints the histogram.
+func (c *histogramCommand) Execute(args []string) error {
+	if c.Bins < 1 || c.Width < 1 {
+		return fmt.Errorf("--bins and --width must be positive")
+	}
+
+	w2vModel, err := loadModel(c.ModelPath)
+	if err != nil {
+		return err
+	}
+
+	query := c.Query
+	if c.IgnoreCase {
+		query = strings.ToLower(query)
+	}
+	queryVector, err := w2vModel.GetEmbedding(query)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, query)
+	}
+
+	counts := make([]int, c.Bins)
+	total, above := 0, 0
+	for _, word := range w2vModel.Words() {
+		if word == query {
+			continue
+		}
+		vector, _ := w2vModel.GetEmbedding(word)
+		score := similarity.CalculateSimilarity(queryVector, vector)
+		if math.IsNaN(score) {
+			continue
+		}
+
+		bin := int((score + 1) / 2 * float64(c.Bins))
+		bin = max(0, min(bin, c.Bins-1))
+		counts[bin]++
+		total++
+		if score > c.Threshold {
+			above++
+		}
+	}
+
+	maxCount := 0
+	for _, count := range counts {
+		maxCount = max(maxCount, count)
+	}
+
+	fmt.Printf("Similarity of '%s' to %d words in the vocabulary:\n", query, total)
+	for i := c.Bins - 1; i >= 0; i-- {
+		low := -1 + 2*float64(i)/float64(c.Bins)
+		high := -1 + 2*float64(i+1)/float64(c.Bins)
+
+		bar := 0
+		if counts[i] > 0 {
+			if c.Log {
+				bar = int(math.Log1p(float64(counts[i])) / math.Log1p(float64(maxCount)) * float64(c.Width))
+			} else {
+				bar = counts[i] * c.Width / maxCount
+			}
+			bar = max(bar, 1)
+		}
+
+		marker := ""
+		if c.Threshold >= low && c.Threshold < high {
+			marker = "  <- threshold"
+		}
+		fmt.Printf("[%5.2f, %5.2f) %8d %s%s\n", low, high, counts[i], strings.Repeat("#", bar), marker)
+	}
+	fmt.Printf("%d words (%.4f%%) score above the threshold %.2f\n", above, 100*float64(above)/float64(max(total, 1)), c.Threshold)
+
+	return nil
+}
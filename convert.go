@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+)
+
+// driftWords is the number of most frequent words whose similarities are
+// compared by "w2vgrep --model convert" after converting.
+const driftWords = 200
+
+// modelConvertCommand implements "w2vgrep --model convert". It rewrites a
+// model in the 32-bit (.bin) or half precision (.f16.bin) format, chosen by
+// the name of the output file, and reports how much the similarities of the
+// most frequent words moved.
+type modelConvertCommand struct {
+	ModelPath string `short:"m" long:"model_path" description:"Path to the Word2Vec model file"`
+	Output    string `short:"o" long:"output" required:"true" description:"Model file to write: .f16.bin for half precision, .bin for 32-bit"`
+}
+
+// Execute converts the model.
+func (c *modelConvertCommand) Execute(args []string) error {
+	write := model.WriteBinary
+	switch {
+	case strings.HasSuffix(c.Output, ".8int.bin"):
+		return fmt.Errorf("%s: 8-bit models are written by model_processing_utils/quantize", c.Output)
+	case strings.HasSuffix(c.Output, ".f16.bin"):
+		write = model.WriteFloat16
+	case !strings.HasSuffix(c.Output, ".bin"):
+		return fmt.Errorf("%s: the name of the model must end in .f16.bin or .bin", c.Output)
+	}
+
+	w2vModel, err := loadModel(c.ModelPath)
+	if err != nil {
+		return err
+	}
+	words := w2vModel.Words()
+	sort.Slice(words, func(i, j int) bool {
+		ri, _ := model.Rank(w2vModel, words[i])
+		rj, _ := model.Rank(w2vModel, words[j])
+		return ri < rj
+	})
+	if err := write(c.Output, w2vModel, words); err != nil {
+		return err
+	}
+
+	converted, err := model.LoadVectorModel(c.Output)
+	if err != nil {
+		return fmt.Errorf("loading the converted model: %v", err)
+	}
+	info, err := os.Stat(c.Output)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d words to %s (%.1f MB)\n", len(words), c.Output, float64(info.Size())/1e6)
+
+	mean, largest, pairs := similarityDrift(w2vModel, converted, model.FrequentWords(w2vModel, driftWords))
+	if pairs > 0 {
+		fmt.Printf("Similarity drift over %d pairs of frequent words: mean %.6f, largest %.6f\n", pairs, mean, largest)
+	}
+	return nil
+}
+
+// similarityDrift returns the mean and largest difference between the
+// cosine similarities of the pairs of words in two models, and the number
+// of pairs.
+func similarityDrift(before, after model.VectorModel, words []string) (mean, largest float64, pairs int) {
+	scorer := similarity.Cosine{}
+	sum := 0.0
+	for i, a := range words {
+		va, err := before.GetEmbedding(a)
+		if err != nil {
+			continue
+		}
+		wa, _ := after.GetEmbedding(a)
+		for _, b := range words[i+1:] {
+			vb, err := before.GetEmbedding(b)
+			if err != nil {
+				continue
+			}
+			wb, _ := after.GetEmbedding(b)
+			drift := math.Abs(scorer.Score(va, vb) - scorer.Score(wa, wb))
+			sum += drift
+			largest = math.Max(largest, drift)
+			pairs++
+		}
+	}
+	if pairs > 0 {
+		mean = sum / float64(pairs)
+	}
+	return mean, largest, pairs
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/arunsupe/semantic-grep/modules/config"
+	"github.com/arunsupe/semantic-grep/modules/daemon"
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/utils"
+)
+
+const (
+	// daemonDialTimeout bounds the wait of a search for a daemon that does
+	// not answer, after which it loads the model itself
+	daemonDialTimeout = time.Second
+	// daemonStartTimeout bounds the wait of --detach for the daemon to have
+	// loaded its model
+	daemonStartTimeout = 10 * time.Minute
+	// sharedIdleTimeout is the idle timeout of the daemons started by
+	// --share-model, which stop once the searches sharing them are done
+	sharedIdleTimeout = time.Minute
+)
+
+// daemonCommand implements "w2vgrep --daemon". It loads a model and holds it
+// in memory, serving it on a Unix socket to the searches of the same user,
+// which then start without loading the model.
+type daemonCommand struct {
+	ModelPath   string        `short:"m" long:"model_path" description:"Path to the Word2Vec model file"`
+	Socket      string        `long:"socket" description:"Unix socket to listen on (default: daemon.sock in the cache directory)"`
+	Detach      bool          `long:"detach" description:"Run in the background, returning once the model is loaded; its messages go to daemon.log in the cache directory"`
+	IdleTimeout time.Duration `long:"idle-timeout" description:"Stop once no search has used the daemon for this long, e.g. 10m (default: never)"`
+	Stop        bool          `long:"stop" description:"Stop the running daemon"`
+}
+
+// Execute serves the model until interrupted or stopped.
+func (c *daemonCommand) Execute(args []string) error {
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	socket := c.Socket
+	if socket == "" {
+		if socket, err = daemonSocket(conf); err != nil {
+			return err
+		}
+	}
+
+	if c.Stop {
+		info, err := daemon.Stop(socket, daemonDialTimeout)
+		if err != nil {
+			return fmt.Errorf("no daemon answers on %s: %v", socket, err)
+		}
+		fmt.Printf("Stopped the daemon of %s\n", info.Path)
+		return nil
+	}
+	if m, info, err := daemon.Dial(socket, daemonDialTimeout, nil); err == nil {
+		m.Close()
+		return fmt.Errorf("a daemon of %s already listens on %s", info.Path, socket)
+	}
+	if c.Detach {
+		info, err := c.detach(socket)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Daemon of %s (%d words) started on %s\n", info.Path, info.Words, socket)
+		return nil
+	}
+
+	modelPath := configuredModelPath(c.ModelPath, conf)
+	if modelPath == "" {
+		return errNoModelPath
+	}
+	info, err := daemonInfo(modelPath)
+	if err != nil {
+		return err
+	}
+	w2vModel, err := model.LoadVectorModel(modelPath)
+	if err != nil {
+		return fmt.Errorf("loading full model: %v", err)
+	}
+	info.Words = len(w2vModel.Words())
+
+	// A socket left by a daemon that was killed is replaced
+	if err := os.MkdirAll(filepath.Dir(socket), 0o700); err != nil {
+		return err
+	}
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(socket, 0o600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving %s (%d words) on %s\n", modelPath, info.Words, socket)
+	server := daemon.NewServer(w2vModel, info)
+	server.IdleTimeout = c.IdleTimeout
+	err = server.Serve(listener)
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// detach starts the daemon in a new process, logging to daemon.log next to
+// socket, and waits until it serves its model, which it describes.
+func (c *daemonCommand) detach(socket string) (daemon.Info, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return daemon.Info{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(socket), 0o700); err != nil {
+		return daemon.Info{}, err
+	}
+	logPath := filepath.Join(filepath.Dir(socket), "daemon.log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return daemon.Info{}, err
+	}
+	defer logFile.Close()
+
+	args := []string{"--daemon", "--socket", socket}
+	if c.ModelPath != "" {
+		args = append(args, "--model_path", utils.ExpandPath(c.ModelPath))
+	}
+	if c.IdleTimeout > 0 {
+		args = append(args, "--idle-timeout", c.IdleTimeout.String())
+	}
+	cmd := exec.Command(executable, args...)
+	cmd.Stdout, cmd.Stderr = logFile, logFile
+	if err := cmd.Start(); err != nil {
+		return daemon.Info{}, err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	deadline := time.Now().Add(daemonStartTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case err := <-exited:
+			return daemon.Info{}, fmt.Errorf("the daemon exited (%v), see %s", err, logPath)
+		case <-time.After(200 * time.Millisecond):
+		}
+		if m, info, err := daemon.Dial(socket, daemonDialTimeout, nil); err == nil {
+			m.Close()
+			return info, nil
+		}
+	}
+	return daemon.Info{}, fmt.Errorf("the daemon did not start within %v, see %s", daemonStartTimeout, logPath)
+}
+
+// shareModel starts a daemon of the model file of the search, for
+// --share-model, so that the searches running at once share one copy of
+// the model, unless a daemon is running already. Of the searches starting
+// at once, the one creating daemon.starting next to the socket starts the
+// daemon, and the others wait until it has, or failed to. The daemon stops
+// once idle for sharedIdleTimeout.
+func shareModel(modelPath string, conf *config.Config) error {
+	modelPath = configuredModelPath(modelPath, conf)
+	socket, err := daemonSocket(conf)
+	if modelPath == "" || err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(socket), 0o700); err != nil {
+		return err
+	}
+
+	starting := filepath.Join(filepath.Dir(socket), "daemon.starting")
+	file, err := os.OpenFile(starting, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		// A search that died while starting the daemon left the file
+		if stat, err := os.Stat(starting); err == nil && time.Since(stat.ModTime()) > daemonStartTimeout {
+			os.Remove(starting)
+			return shareModel(modelPath, conf)
+		}
+		deadline := time.Now().Add(daemonStartTimeout)
+		for time.Now().Before(deadline) {
+			if _, err := os.Stat(starting); errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+		return fmt.Errorf("no daemon started within %v", daemonStartTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	file.Close()
+	defer os.Remove(starting)
+
+	// The search may have lost the race to one that started a daemon
+	if m, _, err := daemon.Dial(socket, daemonDialTimeout, nil); err == nil {
+		m.Close()
+		return nil
+	}
+	c := daemonCommand{ModelPath: modelPath, IdleTimeout: sharedIdleTimeout}
+	_, err = c.detach(socket)
+	return err
+}
+
+// daemonSocket returns the socket of the daemon: daemon.sock in the cache
+// directory.
+func daemonSocket(conf *config.Config) (string, error) {
+	root, err := cacheRoot(conf.CacheDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "daemon.sock"), nil
+}
+
+// daemonInfo returns the description of the model file at modelPath that a
+// daemon serves, and searches compare with the model they would load.
+func daemonInfo(modelPath string) (daemon.Info, error) {
+	file, err := os.Stat(modelPath)
+	if err != nil {
+		return daemon.Info{}, err
+	}
+	absolute, err := filepath.Abs(modelPath)
+	if err != nil {
+		return daemon.Info{}, err
+	}
+	return daemon.Info{Path: absolute, Size: file.Size(), ModTime: file.ModTime().UnixNano()}, nil
+}
+
+// daemonModel returns the model of the running daemon when it serves the
+// model file of the search, which would otherwise load it, or nil.
+func daemonModel(modelPath string, conf *config.Config) model.VectorModel {
+	modelPath = configuredModelPath(modelPath, conf)
+	socket, err := daemonSocket(conf)
+	if modelPath == "" || err != nil {
+		return nil
+	}
+	if _, err := os.Stat(socket); err != nil {
+		return nil
+	}
+	want, err := daemonInfo(modelPath)
+	if err != nil {
+		return nil
+	}
+
+	m, info, err := daemon.Dial(socket, daemonDialTimeout, func(err error) {
+		errorf("%v", err)
+		os.Exit(exitError)
+	})
+	if err != nil {
+		return nil
+	}
+	if info.Path != want.Path || info.Size != want.Size || info.ModTime != want.ModTime {
+		m.Close()
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/config"
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/utils"
+)
+
+// builtinModelSources are the models "w2vgrep --model download" knows without
+// configuration. googlenews-slim is pinned to the checksum of the model
+// shipped in models/googlenews-slim of this repository, which is the
+// download gunzipped. The GloVe archive has no published checksum, so its
+// models are only installed with --insecure, or once pinned in config.json.
+var builtinModelSources = map[string]config.ModelSource{
+	"googlenews-slim": {
+		URL:         "https://github.com/eyaler/word2vec-slim/raw/master/GoogleNews-vectors-negative300-SLIM.bin.gz",
+		Format:      "bin.gz",
+		ModelSHA256: "046e0921bcb665f50d646b0963fcef8c5abb5f830d0daba8f686e1dffd6ad832",
+	},
+	"glove-6B-50d":  gloveSource("glove.6B.50d.txt"),
+	"glove-6B-100d": gloveSource("glove.6B.100d.txt"),
+	"glove-6B-200d": gloveSource("glove.6B.200d.txt"),
+	"glove-6B-300d": gloveSource("glove.6B.300d.txt"),
+}
+
+// gloveSource returns the source of a model in the GloVe 6B archive.
+func gloveSource(file string) config.ModelSource {
+	return config.ModelSource{URL: "https://nlp.stanford.edu/data/glove.6B.zip", Format: "zip", File: file}
+}
+
+// fastTextPrefix names the fastText Common Crawl models, one per language,
+// e.g. fasttext-fr.
+const fastTextPrefix = "fasttext-"
+
+// modelSource returns the source of the named model. Sources from the
+// config file take precedence over the built-in ones.
+func modelSource(name string, conf *config.Config) (config.ModelSource, error) {
+	if name == "" || strings.ContainsAny(name, "/\\") {
+		return config.ModelSource{}, fmt.Errorf("invalid model name %q", name)
+	}
+	if source, ok := conf.ModelSources[name]; ok {
+		return source, nil
+	}
+	if source, ok := builtinModelSources[name]; ok {
+		return source, nil
+	}
+	if lang, ok := strings.CutPrefix(name, fastTextPrefix); ok && lang != "" && !strings.ContainsAny(lang, "/\\.") {
+		return config.ModelSource{
+			URL:    "https://dl.fbaipublicfiles.com/fasttext/vectors-crawl/cc." + lang + ".300.vec.gz",
+			Format: "text.gz",
+		}, nil
+	}
+	return config.ModelSource{}, fmt.Errorf("unknown model %q, see w2vgrep --model list", name)
+}
+
+// cacheOption selects the directory downloaded models are stored in.
+type cacheOption struct {
+	CacheDir string `long:"cache-dir" description:"Directory of downloaded models (default: models in the cache_dir of the config file, or semantic-grep/models in the user cache directory, e.g. ~/.cache)"`
+}
+
+// dir returns the model cache directory, models in the cache_dir of conf
+// when --cache-dir is not given.
+func (o cacheOption) dir(conf *config.Config) (string, error) {
+	if o.CacheDir != "" {
+		return utils.ExpandPath(o.CacheDir), nil
+	}
+	root, err := cacheRoot(conf.CacheDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, "models"), nil
+}
+
+// errReadOnly is returned by the commands that write the model cache when
+// the configuration sets read_only.
+var errReadOnly = errors.New("the configuration sets read_only, so no model can be written to the model cache")
+
+// modelDownloadCommand implements "w2vgrep --model download".
+type modelDownloadCommand struct {
+	cacheOption
+	Force    bool `long:"force" description:"Download the model again even if it is in the cache"`
+	Insecure bool `long:"insecure" description:"Install the model even though no checksum is pinned for it, so that the download cannot be verified"`
+	Quiet    bool `short:"q" long:"quiet" description:"Do not show the progress of the download, nor warnings, only errors"`
+	Args     struct {
+		Name string `positional-arg-name:"NAME" description:"Model to download, see w2vgrep --model list"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Execute downloads, verifies and converts the model.
+func (c *modelDownloadCommand) Execute(args []string) error {
+	if c.Quiet {
+		verbosity = logQuiet
+	}
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if conf.ReadOnly != nil && *conf.ReadOnly {
+		return errReadOnly
+	}
+	name := c.Args.Name
+	source, err := modelSource(name, conf)
+	if err != nil {
+		return err
+	}
+	if !source.Verified() && !c.Insecure {
+		return fmt.Errorf("no checksum is pinned for %s, so its download cannot be verified; set sha256 in its model_sources entry of config.json, or pass --insecure", name)
+	}
+	dir, err := c.dir(conf)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	target := filepath.Join(dir, name+".bin")
+	if _, err := os.Stat(target); err == nil && !c.Force {
+		fmt.Printf("%s is already downloaded: %s\n", name, target)
+		return nil
+	}
+
+	download, err := os.CreateTemp(dir, name+".*.download")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(download.Name())
+	defer download.Close()
+
+	checksum, err := fetch(source.URL, download, name)
+	if err != nil {
+		return err
+	}
+	if !source.Verified() {
+		warnf("no checksum configured for %s; sha256 of the download: %s", name, checksum)
+	} else if source.SHA256 != "" && !strings.EqualFold(checksum, source.SHA256) {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", source.URL, source.SHA256, checksum)
+	}
+
+	// Convert into a temporary file, so that an interrupted or failed
+	// conversion leaves no broken model behind
+	partial := target + ".part"
+	defer os.Remove(partial)
+	if err := convertDownload(download, source, partial); err != nil {
+		return fmt.Errorf("converting %s: %v", name, err)
+	}
+	modelChecksum, err := fileChecksum(partial)
+	if err != nil {
+		return err
+	}
+	if source.ModelSHA256 != "" && !strings.EqualFold(modelChecksum, source.ModelSHA256) {
+		return fmt.Errorf("checksum mismatch for the model converted from %s: expected %s, got %s", source.URL, source.ModelSHA256, modelChecksum)
+	}
+	if err := os.Rename(partial, target); err != nil {
+		return err
+	}
+	if err := writeChecksum(target, modelChecksum); err != nil {
+		return err
+	}
+
+	fmt.Printf("Saved %s to %s\nUse it with -m %s, or set model_path in config.json\n", name, target, target)
+	return nil
+}
+
+// fetch downloads url into file, logging the progress at the normal level,
+// and returns the hex SHA-256 checksum of the download.
+func fetch(url string, file *os.File, name string) (string, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("downloading %s: %s", url, resp.Status)
+	}
+
+	hash := sha256.New()
+	progress := &progressWriter{output: logWriter(logNormal), name: name, total: resp.ContentLength}
+	_, err = io.Copy(io.MultiWriter(file, hash, progress), resp.Body)
+	if progress.lastReported > 0 {
+		fmt.Fprintln(progress.output)
+	}
+	if err != nil {
+		return "", fmt.Errorf("downloading %s: %v", url, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// progressWriter counts the bytes written to it and prints the count to
+// output every megabyte.
+type progressWriter struct {
+	output       io.Writer
+	name         string
+	total        int64 // -1 when unknown
+	written      int64
+	lastReported int64
+}
+
+func (p *progressWriter) Write(data []byte) (int, error) {
+	p.written += int64(len(data))
+	if p.written-p.lastReported >= 1<<20 || p.written == p.total {
+		p.lastReported = p.written
+		if p.total > 0 {
+			fmt.Fprintf(p.output, "\rDownloading %s: %d of %d MB", p.name, p.written>>20, p.total>>20)
+		} else {
+			fmt.Fprintf(p.output, "\rDownloading %s: %d MB", p.name, p.written>>20)
+		}
+	}
+	return len(data), nil
+}
+
+// convertDownload writes the downloaded model in binary format to output.
+func convertDownload(download *os.File, source config.ModelSource, output string) error {
+	if _, err := download.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var input io.Reader = download
+	if strings.HasSuffix(source.Format, ".gz") {
+		gz, err := gzip.NewReader(download)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		input = gz
+	}
+
+	switch source.Format {
+	case "bin", "bin.gz":
+		out, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, input); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	case "text", "text.gz":
+		return model.ConvertText(input, output)
+	case "zip":
+		info, err := download.Stat()
+		if err != nil {
+			return err
+		}
+		archive, err := zip.NewReader(download, info.Size())
+		if err != nil {
+			return err
+		}
+		member, err := archive.Open(source.File)
+		if err != nil {
+			return err
+		}
+		defer member.Close()
+		return model.ConvertText(member, output)
+	}
+	return fmt.Errorf("unsupported format %q", source.Format)
+}
+
+// fileChecksum returns the hex SHA-256 checksum of the file at path.
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeChecksum saves the checksum of a model next to it, in the format of
+// sha256sum, so that the model can be checked with sha256sum -c.
+func writeChecksum(path, checksum string) error {
+	line := fmt.Sprintf("%s  %s\n", checksum, filepath.Base(path))
+	return os.WriteFile(path+".sha256", []byte(line), 0o644)
+}
+
+// modelListCommand implements "w2vgrep --model list".
+type modelListCommand struct {
+	cacheOption
+}
+
+// Execute lists the known and the downloaded models.
+func (c *modelListCommand) Execute(args []string) error {
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	dir, err := c.dir(conf)
+	if err != nil {
+		return err
+	}
+
+	sources := map[string]string{}
+	for name, source := range builtinModelSources {
+		sources[name] = source.URL
+	}
+	for name, source := range conf.ModelSources {
+		sources[name] = source.URL
+	}
+	sources[fastTextPrefix+"LANG"] = "fastText Common Crawl model of a language, e.g. " + fastTextPrefix + "fr"
+
+	installed := map[string]int64{}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".bin")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			installed[name] = info.Size()
+			if _, known := sources[name]; !known {
+				sources[name] = ""
+			}
+		}
+	}
+
+	var names []string
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("Models in %s:\n", dir)
+	for _, name := range names {
+		status := ""
+		if size, ok := installed[name]; ok {
+			status = fmt.Sprintf("downloaded, %d MB", size>>20)
+		}
+		fmt.Printf("  %-18s %-22s %s\n", name, status, sources[name])
+	}
+	return nil
+}
+
+// modelRemoveCommand implements "w2vgrep --model remove".
+type modelRemoveCommand struct {
+	cacheOption
+	Args struct {
+		Names []string `positional-arg-name:"NAME" description:"Downloaded models to remove"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Execute deletes the models and their checksums from the cache.
+func (c *modelRemoveCommand) Execute(args []string) error {
+	conf, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if conf.ReadOnly != nil && *conf.ReadOnly {
+		return errReadOnly
+	}
+	dir, err := c.dir(conf)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range c.Args.Names {
+		if strings.ContainsAny(name, "/\\") {
+			return fmt.Errorf("invalid model name %q", name)
+		}
+		path := filepath.Join(dir, name+".bin")
+		if err := os.Remove(path); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("%s is not downloaded", name)
+			}
+			return err
+		}
+		os.Remove(path + ".sha256")
+		fmt.Printf("Removed %s\n", path)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/arunsupe/semantic-grep/modules/processor"
+)
+
+// fileErrors collects the errors of files that could not be searched, so
+// that a search of many files goes on past them, and sums them up at the
+// end instead of scattering them through the output.
+type fileErrors struct {
+	failures []fileFailure
+}
+
+// fileFailure is a file that could not be searched, or searched to the end.
+type fileFailure struct {
+	name string
+	err  error
+}
+
+// add records the error of the file name.
+func (e *fileErrors) add(name string, err error) {
+	// Path errors repeat the name, e.g. "open x: permission denied"
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		name, err = pathErr.Path, pathErr.Err
+	}
+	e.failures = append(e.failures, fileFailure{name: name, err: err})
+}
+
+// count returns the number of files that failed.
+func (e *fileErrors) count() int {
+	return len(e.failures)
+}
+
+// errorKind returns the kind of a file error, by which the summary groups
+// errors.
+func errorKind(err error) string {
+	var tooLong *processor.LineTooLongError
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return "permission denied"
+	case errors.Is(err, fs.ErrNotExist):
+		return "no such file or directory"
+	case errors.As(err, &tooLong):
+		return "line too long"
+	default:
+		return "other errors"
+	}
+}
+
+// report prints the errors to w. The error of a search of a single file is
+// printed on its own; otherwise the failed files are listed by kind, after
+// their number out of the total searched.
+func (e *fileErrors) report(w io.Writer, total int) {
+	if len(e.failures) == 1 && total <= 1 {
+		f := e.failures[0]
+		fmt.Fprintf(w, "Error searching %s: %v\n", f.name, f.err)
+		return
+	}
+
+	fmt.Fprintf(w, "Error: %d of %d file(s) could not be searched:\n", len(e.failures), total)
+	var kinds []string
+	byKind := make(map[string][]fileFailure)
+	for _, f := range e.failures {
+		kind := errorKind(f.err)
+		if _, seen := byKind[kind]; !seen {
+			kinds = append(kinds, kind)
+		}
+		byKind[kind] = append(byKind[kind], f)
+	}
+	for _, kind := range kinds {
+		fmt.Fprintf(w, "%s (%d):\n", kind, len(byKind[kind]))
+		for _, f := range byKind[kind] {
+			if kind == "permission denied" || kind == "no such file or directory" {
+				fmt.Fprintf(w, "  %s\n", f.name)
+			} else {
+				fmt.Fprintf(w, "  %s: %v\n", f.name, f.err)
+			}
+		}
+	}
+}
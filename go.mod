@@ -5,9 +5,6 @@ go 1.22.5
 require (
 	github.com/clipperhouse/uax29 v1.13.0
 	github.com/jessevdk/go-flags v1.6.1
-)
-
-require (
-	golang.org/x/sys v0.21.0 // indirect
-	golang.org/x/text v0.16.0 // indirect
+	golang.org/x/sys v0.21.0
+	golang.org/x/text v0.16.0
 )
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+)
+
+// graphCommand implements "w2vgrep --graph". Starting from the query, it
+// follows nearest-neighbor links breadth first and writes the visited words
+// and links as a graph, which shows how a concept spreads through the model
+// and where unrelated words creep into its neighborhood.
+type graphCommand struct {
+	ModelPath  string  `short:"m" long:"model_path" description:"Path to the Word2Vec model file"`
+	Query      string  `long:"query" required:"true" description:"Word to start from"`
+	Depth      int     `long:"depth" default:"2" description:"Number of neighbor links to follow from the query"`
+	Threshold  float64 `short:"t" long:"threshold" default:"0.7" description:"Similarity threshold for following a link"`
+	Top        int     `long:"top" default:"5" description:"Follow at most this many neighbors per word (0 means no limit)"`
+	Format     string  `long:"format" default:"dot" choice:"dot" choice:"graphml" description:"Output format"`
+	Output     string  `short:"o" long:"output" description:"File to write the graph to instead of standard output"`
+	IgnoreCase bool    `short:"i" long:"ignore-case" description:"Look up the query in lowercase"`
+}
+
+// graphNode is a word of the graph with its distance in links to the query.
+type graphNode struct {
+	word  string
+	depth int
+}
+
+// graphEdge is a neighbor link between two words. Links are undirected, so
+// a link found from both ends is kept once.
+type graphEdge struct {
+	from, to   string
+	similarity float64
+}
+
+// Execute walks the neighborhood and writes the graph.
+func (c *graphCommand) Execute(args []string) error {
+	if c.Depth < 0 || c.Top < 0 {
+		return fmt.Errorf("--depth and --top must not be negative")
+	}
+
+	w2vModel, err := loadModel(c.ModelPath)
+	if err != nil {
+		return err
+	}
+
+	query := c.Query
+	if c.IgnoreCase {
+		query = strings.ToLower(query)
+	}
+	if _, err := w2vModel.GetEmbedding(query); err != nil {
+		return fmt.Errorf("%w: %s", err, query)
+	}
+
+	nodes, edges := c.walk(w2vModel, query)
+
+	out := io.Writer(os.Stdout)
+	if c.Output != "" {
+		file, err := os.Create(c.Output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	w := bufio.NewWriter(out)
+	if c.Format == "graphml" {
+		writeGraphML(w, nodes, edges)
+	} else {
+		writeDOT(w, query, nodes, edges)
+	}
+	return w.Flush()
+}
+
+// walk visits the words reachable from query in at most c.Depth links.
+func (c *graphCommand) walk(w2vModel model.VectorModel, query string) ([]graphNode, []graphEdge) {
+	nodes := []graphNode{{word: query}}
+	seen := map[string]bool{query: true}
+	linked := map[[2]string]bool{}
+	var edges []graphEdge
+
+	frontier := []string{query}
+	for depth := 1; depth <= c.Depth && len(frontier) > 0; depth++ {
+		var next []string
+		for _, word := range frontier {
+			neighbors, err := model.Neighbors(w2vModel, word, c.Threshold)
+			if err != nil {
+				continue
+			}
+			if c.Top > 0 && len(neighbors) > c.Top {
+				neighbors = neighbors[:c.Top]
+			}
+
+			for _, neighbor := range neighbors {
+				key := [2]string{word, neighbor.Word}
+				if key[0] > key[1] {
+					key[0], key[1] = key[1], key[0]
+				}
+				if !linked[key] {
+					linked[key] = true
+					edges = append(edges, graphEdge{from: word, to: neighbor.Word, similarity: neighbor.Similarity})
+				}
+				if !seen[neighbor.Word] {
+					seen[neighbor.Word] = true
+					nodes = append(nodes, graphNode{word: neighbor.Word, depth: depth})
+					next = append(next, neighbor.Word)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	return nodes, edges
+}
+
+// writeDOT writes the graph in Graphviz DOT format. The query is drawn as a
+// box and edges are labelled with their similarity.
+func writeDOT(w io.Writer, query string, nodes []graphNode, edges []graphEdge) {
+	fmt.Fprintf(w, "graph %s {\n", strconv.Quote(query))
+	for _, node := range nodes {
+		shape := "ellipse"
+		if node.depth == 0 {
+			shape = "box"
+		}
+		fmt.Fprintf(w, "  %s [shape=%s, depth=%d];\n", strconv.Quote(node.word), shape, node.depth)
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(w, "  %s -- %s [label=\"%.4f\", weight=%.4f];\n",
+			strconv.Quote(edge.from), strconv.Quote(edge.to), edge.similarity, edge.similarity)
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// writeGraphML writes the graph in GraphML format with the depth of each
+// node and the similarity of each edge as attributes.
+func writeGraphML(w io.Writer, nodes []graphNode, edges []graphEdge) {
+	fmt.Fprintln(w, `<?xml version="1.0" encoding="UTF-8"?>`)
+	fmt.Fprintln(w, `<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	fmt.Fprintln(w, `  <key id="depth" for="node" attr.name="depth" attr.type="int"/>`)
+	fmt.Fprintln(w, `  <key id="similarity" for="edge" attr.name="similarity" attr.type="double"/>`)
+	fmt.Fprintln(w, `  <graph edgedefault="undirected">`)
+	for _, node := range nodes {
+		fmt.Fprintf(w, "    <node id=\"%s\"><data key=\"depth\">%d</data></node>\n", xmlEscape(node.word), node.depth)
+	}
+	for _, edge := range edges {
+		fmt.Fprintf(w, "    <edge source=\"%s\" target=\"%s\"><data key=\"similarity\">%.4f</data></edge>\n",
+			xmlEscape(edge.from), xmlEscape(edge.to), edge.similarity)
+	}
+	fmt.Fprintln(w, "  </graph>")
+	fmt.Fprintln(w, "</graphml>")
+}
+
+// xmlEscape escapes s for use in XML text and attribute values.
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -28,7 +28,7 @@ read -p "Enter your choice: " choice
 INSTALL_PATH="$(pwd)/w2vgrep"
 if [ $choice -eq 1 ]; then
     echo "[*] Installing w2vgrep in /usr/bin/w2vgrep, please enter your password as sudo is required."
-    sudo cp w2vgrep /usr/bin/w2vgrep
+    sudo cp w2vgrep /usr/bin/w2vgrep && sudo ln -sf w2vgrep /usr/bin/w2vgrepd
     if [ $? -ne 0 ]; then
         echo "Failed to install w2vgrep"
         exit 1
@@ -37,6 +37,7 @@ if [ $choice -eq 1 ]; then
     echo "[OK] w2vgrep installed in /usr/bin/w2vgrep"
 elif [ $choice -eq 2 ]; then
     echo "[*] Adding local path to \$PATH"
+    ln -sf w2vgrep w2vgrepd
     export PATH=$PATH:$(pwd)
     echo "[OK] Local path added to \$PATH: to make this permanent, add the following line to your shell configuration file (e.g. ~/.bashrc or ~/.zshrc):"
     echo "export PATH=\$PATH:$(pwd)"
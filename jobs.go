@@ -0,0 +1,52 @@
+package main
+
+import "bytes"
+
+// fileSearch is the outcome of the search of a file.
+type fileSearch struct {
+	name string
+	// output is the output of the file, kept to be printed in order when
+	// files are searched concurrently
+	output *bytes.Buffer
+	count  int
+	// err is why the file could not be searched, or searched to the end
+	err error
+	// searched is set when the file was searched, even if not to the end,
+	// or answered from the result cache
+	searched bool
+	// clean is set when the file was searched to the end, or skipped as
+	// asked, e.g. as binary
+	clean bool
+}
+
+// searchInOrder searches the n files with up to jobs goroutines, and passes
+// their outcomes to report in the order of the files, as soon as the files
+// before have been reported. A file is only started once less than jobs
+// files are searched or waiting to be reported, which bounds the output
+// held in memory.
+func searchInOrder(n, jobs int, search func(i int) fileSearch, report func(fileSearch)) {
+	if jobs <= 1 {
+		for i := 0; i < n; i++ {
+			report(search(i))
+		}
+		return
+	}
+
+	outcomes := make([]chan fileSearch, n)
+	for i := range outcomes {
+		outcomes[i] = make(chan fileSearch, 1)
+	}
+	slots := make(chan struct{}, jobs)
+	go func() {
+		for i := 0; i < n; i++ {
+			slots <- struct{}{}
+			go func(i int) {
+				outcomes[i] <- search(i)
+			}(i)
+		}
+	}()
+	for i := 0; i < n; i++ {
+		report(<-outcomes[i])
+		<-slots
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/config"
+	"github.com/arunsupe/semantic-grep/modules/language"
+)
+
+// languageModelPath returns the path of the model configured for lang in
+// the models section of the config file. With language.Auto, the language is
+// detected from the first lines of the first input, falling back to the
+// default model (an empty path) when it cannot be detected or has no model.
+// Lines read from standard input for detection are replayed by the returned
+// reader, which is nil otherwise.
+func languageModelPath(lang string, files []string, lines int, conf *config.Config) (string, io.Reader, error) {
+	var stdin io.Reader
+	if lang == language.Auto {
+		sample, replay, err := readSample(files[0], lines)
+		if err != nil {
+			return "", nil, err
+		}
+		stdin = replay
+
+		lang = language.Detect(sample)
+		if lang == "" {
+			warnf("could not detect the language of the input, using the default model")
+			return "", stdin, nil
+		}
+		if _, ok := conf.Models[lang]; !ok {
+			warnf("no model configured for detected language %s, using the default model", lang)
+			return "", stdin, nil
+		}
+		verbosef("Detected language: %s", lang)
+	}
+
+	path, ok := conf.Models[lang]
+	if !ok {
+		return "", nil, fmt.Errorf("no model configured for language %q (configured: %s)", lang, configuredLanguages(conf))
+	}
+	return path, stdin, nil
+}
+
+// readSample returns the first lines of a file, or of standard input for
+// "-". For standard input it also returns a reader yielding the whole input,
+// including the sampled lines.
+func readSample(fileName string, lines int) (string, io.Reader, error) {
+	var input io.Reader = os.Stdin
+	if fileName != "-" {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return "", nil, err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	reader := bufio.NewReader(input)
+	var sample bytes.Buffer
+	for i := 0; i < lines; i++ {
+		line, err := reader.ReadBytes('\n')
+		sample.Write(line)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	if fileName != "-" {
+		return sample.String(), nil, nil
+	}
+	return sample.String(), io.MultiReader(bytes.NewReader(sample.Bytes()), reader), nil
+}
+
+// configuredLanguages lists the languages of the models section, for error messages.
+func configuredLanguages(conf *config.Config) string {
+	if len(conf.Models) == 0 {
+		return "none"
+	}
+	var langs []string
+	for lang := range conf.Models {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return strings.Join(langs, ", ")
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+)
+
+// lexiconCommand implements "w2vgrep --lexicon". Starting from seed words, it
+// proposes the nearest neighbors of the lexicon as candidates and asks, one
+// candidate at a time, whether to accept or reject it. Accepted words are
+// expanded in the next round. The final lexicon is written one word per
+// line, ready for "w2vgrep -f" or "grep -w -F -f".
+type lexiconCommand struct {
+	ModelPath  string   `short:"m" long:"model_path" description:"Path to the Word2Vec model file"`
+	Seeds      []string `short:"s" long:"seed" description:"Seed word of the lexicon (repeatable)"`
+	WordsFile  string   `long:"words" description:"File of seed words: one per line, or one cluster of '|' separated words per line as written by cluster.go"`
+	Threshold  float64  `short:"t" long:"threshold" default:"0.7" description:"Similarity threshold for candidates"`
+	Top        int      `long:"top" default:"10" description:"Propose at most this many neighbors per lexicon word and round (0 means no limit)"`
+	Rounds     int      `long:"rounds" default:"3" description:"Number of expansion rounds"`
+	Output     string   `short:"o" long:"output" description:"Pattern file to write the lexicon to instead of standard output"`
+	Yes        bool     `short:"y" long:"yes" description:"Accept every candidate without asking"`
+	IgnoreCase bool     `short:"i" long:"ignore-case" description:"Look up seeds in lowercase"`
+}
+
+// lexiconCandidate is a proposed word with its best similarity to the
+// lexicon and the lexicon word it is closest to.
+type lexiconCandidate struct {
+	word       string
+	similarity float64
+	closestTo  string
+}
+
+// Execute runs the expansion rounds and writes the lexicon.
+func (c *lexiconCommand) Execute(args []string) error {
+	if c.Top < 0 || c.Rounds < 0 {
+		return fmt.Errorf("--top and --rounds must not be negative")
+	}
+
+	seeds := c.Seeds
+	if c.WordsFile != "" {
+		points, err := readProjectorWords(c.WordsFile)
+		if err != nil {
+			return err
+		}
+		for _, point := range points {
+			seeds = append(seeds, point.word)
+		}
+	}
+	if len(seeds) == 0 {
+		return fmt.Errorf("no seed words: use --seed or --words")
+	}
+
+	w2vModel, err := loadModel(c.ModelPath)
+	if err != nil {
+		return err
+	}
+
+	var lexicon []string
+	decided := map[string]bool{}
+	for _, seed := range seeds {
+		if c.IgnoreCase {
+			seed = strings.ToLower(seed)
+		}
+		if !decided[seed] {
+			decided[seed] = true
+			lexicon = append(lexicon, seed)
+		}
+	}
+
+	answers := bufio.NewReader(os.Stdin)
+	frontier := lexicon
+	for round := 1; round <= c.Rounds && len(frontier) > 0; round++ {
+		candidates := c.candidates(w2vModel, frontier, decided)
+		if len(candidates) == 0 {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "Round %d: %d candidates\n", round, len(candidates))
+
+		var accepted []string
+		acceptAll := c.Yes
+		for _, candidate := range candidates {
+			decided[candidate.word] = true
+			if !acceptAll {
+				answer := askCandidate(answers, candidate)
+				if answer == 'q' {
+					// Keep what was accepted so far and stop expanding
+					lexicon = append(lexicon, accepted...)
+					return c.write(lexicon)
+				}
+				if answer == 'a' {
+					acceptAll = true
+				}
+				if answer == 'n' {
+					continue
+				}
+			}
+			accepted = append(accepted, candidate.word)
+		}
+
+		lexicon = append(lexicon, accepted...)
+		frontier = accepted
+	}
+
+	return c.write(lexicon)
+}
+
+// candidates returns the undecided neighbors of the frontier words, most
+// similar first.
+func (c *lexiconCommand) candidates(w2vModel model.VectorModel, frontier []string, decided map[string]bool) []lexiconCandidate {
+	best := map[string]lexiconCandidate{}
+	for _, word := range frontier {
+		neighbors, err := model.Neighbors(w2vModel, word, c.Threshold)
+		if err != nil {
+			warnf("%v", err)
+			continue
+		}
+
+		proposed := 0
+		for _, neighbor := range neighbors {
+			if c.Top > 0 && proposed == c.Top {
+				break
+			}
+			if decided[neighbor.Word] {
+				continue
+			}
+			proposed++
+			if current, ok := best[neighbor.Word]; !ok || neighbor.Similarity > current.similarity {
+				best[neighbor.Word] = lexiconCandidate{word: neighbor.Word, similarity: neighbor.Similarity, closestTo: word}
+			}
+		}
+	}
+
+	candidates := make([]lexiconCandidate, 0, len(best))
+	for _, candidate := range best {
+		candidates = append(candidates, candidate)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].similarity != candidates[j].similarity {
+			return candidates[i].similarity > candidates[j].similarity
+		}
+		return candidates[i].word < candidates[j].word
+	})
+	return candidates
+}
+
+// askCandidate prompts on stderr until it reads a valid answer: 'y' to
+// accept, 'n' to reject, 'a' to accept the rest of the round or 'q' to stop.
+// The end of input counts as 'q'.
+func askCandidate(answers *bufio.Reader, candidate lexiconCandidate) byte {
+	for {
+		fmt.Fprintf(os.Stderr, "  %s (%.4f to '%s') [y/n/a/q]? ", candidate.word, candidate.similarity, candidate.closestTo)
+		line, err := answers.ReadString('\n')
+		answer := strings.ToLower(strings.TrimSpace(line))
+		if answer != "" {
+			switch answer[0] {
+			case 'y', 'n', 'a', 'q':
+				return answer[0]
+			}
+		}
+		if err == io.EOF {
+			fmt.Fprintln(os.Stderr)
+			return 'q'
+		}
+	}
+}
+
+// write writes the lexicon one word per line.
+func (c *lexiconCommand) write(lexicon []string) error {
+	out := io.Writer(os.Stdout)
+	if c.Output != "" {
+		file, err := os.Create(c.Output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	w := bufio.NewWriter(out)
+	for _, word := range lexicon {
+		fmt.Fprintln(w, word)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if c.Output != "" {
+		fmt.Fprintf(os.Stderr, "Wrote %d words to %s\n", len(lexicon), c.Output)
+	}
+	return nil
+}
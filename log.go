@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// logLevel is how much w2vgrep tells on standard error. Each level prints
+// the messages of the levels below it.
+type logLevel int
+
+const (
+	// logQuiet prints errors only, with -q
+	logQuiet logLevel = iota
+	// logNormal prints warnings too
+	logNormal
+	// logVerbose tells what w2vgrep does, e.g. the configuration file and
+	// the model used, with --verbose
+	logVerbose
+	// logDebug adds timings and statistics, with --debug
+	logDebug
+)
+
+// verbosity is the level of the messages printed.
+var verbosity = logNormal
+
+// logOutput receives the messages. A search sets it to erase its progress
+// line first.
+var logOutput io.Writer = os.Stderr
+
+// logf prints a message of level, prefixed with prefix, if verbosity lets
+// it through.
+func logf(level logLevel, prefix, format string, args ...interface{}) {
+	if level > verbosity {
+		return
+	}
+	fmt.Fprintf(logOutput, prefix+format+"\n", args...)
+}
+
+// errorf prints an error, whatever the verbosity.
+func errorf(format string, args ...interface{}) {
+	logf(logQuiet, "Error: ", format, args...)
+}
+
+// warnf prints a warning, unless with -q.
+func warnf(format string, args ...interface{}) {
+	logf(logNormal, "Warning: ", format, args...)
+}
+
+// infof prints a message of the normal level, such as a summary of the
+// search, unless with -q.
+func infof(format string, args ...interface{}) {
+	logf(logNormal, "", format, args...)
+}
+
+// verbosef prints a message with --verbose or --debug.
+func verbosef(format string, args ...interface{}) {
+	logf(logVerbose, "", format, args...)
+}
+
+// debugf prints a message with --debug.
+func debugf(format string, args ...interface{}) {
+	logf(logDebug, "", format, args...)
+}
+
+// logWriter returns the log output for the messages of level written as a
+// whole, such as a report of several lines, or io.Discard when verbosity
+// does not let them through.
+func logWriter(level logLevel) io.Writer {
+	if level > verbosity {
+		return io.Discard
+	}
+	return logOutput
+}
+
+// warnings returns the writer of the warnings of the processor: the log
+// output, or nothing with -q.
+func warnings() io.Writer {
+	return logWriter(logNormal)
+}
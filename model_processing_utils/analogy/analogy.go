@@ -0,0 +1,326 @@
+// A program to explore a model with vector arithmetic: it prints the words
+// nearest to the vector composed by an expression such as
+// "king - man + woman", leaving out the words of the expression. Solving
+// analogies is also a quick check that a model works, e.g. after converting
+// it, as a broken model answers them with unrelated words.
+//
+// Expressions are words separated by + and -, with spaces around the
+// operators since words may contain hyphens. They are read from the command
+// line, or one per line from standard input when none is given. With
+// -check, the program instead solves the analogies of a test set and
+// reports how many it answers right.
+//
+// Usage: analogy [OPTIONS] -model model.bin [EXPRESSION...]
+// Options:
+//   -model string
+//         Path to the model file (required)
+//   -n int
+//         Number of words printed (default 10)
+//   -method string
+//         How the words of the expression are combined: add, the sum of their
+//         unit vectors, or mul, the multiplicative 3CosMul of Levy and Goldberg (default "add")
+//   -check string
+//         File of analogies "a b c d" (a is to b as c is to d), one per line,
+//         with ": section" lines, e.g. questions-words.txt of word2vec
+//
+// Example:
+//   go run . -model ../../models/glove/glove.6B.300d.bin 'king - man + woman'
+//   go run . -model ../../models/fasttext/cc.zh.300.bin '国王 - 男人 + 女人'
+//   go run . -model ../../models/glove/glove.6B.300d.bin -check questions-words.txt
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+)
+
+// mulEpsilon keeps the 3CosMul quotient finite.
+const mulEpsilon = 0.001
+
+// term is a word of an expression and its sign.
+type term struct {
+	word string
+	sign float32 // 1 or -1
+}
+
+// embeddings are the unit vectors of the words of a model.
+type embeddings struct {
+	words   []string
+	vectors [][]float32
+	index   map[string]int
+}
+
+// scoredWord is a word and its score for an expression.
+type scoredWord struct {
+	word  string
+	score float64
+}
+
+func main() {
+	modelPath := flag.String("model", "", "Path to the model file (required)")
+	n := flag.Int("n", 10, "Number of words printed")
+	method := flag.String("method", "add", "How the words of the expression are combined: add, the sum of their unit vectors, or mul, the multiplicative 3CosMul of Levy and Goldberg")
+	check := flag.String("check", "", "File of analogies \"a b c d\" (a is to b as c is to d), one per line, with \": section\" lines, e.g. questions-words.txt of word2vec")
+	flag.Parse()
+
+	if *modelPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: -model is required")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *method != "add" && *method != "mul" {
+		fmt.Fprintf(os.Stderr, "Error: invalid -method %q: must be add or mul\n", *method)
+		os.Exit(1)
+	}
+	if *n < 1 {
+		fmt.Fprintln(os.Stderr, "Error: -n must be positive")
+		os.Exit(1)
+	}
+
+	model, err := modelio.Load(*modelPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading model: %v\n", err)
+		os.Exit(1)
+	}
+	e := newEmbeddings(model)
+
+	if *check != "" {
+		if err := checkAnalogies(e, *check, *method); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	expressions := flag.Args()
+	if len(expressions) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if expression := strings.TrimSpace(scanner.Text()); expression != "" {
+				expressions = append(expressions, expression)
+			}
+		}
+	}
+	failed := false
+	for _, expression := range expressions {
+		terms, err := parseExpression(expression)
+		if err == nil {
+			err = e.check(terms)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", expression, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s:\n", expression)
+		for _, w := range e.nearest(terms, *method, *n) {
+			fmt.Printf("  %-20s %.4f\n", w.word, w.score)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// parseExpression parses words separated by + and - operators, e.g.
+// "king - man + woman". The first word may be preceded by an operator.
+func parseExpression(expression string) ([]term, error) {
+	var terms []term
+	sign := float32(1)
+	expectWord := true
+	for i, field := range strings.Fields(expression) {
+		if field == "+" || field == "-" {
+			// An operator follows a word, or starts the expression
+			if expectWord && i > 0 {
+				return nil, fmt.Errorf("expected a word before %q", field)
+			}
+			if field == "-" {
+				sign = -1
+			}
+			expectWord = true
+			continue
+		}
+		if !expectWord {
+			return nil, fmt.Errorf("expected + or - before %q (put spaces around operators)", field)
+		}
+		terms = append(terms, term{word: field, sign: sign})
+		sign = 1
+		expectWord = false
+	}
+	if expectWord {
+		return nil, fmt.Errorf("expected words separated by + and -")
+	}
+	return terms, nil
+}
+
+// newEmbeddings returns the unit vectors of the words of m. A word listed
+// twice keeps its first, most frequent, vector.
+func newEmbeddings(m *modelio.Model) *embeddings {
+	e := &embeddings{index: make(map[string]int, len(m.Words))}
+	for i, word := range m.Words {
+		if _, ok := e.index[word]; ok {
+			continue
+		}
+		vector := m.Vectors[i]
+		norm := 0.0
+		for _, x := range vector {
+			norm += float64(x) * float64(x)
+		}
+		unit := make([]float32, len(vector))
+		if norm > 0 {
+			for j, x := range vector {
+				unit[j] = float32(float64(x) / math.Sqrt(norm))
+			}
+		}
+		e.index[word] = len(e.words)
+		e.words = append(e.words, word)
+		e.vectors = append(e.vectors, unit)
+	}
+	return e
+}
+
+// check returns an error naming the words of terms missing from the model.
+func (e *embeddings) check(terms []term) error {
+	var missing []string
+	for _, t := range terms {
+		if _, ok := e.index[t.word]; !ok {
+			missing = append(missing, t.word)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("not in the model: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// nearest returns the n best scoring words for terms, best first, leaving
+// out the words of terms. With add, the score is the cosine with the sum of
+// the signed unit vectors of terms; with mul, it is the product of the
+// shifted cosines with the positive words divided by that with the negative
+// ones (3CosMul), which keeps a single large similarity from dominating.
+func (e *embeddings) nearest(terms []term, method string, n int) []scoredWord {
+	dim := len(e.vectors[0])
+	composed := make([]float32, dim)
+	excluded := make(map[int]bool)
+	for _, t := range terms {
+		i := e.index[t.word]
+		excluded[i] = true
+		for j, x := range e.vectors[i] {
+			composed[j] += t.sign * x
+		}
+	}
+	composedNorm := 0.0
+	for _, x := range composed {
+		composedNorm += float64(x) * float64(x)
+	}
+	composedNorm = math.Sqrt(composedNorm)
+
+	var best []scoredWord
+	for i, vector := range e.vectors {
+		if excluded[i] {
+			continue
+		}
+		var score float64
+		if method == "mul" {
+			numerator, denominator := 1.0, 1.0
+			for _, t := range terms {
+				// Shift cosines to [0, 1] so that the product is meaningful
+				shifted := (dot(vector, e.vectors[e.index[t.word]]) + 1) / 2
+				if t.sign > 0 {
+					numerator *= shifted
+				} else {
+					denominator *= shifted
+				}
+			}
+			score = numerator / (denominator + mulEpsilon)
+		} else if composedNorm > 0 {
+			score = dot(vector, composed) / composedNorm
+		}
+
+		if len(best) < n || score > best[len(best)-1].score {
+			best = append(best, scoredWord{word: e.words[i], score: score})
+			sort.SliceStable(best, func(a, b int) bool { return best[a].score > best[b].score })
+			if len(best) > n {
+				best = best[:n]
+			}
+		}
+	}
+	return best
+}
+
+// dot returns the dot product of two vectors.
+func dot(a, b []float32) float64 {
+	sum := 0.0
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+// checkAnalogies solves the analogies "a b c d" of the file at path as
+// "b - a + c" and prints, by section and in total, the share of those whose
+// best answer is d. Analogies with words missing from the model are
+// skipped and counted.
+func checkAnalogies(e *embeddings, path, method string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	section := ""
+	var correct, total, skipped int
+	var sectionCorrect, sectionTotal int
+	printSection := func() {
+		if sectionTotal > 0 {
+			fmt.Printf("%-30s %6.2f%% (%d/%d)\n", section, 100*float64(sectionCorrect)/float64(sectionTotal), sectionCorrect, sectionTotal)
+		}
+		sectionCorrect, sectionTotal = 0, 0
+	}
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, ":") {
+			printSection()
+			section = strings.TrimSpace(text[1:])
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 4 {
+			return fmt.Errorf("%s:%d: expected four words", path, line)
+		}
+		terms := []term{{fields[1], 1}, {fields[0], -1}, {fields[2], 1}}
+		if e.check(terms) != nil || e.check([]term{{fields[3], 1}}) != nil {
+			skipped++
+			continue
+		}
+		sectionTotal++
+		total++
+		if answer := e.nearest(terms, method, 1); len(answer) > 0 && answer[0].word == fields[3] {
+			sectionCorrect++
+			correct++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	printSection()
+
+	if total == 0 {
+		return fmt.Errorf("no analogy of %s has all its words in the model (%d skipped)", path, skipped)
+	}
+	fmt.Printf("%-30s %6.2f%% (%d/%d), %d skipped for words missing from the model\n", "Total", 100*float64(correct)/float64(total), correct, total, skipped)
+	return nil
+}
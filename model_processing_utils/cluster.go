@@ -15,11 +15,24 @@ The script takes the path to the word2vec binary model, the number of clusters (
 the batch size for mini-batch k-means, the maximum number of iterations, and
 the output file path as input.
 The script performs mini-batch k-means clustering on the word vectors and
-writes the clusters to the output file.
+writes the clusters to the output file. Centroids are seeded with k-means++,
+iterations stop early when the centroids no longer move by more than
+-epsilon, and clusters left empty are re-seeded. Words are assigned to
+clusters in parallel by -workers goroutines, with the progress and
+estimated time left of each step shown on standard error. The random seed is printed;
+pass it to -seed to repeat a run.
+
+-format selects the output: pipe (the default) for the words of a cluster
+joined by |, ere for one grep -E pattern per cluster, to use with
+grep -E -f, or json for the id, centroid word (the word nearest to the
+centroid) and members of each cluster. -max-cluster-size leaves out the
+giant clusters that grep cannot handle.
 
 Usage: cluster.go -model path/to/model.bin \
 				-k 100 -batch-size 100 \
 				-iterations 100 \
+				-seed 42 \
+				-format ere -max-cluster-size 1000 \
 				-output clusters.txt
 */
 
@@ -27,7 +40,7 @@ package main
 
 import (
 	"bufio"
-	"encoding/binary"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -35,110 +48,16 @@ import (
 	"math"
 	"math/rand"
 	"os"
+	"regexp"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
-)
-
-// VectorModel interface defines the methods that all vector models must implement
-type VectorModel interface {
-	LoadModel(filename string) error
-	GetEmbedding(token string) interface{}
-}
-
-// VecModel32bit represents a 32-bit floating point Word2Vec model
-type VecModel32bit struct {
-	Vectors map[string][]float32
-	Size    int
-}
-
-// LoadModel loads a 32-bit floating point Word2Vec model from a file
-func (m *VecModel32bit) LoadModel(filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %v", err)
-	}
-	defer file.Close()
-
-	reader := bufio.NewReader(file)
-
-	// Read header
-	var vocabSize, vectorSize int
-	_, err = fmt.Fscanf(reader, "%d %d\n", &vocabSize, &vectorSize)
-	if err != nil {
-		return fmt.Errorf("failed to read header: %v\nCheck that you have a valid model file", err)
-	}
-
-	// Validate header
-	if vocabSize <= 0 || vectorSize <= 0 {
-		return fmt.Errorf("invalid header: vocabSize=%d, vectorSize=%d\nCheck that you have a valid model file", vocabSize, vectorSize)
-	}
-
-	m.Vectors = make(map[string][]float32, vocabSize)
-	m.Size = vectorSize
-
-	for i := 0; i < vocabSize; i++ {
-		word, err := reader.ReadString(' ')
-		if err != nil {
-			return fmt.Errorf("failed to read word: %v", err)
-		}
-		word = strings.TrimSpace(word)
-
-		vector := make([]float32, vectorSize)
-		for j := 0; j < vectorSize; j++ {
-			err := binary.Read(reader, binary.LittleEndian, &vector[j])
-			if err != nil {
-				return fmt.Errorf("failed to read vector: %v", err)
-			}
-		}
-
-		// Check if we've reached the end of the record
-		nextByte, err := reader.Peek(1)
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("unexpected error reading next byte: %v", err)
-		}
-		if len(nextByte) > 0 && nextByte[0] == '\n' {
-			reader.ReadByte() // consume the newline
-		}
-
-		m.Vectors[word] = vector
-	}
-
-	// Check if we've reached the end of the file
-	_, err = reader.ReadByte()
-	if err != io.EOF {
-		return fmt.Errorf("unexpected data at end of file.\nCheck that you have a valid model file")
-	}
 
-	return nil
-}
-
-// GetEmbedding returns the vector embedding of a token for the 32-bit model
-func (m *VecModel32bit) GetEmbedding(token string) interface{} {
-	vec, ok := m.Vectors[token]
-	if !ok {
-		return make([]float32, m.Size)
-	}
-	return vec
-}
-
-// LoadVectorModel loads either a 32-bit or 8-bit model based on the file extension
-func LoadVectorModel(filename string) (VectorModel, error) {
-	var model VectorModel
-
-	if strings.HasSuffix(filename, ".bin") {
-		model = &VecModel32bit{}
-	} else {
-		return nil, fmt.Errorf("unsupported file format")
-	}
-
-	err := model.LoadModel(filename)
-	if err != nil {
-		return nil, err
-	}
-
-	return model, nil
-}
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+)
 
 // func euclideanDistance(vec1, vec2 []float32) float64 {
 // 	sum := float64(0)
@@ -184,115 +103,377 @@ func calculateCentroid(vectors [][]float32) []float32 {
 	return centroid
 }
 
-// Use cosineDistance
-func miniBatchKMeans(vectors [][]float32, words []string, k, batchSize, maxIterations int) [][]string {
-	rand.Seed(time.Now().UnixNano())
-	dim := len(vectors[0])
+// seedSampleSize is the largest number of words from which k-means++ picks
+// the initial centroids; scanning every word of a large model for each of
+// the k centroids would take longer than the clustering itself.
+const seedSampleSize = 20000
+
+// maxReseedRounds bounds the rounds of re-seeding empty clusters.
+const maxReseedRounds = 10
+
+// parallelChunk is the number of vectors handed to a worker at a time.
+const parallelChunk = 1024
+
+// parallel calls fn on consecutive ranges of 0..n from workers goroutines,
+// and returns once every range is done. done, when not nil, is called after
+// each range with the number of indexes done so far.
+func parallel(n, workers int, fn func(start, end int), done func(int)) {
+	var next, completed int64
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				start := int(atomic.AddInt64(&next, parallelChunk)) - parallelChunk
+				if start >= n {
+					return
+				}
+				end := min(start+parallelChunk, n)
+				fn(start, end)
+				if done != nil {
+					count := atomic.AddInt64(&completed, int64(end-start))
+					mu.Lock()
+					done(int(count))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// progress prints how far a long step is and its estimated time left on
+// standard error, at most once a second.
+type progress struct {
+	step         string
+	total        int
+	start        time.Time
+	lastReported time.Time
+	reported     bool
+}
+
+func newProgress(step string, total int) *progress {
+	now := time.Now()
+	return &progress{step: step, total: total, start: now, lastReported: now}
+}
+
+// report reports that done of the total units of work are done.
+func (p *progress) report(done int) {
+	now := time.Now()
+	if done <= 0 || now.Sub(p.lastReported) < time.Second {
+		return
+	}
+	p.lastReported = now
+	p.reported = true
+	left := time.Duration(float64(now.Sub(p.start)) * float64(p.total-done) / float64(done))
+	fmt.Fprintf(os.Stderr, "\r%s: %d%%, ETA %v   ", p.step, 100*done/p.total, left.Round(time.Second))
+}
+
+// finish ends the progress line, if any was printed.
+func (p *progress) finish() {
+	if p.reported {
+		fmt.Fprintf(os.Stderr, "\r%s: done in %v   \n", p.step, time.Since(p.start).Round(time.Second))
+	}
+}
 
-	// Initialize k random centroids
-	centroids := make([][]float32, k)
-	for i := range centroids {
-		centroids[i] = vectors[rand.Intn(len(vectors))]
+// kMeansPlusPlus picks k initial centroids among a sample of vectors with
+// k-means++: each centroid is drawn with a probability proportional to the
+// squared distance to the nearest centroid already picked, which spreads
+// the centroids over the data.
+func kMeansPlusPlus(vectors [][]float32, k, workers int, rng *rand.Rand) [][]float32 {
+	sample := vectors
+	if len(vectors) > seedSampleSize {
+		sample = make([][]float32, seedSampleSize)
+		for i, idx := range rng.Perm(len(vectors))[:seedSampleSize] {
+			sample[i] = vectors[idx]
+		}
 	}
 
-	for iteration := 0; iteration < maxIterations; iteration++ {
-		// Sample a random batch of data points
-		batchIndices := rand.Perm(len(vectors))[:batchSize]
-		batch := make([][]float32, batchSize)
-		for i, idx := range batchIndices {
-			batch[i] = vectors[idx]
+	centroids := [][]float32{copyVector(sample[rng.Intn(len(sample))])}
+	distances := make([]float64, len(sample))
+	for i := range distances {
+		distances[i] = math.Inf(1)
+	}
+	seeding := newProgress("Seeding centroids", k)
+	for {
+		// Update the distances to the nearest centroid with the newest one
+		centroid := centroids[len(centroids)-1]
+		parallel(len(sample), workers, func(start, end int) {
+			for i := start; i < end; i++ {
+				d := cosineDistance(sample[i], centroid)
+				distances[i] = math.Min(distances[i], d*d)
+			}
+		}, nil)
+		seeding.report(len(centroids))
+		if len(centroids) == k {
+			break
 		}
 
-		// Assign points in the batch to the nearest centroid
-		clusterAssignments := make([]int, batchSize)
-		for i, vec := range batch {
-			bestCluster := 0
-			// bestDistance := euclideanDistance(vec, centroids[0])
-			bestDistance := cosineDistance(vec, centroids[0])
-			for j := 1; j < k; j++ {
-				// distance := euclideanDistance(vec, centroids[j])
-				distance := cosineDistance(vec, centroids[j])
-				if distance < bestDistance {
-					bestDistance = distance
-					bestCluster = j
+		total := 0.0
+		for _, d := range distances {
+			total += d
+		}
+		next := rng.Intn(len(sample))
+		if total > 0 {
+			target := rng.Float64() * total
+			for i, d := range distances {
+				target -= d
+				if target <= 0 {
+					next = i
+					break
 				}
 			}
-			clusterAssignments[i] = bestCluster
 		}
+		centroids = append(centroids, copyVector(sample[next]))
+	}
+	seeding.finish()
+	return centroids
+}
+
+// copyVector returns a copy of vec, so that centroids can be updated
+// without changing the vectors of the model.
+func copyVector(vec []float32) []float32 {
+	return append([]float32(nil), vec...)
+}
+
+// nearestCentroid returns the index of the centroid nearest to vec and its
+// distance.
+func nearestCentroid(vec []float32, centroids [][]float32) (int, float64) {
+	bestCluster := 0
+	bestDistance := cosineDistance(vec, centroids[0])
+	for j := 1; j < len(centroids); j++ {
+		distance := cosineDistance(vec, centroids[j])
+		if distance < bestDistance {
+			bestDistance = distance
+			bestCluster = j
+		}
+	}
+	return bestCluster, bestDistance
+}
+
+// assign sets assignments[i] and distances[i] to the nearest centroid of
+// vectors[i] and its distance, from workers goroutines. The results do not
+// depend on the number of workers.
+func assign(vectors, centroids [][]float32, assignments []int, distances []float64, workers int, p *progress) {
+	var done func(int)
+	if p != nil {
+		done = p.report
+	}
+	parallel(len(vectors), workers, func(start, end int) {
+		for i := start; i < end; i++ {
+			assignments[i], distances[i] = nearestCentroid(vectors[i], centroids)
+		}
+	}, done)
+}
+
+// miniBatchKMeans clusters the words by the cosine distance of their
+// vectors. Centroids are seeded with k-means++ and updated from random
+// batches: each centroid is the mean of all the words assigned to it so
+// far, so that it settles down, and the iterations stop early once no
+// centroid moves by more than epsilon (in cosine distance). Clusters left
+// empty are re-seeded with the words farthest from their centroids. Words
+// are assigned to centroids by workers goroutines.
+func miniBatchKMeans(vectors [][]float32, words []string, k, batchSize, maxIterations, workers int, epsilon float64, rng *rand.Rand) []cluster {
+	centroids := kMeansPlusPlus(vectors, k, workers, rng)
+	counts := make([]int, k)
+	dim := len(vectors[0])
 
-		// Update centroids based on the batch
-		clusterSums := make([][]float32, k)
-		clusterCounts := make([]int, k)
-		for i := range clusterSums {
-			clusterSums[i] = make([]float32, dim)
+	batch := make([][]float32, batchSize)
+	batchAssignments := make([]int, batchSize)
+	batchDistances := make([]float64, batchSize)
+	iterations := newProgress("Mini-batch k-means", maxIterations)
+	for iteration := 1; iteration <= maxIterations; iteration++ {
+		// Assign a random batch of words to the nearest centroids
+		for i := range batch {
+			batch[i] = vectors[rng.Intn(len(vectors))]
 		}
+		assign(batch, centroids, batchAssignments, batchDistances, workers, nil)
+
+		// Sum the words of each centroid, then move each centroid once, to
+		// the mean of all its words so far
+		sums := make(map[int][]float32)
+		batchCounts := make(map[int]int)
 		for i, vec := range batch {
-			cluster := clusterAssignments[i]
+			cluster := batchAssignments[i]
+			if sums[cluster] == nil {
+				sums[cluster] = make([]float32, dim)
+			}
 			for j := range vec {
-				clusterSums[cluster][j] += vec[j]
+				sums[cluster][j] += vec[j]
 			}
-			clusterCounts[cluster]++
+			batchCounts[cluster]++
 		}
-		for i := range centroids {
-			if clusterCounts[i] > 0 {
-				for j := range centroids[i] {
-					centroids[i][j] = clusterSums[i][j] / float32(clusterCounts[i])
-				}
+		movement := 0.0
+		for cluster, sum := range sums {
+			old := copyVector(centroids[cluster])
+			counts[cluster] += batchCounts[cluster]
+			total := float32(counts[cluster])
+			for j := range sum {
+				centroids[cluster][j] += (sum[j] - float32(batchCounts[cluster])*centroids[cluster][j]) / total
 			}
+			movement = math.Max(movement, cosineDistance(old, centroids[cluster]))
+		}
+
+		iterations.report(iteration)
+		if movement < epsilon {
+			iterations.finish()
+			fmt.Printf("Converged after %d iterations\n", iteration)
+			break
+		}
+		if iteration == maxIterations {
+			iterations.finish()
 		}
 	}
 
-	// Assign all points to the nearest centroid
-	clusters := make([][]string, k)
-	for i, vec := range vectors {
-		bestCluster := 0
-		bestDistance := cosineDistance(vec, centroids[0])
-		for j := 1; j < k; j++ {
-			distance := cosineDistance(vec, centroids[j])
-			if distance < bestDistance {
-				bestDistance = distance
-				bestCluster = j
+	// Assign all words to the nearest centroid, re-seeding empty clusters
+	assignments := make([]int, len(vectors))
+	distances := make([]float64, len(vectors))
+	for round := 0; ; round++ {
+		assigning := newProgress("Assigning words", len(vectors))
+		assign(vectors, centroids, assignments, distances, workers, assigning)
+		assigning.finish()
+
+		sizes := make([]int, k)
+		for _, cluster := range assignments {
+			sizes[cluster]++
+		}
+		var empty []int
+		for cluster, size := range sizes {
+			if size == 0 {
+				empty = append(empty, cluster)
 			}
 		}
-		clusters[bestCluster] = append(clusters[bestCluster], words[i])
+		if len(empty) == 0 || round == maxReseedRounds {
+			break
+		}
+		fmt.Printf("Re-seeding %d empty clusters\n", len(empty))
+		for _, cluster := range empty {
+			farthest := 0
+			for i, d := range distances {
+				if d > distances[farthest] {
+					farthest = i
+				}
+			}
+			centroids[cluster] = copyVector(vectors[farthest])
+			distances[farthest] = -1
+		}
 	}
 
+	// The centroid word of a cluster is its word nearest to the centroid
+	clusters := make([]cluster, k)
+	nearest := make([]int, k)
+	for i, c := range assignments {
+		if clusters[c].Members == nil || distances[i] < distances[nearest[c]] {
+			nearest[c] = i
+		}
+		clusters[c].Members = append(clusters[c].Members, words[i])
+	}
+	for c := range clusters {
+		if clusters[c].Members != nil {
+			clusters[c].Centroid = words[nearest[c]]
+		}
+	}
 	return clusters
 }
 
+// cluster is a cluster of words, as written by -format json
+type cluster struct {
+	ID       int      `json:"id"`
+	Centroid string   `json:"centroid"`
+	Members  []string `json:"members"`
+}
+
+// writeClusters writes clusters to w in format: pipe for the words of a
+// cluster joined by |, ere for an extended regular expression matching
+// them for grep -E -f, or json for an array of clusters.
+func writeClusters(w io.Writer, clusters []cluster, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(clusters)
+	}
+
+	for _, c := range clusters {
+		line := strings.Join(c.Members, "|")
+		if format == "ere" {
+			// regexp.QuoteMeta escapes a superset of the ERE metacharacters
+			quoted := make([]string, len(c.Members))
+			for i, word := range c.Members {
+				quoted[i] = regexp.QuoteMeta(word)
+			}
+			line = "\\b(" + strings.Join(quoted, "|") + ")\\b"
+		}
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func main() {
 	modelPath := flag.String("model", "", "Path to word2vec binary model")
 	k := flag.Int("k", 100, "Number of clusters")
 	batchSize := flag.Int("batch-size", 100, "Batch size for mini-batch k-means")
 	maxIterations := flag.Int("iterations", 100, "Maximum number of iterations for mini-batch k-means")
+	epsilon := flag.Float64("epsilon", 1e-4, "Stop once no centroid moves by more than this cosine distance in an iteration")
+	workers := flag.Int("workers", runtime.NumCPU(), "Number of goroutines assigning words to clusters")
+	seed := flag.Int64("seed", 0, "Seed of the random choices, for reproducible runs (default: a new seed each run)")
 	outputPath := flag.String("output", "clusters.txt", "Output file path")
+	format := flag.String("format", "pipe", "Output format: pipe (words joined by |), ere (a grep -E pattern per line) or json")
+	maxClusterSize := flag.Int("max-cluster-size", 0, "Leave out clusters of more words, e.g. too big for grep (0 for no limit)")
 	flag.Parse()
 
 	if *modelPath == "" {
 		log.Fatal("Please provide a path to the word2vec binary model")
 	}
+	if *k < 1 || *batchSize < 1 || *workers < 1 {
+		log.Fatal("-k, -batch-size and -workers must be positive")
+	}
+	if *format != "pipe" && *format != "ere" && *format != "json" {
+		log.Fatalf("Invalid -format %q: must be pipe, ere or json", *format)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	fmt.Printf("Random seed: %d\n", *seed)
 
-	// Load the word2vec model
-	model, err := LoadVectorModel(*modelPath)
+	// Load the word2vec model, its words in file order
+	model, err := modelio.Load(*modelPath)
 	if err != nil {
 		log.Fatalf("Failed to load model: %v", err)
 	}
 
-	// Get all words and vectors
-	var words []string
-	var vectors [][]float32
-	for word, vec := range model.(*VecModel32bit).Vectors {
-		words = append(words, word)
-		vectors = append(vectors, vec)
+	if *k > len(model.Words) {
+		log.Fatalf("-k must not exceed the %d words of the model", len(model.Words))
 	}
 
 	// Perform mini-batch k-means clustering
-	clusters := miniBatchKMeans(vectors, words, *k, *batchSize, *maxIterations)
+	rng := rand.New(rand.NewSource(*seed))
+	clusters := miniBatchKMeans(model.Vectors, model.Words, *k, *batchSize, *maxIterations, *workers, *epsilon, rng)
 
-	// Sort clusters by size (largest first)
-	sort.Slice(clusters, func(i, j int) bool {
-		return len(clusters[i]) > len(clusters[j])
+	// Sort clusters by size (largest first), leaving out empty and giant
+	// clusters, and number them in that order
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return len(clusters[i].Members) > len(clusters[j].Members)
 	})
+	kept := clusters[:0]
+	skipped := 0
+	for _, c := range clusters {
+		if *maxClusterSize > 0 && len(c.Members) > *maxClusterSize {
+			skipped++
+			continue
+		}
+		if len(c.Members) > 0 {
+			c.ID = len(kept) + 1
+			kept = append(kept, c)
+		}
+	}
+	clusters = kept
+	if skipped > 0 {
+		fmt.Printf("Left out %d clusters of more than %d words\n", skipped, *maxClusterSize)
+	}
 
 	// Write clusters to file
 	file, err := os.Create(*outputPath)
@@ -302,13 +483,12 @@ func main() {
 	defer file.Close()
 
 	writer := bufio.NewWriter(file)
-	for _, cluster := range clusters {
-		_, err := writer.WriteString(strings.Join(cluster, "|") + "\n")
-		if err != nil {
-			log.Fatalf("Failed to write to file: %v", err)
-		}
+	if err := writeClusters(writer, clusters, *format); err != nil {
+		log.Fatalf("Failed to write to file: %v", err)
+	}
+	if err := writer.Flush(); err != nil {
+		log.Fatalf("Failed to write to file: %v", err)
 	}
-	writer.Flush()
 
 	fmt.Printf("Clustering complete. %d clusters written to %s\n", len(clusters), *outputPath)
 }
@@ -0,0 +1,298 @@
+// A program to import embeddings saved with NumPy, e.g. from gensim, into
+// the binary format of w2vgrep. The vectors are a 2-D array of float16,
+// float32 or float64, one row per word, in a .npy file or in an array of a
+// .npz archive. The words are read from a vocabulary file, one per line in
+// the order of the rows; a count after the word, as in the vocabulary files
+// written by gensim, is ignored. Words should be listed most frequent first,
+// as w2vgrep takes the order of the model as the frequency rank of words.
+//
+// gensim's KeyedVectors.save writes the vectors of a model as a .npy file
+// next to the pickled model; its words, kv.index_to_key, can be written to a
+// vocabulary file with a line of Python (see the Readme).
+//
+// Usage: npy-to-bin [OPTIONS] -vectors vectors.npy -vocab vocab.txt -output model.bin
+// Options:
+//   -vectors string
+//         Path to the .npy file or .npz archive of the vectors (required)
+//   -array string
+//         Name of the array of a .npz archive (default: its only array, or "vectors")
+//   -vocab string
+//         Path to the vocabulary file, one word per line (required)
+//   -output string
+//         Path of the model file, ending in .bin or .f16.bin (required)
+//
+// Example:
+//   npy-to-bin -vectors model.kv.vectors.npy -vocab vocab.txt -output ../models/my-model.bin
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/float16"
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+)
+
+// npyMagic starts the .npy files.
+const npyMagic = "\x93NUMPY"
+
+var (
+	npyDescr   = regexp.MustCompile(`'descr':\s*'([<>|=]?)([a-z])([0-9]+)'`)
+	npyFortran = regexp.MustCompile(`'fortran_order':\s*(True|False)`)
+	npyShape   = regexp.MustCompile(`'shape':\s*\(([^)]*)\)`)
+)
+
+// npyArray reads the rows of a 2-D array of floats from a .npy file.
+type npyArray struct {
+	reader    *bufio.Reader
+	rows      int
+	columns   int
+	valueSize int
+	order     binary.ByteOrder
+	raw       []byte
+}
+
+func main() {
+	vectorsPath := flag.String("vectors", "", "Path to the .npy file or .npz archive of the vectors (required)")
+	arrayName := flag.String("array", "", "Name of the array of a .npz archive (default: its only array, or \"vectors\")")
+	vocabPath := flag.String("vocab", "", "Path to the vocabulary file, one word per line (required)")
+	output := flag.String("output", "", "Path of the model file, ending in .bin or .f16.bin (required)")
+	flag.Parse()
+
+	if *vectorsPath == "" || *vocabPath == "" || *output == "" {
+		fmt.Fprintln(os.Stderr, "Error: -vectors, -vocab and -output are required")
+		flag.Usage()
+		os.Exit(1)
+	}
+	format, err := modelio.FormatOf(*output)
+	if err != nil || format == modelio.Int8 {
+		fmt.Fprintln(os.Stderr, "Error: the output file name must end in .bin or .f16.bin; see quantize for 8-bit models")
+		os.Exit(1)
+	}
+
+	words, err := readVocabulary(*vocabPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading vocabulary: %v\n", err)
+		os.Exit(1)
+	}
+
+	input, closeInput, err := openArray(*vectorsPath, *arrayName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", *vectorsPath, err)
+		os.Exit(1)
+	}
+	defer closeInput()
+	array, err := newNpyArray(input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", *vectorsPath, err)
+		os.Exit(1)
+	}
+	if array.rows != len(words) {
+		fmt.Fprintf(os.Stderr, "Error: the array has %d rows but the vocabulary %d words\n", array.rows, len(words))
+		os.Exit(1)
+	}
+
+	if err := write(*output, format, words, array); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *output, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %d words of %d dimensions to %s\n", len(words), array.columns, *output)
+}
+
+// readVocabulary reads the words of a vocabulary file, one per line. A
+// count following the word is dropped.
+func readVocabulary(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		word := strings.TrimRight(scanner.Text(), "\r")
+		if i := strings.LastIndexAny(word, " \t"); i > 0 {
+			if _, err := strconv.ParseInt(word[i+1:], 10, 64); err == nil {
+				word = word[:i]
+			}
+		}
+		if word == "" || strings.ContainsAny(word, " \t") {
+			return nil, fmt.Errorf("%s:%d: a word of the model can be neither empty nor contain spaces", path, line)
+		}
+		words = append(words, word)
+	}
+	return words, scanner.Err()
+}
+
+// openArray opens the .npy file at path or, when path is a .npz archive,
+// its array name, and returns its content and a function closing it.
+func openArray(filePath, name string) (io.Reader, func() error, error) {
+	if !strings.HasSuffix(filePath, ".npz") {
+		if name != "" {
+			return nil, nil, errors.New("-array only applies to .npz archives")
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return file, file.Close, nil
+	}
+
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, strings.TrimSuffix(path.Base(f.Name), ".npy"))
+	}
+	if name == "" {
+		switch {
+		case len(names) == 1:
+			name = names[0]
+		case contains(names, "vectors"):
+			name = "vectors"
+		default:
+			archive.Close()
+			return nil, nil, fmt.Errorf("choose an array of the archive with -array: %s", strings.Join(names, ", "))
+		}
+	}
+	for i, f := range archive.File {
+		if names[i] != name {
+			continue
+		}
+		entry, err := f.Open()
+		if err != nil {
+			archive.Close()
+			return nil, nil, err
+		}
+		return entry, func() error {
+			entry.Close()
+			return archive.Close()
+		}, nil
+	}
+	archive.Close()
+	return nil, nil, fmt.Errorf("no array %q in the archive, which has: %s", name, strings.Join(names, ", "))
+}
+
+// contains reports whether names contains name.
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newNpyArray reads the header of a .npy file, which must hold a 2-D array
+// of floats in C order.
+func newNpyArray(input io.Reader) (*npyArray, error) {
+	reader := bufio.NewReader(input)
+	preamble := make([]byte, len(npyMagic)+2)
+	if _, err := io.ReadFull(reader, preamble); err != nil || string(preamble[:len(npyMagic)]) != npyMagic {
+		return nil, errors.New("not a .npy file")
+	}
+	// Version 1 has a 2-byte header length, later versions a 4-byte one
+	var headerLength uint32
+	if major := preamble[len(npyMagic)]; major == 1 {
+		var length uint16
+		if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+			return nil, err
+		}
+		headerLength = uint32(length)
+	} else if err := binary.Read(reader, binary.LittleEndian, &headerLength); err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerLength)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("failed to read header: %v", err)
+	}
+
+	descr := npyDescr.FindSubmatch(header)
+	fortran := npyFortran.FindSubmatch(header)
+	shape := npyShape.FindSubmatch(header)
+	if descr == nil || fortran == nil || shape == nil {
+		return nil, fmt.Errorf("invalid header %q", strings.TrimSpace(string(header)))
+	}
+	a := &npyArray{reader: reader, order: binary.LittleEndian}
+	if string(descr[1]) == ">" {
+		a.order = binary.BigEndian
+	}
+	a.valueSize, _ = strconv.Atoi(string(descr[3]))
+	if string(descr[2]) != "f" || (a.valueSize != 2 && a.valueSize != 4 && a.valueSize != 8) {
+		return nil, fmt.Errorf("the array holds %s%s values, not floats", descr[2], descr[3])
+	}
+	if string(fortran[1]) == "True" {
+		return nil, errors.New("the array is in Fortran order: save it with numpy.ascontiguousarray")
+	}
+	var dimensions []int
+	for _, field := range strings.Split(string(shape[1]), ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shape (%s)", shape[1])
+		}
+		dimensions = append(dimensions, n)
+	}
+	if len(dimensions) != 2 || dimensions[0] == 0 || dimensions[1] == 0 {
+		return nil, fmt.Errorf("the array has shape (%s); a matrix of one row per word is expected", shape[1])
+	}
+	a.rows, a.columns = dimensions[0], dimensions[1]
+	a.raw = make([]byte, a.columns*a.valueSize)
+	return a, nil
+}
+
+// next returns the next row of the array as float32.
+func (a *npyArray) next() ([]float32, error) {
+	if _, err := io.ReadFull(a.reader, a.raw); err != nil {
+		return nil, fmt.Errorf("failed to read vector: %v", err)
+	}
+	row := make([]float32, a.columns)
+	for i := range row {
+		b := a.raw[i*a.valueSize:]
+		switch a.valueSize {
+		case 2:
+			row[i] = float16.Float16(a.order.Uint16(b)).Float32()
+		case 4:
+			row[i] = math.Float32frombits(a.order.Uint32(b))
+		case 8:
+			row[i] = float32(math.Float64frombits(a.order.Uint64(b)))
+		}
+	}
+	return row, nil
+}
+
+// write writes the words and the rows of array to outputFile in format.
+func write(outputFile string, format modelio.Format, words []string, array *npyArray) error {
+	w, err := modelio.Create(outputFile, format, modelio.Header{Words: len(words), Dimensions: array.columns})
+	if err != nil {
+		return err
+	}
+	for _, word := range words {
+		row, err := array.next()
+		if err == nil {
+			err = w.Write(word, row)
+		}
+		if err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
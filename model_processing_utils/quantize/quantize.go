@@ -0,0 +1,302 @@
+// A program to convert a 32-bit Word2Vec binary model into the 8-bit
+// quantized format that w2vgrep loads from files ending in .8int.bin, a
+// quarter of the size, and to report how much quantization moved the
+// similarities of a sample of words.
+//
+// Each value is divided by a scale and rounded to an integer in -127..127.
+// With -scale global, one scale, from the largest absolute value of the
+// model, is used for all dimensions, which keeps the angles between vectors
+// up to rounding. With -scale per-dimension, each dimension is scaled by its
+// own largest absolute value, so that dimensions with small values keep more
+// precision; as w2vgrep scores the integers as they are, this also weighs
+// the dimensions differently. The quality report tells which is better for
+// a model.
+//
+// Usage: quantize [OPTIONS] -input model.bin -output model.8int.bin
+// Options:
+//   -input string
+//         Path to the 32-bit model file (required)
+//   -output string
+//         Path of the quantized model file, ending in .8int.bin (required)
+//   -scale string
+//         Scale values by the global or per-dimension largest absolute value (default "global")
+//   -sample string
+//         File of words, one per line, for the quality report
+//   -sample-size int
+//         Number of words drawn from the model for the report when -sample is not given (default 1000)
+//   -seed int
+//         Seed of the random draw of sample words (default 1)
+//
+// Example:
+//   go run . -input ../../models/glove/glove.6B.300d.bin -output glove.6B.300d.8int.bin -scale per-dimension
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+)
+
+// neighborCount is the number of nearest sample words compared by the
+// report for each sample word.
+const neighborCount = 10
+
+func main() {
+	input := flag.String("input", "", "Path to the 32-bit model file (required)")
+	output := flag.String("output", "", "Path of the quantized model file, ending in .8int.bin (required)")
+	scale := flag.String("scale", "global", "Scale values by the global or per-dimension largest absolute value")
+	sample := flag.String("sample", "", "File of words, one per line, for the quality report")
+	sampleSize := flag.Int("sample-size", 1000, "Number of words drawn from the model for the report when -sample is not given")
+	seed := flag.Int64("seed", 1, "Seed of the random draw of sample words")
+	flag.Parse()
+
+	if *input == "" || *output == "" {
+		fmt.Fprintln(os.Stderr, "Error: -input and -output are required")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if !strings.HasSuffix(*output, ".8int.bin") {
+		fmt.Fprintln(os.Stderr, "Error: the output file name must end in .8int.bin, which tells w2vgrep its format")
+		os.Exit(1)
+	}
+	if *scale != "global" && *scale != "per-dimension" {
+		fmt.Fprintf(os.Stderr, "Error: invalid -scale %q: must be global or per-dimension\n", *scale)
+		os.Exit(1)
+	}
+
+	format, err := modelio.Detect(*input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading model: %v\n", err)
+		os.Exit(1)
+	}
+	if format != modelio.Float32 {
+		fmt.Fprintln(os.Stderr, "Error: the input must be a 32-bit model")
+		os.Exit(1)
+	}
+	original, err := modelio.Load(*input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading model: %v\n", err)
+		os.Exit(1)
+	}
+
+	scales := quantizationScales(original, *scale == "per-dimension")
+	if err := writeQuantized(*output, original, scales); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *output, err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d words of %d dimensions to %s\n", len(original.Words), original.Dimensions(), *output)
+
+	// The report compares the file as w2vgrep loads it
+	quantized, err := model.LoadVectorModel(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading %s: %v\n", *output, err)
+		os.Exit(1)
+	}
+	vectors := make(map[string][]float32, len(original.Words))
+	for i, word := range original.Words {
+		vectors[word] = original.Vectors[i]
+	}
+	var sampleWords []string
+	if *sample != "" {
+		sampleWords, err = readWords(*sample, vectors)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading sample words: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		sampleWords = drawWords(original.Words, *sampleSize, *seed)
+	}
+	report(vectors, quantized, sampleWords)
+}
+
+// quantizationScales returns the scale of each dimension: the largest
+// absolute value of the dimension, or of the whole model when not
+// perDimension, divided by 127.
+func quantizationScales(m *modelio.Model, perDimension bool) []float64 {
+	largest := make([]float64, m.Dimensions())
+	for _, vector := range m.Vectors {
+		for i, x := range vector {
+			largest[i] = math.Max(largest[i], math.Abs(float64(x)))
+		}
+	}
+
+	if !perDimension {
+		global := 0.0
+		for _, x := range largest {
+			global = math.Max(global, x)
+		}
+		for i := range largest {
+			largest[i] = global
+		}
+	}
+	scales := make([]float64, len(largest))
+	for i, x := range largest {
+		scales[i] = x / 127
+	}
+	return scales
+}
+
+// quantize returns vector with each value divided by its scale and rounded.
+func quantize(vector []float32, scales []float64) []int8 {
+	out := make([]int8, len(vector))
+	for i, x := range vector {
+		if scales[i] == 0 {
+			continue
+		}
+		q := math.Round(float64(x) / scales[i])
+		out[i] = int8(math.Max(-127, math.Min(127, q)))
+	}
+	return out
+}
+
+// writeQuantized writes the quantized vectors of m to outputFile in the
+// 8-bit format, with the smallest and largest original values.
+func writeQuantized(outputFile string, m *modelio.Model, scales []float64) error {
+	minValue, maxValue := float32(math.Inf(1)), float32(math.Inf(-1))
+	for _, vector := range m.Vectors {
+		for _, x := range vector {
+			minValue = float32(math.Min(float64(minValue), float64(x)))
+			maxValue = float32(math.Max(float64(maxValue), float64(x)))
+		}
+	}
+
+	header := modelio.Header{Words: len(m.Words), Dimensions: m.Dimensions(), Min: minValue, Max: maxValue}
+	w, err := modelio.Create(outputFile, modelio.Int8, header)
+	if err != nil {
+		return err
+	}
+	for i, word := range m.Words {
+		if err := w.Write(word, quantize(m.Vectors[i], scales)); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// readWords reads the words of a file, one per line, that are in vectors.
+func readWords(path string, vectors map[string][]float32) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if _, ok := vectors[word]; word != "" && ok {
+			words = append(words, word)
+		}
+	}
+	return words, scanner.Err()
+}
+
+// drawWords returns n words drawn at random from words, or all of them when
+// there are fewer.
+func drawWords(words []string, n int, seed int64) []string {
+	if n >= len(words) {
+		return words
+	}
+	rng := rand.New(rand.NewSource(seed))
+	drawn := make([]string, n)
+	for i, j := range rng.Perm(len(words))[:n] {
+		drawn[i] = words[j]
+	}
+	return drawn
+}
+
+// report prints how the cosine similarities between the pairs of sample
+// words moved from the original to the quantized model, and how many of the
+// nearest sample words of each word stayed among its nearest.
+func report(original map[string][]float32, quantized model.VectorModel, words []string) {
+	if len(words) < 2 {
+		fmt.Println("Too few sample words for a quality report")
+		return
+	}
+
+	n := len(words)
+	scorer := similarity.Cosine{}
+	before := make([][]float64, n)
+	after := make([][]float64, n)
+	for i := range words {
+		before[i] = make([]float64, n)
+		after[i] = make([]float64, n)
+	}
+	var drifts []float64
+	sum, sumSquares := 0.0, 0.0
+	worst, worstI, worstJ := -1.0, 0, 0
+	for i := 0; i < n; i++ {
+		a := original[words[i]]
+		qa, _ := quantized.GetEmbedding(words[i])
+		for j := i + 1; j < n; j++ {
+			b := original[words[j]]
+			qb, _ := quantized.GetEmbedding(words[j])
+			before[i][j] = scorer.Score(a, b)
+			after[i][j] = scorer.Score(qa, qb)
+			before[j][i], after[j][i] = before[i][j], after[i][j]
+
+			drift := math.Abs(after[i][j] - before[i][j])
+			drifts = append(drifts, drift)
+			sum += drift
+			sumSquares += drift * drift
+			if drift > worst {
+				worst, worstI, worstJ = drift, i, j
+			}
+		}
+	}
+	sort.Float64s(drifts)
+	percentile := func(p float64) float64 {
+		return drifts[int(p*float64(len(drifts)-1))]
+	}
+
+	kept, compared := 0, 0
+	for i := range words {
+		nearBefore := nearest(before[i], i)
+		nearAfter := make(map[int]bool)
+		for _, j := range nearest(after[i], i) {
+			nearAfter[j] = true
+		}
+		for _, j := range nearBefore {
+			if nearAfter[j] {
+				kept++
+			}
+		}
+		compared += len(nearBefore)
+	}
+
+	fmt.Printf("Similarity drift over %d pairs of %d sample words:\n", len(drifts), n)
+	fmt.Printf("  mean %.5f, RMS %.5f\n", sum/float64(len(drifts)), math.Sqrt(sumSquares/float64(len(drifts))))
+	fmt.Printf("  median %.5f, 95th percentile %.5f, 99th percentile %.5f\n", percentile(0.5), percentile(0.95), percentile(0.99))
+	fmt.Printf("  largest %.5f: %s / %s, %.4f -> %.4f\n", worst, words[worstI], words[worstJ], before[worstI][worstJ], after[worstI][worstJ])
+	fmt.Printf("Nearest %d sample words kept: %.1f%%\n", neighborCount, 100*float64(kept)/float64(compared))
+}
+
+// nearest returns the indexes of the neighborCount highest scores, except
+// the score at self.
+func nearest(scores []float64, self int) []int {
+	indexes := make([]int, 0, len(scores)-1)
+	for i := range scores {
+		if i != self {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Slice(indexes, func(a, b int) bool {
+		return scores[indexes[a]] > scores[indexes[b]]
+	})
+	if len(indexes) > neighborCount {
+		indexes = indexes[:neighborCount]
+	}
+	return indexes
+}
@@ -1,110 +1,72 @@
 package main
 
 import (
-	"bufio"
-	"encoding/binary"
-	"fmt"
-	"io"
-	"os"
-	"strings"
 	"flag"
+	"fmt"
 
+	"github.com/arunsupe/semantic-grep/modules/modelio"
 	"gonum.org/v1/gonum/mat"
 	"gonum.org/v1/gonum/stat"
 )
 
-type VecModel32bit struct {
-	Vectors        map[string][]float32
-	VectorsReduced map[string][]float32
-	Size           int
-}
-
-// LoadModel loads a 32-bit floating point Word2Vec model from a file
-func (m *VecModel32bit) LoadModel(filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %v", err)
-	}
-	defer file.Close()
-
-	reader := bufio.NewReader(file)
-
-	// Read header
-	var vocabSize, vectorSize int
-	_, err = fmt.Fscanf(reader, "%d %d\n", &vocabSize, &vectorSize)
-	if err != nil {
-		return fmt.Errorf("failed to read header: %v\nCheck that you have a valid model file", err)
-	}
-
-	// Validate header
-	if vocabSize <= 0 || vectorSize <= 0 {
-		return fmt.Errorf("invalid header: vocabSize=%d, vectorSize=%d\nCheck that you have a valid model file", vocabSize, vectorSize)
-	}
-
-	m.Vectors = make(map[string][]float32, vocabSize)
-	m.Size = vectorSize
-
-	for i := 0; i < vocabSize; i++ {
-		word, err := reader.ReadString(' ')
-		if err != nil {
-			return fmt.Errorf("failed to read word: %v", err)
-		}
-		word = strings.TrimSpace(word)
-
-		vector := make([]float32, vectorSize)
-		for j := 0; j < vectorSize; j++ {
-			err := binary.Read(reader, binary.LittleEndian, &vector[j])
-			if err != nil {
-				return fmt.Errorf("failed to read vector: %v", err)
-			}
-		}
-
-		// Check if we've reached the end of the record
-		nextByte, err := reader.Peek(1)
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("unexpected error reading next byte: %v", err)
-		}
-		if len(nextByte) > 0 && nextByte[0] == '\n' {
-			reader.ReadByte() // consume the newline
-		}
-
-		m.Vectors[word] = vector
-	}
-
-	// Check if we've reached the end of the file
-	_, err = reader.ReadByte()
-	if err != io.EOF {
-		return fmt.Errorf("unexpected data at end of file.\nCheck that you have a valid model file")
-	}
-
-	return nil
-}
-
-// ReduceDimensions reduces the dimensions of the vectors to 100 using PCA
-func (m *VecModel32bit) ReduceDimensions(targetDim int) error {
-	vocabSize := len(m.Vectors)
-	if vocabSize == 0 || m.Size <= 100 {
-		return fmt.Errorf("no vectors to reduce or vector size is already 100 or less")
+// principalComponents returns the vectors of m as a matrix, one row per
+// word, and their principal components
+func principalComponents(m *modelio.Model) (*mat.Dense, *stat.PC, error) {
+	vocabSize, size := len(m.Words), m.Dimensions()
+	if vocabSize < 2 {
+		return nil, nil, fmt.Errorf("the model has too few words for PCA")
 	}
 
-	// Convert map to matrix
-	data := make([]float64, 0, vocabSize*m.Size)
-	words := make([]string, 0, vocabSize)
-	for word, vector := range m.Vectors {
-		words = append(words, word)
+	// Convert vectors to matrix
+	data := make([]float64, 0, vocabSize*size)
+	for _, vector := range m.Vectors {
 		for _, v := range vector {
 			data = append(data, float64(v))
 		}
 	}
-
-	originalMatrix := mat.NewDense(vocabSize, m.Size, data)
+	originalMatrix := mat.NewDense(vocabSize, size, data)
 
 	// Perform PCA
 	var pc stat.PC
 	ok := pc.PrincipalComponents(originalMatrix, nil)
 	if !ok {
-		return fmt.Errorf("PCA computation failed")
+		return nil, nil, fmt.Errorf("PCA computation failed")
 	}
+	return originalMatrix, &pc, nil
+}
+
+// explainedVariance returns, for each principal component, the fraction of
+// the variance of the vectors explained by it and all the previous ones
+func explainedVariance(pc *stat.PC) []float64 {
+	variances := pc.VarsTo(nil)
+	total := 0.0
+	for _, v := range variances {
+		total += v
+	}
+	cumulative := make([]float64, len(variances))
+	sum := 0.0
+	for i, v := range variances {
+		sum += v
+		cumulative[i] = sum / total
+	}
+	return cumulative
+}
+
+// dimensionsFor returns the smallest number of components explaining at
+// least fraction of the variance
+func dimensionsFor(cumulative []float64, fraction float64) int {
+	for i, explained := range cumulative {
+		if explained >= fraction {
+			return i + 1
+		}
+	}
+	return len(cumulative)
+}
+
+// reduceDimensions returns the vectors of m, whose matrix is data, projected
+// on their first targetDim principal components
+func reduceDimensions(m *modelio.Model, data *mat.Dense, pc *stat.PC, targetDim int) *modelio.Model {
+	vocabSize, size := data.Dims()
 
 	// Get the principal component direction vectors
 	var vec mat.Dense
@@ -112,73 +74,28 @@ func (m *VecModel32bit) ReduceDimensions(targetDim int) error {
 
 	// Select the first targetDim columns of the principal components
 	proj := mat.NewDense(vocabSize, targetDim, nil)
-	proj.Mul(originalMatrix, vec.Slice(0, m.Size, 0, targetDim))
+	proj.Mul(data, vec.Slice(0, size, 0, targetDim))
 
-	// Convert reduced matrix back to map
-	m.VectorsReduced = make(map[string][]float32, vocabSize)
-	for i, word := range words {
+	// Convert reduced matrix back to vectors, in the order of the words
+	reduced := &modelio.Model{Words: m.Words, Vectors: make([][]float32, vocabSize)}
+	for i := range m.Words {
 		reducedVector := make([]float32, targetDim)
 		for j := 0; j < targetDim; j++ {
 			reducedVector[j] = float32(proj.At(i, j))
 		}
-		m.VectorsReduced[word] = reducedVector
+		reduced.Vectors[i] = reducedVector
 	}
-
-	return nil
+	return reduced
 }
 
-// SaveReducedModel saves the reduced model to a file in the same binary format
-func (m *VecModel32bit) SaveReducedModel(filename string) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %v", err)
-	}
-	defer file.Close()
-
-	writer := bufio.NewWriter(file)
-
-	// Get the reduced vector size from the first vector in the map
-	var reducedSize int
-	for _, vector := range m.VectorsReduced {
-		reducedSize = len(vector)
-		break
+// printVariance prints the variance explained by the first components
+func printVariance(cumulative []float64, components int) {
+	fmt.Println("Component  Explained  Cumulative")
+	previous := 0.0
+	for i := 0; i < components; i++ {
+		fmt.Printf("%9d  %8.2f%%  %9.2f%%\n", i+1, 100*(cumulative[i]-previous), 100*cumulative[i])
+		previous = cumulative[i]
 	}
-
-	// Write header: vocabSize and reduced vector size
-	vocabSize := len(m.VectorsReduced)
-	_, err = fmt.Fprintf(writer, "%d %d\n", vocabSize, reducedSize)
-	if err != nil {
-		return fmt.Errorf("failed to write header: %v", err)
-	}
-
-	// Write each word and its reduced vector
-	for word, vector := range m.VectorsReduced {
-		_, err := writer.WriteString(word + " ")
-		if err != nil {
-			return fmt.Errorf("failed to write word: %v", err)
-		}
-
-		for _, value := range vector {
-			err := binary.Write(writer, binary.LittleEndian, value)
-			if err != nil {
-				return fmt.Errorf("failed to write vector value: %v", err)
-			}
-		}
-
-		// Write a newline character after each vector
-		err = writer.WriteByte('\n')
-		if err != nil {
-			return fmt.Errorf("failed to write newline: %v", err)
-		}
-	}
-
-	// Flush the buffer to ensure all data is written to the file
-	err = writer.Flush()
-	if err != nil {
-		return fmt.Errorf("failed to flush writer: %v", err)
-	}
-
-	return nil
 }
 
 func main() {
@@ -186,6 +103,7 @@ func main() {
 	inputFile := flag.String("input", "", "Path to the input Word2Vec model file")
 	outputFile := flag.String("output", "", "Path to the output reduced model file")
 	targetDim := flag.Int("dim", 100, "Target dimension for PCA reduction")
+	variance := flag.Float64("variance", 0, "Instead of -dim, keep the fewest dimensions retaining this fraction of the variance, e.g. 0.95")
 	flag.Parse()
 
 	// Check if input and output paths are provided
@@ -193,28 +111,46 @@ func main() {
 		fmt.Println("Please provide both input and output file paths using -input and -output flags.")
 		return
 	}
+	if *variance < 0 || *variance > 1 {
+		fmt.Println("Please provide a -variance between 0 and 1, e.g. 0.95.")
+		return
+	}
 
 	// Load the model
-	model := VecModel32bit{}
-	err := model.LoadModel(*inputFile)
+	model, err := modelio.Load(*inputFile)
 	if err != nil {
 		fmt.Println("Error loading model:", err)
 		return
 	}
 
-	// Reduce dimensions
-	err = model.ReduceDimensions(*targetDim)
+	// Compute the principal components
+	data, pc, err := principalComponents(model)
 	if err != nil {
 		fmt.Println("Error reducing dimensions:", err)
 		return
 	}
+	cumulative := explainedVariance(pc)
+	if *variance > 0 {
+		*targetDim = dimensionsFor(cumulative, *variance)
+	}
+	// There are as many components as dimensions, or words if fewer
+	if *targetDim < 1 || *targetDim >= model.Dimensions() || *targetDim > len(cumulative) {
+		fmt.Printf("Error reducing dimensions: -dim must be between 1 and %d for a model of %d dimensions and %d words\n",
+			min(model.Dimensions()-1, len(cumulative)), model.Dimensions(), len(model.Words))
+		return
+	}
+	printVariance(cumulative, *targetDim)
+	fmt.Printf("Keeping %d of %d dimensions, retaining %.2f%% of the variance\n", *targetDim, model.Dimensions(), 100*cumulative[*targetDim-1])
 
-	// Save the reduced model
-	err = model.SaveReducedModel(*outputFile)
+	// Reduce dimensions
+	reduced := reduceDimensions(model, data, pc, *targetDim)
+
+	// Save the reduced model, in the format of its name (.bin or .f16.bin)
+	err = modelio.Save(*outputFile, reduced)
 	if err != nil {
 		fmt.Println("Error saving reduced model:", err)
 		return
 	}
 
 	fmt.Println("Reduced model saved successfully!")
-}
\ No newline at end of file
+}
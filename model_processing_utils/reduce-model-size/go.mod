@@ -2,4 +2,17 @@ module reduce-pca
 
 go 1.22.5
 
-require gonum.org/v1/gonum v0.15.1 // indirect
+require (
+	github.com/arunsupe/semantic-grep v0.0.0
+	gonum.org/v1/gonum v0.15.1
+)
+
+require (
+	github.com/clipperhouse/uax29 v1.13.0 // indirect
+	github.com/jessevdk/go-flags v1.6.1 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+)
+
+// The model file formats are shared with w2vgrep
+replace github.com/arunsupe/semantic-grep => ../..
@@ -1,5 +1,6 @@
 // A program to find synonyms for a given word in a Word2Vec model.
-// It finds words in the model with similarity scores above a given threshold.
+// It finds words in the model with similarity scores above a given threshold,
+// or the N most similar words, and prints them by descending similarity.
 //
 // Usage: synonym-finder [OPTIONS] QUERY
 //   QUERY is the word to find similar words for (required)
@@ -7,7 +8,9 @@
 //   -model_path string
 //         Path to the Word2Vec model file (required)
 //   -threshold float
-//         Similarity threshold for matching (required) (default 0.7)
+//         Similarity threshold for matching (required unless -n is used) (default 0.7)
+//   -n, -top int
+//         Print only the N most similar words
 //   -ignore-case
 //         Ignore case. Note: word2vec is case-sensitive. Ignoring case may lead to unexpected results
 //   -f string
@@ -16,18 +19,20 @@
 //
 // Example:
 //   synonym-finder -model_path ../models/glove/glove.6B.300d.bin -threshold 0.5 angry
+//   synonym-finder -model_path ../models/glove/glove.6B.300d.bin -n 10 angry
 
 package main
 
 import (
 	"bufio"
-	"encoding/binary"
 	"flag"
 	"fmt"
-	"io"
 	"math"
 	"os"
+	"sort"
 	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/modelio"
 )
 
 // Options defines the command-line options
@@ -37,106 +42,43 @@ type Options struct {
 	IgnoreCase          bool
 	PatternFile         string
 	OnlyMatching        bool // New field for -o flag
+	Top                 int  // Print only the N most similar words, 0 for all above the threshold
 }
 
-// VectorModel interface defines the methods that all vector models must implement
-type VectorModel interface {
-	LoadModel(filename string) error
-	GetEmbedding(token string) interface{}
+// similarWord is a word of the model and its similarity to the query
+type similarWord struct {
+	word       string
+	similarity float64
 }
 
-// VecModel32bit represents a 32-bit floating point Word2Vec model
-type VecModel32bit struct {
-	Vectors map[string][]float32
-	Size    int
+// wordVectors is a model loaded with modelio, with its words indexed
+type wordVectors struct {
+	*modelio.Model
+	index map[string]int
 }
 
-// LoadModel loads a 32-bit floating point Word2Vec model from a file
-func (m *VecModel32bit) LoadModel(filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %v", err)
-	}
-	defer file.Close()
-
-	reader := bufio.NewReader(file)
-
-	// Read header
-	var vocabSize, vectorSize int
-	_, err = fmt.Fscanf(reader, "%d %d\n", &vocabSize, &vectorSize)
+// loadWordVectors loads the model file at path
+func loadWordVectors(path string) (*wordVectors, error) {
+	model, err := modelio.Load(path)
 	if err != nil {
-		return fmt.Errorf("failed to read header: %v\nCheck that you have a valid model file", err)
-	}
-
-	// Validate header
-	if vocabSize <= 0 || vectorSize <= 0 {
-		return fmt.Errorf("invalid header: vocabSize=%d, vectorSize=%d\nCheck that you have a valid model file", vocabSize, vectorSize)
+		return nil, err
 	}
-
-	m.Vectors = make(map[string][]float32, vocabSize)
-	m.Size = vectorSize
-
-	for i := 0; i < vocabSize; i++ {
-		word, err := reader.ReadString(' ')
-		if err != nil {
-			return fmt.Errorf("failed to read word: %v", err)
+	index := make(map[string]int, len(model.Words))
+	for i, word := range model.Words {
+		if _, ok := index[word]; !ok {
+			index[word] = i
 		}
-		word = strings.TrimSpace(word)
-
-		vector := make([]float32, vectorSize)
-		for j := 0; j < vectorSize; j++ {
-			err := binary.Read(reader, binary.LittleEndian, &vector[j])
-			if err != nil {
-				return fmt.Errorf("failed to read vector: %v", err)
-			}
-		}
-
-		// Check if we've reached the end of the record
-		nextByte, err := reader.Peek(1)
-		if err != nil && err != io.EOF {
-			return fmt.Errorf("unexpected error reading next byte: %v", err)
-		}
-		if len(nextByte) > 0 && nextByte[0] == '\n' {
-			reader.ReadByte() // consume the newline
-		}
-
-		m.Vectors[word] = vector
 	}
-
-	// Check if we've reached the end of the file
-	_, err = reader.ReadByte()
-	if err != io.EOF {
-		return fmt.Errorf("unexpected data at end of file.\nCheck that you have a valid model file")
-	}
-
-	return nil
+	return &wordVectors{Model: model, index: index}, nil
 }
 
-// GetEmbedding returns the vector embedding of a token for the 32-bit model
-func (m *VecModel32bit) GetEmbedding(token string) interface{} {
-	vec, ok := m.Vectors[token]
+// vector returns the vector of word, and false when it is not in the model
+func (m *wordVectors) vector(word string) ([]float32, bool) {
+	i, ok := m.index[word]
 	if !ok {
-		return make([]float32, m.Size)
-	}
-	return vec
-}
-
-// LoadVectorModel loads either a 32-bit or 8-bit model based on the file extension
-func LoadVectorModel(filename string) (VectorModel, error) {
-	var model VectorModel
-
-	if strings.HasSuffix(filename, ".bin") {
-		model = &VecModel32bit{}
-	} else {
-		return nil, fmt.Errorf("unsupported file format")
-	}
-
-	err := model.LoadModel(filename)
-	if err != nil {
-		return nil, err
+		return nil, false
 	}
-
-	return model, nil
+	return m.Vectors[i], true
 }
 
 // calculateSimilarity calculates the cosine similarity between two vectors
@@ -152,27 +94,48 @@ func calculateSimilarity32bit(vec1, vec2 []float32) float64 {
 	return dotProduct / (math.Sqrt(norm1) * math.Sqrt(norm2))
 }
 
-// findSimilarWords finds words in the model that are similar to the query word above the given threshold
-func findSimilarWords(model VectorModel, query string, threshold float64, onlyMatching bool) error {
-	queryEmbedding := model.GetEmbedding(query).([]float32)
-	if len(queryEmbedding) == 0 {
+// findSimilarWords finds words in the model that are similar to the query word above the given threshold,
+// and prints them by descending similarity. When top is positive, only the top most similar are printed.
+func findSimilarWords(model *wordVectors, query string, threshold float64, top int, onlyMatching bool) error {
+	queryEmbedding, ok := model.vector(query)
+	if !ok {
 		return fmt.Errorf("query word not found in model")
 	}
 
 	if onlyMatching {
 		fmt.Println(query) // Print the bare query
+	} else if top > 0 && math.IsInf(threshold, -1) {
+		fmt.Printf("The %d words most similar to '%s':\n", top, query)
+	} else if top > 0 {
+		fmt.Printf("The %d words most similar to '%s' with similarity >= %.2f:\n", top, query, threshold)
 	} else {
 		fmt.Printf("Words similar to '%s' with similarity >= %.2f:\n", query, threshold)
 	}
 
-	for word, embedding := range model.(*VecModel32bit).Vectors {
-		similarity := calculateSimilarity32bit(queryEmbedding, embedding)
+	var similar []similarWord
+	for i, word := range model.Words {
+		similarity := calculateSimilarity32bit(queryEmbedding, model.Vectors[i])
 		if similarity >= threshold && similarity < 1.0 {
-			if onlyMatching {
-				fmt.Println(word)
-			} else {
-				fmt.Printf("%s %.4f\n", word, similarity)
-			}
+			similar = append(similar, similarWord{word, similarity})
+		}
+	}
+
+	// Sort by similarity, then by word for stable output
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].similarity != similar[j].similarity {
+			return similar[i].similarity > similar[j].similarity
+		}
+		return similar[i].word < similar[j].word
+	})
+	if top > 0 && len(similar) > top {
+		similar = similar[:top]
+	}
+
+	for _, s := range similar {
+		if onlyMatching {
+			fmt.Println(s.word)
+		} else {
+			fmt.Printf("%s %.4f\n", s.word, s.similarity)
 		}
 	}
 
@@ -180,7 +143,7 @@ func findSimilarWords(model VectorModel, query string, threshold float64, onlyMa
 }
 
 // findSimilarWordsForPatterns finds similar words for each pattern in the given file
-func findSimilarWordsForPatterns(model VectorModel, patternFile string, threshold float64, onlyMatching bool) error {
+func findSimilarWordsForPatterns(model *wordVectors, patternFile string, threshold float64, top int, onlyMatching bool) error {
 	file, err := os.Open(patternFile)
 	if err != nil {
 		return fmt.Errorf("failed to open pattern file: %v", err)
@@ -194,7 +157,7 @@ func findSimilarWordsForPatterns(model VectorModel, patternFile string, threshol
 			continue
 		}
 
-		err := findSimilarWords(model, pattern, threshold, onlyMatching)
+		err := findSimilarWords(model, pattern, threshold, top, onlyMatching)
 		if err != nil {
 			fmt.Printf("Warning: %v\n", err)
 		}
@@ -215,6 +178,8 @@ func main() {
 	flag.BoolVar(&opts.IgnoreCase, "ignore-case", false, "Ignore case. Note: word2vec is case-sensitive. Ignoring case may lead to unexpected results")
 	flag.StringVar(&opts.PatternFile, "f", "", "File containing patterns, one per line")
 	flag.BoolVar(&opts.OnlyMatching, "o", false, "Print only matching tokens")
+	flag.IntVar(&opts.Top, "n", 0, "Print only the N most similar words, by descending similarity")
+	flag.IntVar(&opts.Top, "top", 0, "Same as -n")
 
 	// Custom usage message
 	flag.Usage = func() {
@@ -226,6 +191,7 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  %s -model_path path/to/model.bin -threshold 0.8 cat\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -model_path path/to/model.bin -threshold 0.8 -f patterns.txt\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -model_path path/to/model.bin -threshold 0.8 -o cat\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -model_path path/to/model.bin -n 10 cat\n", os.Args[0])
 	}
 
 	flag.Parse()
@@ -236,20 +202,36 @@ func main() {
 		os.Exit(1)
 	}
 
-	if flag.Lookup("threshold").Value.String() == "0.7" {
-		fmt.Fprintln(os.Stderr, "Error: Threshold is required. Please provide it via -threshold flag.")
-		flag.Usage()
+	if opts.Top < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -n must be positive.")
 		os.Exit(1)
 	}
 
-	model, err := LoadVectorModel(opts.ModelPath)
+	// The threshold is required, unless -n selects the words; then it only
+	// filters when it is given too
+	thresholdSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "threshold" {
+			thresholdSet = true
+		}
+	})
+	if !thresholdSet {
+		if opts.Top == 0 {
+			fmt.Fprintln(os.Stderr, "Error: Threshold is required. Please provide it via -threshold flag, or use -n.")
+			flag.Usage()
+			os.Exit(1)
+		}
+		opts.SimilarityThreshold = math.Inf(-1)
+	}
+
+	model, err := loadWordVectors(opts.ModelPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading model: %v\n", err)
 		os.Exit(1)
 	}
 
 	if opts.PatternFile != "" {
-		err = findSimilarWordsForPatterns(model, opts.PatternFile, opts.SimilarityThreshold, opts.OnlyMatching)
+		err = findSimilarWordsForPatterns(model, opts.PatternFile, opts.SimilarityThreshold, opts.Top, opts.OnlyMatching)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error processing pattern file: %v\n", err)
 			os.Exit(1)
@@ -263,7 +245,7 @@ func main() {
 		}
 
 		query := args[0]
-		err = findSimilarWords(model, query, opts.SimilarityThreshold, opts.OnlyMatching)
+		err = findSimilarWords(model, query, opts.SimilarityThreshold, opts.Top, opts.OnlyMatching)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error finding similar words: %v\n", err)
 			os.Exit(1)
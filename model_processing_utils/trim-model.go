@@ -0,0 +1,199 @@
+// A program to make a model smaller by keeping only its most frequent words.
+// Models are written most frequent word first, so by default the first N
+// words of the model are kept. With -frequencies, the words are instead
+// ranked by the counts of an external frequency list, e.g. of the corpora
+// to be searched, and only the words of the list are kept; the output is
+// then written in the order of the list, so that the ranks w2vgrep derives
+// from the word order stay frequency ranks.
+//
+// The input may be in any format w2vgrep loads. The output is written in
+// the format of its name: 32-bit (.bin) or half precision (.f16.bin), or
+// 8-bit (.8int.bin) when the input is 8-bit too. Combined with
+// reduce-model-size, this makes large fastText models practical on laptops.
+//
+// Usage: trim-model [OPTIONS] -input model.bin -output small.bin
+// Options:
+//   -input string
+//         Path to the model file (required)
+//   -output string
+//         Path of the trimmed model file (required)
+//   -top int
+//         Keep the N most frequent words
+//   -frequencies string
+//         File of words and their counts, one "word count" pair per line
+//   -min-count int
+//         With -frequencies, keep only the words counted at least this many times
+//
+// Example:
+//   trim-model -input ../models/fasttext/cc.fr.300.bin -output cc.fr.300.100k.bin -top 100000
+//   trim-model -input ../models/fasttext/cc.fr.300.bin -output cc.fr.300.f16.bin -frequencies fr-counts.txt -min-count 5
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+)
+
+// keptWord is a word of the input kept in the trimmed model
+type keptWord struct {
+	word   string
+	vector interface{}
+	count  int64 // count in the frequency list, if any
+}
+
+func main() {
+	input := flag.String("input", "", "Path to the model file (required)")
+	output := flag.String("output", "", "Path of the trimmed model file (required)")
+	top := flag.Int("top", 0, "Keep the N most frequent words")
+	frequencies := flag.String("frequencies", "", "File of words and their counts, one \"word count\" pair per line")
+	minCount := flag.Int64("min-count", 0, "With -frequencies, keep only the words counted at least this many times")
+	flag.Parse()
+
+	if *input == "" || *output == "" {
+		fmt.Fprintln(os.Stderr, "Error: -input and -output are required")
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *top <= 0 && *frequencies == "" {
+		fmt.Fprintln(os.Stderr, "Error: -top or -frequencies is required")
+		os.Exit(1)
+	}
+	if *minCount != 0 && *frequencies == "" {
+		fmt.Fprintln(os.Stderr, "Error: -min-count requires -frequencies")
+		os.Exit(1)
+	}
+	format, err := modelio.FormatOf(*output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error: the output file name must end in .bin, .f16.bin or .8int.bin")
+		os.Exit(1)
+	}
+
+	var counts map[string]int64
+	if *frequencies != "" {
+		counts, err = readCounts(*frequencies)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading frequencies: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	r, err := modelio.Open(*input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading model: %v\n", err)
+		os.Exit(1)
+	}
+	defer r.Close()
+	if format == modelio.Int8 && r.Format() != modelio.Int8 {
+		fmt.Fprintln(os.Stderr, "Error: only 8-bit models can be trimmed to an 8-bit model; see quantize")
+		os.Exit(1)
+	}
+
+	kept, total, err := trim(r, counts, *minCount, *top)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading model: %v\n", err)
+		os.Exit(1)
+	}
+	if len(kept) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no word of the model is kept")
+		os.Exit(1)
+	}
+
+	if err := write(*output, format, r.Format(), r.Header(), kept); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", *output, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Kept %d of %d words in %s\n", len(kept), total, *output)
+}
+
+// readCounts reads a frequency list of "word count" lines, separated by
+// spaces or tabs. A word listed twice gets the sum of its counts.
+func readCounts(path string) (map[string]int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	counts := make(map[string]int64)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a word and its count", path, line)
+		}
+		count, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid count %q", path, line, fields[1])
+		}
+		counts[fields[0]] += count
+	}
+	return counts, scanner.Err()
+}
+
+// trim reads the words of r and returns those kept, most frequent first,
+// and the number of words read. Without counts, the first top words are
+// kept. With counts, the words counted at least minCount times are kept,
+// and only the top most counted ones when top is positive.
+func trim(r *modelio.Reader, counts map[string]int64, minCount int64, top int) ([]keptWord, int, error) {
+	var kept []keptWord
+	total := 0
+	for r.Next() {
+		total++
+		if counts == nil {
+			if len(kept) < top {
+				kept = append(kept, keptWord{word: r.Word(), vector: r.Vector()})
+			}
+			continue
+		}
+		if count, ok := counts[r.Word()]; ok && count >= minCount {
+			kept = append(kept, keptWord{word: r.Word(), vector: r.Vector(), count: count})
+		}
+	}
+	if err := r.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if counts != nil {
+		// Words of equal count keep the order of the model
+		sort.SliceStable(kept, func(i, j int) bool { return kept[i].count > kept[j].count })
+		if top > 0 && len(kept) > top {
+			kept = kept[:top]
+		}
+	}
+	return kept, total, nil
+}
+
+// write writes the kept words, read from a model in inputFormat, to
+// outputFile in format. The range of the values of an 8-bit model is that of
+// the input.
+func write(outputFile string, format, inputFormat modelio.Format, input modelio.Header, kept []keptWord) error {
+	header := modelio.Header{Words: len(kept), Dimensions: input.Dimensions, Min: input.Min, Max: input.Max}
+	w, err := modelio.Create(outputFile, format, header)
+	if err != nil {
+		return err
+	}
+	for _, k := range kept {
+		// Vectors are copied as they are to a model of the same format, and
+		// converted through float32 otherwise
+		vector := k.vector
+		if format != inputFormat {
+			vector = modelio.Float32Values(vector)
+		}
+		if err := w.Write(k.word, vector); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
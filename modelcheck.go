@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+	"github.com/arunsupe/semantic-grep/modules/utils"
+)
+
+// anomalyExamples is the number of words shown for each kind of anomaly
+// found by "w2vgrep --model check".
+const anomalyExamples = 3
+
+// modelCheckCommand implements "w2vgrep --model check". It reads a model file
+// from start to end and reports its format, its header, whether its records
+// agree with the header, and words or vectors that no search will match as
+// expected, so that a model that "doesn't work" can be diagnosed.
+type modelCheckCommand struct {
+	ModelPath string `short:"m" long:"model_path" description:"Path to the Word2Vec model file"`
+	Samples   int    `long:"samples" default:"10" description:"Number of words of the vocabulary to show"`
+}
+
+// anomaly counts the words of one kind of anomaly, keeping a few examples.
+type anomaly struct {
+	description string
+	count       int
+	examples    []string
+}
+
+// add counts word, keeping it as an example when there are few.
+func (a *anomaly) add(word string) {
+	a.count++
+	if len(a.examples) < anomalyExamples {
+		a.examples = append(a.examples, fmt.Sprintf("%q", word))
+	}
+}
+
+// Execute checks the model.
+func (c *modelCheckCommand) Execute(args []string) error {
+	modelPath := utils.ExpandPath(c.ModelPath)
+	if modelPath == "" {
+		conf, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		modelPath = conf.ModelPath
+	}
+	if modelPath == "" {
+		return errNoModelPath
+	}
+
+	info, err := os.Stat(modelPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("File:       %s (%.1f MB)\n", modelPath, float64(info.Size())/1e6)
+
+	format, err := modelio.Detect(modelPath)
+	if err != nil {
+		return fmt.Errorf("%s is not a model w2vgrep can load: %v", modelPath, err)
+	}
+	r, err := modelio.OpenFormat(modelPath, format)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	name := modelPath
+	if r.Compressed() {
+		fmt.Printf("Format:     %s, compressed with gzip\n", format)
+		name = strings.TrimSuffix(name, ".gz")
+	} else {
+		fmt.Printf("Format:     %s\n", format)
+	}
+	if named, err := modelio.FormatOf(name); err != nil || named != format {
+		fmt.Println("Note:       the file name does not tell this format; it loads anyway, as the format is detected from the content")
+	}
+	header := r.Header()
+	fmt.Printf("Header:     %d words of %d dimensions\n", header.Words, header.Dimensions)
+	if format == modelio.Int8 {
+		fmt.Printf("Range:      %g to %g\n", header.Min, header.Max)
+	}
+
+	invalidUTF8 := &anomaly{description: "words that are not valid UTF-8"}
+	bom := &anomaly{description: "words starting with a byte order mark"}
+	carriageReturn := &anomaly{description: "words with a carriage return, from CRLF line endings"}
+	control := &anomaly{description: "words with other control characters or spaces"}
+	empty := &anomaly{description: "empty words"}
+	duplicate := &anomaly{description: "words listed more than once, of which only the first is used"}
+	notFinite := &anomaly{description: "vectors with infinite or NaN values"}
+	zero := &anomaly{description: "vectors of zeros, which match nothing"}
+	anomalies := []*anomaly{invalidUTF8, bom, carriageReturn, control, empty, duplicate, notFinite, zero}
+
+	seen := make(map[string]bool, header.Words)
+	var samples []string
+	read := 0
+	minNorm, maxNorm, sumNorm := math.Inf(1), 0.0, 0.0
+	for r.Next() {
+		read++
+		word := r.Word()
+		switch {
+		case word == "":
+			empty.add(word)
+		case !utf8.ValidString(word):
+			invalidUTF8.add(word)
+		case strings.HasPrefix(word, "\ufeff"):
+			bom.add(word)
+		case strings.ContainsRune(word, '\r'):
+			carriageReturn.add(word)
+		case strings.IndexFunc(word, func(r rune) bool { return unicode.IsControl(r) || unicode.IsSpace(r) }) >= 0:
+			control.add(word)
+		}
+		if seen[word] {
+			duplicate.add(word)
+		}
+		seen[word] = true
+
+		vector := modelio.Float32Values(r.Vector())
+		norm, finite := 0.0, true
+		for _, x := range vector {
+			if math.IsNaN(float64(x)) || math.IsInf(float64(x), 0) {
+				finite = false
+			}
+			norm += float64(x) * float64(x)
+		}
+		norm = math.Sqrt(norm)
+		switch {
+		case !finite:
+			notFinite.add(word)
+		case norm == 0:
+			zero.add(word)
+		default:
+			minNorm, maxNorm, sumNorm = math.Min(minNorm, norm), math.Max(maxNorm, norm), sumNorm+norm
+		}
+
+		if len(samples) < c.Samples {
+			samples = append(samples, fmt.Sprintf("%-20q %s", word, formatValues(vector)))
+		}
+	}
+
+	problems := 0
+	if err := r.Err(); err != nil {
+		problems++
+		if read < header.Words {
+			fmt.Printf("Error:      the file ends or breaks after %d of the %d words of the header, so it is truncated or corrupt: %v\n", read, header.Words, err)
+		} else {
+			fmt.Printf("Error:      %v\n", err)
+		}
+	} else {
+		fmt.Printf("Records:    %d, as in the header\n", read)
+	}
+	if valid := read - notFinite.count - zero.count; valid > 0 {
+		fmt.Printf("Norms:      %.4g to %.4g, mean %.4g\n", minNorm, maxNorm, sumNorm/float64(valid))
+	}
+
+	for _, a := range anomalies {
+		if a.count == 0 {
+			continue
+		}
+		problems++
+		fmt.Printf("Warning:    %d %s, e.g. %s\n", a.count, a.description, strings.Join(a.examples, ", "))
+	}
+
+	if len(samples) > 0 {
+		fmt.Println("Sample words, most frequent first:")
+		for _, sample := range samples {
+			fmt.Printf("  %s\n", sample)
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%d problem(s) found in %s", problems, modelPath)
+	}
+	fmt.Println("No problems found")
+	return nil
+}
+
+// formatValues returns the first values of vector, for a glance at its
+// magnitude.
+func formatValues(vector []float32) string {
+	const shown = 5
+	var values []string
+	for i, x := range vector {
+		if i == shown {
+			values = append(values, "...")
+			break
+		}
+		values = append(values, fmt.Sprintf("%.4f", x))
+	}
+	return strings.Join(values, " ")
+}
@@ -8,16 +8,130 @@ configuration file and returns a Config struct.
 package config
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/language"
+	"github.com/arunsupe/semantic-grep/modules/pipeline"
+	"github.com/arunsupe/semantic-grep/modules/processor"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+	"github.com/arunsupe/semantic-grep/modules/stemmer"
+	"github.com/arunsupe/semantic-grep/modules/utils"
 )
 
 const DefaultConfigPath = "config.json"
 
+// Config holds defaults for w2vgrep options. Options given on the command
+// line take precedence. Pointer fields are nil when not configured.
 type Config struct {
-	ModelPath string `json:"model_path"`
+	ModelPath      string `json:"model_path"`
+	HighlightStyle string `json:"highlight_style"`
+
+	Threshold     *float64 `json:"threshold"`
+	ContextBefore *int     `json:"before_context"`
+	ContextAfter  *int     `json:"after_context"`
+	Context       *int     `json:"context"`
+	LineNumbers   *bool    `json:"line_number"`
+	IgnoreCase    *bool    `json:"ignore_case"`
+	CaseFold      string   `json:"case_fold"`
+	Color         string   `json:"color"`
+	ShowScores    string   `json:"show_scores"`
+	Segmenter     string   `json:"segmenter"`
+	CJKMaxLength  *int     `json:"cjk_max_length"`
+	Normalize     string   `json:"normalize"`
+	Stem          string   `json:"stem"`
+	Scorer        string   `json:"scorer"`
+	// ScorerOptions is the configuration of the scorer, e.g. {"scale": 0.1} for dot
+	ScorerOptions json.RawMessage `json:"scorer_options"`
+	// Models maps language codes to model paths, e.g. {"fr": "models/cc.fr.300.bin"}, see --lang
+	Models map[string]string `json:"models"`
+	// ModelSources adds models to, or overrides models of, the catalog of "w2vgrep --model download"
+	ModelSources map[string]ModelSource `json:"model_sources"`
+	// ScriptModels maps Unicode script names to model paths, e.g. {"Han": "models/cc.zh.300.bin"}
+	ScriptModels map[string]string `json:"script_models"`
+	// Pipelines add or replace the file types converted to text, e.g.
+	// {"pdf": {"extensions": [".pdf"], "command": ["pdftotext", "-", "-"]}}
+	Pipelines map[string]pipeline.Pipeline `json:"pipelines"`
+	// Cache enables the result cache, like --cache
+	Cache *bool `json:"cache"`
+	// CacheDir is the directory of the result cache and of downloaded models, like --cache-dir
+	CacheDir string `json:"cache_dir"`
+	// ReadOnly keeps w2vgrep from writing any file, like --read-only
+	ReadOnly *bool `json:"read_only"`
+	// Daemon, when false, makes searches load the model even when a
+	// daemon serves it
+	Daemon *bool `json:"daemon"`
+	// FastMath computes scores in float32, like --fast-math
+	FastMath *bool `json:"fast_math"`
+	// FrequencyThresholds are thresholds for the most frequent words, by increasing max_rank
+	FrequencyThresholds []FrequencyThreshold `json:"frequency_thresholds"`
+}
+
+// FrequencyThreshold is the similarity threshold of the words among the
+// MaxRank most frequent words of the model, see processor.FrequencyBand.
+type FrequencyThreshold struct {
+	MaxRank   int     `json:"max_rank"`
+	Threshold float64 `json:"threshold"`
+}
+
+// ModelSource tells "w2vgrep --model download" where to get a model and how
+// to turn it into the binary format.
+type ModelSource struct {
+	URL string `json:"url"`
+	// Format is one of ModelFormats.
+	Format string `json:"format"`
+	// File is the model file inside a zip archive.
+	File string `json:"file,omitempty"`
+	// SHA256 is the hex checksum of the downloaded file, checked when set.
+	SHA256 string `json:"sha256,omitempty"`
+	// ModelSHA256 is the hex checksum of the model once converted to the
+	// binary format, checked when set.
+	ModelSHA256 string `json:"model_sha256,omitempty"`
+}
+
+// ModelFormats are the supported formats of downloads: binary models,
+// fastText or GloVe text models, optionally gzipped, and zip archives of
+// text models.
+var ModelFormats = []string{"bin", "bin.gz", "text", "text.gz", "zip"}
+
+// Validate checks that the source is complete.
+func (s ModelSource) Validate() error {
+	if s.URL == "" {
+		return fmt.Errorf("no url")
+	}
+	if !slices.Contains(ModelFormats, s.Format) {
+		return fmt.Errorf("invalid format %q, expected one of %s", s.Format, strings.Join(ModelFormats, ", "))
+	}
+	if s.Format == "zip" && s.File == "" {
+		return fmt.Errorf("no file to extract from the zip archive")
+	}
+	if err := validateChecksum("sha256", s.SHA256); err != nil {
+		return err
+	}
+	return validateChecksum("model_sha256", s.ModelSHA256)
+}
+
+// Verified reports whether a download from the source is checked against a
+// checksum.
+func (s ModelSource) Verified() bool {
+	return s.SHA256 != "" || s.ModelSHA256 != ""
+}
+
+// validateChecksum checks that the checksum of the field, when set, is a hex
+// SHA-256.
+func validateChecksum(field, checksum string) error {
+	if checksum == "" {
+		return nil
+	}
+	if _, err := hex.DecodeString(checksum); err != nil || len(checksum) != 64 {
+		return fmt.Errorf("invalid %s %q, expected 64 hexadecimal digits", field, checksum)
+	}
+	return nil
 }
 
 func FindConfigFile() string {
@@ -30,9 +144,16 @@ func FindConfigFile() string {
 	locations := []string{
 		filepath.Join(cwd, "config.json"),
 		DefaultConfigPath,
-		os.ExpandEnv("$HOME/.config/semantic-grep/config.json"),
-		"/etc/semantic-grep/config.json",
 	}
+	// os.UserHomeDir also works on Windows, where $HOME is usually unset
+	if home, err := os.UserHomeDir(); err == nil {
+		locations = append(locations, filepath.Join(home, ".config", "semantic-grep", "config.json"))
+	}
+	// e.g. %AppData%\semantic-grep\config.json on Windows
+	if configDir, err := os.UserConfigDir(); err == nil {
+		locations = append(locations, filepath.Join(configDir, "semantic-grep", "config.json"))
+	}
+	locations = append(locations, "/etc/semantic-grep/config.json")
 
 	for _, location := range locations {
 		if _, err := os.Stat(location); err == nil {
@@ -52,9 +173,133 @@ func LoadConfig(configPath string) (*Config, error) {
 
 	var config Config
 	decoder := json.NewDecoder(file)
+	// Report misspelled options instead of silently ignoring them
+	decoder.DisallowUnknownFields()
 	if err := decoder.Decode(&config); err != nil {
 		return nil, err
 	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 
+	config.ModelPath = resolveModelPath(configPath, config.ModelPath)
+	for lang, path := range config.Models {
+		config.Models[lang] = resolveModelPath(configPath, path)
+	}
+	for script, path := range config.ScriptModels {
+		config.ScriptModels[script] = resolveModelPath(configPath, path)
+	}
+	config.CacheDir = utils.ExpandPath(config.CacheDir)
 	return &config, nil
 }
+
+// Validate checks that the configured values are usable.
+func (c *Config) Validate() error {
+	if c.Threshold != nil && (*c.Threshold < -1 || *c.Threshold > 1) {
+		return fmt.Errorf("threshold: %v is out of range, expected a similarity between -1 and 1", *c.Threshold)
+	}
+	for name, value := range map[string]*int{
+		"before_context": c.ContextBefore,
+		"after_context":  c.ContextAfter,
+		"context":        c.Context,
+	} {
+		if value != nil {
+			if err := processor.ValidateContext(*value); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+	}
+	if c.CJKMaxLength != nil && *c.CJKMaxLength < 1 {
+		return fmt.Errorf("cjk_max_length: %d is too small, expected a number of characters of at least 1", *c.CJKMaxLength)
+	}
+
+	for _, choice := range []struct {
+		name, value string
+		allowed     []string
+	}{
+		{"color", c.Color, []string{"auto", "always", "never"}},
+		{"show_scores", c.ShowScores, []string{"prefix", "inline", "none"}},
+		{"segmenter", c.Segmenter, []string{"words", "cjk"}},
+		{"normalize", c.Normalize, []string{"none", "nfc", "nfkc", "nfd", "nfkd"}},
+		{"case_fold", c.CaseFold, []string{"frequent", "mean"}},
+	} {
+		if choice.value != "" && !slices.Contains(choice.allowed, choice.value) {
+			return fmt.Errorf("%s: invalid value %q, expected one of %s", choice.name, choice.value, strings.Join(choice.allowed, ", "))
+		}
+	}
+
+	if c.HighlightStyle != "" {
+		if err := utils.ValidateStyle(c.HighlightStyle); err != nil {
+			return fmt.Errorf("highlight_style: %v", err)
+		}
+	}
+	if c.Stem != "" {
+		if _, err := stemmer.New(c.Stem); err != nil {
+			return fmt.Errorf("stem: %v", err)
+		}
+	}
+	if c.Scorer != "" || len(c.ScorerOptions) > 0 {
+		name := c.Scorer
+		if name == "" {
+			name = similarity.DefaultScorer
+		}
+		if _, err := similarity.NewScorer(name, c.ScorerOptions); err != nil {
+			return fmt.Errorf("scorer: %v", err)
+		}
+	}
+	for lang, path := range c.Models {
+		if lang == language.Auto {
+			return fmt.Errorf("models: %q is reserved for language detection", lang)
+		}
+		if path == "" {
+			return fmt.Errorf("models: no model path for language %q", lang)
+		}
+	}
+	for i, band := range c.FrequencyThresholds {
+		if band.MaxRank < 1 {
+			return fmt.Errorf("frequency_thresholds: max_rank %d is not a positive rank", band.MaxRank)
+		}
+		if i > 0 && band.MaxRank <= c.FrequencyThresholds[i-1].MaxRank {
+			return fmt.Errorf("frequency_thresholds: max_rank %d does not follow %d, expected increasing ranks", band.MaxRank, c.FrequencyThresholds[i-1].MaxRank)
+		}
+		if band.Threshold < -1 || band.Threshold > 1 {
+			return fmt.Errorf("frequency_thresholds: threshold %v is out of range, expected a similarity between -1 and 1", band.Threshold)
+		}
+	}
+
+	if _, err := pipeline.NewRouter(c.Pipelines); err != nil {
+		return fmt.Errorf("pipelines: %v", err)
+	}
+
+	for name, source := range c.ModelSources {
+		if err := source.Validate(); err != nil {
+			return fmt.Errorf("model_sources: %s: %v", name, err)
+		}
+	}
+	for script, path := range c.ScriptModels {
+		if path == "" {
+			return fmt.Errorf("script_models: no model path for script %q", script)
+		}
+	}
+	return nil
+}
+
+// resolveModelPath expands ~ and environment variables in the model path
+// of a config file. A relative path is taken relative to the directory of
+// the config file when the model is there, so that the config keeps working
+// from any directory; otherwise it stays relative to the current directory.
+func resolveModelPath(configPath, modelPath string) string {
+	if modelPath == "" {
+		return ""
+	}
+
+	modelPath = utils.ExpandPath(modelPath)
+	if filepath.IsAbs(modelPath) {
+		return modelPath
+	}
+	besideConfig := filepath.Join(filepath.Dir(configPath), modelPath)
+	if _, err := os.Stat(besideConfig); err == nil {
+		return besideConfig
+	}
+	return modelPath
+}
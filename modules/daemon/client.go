@@ -0,0 +1,202 @@
+package daemon
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/arunsupe/semantic-grep/modules/float16"
+	"github.com/arunsupe/semantic-grep/modules/model"
+)
+
+// connection is the connection of a client to a Server, shared by the
+// views of its model.
+type connection struct {
+	mu      sync.Mutex
+	conn    net.Conn
+	encoder *gob.Encoder
+	decoder *gob.Decoder
+	// failed is called when the server cannot be reached any more, as a
+	// search cannot go on without its model
+	failed func(error)
+}
+
+// Model is a model held by a Server. Each word is requested once, and its
+// embedding kept for later lookups. It is safe for concurrent use.
+type Model struct {
+	conn *connection
+	view string
+
+	mu      sync.Mutex
+	entries map[string]entry
+	words   []string
+	ranks   map[string]int
+}
+
+// entry is the answer of the server for a word.
+type entry struct {
+	vector  interface{}
+	rank    int
+	hasRank bool
+	norm    float64
+	hasNorm bool
+}
+
+// Dial connects to the server listening on socket and returns its model
+// and the description of the model. failed is called, e.g. to end the
+// process, when the server cannot be reached any more.
+func Dial(socket string, timeout time.Duration, failed func(error)) (*Model, Info, error) {
+	conn, err := net.DialTimeout("unix", socket, timeout)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	c := &connection{conn: conn, encoder: gob.NewEncoder(conn), decoder: gob.NewDecoder(conn), failed: failed}
+	conn.SetDeadline(time.Now().Add(timeout))
+	resp, err := roundTrip(c.encoder, c.decoder, request{Op: opInfo})
+	conn.SetDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, Info{}, err
+	}
+	return newModel(c, viewModel), resp.Info, nil
+}
+
+// Stop asks the server listening on socket to stop, and returns the
+// description of its model.
+func Stop(socket string, timeout time.Duration) (Info, error) {
+	conn, err := net.DialTimeout("unix", socket, timeout)
+	if err != nil {
+		return Info{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	resp, err := roundTrip(gob.NewEncoder(conn), gob.NewDecoder(conn), request{Op: opStop})
+	return resp.Info, err
+}
+
+// newModel returns the view of the model of c named view.
+func newModel(c *connection, view string) *Model {
+	return &Model{conn: c, view: view, entries: make(map[string]entry)}
+}
+
+// Close closes the connection to the server.
+func (m *Model) Close() error {
+	return m.conn.conn.Close()
+}
+
+// request sends req to the server and returns its response. A failure of
+// the connection is reported to the failed function of Dial.
+func (m *Model) request(req request) (response, error) {
+	c := m.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	req.View = m.view
+	resp, err := roundTrip(c.encoder, c.decoder, req)
+	if err != nil && resp.Err == "" && c.failed != nil {
+		c.failed(fmt.Errorf("lost the connection to the w2vgrep --daemon: %v", err))
+	}
+	return resp, err
+}
+
+// lookup returns the entry of word, requesting it on first use, and false
+// when word is not in the model.
+func (m *Model) lookup(word string) (entry, bool) {
+	m.mu.Lock()
+	e, ok := m.entries[word]
+	m.mu.Unlock()
+	if ok {
+		return e, e.vector != nil
+	}
+
+	resp, err := m.request(request{Op: opEmbedding, Word: word})
+	if err == nil && resp.Found {
+		e = entry{rank: resp.Rank, hasRank: resp.HasRank, norm: resp.Norm, hasNorm: resp.HasNorm}
+		switch {
+		case resp.Float16 != nil:
+			vector := make([]float16.Float16, len(resp.Float16))
+			for i, x := range resp.Float16 {
+				vector[i] = float16.Float16(x)
+			}
+			e.vector = vector
+		case resp.Int8 != nil:
+			e.vector = resp.Int8
+		default:
+			e.vector = resp.Float32
+		}
+	}
+	m.mu.Lock()
+	m.entries[word] = e
+	m.mu.Unlock()
+	return e, e.vector != nil
+}
+
+// LoadModel is not supported: the model is loaded by the server.
+func (m *Model) LoadModel(filename string) error {
+	return errors.New("the model of a w2vgrep --daemon is loaded by the daemon")
+}
+
+// GetEmbedding returns the vector of token, requesting it from the server
+// on first use.
+func (m *Model) GetEmbedding(token string) (interface{}, error) {
+	e, ok := m.lookup(token)
+	if !ok {
+		return nil, model.ErrWordNotFound
+	}
+	return e.vector, nil
+}
+
+// Rank returns the 1-based frequency rank of word.
+func (m *Model) Rank(word string) (int, bool) {
+	m.mu.Lock()
+	ranks := m.ranks
+	m.mu.Unlock()
+	if ranks != nil {
+		rank, ok := ranks[word]
+		return rank, ok
+	}
+	e, ok := m.lookup(word)
+	return e.rank, ok && e.hasRank
+}
+
+// Norm returns the L2 norm of the vector of word.
+func (m *Model) Norm(word string) (float64, bool) {
+	e, ok := m.lookup(word)
+	return e.norm, ok && e.hasNorm
+}
+
+// Words returns the vocabulary of the model, most frequent word first. It
+// is requested once, with the ranks of the words.
+func (m *Model) Words() []string {
+	m.mu.Lock()
+	words := m.words
+	m.mu.Unlock()
+	if words == nil {
+		resp, err := m.request(request{Op: opWords})
+		if err != nil {
+			return nil
+		}
+		m.mu.Lock()
+		m.words = resp.Words
+		if resp.Ranks != nil {
+			m.ranks = make(map[string]int, len(resp.Words))
+			for i, word := range resp.Words {
+				m.ranks[word] = resp.Ranks[i]
+			}
+		}
+		words = m.words
+		m.mu.Unlock()
+	}
+	return append([]string(nil), words...)
+}
+
+// CaseFolded returns the model folded to lowercase by the server, see
+// model.CaseFolded, on the same connection.
+func (m *Model) CaseFolded(mean bool) model.VectorModel {
+	if mean {
+		return newModel(m.conn, viewMean)
+	}
+	return newModel(m.conn, viewFrequent)
+}
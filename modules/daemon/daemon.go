@@ -0,0 +1,270 @@
+// Package daemon shares a model loaded by one long-running process with
+// the w2vgrep processes of the same user, over a Unix socket, so that they
+// start without loading the model themselves. A Server answers requests for
+// the embeddings, ranks and norms of words; a Model is a model.VectorModel
+// whose words are looked up in a Server and cached.
+//
+// Requests and responses are gob encoded, one response per request, in
+// order, on a connection per client process.
+package daemon
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/arunsupe/semantic-grep/modules/float16"
+	"github.com/arunsupe/semantic-grep/modules/model"
+)
+
+// Operations of a request.
+const (
+	opInfo      = "info"      // describe the model
+	opEmbedding = "embedding" // look up a word
+	opWords     = "words"     // list the vocabulary
+	opStop      = "stop"      // stop the server
+)
+
+// Views of the model a request is made in. A client folds case by looking
+// up words in a view folded by the server, which builds it once.
+const (
+	viewModel    = ""
+	viewFrequent = "frequent" // model.CaseFolded without mean
+	viewMean     = "mean"     // model.CaseFolded with mean
+)
+
+// Info identifies the model of a Server, so that a client only uses it for
+// the model file it would load itself.
+type Info struct {
+	Path    string // absolute path of the model file
+	Size    int64
+	ModTime int64 // time of last modification, in nanoseconds
+	Words   int
+}
+
+// request is a request of a client.
+type request struct {
+	Op   string
+	View string
+	Word string
+}
+
+// response answers a request. Exactly one of the vector fields is set for
+// a word found in the model, by its format.
+type response struct {
+	Info    Info
+	Found   bool
+	Float32 []float32
+	Float16 []uint16
+	Int8    []int8
+	Rank    int
+	HasRank bool
+	Norm    float64
+	HasNorm bool
+	Words   []string
+	Ranks   []int
+	Err     string
+}
+
+// Server answers the requests of clients for the words of a model.
+type Server struct {
+	// IdleTimeout, when not 0, stops the server once no client has been
+	// connected for that long.
+	IdleTimeout time.Duration
+
+	info  Info
+	model model.VectorModel
+
+	mu    sync.Mutex
+	views map[string]model.VectorModel
+	stop  chan struct{}
+	// clients is the number of connected clients, and idleSince the time
+	// the last one disconnected
+	clients   int
+	idleSince time.Time
+}
+
+// NewServer returns a server of m, the model described by info.
+func NewServer(m model.VectorModel, info Info) *Server {
+	return &Server{
+		info:  info,
+		model: m,
+		views: map[string]model.VectorModel{viewModel: m},
+		stop:  make(chan struct{}),
+	}
+}
+
+// Serve answers the connections of listener until it is closed, or until
+// a client asks the server to stop, or it has been idle for IdleTimeout,
+// which closes it.
+func (s *Server) Serve(listener net.Listener) error {
+	go func() {
+		<-s.stop
+		listener.Close()
+	}()
+	if s.IdleTimeout > 0 {
+		s.idleSince = time.Now()
+		go s.stopWhenIdle()
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-s.stop:
+				return nil
+			default:
+				return err
+			}
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// stopWhenIdle stops the server once no client has been connected for
+// IdleTimeout.
+func (s *Server) stopWhenIdle() {
+	ticker := time.NewTicker(min(s.IdleTimeout, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		if s.clients == 0 && time.Since(s.idleSince) >= s.IdleTimeout {
+			s.stopLocked()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// stopLocked stops the server, unless stopped already. s.mu must be held.
+func (s *Server) stopLocked() {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+}
+
+// serveConn answers the requests of one client until it disconnects.
+func (s *Server) serveConn(conn net.Conn) {
+	s.mu.Lock()
+	s.clients++
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.clients--
+		s.idleSince = time.Now()
+		s.mu.Unlock()
+	}()
+	defer conn.Close()
+	decoder := gob.NewDecoder(conn)
+	encoder := gob.NewEncoder(conn)
+	for {
+		var req request
+		if err := decoder.Decode(&req); err != nil {
+			return
+		}
+		if err := encoder.Encode(s.answer(req)); err != nil {
+			return
+		}
+		if req.Op == opStop {
+			s.mu.Lock()
+			s.stopLocked()
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// answer returns the response to req.
+func (s *Server) answer(req request) response {
+	switch req.Op {
+	case opInfo, opStop:
+		return response{Info: s.info}
+	case opEmbedding:
+		m, err := s.view(req.View)
+		if err != nil {
+			return response{Err: err.Error()}
+		}
+		return lookup(m, req.Word)
+	case opWords:
+		m, err := s.view(req.View)
+		if err != nil {
+			return response{Err: err.Error()}
+		}
+		words := model.FrequentWords(m, len(m.Words()))
+		resp := response{Words: words}
+		if _, ok := m.(model.Ranker); ok {
+			resp.Ranks = make([]int, len(words))
+			for i, word := range words {
+				resp.Ranks[i], _ = model.Rank(m, word)
+			}
+		}
+		return resp
+	}
+	return response{Err: "unknown operation " + req.Op}
+}
+
+// view returns the view of the model named view, building it on first use.
+func (s *Server) view(view string) (model.VectorModel, error) {
+	if view != viewModel && view != viewFrequent && view != viewMean {
+		return nil, errors.New("unknown view " + view)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.views[view]
+	if !ok {
+		m = model.CaseFolded(s.model, view == viewMean)
+		s.views[view] = m
+	}
+	return m, nil
+}
+
+// lookup returns the embedding, rank and norm of word in m.
+func lookup(m model.VectorModel, word string) response {
+	vector, err := m.GetEmbedding(word)
+	if err != nil {
+		return response{}
+	}
+	resp := response{Found: true}
+	switch v := vector.(type) {
+	case []float32:
+		resp.Float32 = v
+	case []float16.Float16:
+		resp.Float16 = make([]uint16, len(v))
+		for i, x := range v {
+			resp.Float16[i] = uint16(x)
+		}
+	case []int8:
+		resp.Int8 = v
+	default:
+		resp.Float32 = model.Float32Vector(vector)
+	}
+	resp.Rank, resp.HasRank = model.Rank(m, word)
+	resp.Norm, resp.HasNorm = model.Norm(m, word)
+	return resp
+}
+
+// roundTrip sends req on a connection and reads its response.
+func roundTrip(encoder *gob.Encoder, decoder *gob.Decoder, req request) (response, error) {
+	var resp response
+	if err := encoder.Encode(req); err != nil {
+		return resp, err
+	}
+	if err := decoder.Decode(&resp); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return resp, err
+	}
+	if resp.Err != "" {
+		return resp, errors.New(resp.Err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,96 @@
+// Package float16 converts between float32 and IEEE 754 half precision
+// values, the values of float16 models. Half precision keeps 11 significant
+// bits, a relative error of at most 2^-11 per value, which moves cosine
+// similarities far less than the differences that matter for a threshold.
+package float16
+
+import "math"
+
+// Float16 holds the bits of an IEEE 754 half precision value.
+type Float16 uint16
+
+// toFloat32 maps every half precision value to its float32 value, so that
+// conversions in similarity loops are a single lookup.
+var toFloat32 [1 << 16]float32
+
+func init() {
+	for i := range toFloat32 {
+		toFloat32[i] = decode(Float16(i))
+	}
+}
+
+// Float32 returns the value of h.
+func (h Float16) Float32() float32 {
+	return toFloat32[h]
+}
+
+// decode computes the float32 value of h from its bits.
+func decode(h Float16) float32 {
+	sign := uint32(h>>15) << 31
+	exponent := uint32(h>>10) & 0x1f
+	mantissa := uint32(h) & 0x3ff
+
+	switch {
+	case exponent == 0x1f:
+		// Infinity or NaN
+		return math.Float32frombits(sign | 0xff<<23 | mantissa<<13)
+	case exponent == 0 && mantissa == 0:
+		return math.Float32frombits(sign)
+	case exponent == 0:
+		// Subnormal: mantissa * 2^-24
+		value := float32(mantissa) / (1 << 24)
+		if sign != 0 {
+			value = -value
+		}
+		return value
+	}
+	return math.Float32frombits(sign | (exponent+127-15)<<23 | mantissa<<13)
+}
+
+// FromFloat32 returns the half precision value nearest to f, rounding ties
+// to even. Values beyond the half precision range, about ±65504, become
+// infinities.
+func FromFloat32(f float32) Float16 {
+	bits := math.Float32bits(f)
+	sign := Float16(bits>>16) & 0x8000
+	exponent := int32(bits>>23&0xff) - 127 + 15
+	mantissa := bits & 0x7fffff
+
+	switch {
+	case bits&0x7fffffff > 0x7f800000:
+		// NaN, keeping it a NaN
+		return sign | 0x7e00
+	case exponent >= 0x1f:
+		return sign | 0x7c00
+	case exponent <= 0:
+		// Subnormal or zero: shift the mantissa, with its implicit bit, into place
+		if exponent < -10 {
+			return sign
+		}
+		mantissa |= 0x800000
+		shift := uint32(14 - exponent)
+		return sign | Float16(roundShift(mantissa, shift))
+	}
+	// A carry out of the mantissa correctly increments the exponent
+	return sign | Float16(uint32(exponent)<<10+roundShift(mantissa, 13))
+}
+
+// roundShift returns x shifted right by shift bits, rounded to nearest even.
+func roundShift(x, shift uint32) uint32 {
+	half := uint32(1) << (shift - 1)
+	rest := x & (1<<shift - 1)
+	x >>= shift
+	if rest > half || rest == half && x&1 == 1 {
+		x++
+	}
+	return x
+}
+
+// Vector returns the half precision values of v.
+func Vector(v []float32) []Float16 {
+	out := make([]Float16, len(v))
+	for i, x := range v {
+		out[i] = FromFloat32(x)
+	}
+	return out
+}
@@ -0,0 +1,74 @@
+// Package testmodel writes small word embedding models for the tests and
+// benchmarks of the packages, in each format modelio writes.
+package testmodel
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+)
+
+// Vector is a word of a test model and its embedding.
+type Vector struct {
+	Word   string
+	Values []float32
+}
+
+// fileNames are the names of the models written, by format.
+var fileNames = map[modelio.Format]string{
+	modelio.Float32: "model.bin",
+	modelio.Float16: "model.f16.bin",
+	modelio.Int8:    "model.8int.bin",
+}
+
+// Write writes vectors to a model in format in dir, and returns its path.
+// The values must be between -1 and 1; 8-bit models store them scaled to
+// -127 to 127.
+func Write(tb testing.TB, dir string, format modelio.Format, vectors []Vector) string {
+	tb.Helper()
+	dimensions := 0
+	if len(vectors) > 0 {
+		dimensions = len(vectors[0].Values)
+	}
+	path := filepath.Join(dir, fileNames[format])
+	w, err := modelio.Create(path, format, modelio.Header{Words: len(vectors), Dimensions: dimensions, Min: -1, Max: 1})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	quantized := make([]int8, dimensions)
+	for _, v := range vectors {
+		var vector interface{} = v.Values
+		if format == modelio.Int8 {
+			for i, x := range v.Values {
+				quantized[i] = int8(math.Round(float64(x) * math.MaxInt8))
+			}
+			vector = quantized
+		}
+		if err := w.Write(v.Word, vector); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+// Random returns words vectors of random values between -1 and 1, of the
+// words w0, w1 and so on. The values are the same on every call.
+func Random(words, dimensions int) []Vector {
+	random := rand.New(rand.NewSource(1))
+	vectors := make([]Vector, words)
+	for i := range vectors {
+		values := make([]float32, dimensions)
+		for j := range values {
+			values[j] = random.Float32()*2 - 1
+		}
+		vectors[i] = Vector{Word: fmt.Sprintf("w%d", i), Values: values}
+	}
+	return vectors
+}
@@ -0,0 +1,124 @@
+// Package language guesses the language of a text sample, so that a
+// matching word embedding model can be picked without the user naming it.
+package language
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Auto is the language name asking for the language to be detected.
+const Auto = "auto"
+
+// scriptLanguages maps scripts used by essentially one language (among those
+// with common word2vec or fastText models) to its ISO 639-1 code.
+var scriptLanguages = []struct {
+	script *unicode.RangeTable
+	code   string
+}{
+	{unicode.Hangul, "ko"},
+	{unicode.Cyrillic, "ru"},
+	{unicode.Arabic, "ar"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+}
+
+// stopwords are frequent short words of languages written in Latin script.
+// A word may belong to several languages; the language with most hits wins.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "it", "was", "for", "with", "he", "she", "you", "not", "this", "are", "be"},
+	"fr": {"le", "la", "les", "et", "de", "des", "un", "une", "est", "que", "qui", "dans", "pour", "pas", "il", "elle", "du", "au"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ein", "eine", "zu", "den", "mit", "sich", "auf", "ich", "es", "dem", "auch"},
+	"es": {"el", "la", "los", "las", "y", "de", "que", "en", "un", "una", "es", "por", "con", "para", "no", "se", "del", "lo"},
+	"it": {"il", "la", "di", "che", "e", "un", "una", "non", "per", "con", "sono", "gli", "del", "della", "nel", "è", "lo"},
+	"pt": {"o", "a", "os", "as", "e", "de", "que", "um", "uma", "não", "em", "para", "com", "do", "da", "se", "é"},
+	"nl": {"de", "het", "een", "en", "van", "is", "niet", "dat", "op", "te", "zijn", "met", "voor", "ik", "die", "ook"},
+}
+
+// stopwordLanguages is the inverse of stopwords.
+var stopwordLanguages = map[string][]string{}
+
+func init() {
+	for code, words := range stopwords {
+		for _, word := range words {
+			stopwordLanguages[word] = append(stopwordLanguages[word], code)
+		}
+	}
+}
+
+// Detect returns the ISO 639-1 code of the most likely language of text,
+// e.g. "en" or "zh", or "" when the text gives too little evidence. Chinese,
+// Japanese and languages with a script of their own are recognized by their
+// letters, languages written in Latin script by their most frequent words.
+func Detect(text string) string {
+	scripts := map[string]int{}
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+			scripts["ja"]++
+		case unicode.Is(unicode.Han, r):
+			scripts["zh"]++
+		case unicode.Is(unicode.Latin, r):
+			scripts["latin"]++
+		default:
+			for _, s := range scriptLanguages {
+				if unicode.Is(s.script, r) {
+					scripts[s.code]++
+					break
+				}
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+
+	// Japanese mixes kana with Han characters
+	if scripts["ja"] > 0 && scripts["ja"]+scripts["zh"] > letters/2 {
+		return "ja"
+	}
+	best, bestCount := "", 0
+	for code, count := range scripts {
+		if count > bestCount || count == bestCount && code < best {
+			best, bestCount = code, count
+		}
+	}
+	if best != "latin" {
+		return best
+	}
+	return detectLatin(text)
+}
+
+// detectLatin returns the language with the most stopwords in text, or ""
+// when no language stands out.
+func detectLatin(text string) string {
+	hits := map[string]int{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	}) {
+		for _, code := range stopwordLanguages[word] {
+			hits[code]++
+		}
+	}
+
+	best, bestCount, tied := "", 0, false
+	for code, count := range hits {
+		switch {
+		case count > bestCount:
+			best, bestCount, tied = code, count, false
+		case count == bestCount:
+			tied = true
+		}
+	}
+	if tied || bestCount < 2 {
+		return ""
+	}
+	return best
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"os"
+	"testing"
+
+	"github.com/arunsupe/semantic-grep/modules/internal/testmodel"
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+)
+
+// benchVectors are the vectors of the benchmark models: 20000 words of a
+// typical size.
+var benchVectors = testmodel.Random(20000, 300)
+
+// BenchmarkLoadModel measures loading a model of 20000 words in each
+// format. SetBytes reports the speed in MB/s of the file.
+func BenchmarkLoadModel(b *testing.B) {
+	dir := b.TempDir()
+	for _, format := range []modelio.Format{modelio.Float32, modelio.Float16, modelio.Int8} {
+		path := testmodel.Write(b, dir, format, benchVectors)
+		b.Run(format.String(), func(b *testing.B) {
+			info, err := os.Stat(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(info.Size())
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := LoadVectorModel(path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGetEmbedding measures looking up a word of the model, and a
+// word missing from it, which is what most tokens of a search are.
+func BenchmarkGetEmbedding(b *testing.B) {
+	m, err := LoadVectorModel(testmodel.Write(b, b.TempDir(), modelio.Float32, benchVectors))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, word := range []string{"w1234", "missing"} {
+		b.Run(word, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m.GetEmbedding(word)
+			}
+		})
+	}
+}
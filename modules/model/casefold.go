@@ -0,0 +1,66 @@
+package model
+
+import "strings"
+
+// CaseFolder is implemented by models that fold their vocabulary to
+// lowercase themselves, such as a model held by another process.
+type CaseFolder interface {
+	CaseFolded(mean bool) VectorModel
+}
+
+// CaseFolded returns a model whose vocabulary is the vocabulary of m in
+// lowercase, so that the lowercased tokens of a case-insensitive search
+// find words that the model only has capitalized, such as "Death" or
+// "NASA". m itself is left unchanged. When several casings of a word are in
+// m, the folded word has the vector of the most frequent one, or with mean
+// the mean of their vectors. A word with a single casing keeps its vector,
+// and a folded word takes the best rank of its casings.
+func CaseFolded(m VectorModel, mean bool) VectorModel {
+	switch m := m.(type) {
+	case *VecModel32bit:
+		return &VecModel32bit{vocabulary: foldCase(m.vocabulary, mean), size: m.size}
+	case *VecModel16bit:
+		return &VecModel16bit{vocabulary: foldCase(m.vocabulary, mean), size: m.size}
+	case *VecModel8bit:
+		return &VecModel8bit{vocabulary: foldCase(m.vocabulary, mean), min: m.min, max: m.max, size: m.size}
+	case *ScriptRouter:
+		router := NewScriptRouter(CaseFolded(m.Default, mean))
+		for _, s := range m.scripts {
+			router.scripts = append(router.scripts, scriptModel{name: s.name, table: s.table, model: CaseFolded(s.model, mean)})
+		}
+		return router
+	case CaseFolder:
+		return m.CaseFolded(mean)
+	}
+	return m
+}
+
+// foldCase returns v keyed by the lowercased words.
+func foldCase(v *vocabulary, mean bool) *vocabulary {
+	// The casings of each word, most frequent first
+	casings := make(map[string][]string, len(v.order))
+	var keys []string
+	for _, word := range v.order {
+		key := strings.ToLower(word)
+		if _, seen := casings[key]; !seen {
+			keys = append(keys, key)
+		}
+		casings[key] = append(casings[key], word)
+	}
+
+	folded := newVocabulary(len(keys))
+	for _, key := range keys {
+		words := casings[key]
+		if !mean || len(words) == 1 {
+			folded.order = append(folded.order, key)
+			folded.entries[key] = v.entries[words[0]]
+			continue
+		}
+		variants := make([]interface{}, len(words))
+		for i, word := range words {
+			variants[i] = v.entries[word].vector
+		}
+		folded.add(key, Mean(variants, nil))
+	}
+	return folded
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+)
+
+// ConvertText converts a model in text format, one word and its values per
+// line, to the binary format read by VecModel32bit. The "count dimensions"
+// header line of fastText .vec files is optional, so GloVe .txt files
+// convert too. The output file is only created once the input was read
+// successfully.
+func ConvertText(input io.Reader, outputFile string) error {
+	// The header needs the word count, so vectors go to a temporary file first
+	body, err := os.CreateTemp(filepath.Dir(outputFile), filepath.Base(outputFile)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(body.Name())
+	defer body.Close()
+
+	writer := bufio.NewWriter(body)
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	count, dimensions, lineNumber := 0, 0, 0
+	for scanner.Scan() {
+		lineNumber++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if lineNumber == 1 && len(fields) == 2 {
+			if _, err := strconv.Atoi(fields[0]); err == nil {
+				if dimensions, err = strconv.Atoi(fields[1]); err == nil {
+					continue
+				}
+				dimensions = 0
+			}
+		}
+
+		if dimensions == 0 {
+			dimensions = len(fields) - 1
+		}
+		if len(fields) != dimensions+1 {
+			return fmt.Errorf("line %d: expected a word and %d values, got %d fields", lineNumber, dimensions, len(fields))
+		}
+
+		values := make([]float32, dimensions)
+		for i, field := range fields[1:] {
+			value, err := strconv.ParseFloat(field, 32)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			values[i] = float32(value)
+		}
+		if err := modelio.WriteRecord(writer, modelio.Float32, fields[0], values); err != nil {
+			return err
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if count == 0 || dimensions == 0 {
+		return fmt.Errorf("no vectors found")
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	out, err := os.Create(outputFile)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	modelio.WriteHeader(w, modelio.Float32, modelio.Header{Words: count, Dimensions: dimensions})
+	if _, err := io.Copy(w, body); err != nil {
+		out.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
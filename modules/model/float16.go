@@ -0,0 +1,36 @@
+package model
+
+import "github.com/arunsupe/semantic-grep/modules/modelio"
+
+// VecModel16bit represents a half precision Word2Vec model, read from files
+// ending in .f16.bin. Its vectors take half the memory of a 32-bit model's
+// and are converted to float32 value by value as they are scored.
+type VecModel16bit struct {
+	// vocabulary holds []float16.Float16 vectors
+	*vocabulary
+	size int
+}
+
+// LoadModel loads a half precision model from a file. The format is that of
+// 32-bit models with values of 2 bytes instead of 4.
+func (m *VecModel16bit) LoadModel(filename string) error {
+	vocabulary, header, err := loadVocabulary(filename, modelio.Float16)
+	if err != nil {
+		return err
+	}
+	m.vocabulary = vocabulary
+	m.size = header.Dimensions
+	return nil
+}
+
+// Size returns the number of dimensions of the vectors of the half precision model
+func (m *VecModel16bit) Size() int {
+	return m.size
+}
+
+// WriteFloat16 writes the vectors of words to outputFile in the half
+// precision format read by VecModel16bit, rounding each value to the
+// nearest half precision value, like WriteBinary.
+func WriteFloat16(outputFile string, m VectorModel, words []string) error {
+	return writeModel(outputFile, modelio.Float16, m, words)
+}
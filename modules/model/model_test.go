@@ -0,0 +1,137 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/arunsupe/semantic-grep/modules/internal/testmodel"
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+)
+
+// testVectors are the embeddings of the test models, with several casings
+// of some words for CaseFolded.
+var testVectors = []testmodel.Vector{
+	{Word: "the", Values: []float32{0.05, 0.05, 0.05, 1}},
+	{Word: "death", Values: []float32{1, 0, 0, 0.1}},
+	{Word: "Death", Values: []float32{0.9, 0.1, 0, 0.1}},
+	{Word: "DEATH", Values: []float32{0.8, 0, 0.2, 0.1}},
+	{Word: "died", Values: []float32{0.95, 0.05, 0, 0.1}},
+	{Word: "sea", Values: []float32{0, 1, 0, 0.1}},
+	{Word: "Sea", Values: []float32{0.1, 0.9, 0, 0.1}},
+	{Word: "ocean", Values: []float32{0.1, 0.95, 0, 0.1}},
+	{Word: "cat", Values: []float32{0, 0, 1, 0.1}},
+	{Word: "NASA", Values: []float32{0.3, 0.3, 0.3, 0.3}},
+}
+
+// loadTestModels writes testVectors in each format, and loads them.
+func loadTestModels(t testing.TB) []VectorModel {
+	t.Helper()
+	dir := t.TempDir()
+	var models []VectorModel
+	for _, format := range []modelio.Format{modelio.Float32, modelio.Float16, modelio.Int8} {
+		m, err := LoadVectorModel(testmodel.Write(t, dir, format, testVectors))
+		if err != nil {
+			t.Fatal(err)
+		}
+		models = append(models, m)
+	}
+	return models
+}
+
+// derivedModels returns m and the models derived from it.
+func derivedModels(m VectorModel) map[string]VectorModel {
+	return map[string]VectorModel{
+		"loaded":           m,
+		"case-folded":      CaseFolded(m, false),
+		"case-folded mean": CaseFolded(m, true),
+		"normalized":       Normalized(m, strings.ToUpper),
+	}
+}
+
+// lookup is what a goroutine finds in a model for word.
+type lookup struct {
+	vector string
+	found  bool
+	rank   int
+	ranked bool
+	norm   float64
+	normed bool
+}
+
+// lookupWord looks word up in m with every read-only accessor.
+func lookupWord(m VectorModel, word string) lookup {
+	var l lookup
+	if vector, err := m.GetEmbedding(word); err == nil {
+		l.vector, l.found = fmt.Sprint(vector), true
+	}
+	l.rank, l.ranked = Rank(m, word)
+	l.norm, l.normed = Norm(m, word)
+	return l
+}
+
+// TestConcurrentLookups looks words up in loaded and derived models from
+// many goroutines at once, which must find what a single goroutine finds.
+// Run it with -race to check that the accessors do not write to the models.
+func TestConcurrentLookups(t *testing.T) {
+	const goroutines, rounds = 16, 200
+
+	for _, loaded := range loadTestModels(t) {
+		for name, m := range derivedModels(loaded) {
+			t.Run(fmt.Sprintf("%T/%s", loaded, name), func(t *testing.T) {
+				words := append(m.Words(), "missing", "")
+				want := make(map[string]lookup, len(words))
+				for _, word := range words {
+					want[word] = lookupWord(m, word)
+				}
+				vocabulary := len(m.Words())
+
+				var wg sync.WaitGroup
+				errs := make(chan error, goroutines)
+				for g := 0; g < goroutines; g++ {
+					wg.Add(1)
+					go func(g int) {
+						defer wg.Done()
+						for i := 0; i < rounds; i++ {
+							word := words[(g+i)%len(words)]
+							if got := lookupWord(m, word); got != want[word] {
+								errs <- fmt.Errorf("lookup of %q: %+v, want %+v", word, got, want[word])
+								return
+							}
+							if i%50 == 0 && len(m.Words()) != vocabulary {
+								errs <- fmt.Errorf("Words changed size")
+								return
+							}
+						}
+					}(g)
+				}
+				wg.Wait()
+				close(errs)
+				for err := range errs {
+					t.Error(err)
+				}
+			})
+		}
+	}
+}
+
+// TestLookupsDoNotAllocate checks that GetEmbedding, Rank and Norm do not
+// allocate, for words in the model and missing from it, as they are called
+// for every token searched.
+func TestLookupsDoNotAllocate(t *testing.T) {
+	for _, loaded := range loadTestModels(t) {
+		for name, m := range derivedModels(loaded) {
+			for _, word := range []string{m.Words()[0], "missing"} {
+				allocs := testing.AllocsPerRun(100, func() {
+					m.GetEmbedding(word)
+					Rank(m, word)
+					Norm(m, word)
+				})
+				if allocs != 0 {
+					t.Errorf("%T/%s: looking up %q allocates %v times", loaded, name, word, allocs)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package model
+
+import "fmt"
+
+// NamedVectors is a model with vectors named by their user, e.g. the
+// vectors a session of "w2vgrep --serve" defines by combining words of the
+// model, looked up before the words of the model they extend. The
+// vocabulary is that of the model, so that named vectors are found as
+// queries, not as words similar to a query.
+type NamedVectors struct {
+	Model   VectorModel
+	vectors map[string]interface{}
+}
+
+// WithVectors returns m extended with vectors, keyed by their names, which
+// must not be modified afterwards. The vectors must have the type of those
+// of m, see VectorLike.
+func WithVectors(m VectorModel, vectors map[string]interface{}) *NamedVectors {
+	return &NamedVectors{Model: m, vectors: vectors}
+}
+
+// LoadModel is not supported: the model is loaded before being extended.
+func (n *NamedVectors) LoadModel(filename string) error {
+	return fmt.Errorf("named vectors cannot load %s: load the model and extend it", filename)
+}
+
+// GetEmbedding returns the vector named token, or else the embedding of
+// token in the model.
+func (n *NamedVectors) GetEmbedding(token string) (interface{}, error) {
+	if vector, ok := n.vectors[token]; ok {
+		return vector, nil
+	}
+	return n.Model.GetEmbedding(token)
+}
+
+// Rank returns the frequency rank of word in the model. Named vectors have
+// none.
+func (n *NamedVectors) Rank(word string) (int, bool) {
+	if _, ok := n.vectors[word]; ok {
+		return 0, false
+	}
+	return Rank(n.Model, word)
+}
+
+// Norm returns the norm of the vector of word in the model. Those of named
+// vectors are not precomputed.
+func (n *NamedVectors) Norm(word string) (float64, bool) {
+	if _, ok := n.vectors[word]; ok {
+		return 0, false
+	}
+	return Norm(n.Model, word)
+}
+
+// Words returns the words of the model, without the names.
+func (n *NamedVectors) Words() []string {
+	return n.Model.Words()
+}
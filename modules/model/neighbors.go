@@ -0,0 +1,151 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/arunsupe/semantic-grep/modules/float16"
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+)
+
+// Neighbor is a vocabulary word together with its similarity to a query
+type Neighbor struct {
+	Word       string
+	Similarity float64
+}
+
+// Neighbors returns the words of the model whose similarity to query is above
+// threshold, most similar first. The query itself is not included. With a
+// ScriptRouter, only the words of the query's model are compared.
+func Neighbors(m VectorModel, query string, threshold float64) ([]Neighbor, error) {
+	m = Route(m, query)
+	queryVector, err := m.GetEmbedding(query)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, query)
+	}
+
+	// With precomputed norms, each cosine is a dot product
+	queryNorm, haveNorms := Norm(m, query)
+	var neighbors []Neighbor
+	for _, word := range m.Words() {
+		if word == query {
+			continue
+		}
+		vector, _ := m.GetEmbedding(word)
+		var score float64
+		if norm, ok := Norm(m, word); haveNorms && ok {
+			score = similarity.Cosine{}.ScoreNorms(queryVector, vector, queryNorm, norm)
+		} else {
+			score = similarity.CalculateSimilarity(queryVector, vector)
+		}
+		if score > threshold && !math.IsNaN(score) {
+			neighbors = append(neighbors, Neighbor{Word: word, Similarity: score})
+		}
+	}
+
+	sort.Slice(neighbors, func(i, j int) bool {
+		if neighbors[i].Similarity != neighbors[j].Similarity {
+			return neighbors[i].Similarity > neighbors[j].Similarity
+		}
+		return neighbors[i].Word < neighbors[j].Word
+	})
+	return neighbors, nil
+}
+
+// Float32Vector returns an embedding as []float32, converting quantized and
+// half precision vectors value by value. It returns nil for unsupported
+// vector types.
+func Float32Vector(vector interface{}) []float32 {
+	return modelio.Float32Values(vector)
+}
+
+// Mean returns the weighted mean of embeddings of one type, []float32, []int8
+// or []float16.Float16, as an embedding of that type, e.g. to embed a phrase or document
+// as the centroid of its words. Weights may be nil to weigh all embeddings
+// equally. It returns nil when there are no embeddings or weights are all 0.
+func Mean(vectors []interface{}, weights []float64) interface{} {
+	var sum []float64
+	total := 0.0
+	quantized, half := false, false
+	for i, vector := range vectors {
+		weight := 1.0
+		if weights != nil {
+			weight = weights[i]
+		}
+		_, quantized = vector.([]int8)
+		_, half = vector.([]float16.Float16)
+		values := Float32Vector(vector)
+		if sum == nil {
+			sum = make([]float64, len(values))
+		}
+		for j, x := range values {
+			sum[j] += weight * float64(x)
+		}
+		total += weight
+	}
+	if sum == nil || total == 0 {
+		return nil
+	}
+
+	if quantized {
+		mean := make([]int8, len(sum))
+		for i, x := range sum {
+			mean[i] = int8(math.Round(x / total))
+		}
+		return mean
+	}
+	mean := make([]float32, len(sum))
+	for i, x := range sum {
+		mean[i] = float32(x / total)
+	}
+	if half {
+		return float16.Vector(mean)
+	}
+	return mean
+}
+
+// VectorLike returns values as an embedding of the type of like, []float32,
+// []int8 or []float16.Float16, so that a vector computed from the
+// embeddings of a model, e.g. a weighted sum, is scored against its words.
+// Int8 values are scaled to the range of int8, as cosine similarities do not
+// depend on the length of a vector.
+func VectorLike(values []float32, like interface{}) interface{} {
+	switch like.(type) {
+	case []int8:
+		largest := 0.0
+		for _, x := range values {
+			largest = max(largest, math.Abs(float64(x)))
+		}
+		quantized := make([]int8, len(values))
+		if largest == 0 {
+			return quantized
+		}
+		for i, x := range values {
+			quantized[i] = int8(math.Round(float64(x) / largest * math.MaxInt8))
+		}
+		return quantized
+	case []float16.Float16:
+		return float16.Vector(values)
+	}
+	return values
+}
+
+// FrequentWords returns the n most frequent words of m, most frequent
+// first (see Rank), or n arbitrary words when m does not know the frequency
+// of its words.
+func FrequentWords(m VectorModel, n int) []string {
+	words := m.Words()
+	if _, ok := m.(Ranker); ok {
+		ranks := make(map[string]int, len(words))
+		for _, word := range words {
+			ranks[word], _ = Rank(m, word)
+		}
+		sort.Slice(words, func(i, j int) bool { return ranks[words[i]] < ranks[words[j]] })
+	}
+	if len(words) > n {
+		words = words[:n]
+	}
+	return words
+}
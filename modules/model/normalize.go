@@ -0,0 +1,42 @@
+package model
+
+// Normalized returns a model whose vocabulary is the vocabulary of m
+// rewritten with normalize, e.g. a Unicode normalization, so that lookups of
+// normalized tokens succeed. m itself is left unchanged and the vectors are
+// shared. When several words normalize to the same form, the word already in
+// that form keeps its vector; otherwise the most frequent one does.
+func Normalized(m VectorModel, normalize func(string) string) VectorModel {
+	switch m := m.(type) {
+	case *VecModel32bit:
+		return &VecModel32bit{vocabulary: normalizeKeys(m.vocabulary, normalize), size: m.size}
+	case *VecModel16bit:
+		return &VecModel16bit{vocabulary: normalizeKeys(m.vocabulary, normalize), size: m.size}
+	case *VecModel8bit:
+		return &VecModel8bit{vocabulary: normalizeKeys(m.vocabulary, normalize), min: m.min, max: m.max, size: m.size}
+	case *ScriptRouter:
+		router := NewScriptRouter(Normalized(m.Default, normalize))
+		for _, s := range m.scripts {
+			router.scripts = append(router.scripts, scriptModel{name: s.name, table: s.table, model: Normalized(s.model, normalize)})
+		}
+		return router
+	}
+	return m
+}
+
+// normalizeKeys returns v keyed by the normalized words. A normalized word
+// takes the best rank of the words normalizing to it.
+func normalizeKeys(v *vocabulary, normalize func(string) string) *vocabulary {
+	normalized := newVocabulary(len(v.order))
+	for _, word := range v.order {
+		key := normalize(word)
+		if _, taken := normalized.entries[key]; taken {
+			if key != word {
+				continue
+			}
+		} else {
+			normalized.order = append(normalized.order, key)
+		}
+		normalized.entries[key] = v.entries[word]
+	}
+	return normalized
+}
@@ -0,0 +1,18 @@
+package model
+
+// Normer is implemented by models that compute the L2 norms of their
+// vectors when they are loaded, so that cosines need only a dot product.
+type Normer interface {
+	// Norm returns the L2 norm of the vector of word, and false when word
+	// is not in the model.
+	Norm(word string) (float64, bool)
+}
+
+// Norm returns the L2 norm of the vector of word in m, from the norms
+// precomputed by m when it has them, and false otherwise.
+func Norm(m VectorModel, word string) (float64, bool) {
+	if n, ok := m.(Normer); ok {
+		return n.Norm(word)
+	}
+	return 0, false
+}
@@ -0,0 +1,158 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// ScriptRouter is a VectorModel made of several models, each serving the
+// words written in one Unicode script, so that mixed-language text such as
+// English with Chinese can be looked up in a model for each language. Words
+// in any other script are served by the default model.
+type ScriptRouter struct {
+	Default VectorModel
+	scripts []scriptModel
+}
+
+// scriptModel is a model serving the words of one script.
+type scriptModel struct {
+	name  string
+	table *unicode.RangeTable
+	model VectorModel
+}
+
+// NewScriptRouter returns a router sending every word to defaultModel until
+// script models are added.
+func NewScriptRouter(defaultModel VectorModel) *ScriptRouter {
+	return &ScriptRouter{Default: defaultModel}
+}
+
+// Add routes the words written in script, a Unicode script name such as
+// "Han", "Cyrillic" or "Arabic", to m. Scripts are added while building the
+// router; like any model, a router in use is not modified.
+func (r *ScriptRouter) Add(script string, m VectorModel) error {
+	for name, table := range unicode.Scripts {
+		if strings.EqualFold(name, script) {
+			r.scripts = append(r.scripts, scriptModel{name: name, table: table, model: m})
+			return nil
+		}
+	}
+
+	names := make([]string, 0, len(unicode.Scripts))
+	for name := range unicode.Scripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Errorf("unknown script %q, expected one of %s", script, strings.Join(names, ", "))
+}
+
+// ModelFor returns the model serving word. A word belongs to the script
+// most of its letters are written in; digits and punctuation do not count.
+func (r *ScriptRouter) ModelFor(word string) VectorModel {
+	if len(r.scripts) == 0 {
+		return r.Default
+	}
+
+	counts := make([]int, len(r.scripts))
+	letters := 0
+	for _, c := range word {
+		if !unicode.IsLetter(c) {
+			continue
+		}
+		letters++
+		for i, s := range r.scripts {
+			if unicode.Is(s.table, c) {
+				counts[i]++
+			}
+		}
+	}
+
+	best := -1
+	for i, count := range counts {
+		if count > 0 && (best < 0 || count > counts[best]) {
+			best = i
+		}
+	}
+	if best < 0 || 2*counts[best] < letters {
+		return r.Default
+	}
+	return r.scripts[best].model
+}
+
+// LoadModel is not supported: the models of a router are loaded separately.
+func (r *ScriptRouter) LoadModel(filename string) error {
+	return fmt.Errorf("a script router cannot load %s: load each model and add it to the router", filename)
+}
+
+// GetEmbedding returns the embedding of token from the model of its script.
+func (r *ScriptRouter) GetEmbedding(token string) (interface{}, error) {
+	return r.ModelFor(token).GetEmbedding(token)
+}
+
+// Rank returns the frequency rank of word in the model of its script.
+func (r *ScriptRouter) Rank(word string) (int, bool) {
+	return Rank(r.ModelFor(word), word)
+}
+
+// Norm returns the norm of the vector of word in the model of its script.
+func (r *ScriptRouter) Norm(word string) (float64, bool) {
+	return Norm(r.ModelFor(word), word)
+}
+
+// Words returns the words of every model that the router sends to that model.
+func (r *ScriptRouter) Words() []string {
+	var words []string
+	for _, m := range r.Models() {
+		for _, word := range m.Words() {
+			if r.ModelFor(word) == m {
+				words = append(words, word)
+			}
+		}
+	}
+	return words
+}
+
+// Models returns the default model followed by the script models.
+func (r *ScriptRouter) Models() []VectorModel {
+	models := []VectorModel{r.Default}
+	for _, s := range r.scripts {
+		models = append(models, s.model)
+	}
+	return models
+}
+
+// Route returns the model that serves word in m: for a ScriptRouter the
+// model of the word's script, and m itself otherwise. Embeddings can only be
+// compared when they come from the same model.
+func Route(m VectorModel, word string) VectorModel {
+	if r, ok := m.(*ScriptRouter); ok {
+		return r.ModelFor(word)
+	}
+	return m
+}
+
+// Models returns the models m is made of.
+func Models(m VectorModel) []VectorModel {
+	if r, ok := m.(*ScriptRouter); ok {
+		return r.Models()
+	}
+	return []VectorModel{m}
+}
+
+// Ranker is implemented by models that know how frequent their words are.
+type Ranker interface {
+	// Rank returns the 1-based frequency rank of word, 1 being the most
+	// frequent word, and false when word is not in the model.
+	Rank(word string) (int, bool)
+}
+
+// Rank returns the frequency rank of word in m, and false when word is not
+// in m or m does not know the frequency of its words.
+func Rank(m VectorModel, word string) (int, bool) {
+	if r, ok := m.(Ranker); ok {
+		return r.Rank(word)
+	}
+	return 0, false
+}
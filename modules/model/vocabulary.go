@@ -0,0 +1,99 @@
+package model
+
+import (
+	"sync"
+
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+)
+
+// entry is a word of a model: its vector, boxed once at load time so that
+// GetEmbedding can return it without allocating, and the L2 norm of the
+// vector, computed as it is stored.
+type entry struct {
+	vector interface{}
+	norm   float64
+}
+
+// vocabulary holds the words of a model and their vectors, for the 32-bit,
+// half precision and 8-bit models alike.
+type vocabulary struct {
+	entries map[string]entry
+	// order lists each word once, in the order of the model file, which is
+	// most frequent first
+	order []string
+
+	// ranks is built from order on the first call of Rank, as only
+	// frequency bands, --line-mode=sif and a few commands need ranks
+	rankOnce sync.Once
+	ranks    map[string]int
+}
+
+// newVocabulary returns a vocabulary of capacity words.
+func newVocabulary(capacity int) *vocabulary {
+	return &vocabulary{entries: make(map[string]entry, capacity), order: make([]string, 0, capacity)}
+}
+
+// add stores the vector of word. A word already stored takes the new
+// vector, and keeps its rank.
+func (v *vocabulary) add(word string, vector interface{}) {
+	if _, ok := v.entries[word]; !ok {
+		v.order = append(v.order, word)
+	}
+	v.entries[word] = entry{vector: vector, norm: similarity.Norm(vector)}
+}
+
+// loadVocabulary reads the model file at filename in format, and returns
+// its words and its header.
+func loadVocabulary(filename string, format modelio.Format) (*vocabulary, modelio.Header, error) {
+	r, err := modelio.OpenFormat(filename, format)
+	if err != nil {
+		return nil, modelio.Header{}, err
+	}
+	defer r.Close()
+
+	header := r.Header()
+	v := newVocabulary(header.Words)
+	for r.Next() {
+		v.add(r.Word(), r.Vector())
+	}
+	if err := r.Err(); err != nil {
+		return nil, header, err
+	}
+	return v, header, nil
+}
+
+// GetEmbedding returns the vector embedding of a token.
+func (v *vocabulary) GetEmbedding(token string) (interface{}, error) {
+	e, ok := v.entries[token]
+	if !ok {
+		return nil, ErrWordNotFound
+	}
+	return e.vector, nil
+}
+
+// Rank returns the 1-based position of word in the model file. Models are
+// written most frequent word first, so this is the frequency rank of word.
+func (v *vocabulary) Rank(word string) (int, bool) {
+	v.rankOnce.Do(func() {
+		v.ranks = make(map[string]int, len(v.order))
+		for i, w := range v.order {
+			v.ranks[w] = i + 1
+		}
+	})
+	rank, ok := v.ranks[word]
+	return rank, ok
+}
+
+// Norm returns the L2 norm of the vector of word, computed at load time.
+func (v *vocabulary) Norm(word string) (float64, bool) {
+	e, ok := v.entries[word]
+	return e.norm, ok
+}
+
+// Words returns the vocabulary, most frequent word first.
+func (v *vocabulary) Words() []string {
+	words := make([]string, len(v.order))
+	copy(words, v.order)
+	return words
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"fmt"
+
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+)
+
+// WriteBinary writes the vectors of words to outputFile in the binary format
+// read by VecModel32bit. Quantized vectors are written as float32 values.
+// Every word must be in the model, and all vectors must have the same size.
+func WriteBinary(outputFile string, m VectorModel, words []string) error {
+	return writeModel(outputFile, modelio.Float32, m, words)
+}
+
+// writeModel writes the vectors of words to outputFile in format, Float32
+// or Float16, from their values as float32.
+func writeModel(outputFile string, format modelio.Format, m VectorModel, words []string) error {
+	if len(words) == 0 {
+		return fmt.Errorf("no words to write")
+	}
+	first, err := m.GetEmbedding(words[0])
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, words[0])
+	}
+
+	w, err := modelio.Create(outputFile, format, modelio.Header{Words: len(words), Dimensions: len(Float32Vector(first))})
+	if err != nil {
+		return err
+	}
+	for _, word := range words {
+		vector, err := m.GetEmbedding(word)
+		if err == nil {
+			err = w.Write(word, Float32Vector(vector))
+		} else {
+			err = fmt.Errorf("%w: %s", err, word)
+		}
+		if err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
@@ -0,0 +1,238 @@
+package modelio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// sniffSize is the number of bytes at the start of a file read by Detect
+	sniffSize = 1 << 20
+	// sniffRecords is the number of records checked against each layout
+	sniffRecords = 8
+	// maxWordLength is the length beyond which a record is not taken to
+	// start with a word
+	maxWordLength = 1000
+	// maxValue is the magnitude beyond which a float is not taken to be an
+	// embedding value, which catches values read at the wrong offset
+	maxValue = 1e6
+)
+
+// textHeader matches the "words dimensions" first line of 32-bit and half
+// precision models.
+var textHeader = regexp.MustCompile(`^([0-9]+) ([0-9]+)[ \t]*\n`)
+
+// crlfHeader matches a text header whose line ending was rewritten.
+var crlfHeader = regexp.MustCompile(`^[0-9]+ [0-9]+\r\n`)
+
+// notModels are files often taken for models, by their first bytes.
+var notModels = []struct {
+	magic       string
+	description string
+}{
+	{"PK\x03\x04", "the file is a zip archive: extract the model from it first"},
+	{"\xba\x16\x4f\x2f", "the file is a model in the native binary format of fastText, which w2vgrep does not read: " +
+		"use the .vec text model of fastText, converted with model_processing_utils/fasttext-to-bin"},
+	{"\xef\xbb\xbf", "the file starts with a UTF-8 byte order mark, so it was probably saved by a text editor, which corrupts binary models"},
+}
+
+// Detect returns the format of the model file at path, from its content
+// rather than its name, so that a model with a misleading name still loads.
+// The format of a file compressed with gzip is that of its content. The
+// error of a file in no known format describes what was found.
+func Detect(path string) (Format, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+	input, _, err := decompress(file)
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := make([]byte, sniffSize)
+	n, err := io.ReadFull(input, prefix)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, fmt.Errorf("failed to read file: %v", err)
+	}
+	return sniff(prefix[:n], n < sniffSize)
+}
+
+// sniff returns the format of a model file starting with data, which is the
+// whole file when whole is true.
+func sniff(data []byte, whole bool) (Format, error) {
+	if len(data) == 0 {
+		return 0, fmt.Errorf("the model file is empty")
+	}
+	for _, n := range notModels {
+		if bytes.HasPrefix(data, []byte(n.magic)) {
+			return 0, fmt.Errorf("unsupported file format: %s", n.description)
+		}
+	}
+	if crlfHeader.Match(data) {
+		return 0, fmt.Errorf("unsupported file format: the header line ends in CRLF, so the file was probably converted " +
+			"to Windows line endings, e.g. by a transfer in text mode, which corrupts binary models")
+	}
+
+	if m := textHeader.FindSubmatch(data); m != nil {
+		words, err1 := strconv.Atoi(string(m[1]))
+		dimensions, err2 := strconv.Atoi(string(m[2]))
+		if err1 != nil || err2 != nil || words <= 0 || dimensions <= 0 {
+			return 0, fmt.Errorf("invalid header %q\nCheck that you have a valid model file", bytes.TrimSpace(m[0]))
+		}
+		records := data[len(m[0]):]
+		if isTextRecord(records, dimensions) {
+			return 0, fmt.Errorf("unsupported file format: the file is a text model (words and values written as text, like the .vec files of fastText): " +
+				"convert it to the binary format with model_processing_utils/fasttext-to-bin")
+		}
+		why32 := checkRecords(records, Float32, words, dimensions, whole)
+		if why32 == "" {
+			return Float32, nil
+		}
+		why16 := checkRecords(records, Float16, words, dimensions, whole)
+		if why16 == "" {
+			return Float16, nil
+		}
+		return 0, fmt.Errorf("the header %q is that of a 32-bit or half precision model, but the records fit neither: "+
+			"with 4-byte values %s; with 2-byte values %s\nCheck that you have a valid model file", bytes.TrimSpace(m[0]), why32, why16)
+	}
+
+	if len(data) >= 16 {
+		words := int32(binary.LittleEndian.Uint32(data[0:]))
+		dimensions := int32(binary.LittleEndian.Uint32(data[4:]))
+		min := math.Float32frombits(binary.LittleEndian.Uint32(data[8:]))
+		max := math.Float32frombits(binary.LittleEndian.Uint32(data[12:]))
+		if words > 0 && dimensions > 0 && finite(min) && finite(max) && min <= max {
+			why := checkRecords(data[16:], Int8, int(words), int(dimensions), whole)
+			if why == "" {
+				return Int8, nil
+			}
+			return 0, fmt.Errorf("the header is that of an 8-bit model of %d words of %d dimensions, but %s\nCheck that you have a valid model file", words, dimensions, why)
+		}
+	}
+
+	start := data
+	if len(start) > 16 {
+		start = start[:16]
+	}
+	return 0, fmt.Errorf("unsupported file format: the file starts with neither the \"words dimensions\" line of a 32-bit or half precision model "+
+		"nor the header of an 8-bit model (first bytes: % x)", start)
+}
+
+// checkRecords returns why data does not start with the records of a model
+// in format with the header's words and dimensions, or "" when it does. A
+// record cut by the end of data only fails when data is the whole file.
+func checkRecords(data []byte, format Format, words, dimensions int, whole bool) string {
+	separator, valueSize := byte(' '), 4
+	switch format {
+	case Float16:
+		valueSize = 2
+	case Int8:
+		separator, valueSize = 0, 1
+	}
+
+	pos := 0
+	for i := 1; i <= words && i <= sniffRecords; i++ {
+		end := bytes.IndexByte(data[pos:], separator)
+		if end < 0 {
+			if whole || len(data)-pos > maxWordLength {
+				return fmt.Sprintf("record %d has no word", i)
+			}
+			return ""
+		}
+		if !plausibleWord(data[pos : pos+end]) {
+			return fmt.Sprintf("record %d does not start with a word", i)
+		}
+		pos += end + 1
+
+		size := dimensions * valueSize
+		if pos+size > len(data) {
+			if whole {
+				return fmt.Sprintf("the file ends within the vector of record %d", i)
+			}
+			return ""
+		}
+		if !plausibleValues(data[pos:pos+size], format) {
+			return fmt.Sprintf("the vector of record %d holds values no model has", i)
+		}
+		pos += size
+
+		if format != Int8 && pos < len(data) && data[pos] == '\n' {
+			pos++
+		}
+	}
+
+	if whole && words <= sniffRecords && pos != len(data) {
+		return fmt.Sprintf("%d bytes follow the %d records of the header", len(data)-pos, words)
+	}
+	return ""
+}
+
+// isTextRecord reports whether data starts with a line of a text model: a
+// word and the given number of decimal values.
+func isTextRecord(data []byte, dimensions int) bool {
+	line, _, found := bytes.Cut(data, []byte("\n"))
+	if !found {
+		return false
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) != dimensions+1 {
+		return false
+	}
+	for _, field := range fields[1:] {
+		if _, err := strconv.ParseFloat(field, 32); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// plausibleWord reports whether b can be a word of a model: not empty, not
+// too long and without control characters, which values read as a word
+// almost always contain.
+func plausibleWord(b []byte) bool {
+	if len(b) == 0 || len(b) > maxWordLength {
+		return false
+	}
+	for _, c := range b {
+		if c < 0x20 || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// plausibleValues reports whether the little-endian values of b, in format,
+// are finite and of a magnitude found in embeddings.
+func plausibleValues(b []byte, format Format) bool {
+	switch format {
+	case Float32:
+		for i := 0; i+4 <= len(b); i += 4 {
+			x := math.Float32frombits(binary.LittleEndian.Uint32(b[i:]))
+			if !finite(x) || math.Abs(float64(x)) > maxValue {
+				return false
+			}
+		}
+	case Float16:
+		for i := 0; i+2 <= len(b); i += 2 {
+			// An all-ones exponent is an infinity or NaN
+			if binary.LittleEndian.Uint16(b[i:])&0x7c00 == 0x7c00 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// finite reports whether x is neither infinite nor NaN.
+func finite(x float32) bool {
+	return !math.IsInf(float64(x), 0) && !math.IsNaN(float64(x))
+}
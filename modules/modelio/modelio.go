@@ -0,0 +1,367 @@
+// Package modelio reads and writes the files of word embedding models, so
+// that w2vgrep and the model utilities share one implementation of each
+// format. Files are written in the format of their name, and read in the
+// format detected from their content, see Detect, and may be compressed
+// with gzip:
+//
+//   - .bin: a "words dimensions" header line, then each word, a space and
+//     its float32 values, little-endian, optionally followed by a newline.
+//     This is the binary format of the original word2vec, e.g. of the
+//     GoogleNews-vectors-negative300.bin.gz model published by Google.
+//   - .f16.bin: the same with half precision values of 2 bytes.
+//   - .8int.bin: the number of words and of dimensions as int32, the range
+//     of the original values as two float32, then each word, NUL terminated,
+//     and its int8 values.
+//
+// Words are kept in the order of the file, which is by decreasing frequency
+// for models written most frequent word first.
+package modelio
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/float16"
+)
+
+// Format is the encoding of a model file.
+type Format int
+
+const (
+	Float32 Format = iota // .bin
+	Float16               // .f16.bin
+	Int8                  // .8int.bin
+)
+
+// String returns the name of the format.
+func (f Format) String() string {
+	switch f {
+	case Float32:
+		return "32-bit"
+	case Float16:
+		return "half precision"
+	case Int8:
+		return "8-bit"
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// FormatOf returns the format of the model file at path from its name, the
+// format a new file is written in.
+func FormatOf(path string) (Format, error) {
+	// .8int.bin and .f16.bin end in .bin too, so they are checked first
+	switch {
+	case strings.HasSuffix(path, ".8int.bin"):
+		return Int8, nil
+	case strings.HasSuffix(path, ".f16.bin"):
+		return Float16, nil
+	case strings.HasSuffix(path, ".bin"):
+		return Float32, nil
+	}
+	return 0, fmt.Errorf("unsupported file format")
+}
+
+// Header describes the vectors of a model file.
+type Header struct {
+	Words      int
+	Dimensions int
+	// Min and Max are the range of the values that were quantized into an
+	// Int8 model. Other formats do not store them.
+	Min, Max float32
+}
+
+// Reader reads the words and vectors of a model file in order.
+type Reader struct {
+	reader *bufio.Reader
+	closer io.Closer
+	format Format
+	header Header
+	read   int
+	word   string
+	vector interface{}
+	err    error
+
+	// compressed is whether the file opened by Open is compressed with gzip
+	compressed bool
+}
+
+// Open opens the model file at path for reading, in the format detected
+// from its content.
+func Open(path string) (*Reader, error) {
+	format, err := Detect(path)
+	if err != nil {
+		return nil, err
+	}
+	return OpenFormat(path, format)
+}
+
+// OpenFormat opens the model file at path for reading in format.
+func OpenFormat(path string, format Format) (*Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	input, compressed, err := decompress(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r, err := NewReader(input, format)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r.closer = file
+	r.compressed = compressed
+	return r, nil
+}
+
+// gzipMagic starts the files compressed with gzip.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompress returns the content of file, decompressed when it is
+// compressed with gzip, and whether it was.
+func decompress(file io.Reader) (io.Reader, bool, error) {
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(len(gzipMagic))
+	if !bytes.Equal(magic, gzipMagic) {
+		return buffered, false, nil
+	}
+	gz, err := gzip.NewReader(buffered)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to decompress file: %v", err)
+	}
+	return gz, true, nil
+}
+
+// NewReader returns a reader of a model in format from input, having read
+// its header.
+func NewReader(input io.Reader, format Format) (*Reader, error) {
+	r := &Reader{reader: bufio.NewReader(input), format: format}
+	if format == Int8 {
+		var words, dimensions int32
+		for _, field := range []struct {
+			name  string
+			value interface{}
+		}{
+			{"vocab size", &words},
+			{"vector size", &dimensions},
+			{"min value", &r.header.Min},
+			{"max value", &r.header.Max},
+		} {
+			if err := binary.Read(r.reader, binary.LittleEndian, field.value); err != nil {
+				return nil, fmt.Errorf("failed to read %s: %v", field.name, err)
+			}
+		}
+		r.header.Words, r.header.Dimensions = int(words), int(dimensions)
+	} else {
+		// Spaces may end the header line, as written by some word2vec tools
+		line, err := r.reader.ReadString('\n')
+		if err == nil {
+			_, err = fmt.Sscanf(strings.TrimRight(line, " \t\n"), "%d %d", &r.header.Words, &r.header.Dimensions)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read header: %v\nCheck that you have a valid model file", err)
+		}
+	}
+
+	if r.header.Words <= 0 || r.header.Dimensions <= 0 {
+		return nil, fmt.Errorf("invalid header: vocabSize=%d, vectorSize=%d\nCheck that you have a valid model file", r.header.Words, r.header.Dimensions)
+	}
+	return r, nil
+}
+
+// Format returns the format of the model.
+func (r *Reader) Format() Format {
+	return r.format
+}
+
+// Compressed reports whether the file opened by Open is compressed with
+// gzip.
+func (r *Reader) Compressed() bool {
+	return r.compressed
+}
+
+// Header returns the header of the model.
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Next reads the next word and its vector, and returns false after the
+// last one or on an error, see Err.
+func (r *Reader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	if r.read == r.header.Words {
+		// Records must end with the file
+		if _, err := r.reader.ReadByte(); err != io.EOF {
+			r.err = fmt.Errorf("unexpected data at end of file.\nCheck that you have a valid model file")
+		}
+		return false
+	}
+
+	if r.format == Int8 {
+		word, err := r.reader.ReadString(0)
+		if err != nil {
+			r.err = fmt.Errorf("failed to read word: %v", err)
+			return false
+		}
+		r.word = strings.TrimSuffix(word, "\x00")
+	} else {
+		word, err := r.reader.ReadString(' ')
+		if err != nil {
+			r.err = fmt.Errorf("failed to read word: %v", err)
+			return false
+		}
+		r.word = strings.TrimSpace(word)
+	}
+
+	switch r.format {
+	case Float32:
+		r.vector = make([]float32, r.header.Dimensions)
+	case Float16:
+		r.vector = make([]float16.Float16, r.header.Dimensions)
+	case Int8:
+		r.vector = make([]int8, r.header.Dimensions)
+	}
+	if err := binary.Read(r.reader, binary.LittleEndian, r.vector); err != nil {
+		r.err = fmt.Errorf("failed to read vector: %v", err)
+		return false
+	}
+
+	// Records of text-headed formats may end with a newline
+	if r.format != Int8 {
+		next, err := r.reader.Peek(1)
+		if err != nil && err != io.EOF {
+			r.err = fmt.Errorf("unexpected error reading next byte: %v", err)
+			return false
+		}
+		if len(next) > 0 && next[0] == '\n' {
+			r.reader.ReadByte()
+		}
+	}
+	r.read++
+	return true
+}
+
+// Word returns the word read by Next.
+func (r *Reader) Word() string {
+	return r.word
+}
+
+// Vector returns the vector read by Next, a []float32, []float16.Float16 or
+// []int8 by the format, newly allocated for each word.
+func (r *Reader) Vector() interface{} {
+	return r.vector
+}
+
+// Err returns the error that stopped Next, if any.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Close closes the file opened by Open.
+func (r *Reader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+// Float32Values returns the values of a []float32, []float16.Float16 or
+// []int8 vector as float32, converting them when needed.
+func Float32Values(vector interface{}) []float32 {
+	switch v := vector.(type) {
+	case []float32:
+		return v
+	case []float16.Float16:
+		out := make([]float32, len(v))
+		for i, x := range v {
+			out[i] = x.Float32()
+		}
+		return out
+	case []int8:
+		out := make([]float32, len(v))
+		for i, x := range v {
+			out[i] = float32(x)
+		}
+		return out
+	}
+	return nil
+}
+
+// Each calls fn with every word of the model file at path and its values
+// as float32, in the order of the file, stopping at the first error.
+func Each(path string, fn func(word string, vector []float32) error) error {
+	r, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for r.Next() {
+		if err := fn(r.Word(), Float32Values(r.Vector())); err != nil {
+			return err
+		}
+	}
+	return r.Err()
+}
+
+// Model is a whole model in memory, its words in the order of the file and
+// their values as float32, for utilities that work on all vectors at once.
+type Model struct {
+	Words   []string
+	Vectors [][]float32
+}
+
+// Dimensions returns the number of values of the vectors.
+func (m *Model) Dimensions() int {
+	if len(m.Vectors) == 0 {
+		return 0
+	}
+	return len(m.Vectors[0])
+}
+
+// Load reads the model file at path.
+func Load(path string) (*Model, error) {
+	m := &Model{}
+	err := Each(path, func(word string, vector []float32) error {
+		m.Words = append(m.Words, word)
+		m.Vectors = append(m.Vectors, vector)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Save writes m to path, in the Float32 or Float16 format by its name. Int8
+// models are written with a Writer, from vectors quantized by the caller.
+func Save(path string, m *Model) error {
+	format, err := FormatOf(path)
+	if err != nil {
+		return err
+	}
+	if format == Int8 {
+		return errors.New("int8 models must be quantized first, see Writer")
+	}
+	w, err := Create(path, format, Header{Words: len(m.Words), Dimensions: m.Dimensions()})
+	if err != nil {
+		return err
+	}
+	for i, word := range m.Words {
+		if err := w.Write(word, m.Vectors[i]); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
@@ -0,0 +1,145 @@
+package modelio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/arunsupe/semantic-grep/modules/float16"
+)
+
+// Writer writes the words and vectors of a model file. The header, written
+// first, must give the number of words that follow.
+type Writer struct {
+	writer  *bufio.Writer
+	file    *os.File
+	format  Format
+	header  Header
+	written int
+}
+
+// Create creates the model file at path and writes its header.
+func Create(path string, format Format, header Header) (*Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(file, format, header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	w.file = file
+	return w, nil
+}
+
+// NewWriter returns a writer of a model in format to output, having
+// written its header.
+func NewWriter(output io.Writer, format Format, header Header) (*Writer, error) {
+	if header.Words <= 0 || header.Dimensions <= 0 {
+		return nil, fmt.Errorf("no words to write")
+	}
+	w := &Writer{writer: bufio.NewWriter(output), format: format, header: header}
+	if err := WriteHeader(w.writer, format, header); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteHeader writes the header of a model in format to w. Writer writes
+// it itself; WriteHeader and WriteRecord are for writers that only know the
+// number of words at the end, and write the records to a temporary file.
+func WriteHeader(w io.Writer, format Format, header Header) error {
+	if format == Int8 {
+		fields := []interface{}{int32(header.Words), int32(header.Dimensions), header.Min, header.Max}
+		for _, field := range fields {
+			if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "%d %d\n", header.Words, header.Dimensions)
+	return err
+}
+
+// Write writes word and its vector, see WriteRecord.
+func (w *Writer) Write(word string, vector interface{}) error {
+	if w.written == w.header.Words {
+		return fmt.Errorf("more words than the %d of the header", w.header.Words)
+	}
+	if length := vectorLength(vector); length != w.header.Dimensions {
+		return fmt.Errorf("vector of %q has %d dimensions, expected %d", word, length, w.header.Dimensions)
+	}
+	if err := WriteRecord(w.writer, w.format, word, vector); err != nil {
+		return err
+	}
+	w.written++
+	return nil
+}
+
+// WriteRecord writes word and its vector to w in format. Float32 and
+// Float16 models take []float32 vectors, converted to half precision for
+// Float16, and Float16 and Int8 models take vectors of their own type.
+func WriteRecord(w io.Writer, format Format, word string, vector interface{}) error {
+	var values interface{}
+	switch v := vector.(type) {
+	case []float32:
+		switch format {
+		case Float32:
+			values = v
+		case Float16:
+			values = float16.Vector(v)
+		}
+	case []float16.Float16:
+		if format == Float16 {
+			values = v
+		}
+	case []int8:
+		if format == Int8 {
+			values = v
+		}
+	}
+	if values == nil {
+		return fmt.Errorf("vector of %q: %T values cannot be written to this format", word, vector)
+	}
+
+	separator := " "
+	if format == Int8 {
+		separator = "\x00"
+	}
+	if _, err := io.WriteString(w, word+separator); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, values)
+}
+
+// Close flushes the model and closes the file created by Create. It fails
+// when fewer words were written than the header announced.
+func (w *Writer) Close() error {
+	err := w.writer.Flush()
+	if err == nil && w.written != w.header.Words {
+		err = fmt.Errorf("%d words written, but the header announced %d", w.written, w.header.Words)
+	}
+	if w.file != nil {
+		if closeErr := w.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// vectorLength returns the number of values of a vector.
+func vectorLength(vector interface{}) int {
+	switch v := vector.(type) {
+	case []float32:
+		return len(v)
+	case []float16.Float16:
+		return len(v)
+	case []int8:
+		return len(v)
+	}
+	return 0
+}
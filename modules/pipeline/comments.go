@@ -0,0 +1,174 @@
+package pipeline
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Syntax is the syntax of the comments and string literals of a language.
+type Syntax struct {
+	// LineComments start comments running to the end of the line, e.g. "//"
+	LineComments []string
+	// BlockComments are the start and end of comments spanning lines
+	BlockComments [][2]string
+	// Quotes delimit string literals, longest first
+	Quotes []Quote
+}
+
+// Quote is the delimiter of string literals.
+type Quote struct {
+	Delimiter string
+	// Multiline literals may span lines, e.g. Python's """ or Go's `
+	Multiline bool
+	// Raw literals have no backslash escapes
+	Raw bool
+}
+
+var (
+	cQuotes    = []Quote{{Delimiter: `"`}, {Delimiter: `'`}}
+	hashQuotes = []Quote{{Delimiter: `"`}, {Delimiter: `'`, Raw: true}}
+	cSyntax    = &Syntax{LineComments: []string{"//"}, BlockComments: [][2]string{{"/*", "*/"}}, Quotes: cQuotes}
+)
+
+// syntaxes are the syntaxes of the source files, by extension.
+var syntaxes = map[string]*Syntax{
+	".go": {LineComments: []string{"//"}, BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes: []Quote{{Delimiter: "`", Multiline: true, Raw: true}, {Delimiter: `"`}, {Delimiter: `'`}}},
+	".js": {LineComments: []string{"//"}, BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes: []Quote{{Delimiter: "`", Multiline: true}, {Delimiter: `"`}, {Delimiter: `'`}}},
+	".ts": {LineComments: []string{"//"}, BlockComments: [][2]string{{"/*", "*/"}},
+		Quotes: []Quote{{Delimiter: "`", Multiline: true}, {Delimiter: `"`}, {Delimiter: `'`}}},
+	".java":  cSyntax,
+	".c":     cSyntax,
+	".h":     cSyntax,
+	".cc":    cSyntax,
+	".cpp":   cSyntax,
+	".cs":    cSyntax,
+	".kt":    {LineComments: []string{"//"}, BlockComments: [][2]string{{"/*", "*/"}}, Quotes: []Quote{{Delimiter: `"""`, Multiline: true, Raw: true}, {Delimiter: `"`}}},
+	".swift": {LineComments: []string{"//"}, BlockComments: [][2]string{{"/*", "*/"}}, Quotes: []Quote{{Delimiter: `"""`, Multiline: true}, {Delimiter: `"`}}},
+	// A ' in Rust also starts lifetimes, as in &'a str
+	".rs":  {LineComments: []string{"//"}, BlockComments: [][2]string{{"/*", "*/"}}, Quotes: []Quote{{Delimiter: `"`, Multiline: true}}},
+	".php": {LineComments: []string{"//", "#"}, BlockComments: [][2]string{{"/*", "*/"}}, Quotes: cQuotes},
+	".py": {LineComments: []string{"#"},
+		Quotes: []Quote{{Delimiter: `"""`, Multiline: true}, {Delimiter: `'''`, Multiline: true}, {Delimiter: `"`}, {Delimiter: `'`}}},
+	".rb": {LineComments: []string{"#"}, Quotes: hashQuotes},
+	".sh": {LineComments: []string{"#"}, Quotes: hashQuotes},
+}
+
+// CommentSyntax returns the syntax of the source file name, by its
+// extension, or nil when it is not a source file.
+func CommentSyntax(name string) *Syntax {
+	return syntaxes[strings.ToLower(filepath.Ext(name))]
+}
+
+// Comments writes the comments and string literals of the source code read
+// from r, a line for each line of the source, with the rest of the code
+// blanked with spaces, so that line numbers and columns still refer to the
+// source. Lines with neither are empty.
+func (s *Syntax) Comments(r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+	out := bufio.NewWriter(w)
+	var c commentScanner
+	c.syntax = s
+	for scanner.Scan() {
+		out.WriteString(c.line(scanner.Text()))
+		out.WriteByte('\n')
+	}
+	err := scanner.Err()
+	if flushErr := out.Flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+// commentScanner finds the comments and string literals of lines, which may
+// start in a line and end in another.
+type commentScanner struct {
+	syntax *Syntax
+	// blockEnd is the end of the block comment open, if any
+	blockEnd string
+	// quote is the delimiter of the string literal open, if any
+	quote *Quote
+}
+
+// line returns line with its code blanked, keeping comments and string
+// literals with their delimiters.
+func (c *commentScanner) line(line string) string {
+	kept := []byte(line)
+	blank := func(i int) {
+		if kept[i] != '\t' {
+			kept[i] = ' '
+		}
+	}
+
+	i := 0
+	for i < len(line) {
+		switch {
+		case c.blockEnd != "":
+			end := strings.Index(line[i:], c.blockEnd)
+			if end < 0 {
+				i = len(line)
+				break
+			}
+			i += end + len(c.blockEnd)
+			c.blockEnd = ""
+		case c.quote != nil:
+			i = c.endQuote(line, i)
+		default:
+			i = c.code(line, i, blank)
+		}
+	}
+	// Only multiline literals go on past the end of the line
+	if c.quote != nil && !c.quote.Multiline {
+		c.quote = nil
+	}
+	return strings.TrimRight(string(kept), " \t")
+}
+
+// endQuote returns the index in line past the end of the string literal
+// open at i, or the length of line if it does not end in it.
+func (c *commentScanner) endQuote(line string, i int) int {
+	for i < len(line) {
+		if !c.quote.Raw && line[i] == '\\' {
+			i += 2
+			continue
+		}
+		if strings.HasPrefix(line[i:], c.quote.Delimiter) {
+			i += len(c.quote.Delimiter)
+			c.quote = nil
+			return i
+		}
+		i++
+	}
+	return len(line)
+}
+
+// code blanks the code of line from i to the start of the next comment or
+// string literal, and returns the index past the start of it.
+func (c *commentScanner) code(line string, i int, blank func(int)) int {
+	for ; i < len(line); i++ {
+		rest := line[i:]
+		for _, start := range c.syntax.LineComments {
+			if strings.HasPrefix(rest, start) {
+				return len(line)
+			}
+		}
+		for _, block := range c.syntax.BlockComments {
+			if strings.HasPrefix(rest, block[0]) {
+				c.blockEnd = block[1]
+				return i + len(block[0])
+			}
+		}
+		for q := range c.syntax.Quotes {
+			if quote := &c.syntax.Quotes[q]; strings.HasPrefix(rest, quote.Delimiter) {
+				c.quote = quote
+				return i + len(quote.Delimiter)
+			}
+		}
+		blank(i)
+	}
+	return i
+}
@@ -0,0 +1,185 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// Extractors write the text of a whole document read from r to w. Unlike
+// preprocessors, they produce lines of their own, e.g. one per paragraph.
+var Extractors = map[string]func(r io.Reader, w io.Writer) error{
+	"html": HTML,
+	"docx": DOCX,
+}
+
+// htmlBlocks are the HTML elements that start and end a line of text.
+var htmlBlocks = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "body": true, "br": true,
+	"caption": true, "dd": true, "div": true, "dl": true, "dt": true, "figcaption": true, "figure": true,
+	"footer": true, "form": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"head": true, "header": true, "hr": true, "html": true, "li": true, "main": true, "nav": true, "ol": true,
+	"option": true, "p": true, "pre": true, "section": true, "table": true, "title": true, "tr": true, "ul": true,
+}
+
+// htmlCells are the HTML elements separated by a space on their line.
+var htmlCells = map[string]bool{"td": true, "th": true}
+
+// HTML writes the text of an HTML document: the content of its elements,
+// without tags, comments, scripts and styles, with character references
+// such as &amp; decoded and spaces collapsed. Block elements, such as
+// paragraphs, headings and list items, are written on lines of their own.
+func HTML(r io.Reader, w io.Writer) error {
+	doc, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(w)
+	var text strings.Builder
+	endLine := func() {
+		if line := strings.Join(strings.Fields(html.UnescapeString(text.String())), " "); line != "" {
+			out.WriteString(line)
+			out.WriteByte('\n')
+		}
+		text.Reset()
+	}
+
+	s := string(doc)
+	for s != "" {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			text.WriteString(s)
+			break
+		}
+		text.WriteString(s[:i])
+		s = s[i:]
+
+		// A < not starting a tag, as in "a < b", is text
+		if len(s) < 2 || !isTagStart(s[1]) {
+			text.WriteByte('<')
+			s = s[1:]
+			continue
+		}
+		if strings.HasPrefix(s, "<!--") {
+			s = after(s, "-->")
+			continue
+		}
+		end := strings.IndexByte(s, '>')
+		if end < 0 {
+			break
+		}
+		tag := s[1:end]
+		s = s[end+1:]
+
+		name := tagName(tag)
+		switch {
+		case !strings.HasPrefix(tag, "/") && (name == "script" || name == "style"):
+			// Their content is not text; skip to the closing tag
+			if i := strings.Index(strings.ToLower(s), "</"+name); i >= 0 {
+				s = s[i:]
+			} else {
+				s = ""
+			}
+		case htmlBlocks[name]:
+			endLine()
+		case htmlCells[name]:
+			text.WriteByte(' ')
+		}
+	}
+	endLine()
+	return out.Flush()
+}
+
+// isTagStart reports whether c, following a <, starts a tag, a closing tag,
+// a comment or a declaration.
+func isTagStart(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '/' || c == '!' || c == '?'
+}
+
+// tagName returns the lowercase name of an element from the text of its
+// tag, between < and >, e.g. "p" for `/p` or `p class="x"`.
+func tagName(tag string) string {
+	tag = strings.TrimPrefix(tag, "/")
+	if i := strings.IndexAny(tag, " \t\r\n/"); i >= 0 {
+		tag = tag[:i]
+	}
+	return strings.ToLower(tag)
+}
+
+// after returns the rest of s after the first sep, or "" if there is none.
+func after(s, sep string) string {
+	if _, rest, found := strings.Cut(s, sep); found {
+		return rest
+	}
+	return ""
+}
+
+// DOCX writes the text of a Word document (.docx), one paragraph per line,
+// with tabs and line breaks kept. The document is read into memory, as a
+// zip archive cannot be read as a stream.
+func DOCX(r io.Reader, w io.Writer) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("not a DOCX document: %v", err)
+	}
+	var document *zip.File
+	for _, f := range archive.File {
+		if f.Name == "word/document.xml" {
+			document = f
+		}
+	}
+	if document == nil {
+		return fmt.Errorf("not a DOCX document: no word/document.xml")
+	}
+	content, err := document.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	out := bufio.NewWriter(w)
+	decoder := xml.NewDecoder(content)
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("DOCX document: %v", err)
+		}
+		// Text is in runs of w:t elements, within w:p paragraphs
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				out.WriteByte('\t')
+			case "br", "cr":
+				out.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				out.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				out.Write(t)
+			}
+		}
+	}
+	return out.Flush()
+}
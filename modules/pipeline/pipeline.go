@@ -0,0 +1,299 @@
+// Package pipeline detects the type of files, such as Markdown, source code
+// or PDF, and turns them into plain text lines for matching. Preprocessors
+// work line by line, so line numbers still refer to the original file;
+// commands, such as pdftotext for PDF, and extractors, such as those of HTML
+// and DOCX documents, produce text of their own.
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Binary is the type of files that are not text and have no pipeline.
+// They are skipped when searching directories, see --binary-files.
+const Binary = "binary"
+
+// Pipeline turns files of one type into text.
+type Pipeline struct {
+	// Extensions are the file name extensions of the type, e.g. ".md".
+	Extensions []string `json:"extensions"`
+	// Preprocessor is the name of a built-in preprocessor (see
+	// Preprocessors), or "none" to search the file as it is.
+	Preprocessor string `json:"preprocessor,omitempty"`
+	// Command, when set, is run with the file as standard input, and its
+	// output is searched instead, e.g. ["pdftotext", "-", "-"].
+	Command []string `json:"command,omitempty"`
+	// Extractor is the name of a built-in extractor of the text of whole
+	// documents (see Extractors).
+	Extractor string `json:"extractor,omitempty"`
+}
+
+// Defaults are the built-in types and their pipelines.
+var Defaults = map[string]Pipeline{
+	"markdown":  {Extensions: []string{".md", ".markdown"}, Preprocessor: "markdown"},
+	"code":      {Extensions: []string{".go", ".py", ".js", ".ts", ".java", ".c", ".h", ".cc", ".cpp", ".rs", ".rb", ".php", ".cs", ".kt", ".swift", ".sh"}, Preprocessor: "code"},
+	"subtitles": {Extensions: []string{".srt", ".vtt"}, Preprocessor: "subtitles"},
+	"jsonl":     {Extensions: []string{".jsonl", ".ndjson"}, Preprocessor: "jsonl"},
+	"pdf":       {Extensions: []string{".pdf"}, Command: []string{"pdftotext", "-q", "-", "-"}},
+	"html":      {Extensions: []string{".html", ".htm", ".xhtml"}, Extractor: "html"},
+	"docx":      {Extensions: []string{".docx"}, Extractor: "docx"},
+}
+
+// Router picks the pipeline of each file.
+type Router struct {
+	pipelines map[string]Pipeline
+	types     map[string]string // extension to type
+}
+
+// NewRouter returns a router using the default pipelines, replaced or
+// extended by the configured ones.
+func NewRouter(configured map[string]Pipeline) (*Router, error) {
+	r := &Router{pipelines: make(map[string]Pipeline), types: make(map[string]string)}
+	for name, p := range Defaults {
+		r.pipelines[name] = p
+	}
+	for name, p := range configured {
+		if name == Binary {
+			return nil, fmt.Errorf("%s: reserved type name", name)
+		}
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		r.pipelines[name] = p
+	}
+
+	// Configured extensions take precedence over the default ones
+	names := make([]string, 0, len(r.pipelines))
+	for name := range r.pipelines {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		_, iConfigured := configured[names[i]]
+		_, jConfigured := configured[names[j]]
+		if iConfigured != jConfigured {
+			return jConfigured
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		for _, ext := range r.pipelines[name].Extensions {
+			r.types[strings.ToLower(ext)] = name
+		}
+	}
+	return r, nil
+}
+
+// Validate checks that the pipeline does one thing.
+func (p Pipeline) Validate() error {
+	for _, ext := range p.Extensions {
+		if !strings.HasPrefix(ext, ".") {
+			return fmt.Errorf("extension %q does not start with a dot", ext)
+		}
+	}
+	ways := 0
+	for _, set := range []bool{p.Preprocessor != "", len(p.Command) > 0, p.Extractor != ""} {
+		if set {
+			ways++
+		}
+	}
+	if ways > 1 {
+		return fmt.Errorf("a pipeline has either a preprocessor, a command or an extractor")
+	}
+	if ways == 0 {
+		return fmt.Errorf("no preprocessor, command or extractor")
+	}
+	if p.Extractor != "" {
+		if _, ok := Extractors[p.Extractor]; !ok {
+			return fmt.Errorf("unknown extractor %q, expected one of %s", p.Extractor, strings.Join(extractorNames(), ", "))
+		}
+	}
+	if p.Preprocessor != "" && p.Preprocessor != "none" {
+		if _, ok := Preprocessors[p.Preprocessor]; !ok {
+			return fmt.Errorf("unknown preprocessor %q, expected none or one of %s", p.Preprocessor, strings.Join(preprocessorNames(), ", "))
+		}
+	}
+	return nil
+}
+
+// Detect returns the type of a file from its name and its first bytes, or
+// "" for plain text. The extension decides first; otherwise PDF,
+// subtitles, JSON lines and binary content are recognized by sniffing.
+func (r *Router) Detect(name string, head []byte) string {
+	if t, ok := r.types[strings.ToLower(filepath.Ext(name))]; ok {
+		return t
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return r.known("pdf")
+	case isHTML(head):
+		return r.known("html")
+	case bytes.IndexByte(head, 0) >= 0:
+		return Binary
+	case bytes.HasPrefix(head, []byte("WEBVTT")):
+		return r.known("subtitles")
+	}
+
+	lines := strings.SplitN(string(head), "\n", 3)
+	if len(lines) >= 2 && isDigits(strings.TrimSpace(lines[0])) && strings.Contains(lines[1], "-->") {
+		return r.known("subtitles")
+	}
+	if first := strings.TrimSpace(lines[0]); strings.HasPrefix(first, "{") && len(lines) > 1 && json.Valid([]byte(first)) {
+		return r.known("jsonl")
+	}
+	return ""
+}
+
+// isHTML reports whether head is the start of an HTML document.
+func isHTML(head []byte) bool {
+	start := strings.ToLower(string(bytes.TrimSpace(head[:min(len(head), 64)])))
+	return strings.HasPrefix(start, "<!doctype html") || strings.HasPrefix(start, "<html")
+}
+
+// Has reports whether the router has a pipeline for type t.
+func (r *Router) Has(t string) bool {
+	_, ok := r.pipelines[t]
+	return ok
+}
+
+// Types returns the names of the types of the router, sorted.
+func (r *Router) Types() []string {
+	names := make([]string, 0, len(r.pipelines))
+	for name := range r.pipelines {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// IsDocument reports whether files of type t are documents converted to
+// text of their own by a command or an extractor, such as PDF, rather than
+// text files preprocessed line by line.
+func (r *Router) IsDocument(t string) bool {
+	p, ok := r.pipelines[t]
+	return ok && (len(p.Command) > 0 || p.Extractor != "")
+}
+
+// known returns t if the router has a pipeline for it, and "" otherwise.
+func (r *Router) known(t string) string {
+	if _, ok := r.pipelines[t]; ok {
+		return t
+	}
+	return ""
+}
+
+// SniffSize is the number of bytes Detect needs to sniff content.
+const SniffSize = 512
+
+// Open returns the text of a file of type t read from input: input itself
+// for plain text, or the output of the pipeline of t. The returned close
+// function waits for a command and reports its failure.
+func (r *Router) Open(t string, input io.Reader) (io.Reader, func() error, error) {
+	p, ok := r.pipelines[t]
+	if !ok || p.Preprocessor == "none" {
+		return input, func() error { return nil }, nil
+	}
+
+	if len(p.Command) > 0 {
+		cmd := exec.Command(p.Command[0], p.Command[1:]...)
+		cmd.Stdin = input
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		output, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, nil, fmt.Errorf("%s pipeline: %v", t, err)
+		}
+		wait := func() error {
+			// Drain the output, so that the command finishes even when the search stopped early
+			io.Copy(io.Discard, output)
+			if err := cmd.Wait(); err != nil {
+				return fmt.Errorf("%s pipeline: %s: %v %s", t, p.Command[0], err, strings.TrimSpace(stderr.String()))
+			}
+			return nil
+		}
+		return output, wait, nil
+	}
+
+	if p.Extractor != "" {
+		reader, close := Extract(t, Extractors[p.Extractor], input)
+		return reader, close, nil
+	}
+
+	preprocess := Preprocessors[p.Preprocessor]
+	reader, writer := io.Pipe()
+	go func() {
+		scanner := bufio.NewScanner(input)
+		scanner.Buffer(make([]byte, 0, 64*1024), 64<<20)
+		w := bufio.NewWriter(writer)
+		for scanner.Scan() {
+			w.WriteString(preprocess(scanner.Text()))
+			w.WriteByte('\n')
+		}
+		err := scanner.Err()
+		if flushErr := w.Flush(); err == nil {
+			err = flushErr
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader, func() error { return reader.Close() }, nil
+}
+
+// Extract returns the text extracted from input by extract, as it is
+// written, and a function to call when done reading it. Errors of extract
+// are read as errors of the pipeline of type t.
+func Extract(t string, extract func(r io.Reader, w io.Writer) error, input io.Reader) (io.Reader, func() error) {
+	reader, writer := io.Pipe()
+	go func() {
+		err := extract(input, writer)
+		if err != nil {
+			err = fmt.Errorf("%s pipeline: %v", t, err)
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader, func() error { return reader.Close() }
+}
+
+// preprocessorNames returns the names of the built-in preprocessors, sorted.
+func preprocessorNames() []string {
+	names := make([]string, 0, len(Preprocessors))
+	for name := range Preprocessors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// extractorNames returns the names of the built-in extractors, sorted.
+func extractorNames() []string {
+	names := make([]string, 0, len(Extractors))
+	for name := range Extractors {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// isDigits reports whether s is a non-empty string of ASCII digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
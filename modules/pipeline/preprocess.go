@@ -0,0 +1,115 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Preprocessors turn a line of a file into the text to match. They never
+// join or split lines, so line numbers are kept.
+var Preprocessors = map[string]func(line string) string{
+	"markdown":  Markdown,
+	"code":      Code,
+	"subtitles": Subtitles,
+	"jsonl":     JSONLine,
+}
+
+var (
+	markdownImage    = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	markdownLink     = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	markdownPrefix   = regexp.MustCompile(`^\s*(#{1,6}\s+|>\s?|[-*+]\s+|\d+[.)]\s+)+`)
+	markdownEmphasis = regexp.MustCompile("[*_`~]+")
+	htmlTag          = regexp.MustCompile(`<[^>]*>`)
+)
+
+// Markdown strips the markup of a line of Markdown: heading, quote and list
+// markers, emphasis, HTML tags, and link targets, keeping link texts and
+// image descriptions.
+func Markdown(line string) string {
+	line = markdownImage.ReplaceAllString(line, "$1")
+	line = markdownLink.ReplaceAllString(line, "$1")
+	line = markdownPrefix.ReplaceAllString(line, "")
+	line = htmlTag.ReplaceAllString(line, "")
+	return markdownEmphasis.ReplaceAllString(line, "")
+}
+
+// Code splits the identifiers of a line of source code into words, so that
+// "maxLineLength" reads "max Line Length" and "max_line_length" reads
+// "max line length"; with -i, both match "length".
+func Code(line string) string {
+	var b strings.Builder
+	var prev rune
+	for i, r := range line {
+		switch {
+		case r == '_':
+			b.WriteByte(' ')
+		case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsUpper(prev) && nextIsLower(line[i:])):
+			// A capital starts a word, and so does the last capital of an
+			// acronym, as in "HTTPServer"
+			b.WriteByte(' ')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+		prev = r
+	}
+	return b.String()
+}
+
+// nextIsLower reports whether the rune after the first one of s is a
+// lowercase letter.
+func nextIsLower(s string) bool {
+	for i, r := range s {
+		if i > 0 {
+			return unicode.IsLower(r)
+		}
+	}
+	return false
+}
+
+// Subtitles blanks the cue numbers, timings and WEBVTT header of SRT and
+// WebVTT subtitles, and strips formatting tags from the text.
+func Subtitles(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if isDigits(trimmed) || strings.Contains(trimmed, "-->") || strings.HasPrefix(trimmed, "WEBVTT") {
+		return ""
+	}
+	return htmlTag.ReplaceAllString(line, "")
+}
+
+// JSONLine replaces a JSON object with its string values, in key order,
+// so that field names and syntax do not match. Lines that are not JSON are
+// kept.
+func JSONLine(line string) string {
+	var value interface{}
+	if err := json.Unmarshal([]byte(line), &value); err != nil {
+		return line
+	}
+	var texts []string
+	collectStrings(value, &texts)
+	return strings.Join(texts, " ")
+}
+
+// collectStrings appends the strings of a decoded JSON value to texts.
+func collectStrings(value interface{}, texts *[]string) {
+	switch v := value.(type) {
+	case string:
+		*texts = append(*texts, v)
+	case []interface{}:
+		for _, item := range v {
+			collectStrings(item, texts)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			collectStrings(v[key], texts)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Defaults of AdaptiveScan.
+const (
+	DefaultBlockLines  = 32
+	DefaultSampleEvery = 4
+)
+
+// AdaptiveScan makes searches of large inputs with few matches faster by
+// scoring the input in two passes. A coarse pass reads blocks of lines and
+// scores only a sample of their words; the lines of a block are scored in
+// full only when a sampled word scores above CoarseThreshold, or when a
+// query word itself occurs in the block. Lines of the other blocks do not
+// match. This is an approximation: a block whose only similar word was not
+// sampled is skipped, so CoarseThreshold is usually set below the threshold.
+type AdaptiveScan struct {
+	// BlockLines is the number of lines of a block (DefaultBlockLines when 0).
+	BlockLines int
+	// SampleEvery scores one word in SampleEvery in the coarse pass
+	// (DefaultSampleEvery when 0). 1 scores every word.
+	SampleEvery int
+	// CoarseThreshold is the score a sampled word must exceed for its block
+	// to be scored in full.
+	CoarseThreshold float64
+}
+
+// blockLines returns the number of lines of a block.
+func (a *AdaptiveScan) blockLines() int {
+	if a.BlockLines <= 0 {
+		return DefaultBlockLines
+	}
+	return a.BlockLines
+}
+
+// sampleEvery returns the sampling interval of the coarse pass.
+func (a *AdaptiveScan) sampleEvery() int {
+	if a.SampleEvery <= 0 {
+		return DefaultSampleEvery
+	}
+	return a.SampleEvery
+}
+
+// blockLine is a line read ahead with its byte offset in the input.
+type blockLine struct {
+	text  []byte
+	start int64
+}
+
+// lineSource reads the lines of an input with their byte offsets. With
+// Options.Adaptive, it reads a block of lines ahead and runs the coarse
+// pass on it, telling for each line whether its block was skipped.
+type lineSource struct {
+	scanner  *bufio.Scanner
+	offsets  *offsetTracker
+	adaptive *AdaptiveScan
+	matcher  *Matcher
+	block    []blockLine
+	next     int
+
+	// The current line, its offset, and whether its block was skipped
+	text    []byte
+	start   int64
+	skipped bool
+}
+
+// newLineSource returns a source of the lines of input, read with the
+// line length, terminator and adaptive options of the matcher.
+func newLineSource(input io.Reader, matcher *Matcher) *lineSource {
+	offsets := newOffsetTracker(matcher.opts)
+	scanner := newScanner(input, matcher.opts)
+	scanner.Split(offsets.split)
+	return &lineSource{scanner: scanner, offsets: offsets, adaptive: matcher.opts.Adaptive, matcher: matcher}
+}
+
+// scan advances to the next line, and returns false at the end of the
+// input or on an error, see err.
+func (s *lineSource) scan() bool {
+	if s.adaptive == nil {
+		if !s.scanner.Scan() {
+			return false
+		}
+		s.text, s.start = s.scanner.Bytes(), s.offsets.lineStart
+		return true
+	}
+
+	if s.next == len(s.block) {
+		s.block, s.next = s.block[:0], 0
+		for len(s.block) < s.adaptive.blockLines() && s.scanner.Scan() {
+			s.block = append(s.block, blockLine{text: bytes.Clone(s.scanner.Bytes()), start: s.offsets.lineStart})
+		}
+		if len(s.block) == 0 {
+			return false
+		}
+		s.skipped = !s.matcher.coarseMatch(s.block, s.adaptive)
+	}
+	line := s.block[s.next]
+	s.next++
+	s.text, s.start = line.text, line.start
+	return true
+}
+
+// err returns the error that stopped scan, if any.
+func (s *lineSource) err() error {
+	return s.scanner.Err()
+}
+
+// coarseMatch reports whether a block may hold a match: whether one of
+// its words is a query word, or one of its sampled words scores above the
+// coarse threshold.
+func (m *Matcher) coarseMatch(block []blockLine, a *AdaptiveScan) bool {
+	every := a.sampleEvery()
+	words := 0
+	for _, line := range block {
+		for _, token := range m.coarseWords(line.text) {
+			if m.opts.IgnoreCase {
+				token = strings.ToLower(token)
+			}
+			sampled := words%every == 0
+			words++
+			for _, q := range m.concepts {
+				if q.literal(token) {
+					return true
+				}
+				if !sampled {
+					continue
+				}
+				if _, ok := q.score(token, m.model, a.CoarseThreshold); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// coarseWords splits a line into words for the coarse pass. Runs of
+// letters, digits and symbols are much cheaper to find than Unicode word
+// boundaries, and are good enough to sample; only the cjk segmenter, which
+// needs the model to find words, is used as is.
+func (m *Matcher) coarseWords(line []byte) []string {
+	var words []string
+	if m.opts.Segmenter == SegmenterCJK {
+		for _, segment := range segmentLine(line, m.model, m.opts) {
+			if isWord(segment.text) {
+				words = append(words, segment.text)
+			}
+		}
+		return words
+	}
+	for _, field := range bytes.FieldsFunc(line, isCoarseSeparator) {
+		words = append(words, string(field))
+	}
+	return words
+}
+
+// isCoarseSeparator reports whether r separates the words of the coarse pass.
+func isCoarseSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r) && !unicode.IsSymbol(r) && r != '\u200d'
+}
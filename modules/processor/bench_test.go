@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+)
+
+// benchText is the text tokenized and searched by the benchmarks: English,
+// and Chinese for the cjk segmenter.
+var benchText = []byte(strings.Repeat(`The old man and the sea.
+He was killed near the ocean waves, and his death was mourned by all.
+A cat sat on the mat while the invoice for the fraud was paid.
+Running water runs to the sea; the dying light fades over the ocean.
+战争带来了死亡和痛苦，海洋里的猫在看海。
+`, 200))
+
+// BenchmarkTokenize measures splitting lines into tokens, with each
+// segmenter.
+func BenchmarkTokenize(b *testing.B) {
+	m := writeTestModel(b, b.TempDir(), modelio.Float32)
+	lines := bytes.Split(benchText, []byte("\n"))
+	for _, segmenter := range []string{SegmenterWords, SegmenterCJK} {
+		opts := Options{Segmenter: segmenter}
+		b.Run(segmenter, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(benchText)))
+			for i := 0; i < b.N; i++ {
+				for _, line := range lines {
+					segmentLine(line, m, opts)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSearch measures a search of benchText counting the matching
+// lines, as w2vgrep -c does, with the similarity cache and with Prescore.
+func BenchmarkSearch(b *testing.B) {
+	m := writeTestModel(b, b.TempDir(), modelio.Float32)
+	for _, prescore := range []bool{false, true} {
+		name := "cache"
+		if prescore {
+			name = "prescore"
+		}
+		b.Run(name, func(b *testing.B) {
+			opts := Options{SimilarityThreshold: 0.7, CountOnly: true, Output: io.Discard, Warnings: io.Discard}
+			if prescore {
+				opts.Prescore = NewPrescored()
+			}
+			b.ReportAllocs()
+			b.SetBytes(int64(len(benchText)))
+			for i := 0; i < b.N; i++ {
+				cache := similarity.NewSimilarityCache()
+				if _, err := ProcessLineByLine([]string{"death", "sea"}, m, cache, bytes.NewReader(benchText), opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
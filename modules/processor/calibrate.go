@@ -0,0 +1,193 @@
+package processor
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+)
+
+const (
+	// calibrationStep is the step between the thresholds of a calibration
+	calibrationStep = 0.05
+	// calibrationSamples is the number of tokens shown for each threshold
+	calibrationSamples = 3
+	// sampleLength is the longest sample shown, in characters, as the
+	// samples of Options.LineMode are whole lines
+	sampleLength = 40
+)
+
+// Calibration counts the lines and tokens that would match at a range of
+// similarity thresholds, from 0.95 down to 0.05, to help choose one. Each
+// threshold shows samples of the tokens it lets in, those scoring up to the
+// next higher threshold. It collects the matches of all inputs until printed.
+type Calibration struct {
+	// thresholds, from highest to lowest, and for each the lines and
+	// tokens whose best score falls between it and the previous one
+	thresholds []float64
+	lines      []int
+	tokens     []int
+	samples    [][]TokenSpan
+	read       int
+	current    float64
+}
+
+// NewCalibration returns a calibration at the thresholds from 0.95 down to
+// 0.05, and at current, the threshold of the search, when not among them.
+func NewCalibration(current float64) *Calibration {
+	c := &Calibration{current: current}
+	for i := 19; i >= 1; i-- {
+		c.thresholds = append(c.thresholds, float64(i)*calibrationStep)
+	}
+	onGrid := false
+	for _, t := range c.thresholds {
+		onGrid = onGrid || math.Abs(t-current) < 1e-9
+	}
+	if !onGrid {
+		c.thresholds = append(c.thresholds, current)
+		sort.Sort(sort.Reverse(sort.Float64Slice(c.thresholds)))
+	}
+	c.lines = make([]int, len(c.thresholds))
+	c.tokens = make([]int, len(c.thresholds))
+	c.samples = make([][]TokenSpan, len(c.thresholds))
+	return c
+}
+
+// band returns the index of the highest threshold below score, or -1 when
+// score is below them all.
+func (c *Calibration) band(score float64) int {
+	for i, t := range c.thresholds {
+		if score > t {
+			return i
+		}
+	}
+	return -1
+}
+
+// add records the matches of a line, scored regardless of the threshold.
+func (c *Calibration) add(matches []TokenSpan) {
+	best := -1
+	for _, match := range matches {
+		i := c.band(match.Score)
+		if i < 0 {
+			continue
+		}
+		c.tokens[i]++
+		if best < 0 || i < best {
+			best = i
+		}
+		if len(c.samples[i]) < calibrationSamples && !sampled(c.samples[i], match.Token) {
+			c.samples[i] = append(c.samples[i], match)
+		}
+	}
+	if best >= 0 {
+		c.lines[best]++
+	}
+}
+
+// sampled reports whether token is among samples.
+func sampled(samples []TokenSpan, token string) bool {
+	for _, s := range samples {
+		if s.Token == token {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the number of lines and tokens matching at each threshold,
+// marking the threshold of the search, with samples of the tokens each one
+// lets in. The lowest thresholds are left out when they add no matches.
+func (c *Calibration) Print(w io.Writer) error {
+	last := len(c.thresholds) - 1
+	for last > 0 && c.lines[last] == 0 && c.tokens[last] == 0 && !c.isCurrent(last) {
+		last--
+	}
+
+	out := &output{w: w, eol: "\n"}
+	out.printText(fmt.Sprintf("%d line(s) read", c.read))
+	out.printText(fmt.Sprintf("  %-9s  %8s  %8s  %s", "Threshold", "Lines", "Tokens", "New matches"))
+	lines, tokens := 0, 0
+	for i := 0; i <= last; i++ {
+		lines += c.lines[i]
+		tokens += c.tokens[i]
+		marker := " "
+		if c.isCurrent(i) {
+			marker = "*"
+		}
+		var samples []string
+		for _, s := range c.samples[i] {
+			samples = append(samples, fmt.Sprintf("%s (%.2f)", shorten(s.Token), s.Score))
+		}
+		row := fmt.Sprintf("%s %9.2f  %8d  %8d  %s", marker, c.thresholds[i], lines, tokens, strings.Join(samples, ", "))
+		out.printText(strings.TrimRight(row, " "))
+	}
+	out.printText("* the threshold of this search (-t)")
+	return out.err
+}
+
+// isCurrent reports whether the i-th threshold is that of the search.
+func (c *Calibration) isCurrent(i int) bool {
+	return math.Abs(c.thresholds[i]-c.current) < 1e-9
+}
+
+// shorten returns text cut to sampleLength characters.
+func shorten(text string) string {
+	runes := []rune(text)
+	if len(runes) <= sampleLength {
+		return text
+	}
+	return string(runes[:sampleLength-1]) + "…"
+}
+
+// CollectCalibration matches the lines of input against the queries,
+// scoring every token in the model regardless of the similarity threshold,
+// and adds their scores to c. Lines outside of Options.TimeRange are
+// ignored. It returns the number of lines matching at the threshold of
+// opts, and any error encountered while reading the input.
+//
+// queries: List of query words to search for.
+// w2vModel: The Word2Vec model used for semantic matching.
+// similarityCache: Cache for storing similarity calculations.
+// input: The input to process.
+// c: The calibration so far, across files.
+// opts: Matching options.
+func CollectCalibration(queries []string, w2vModel model.VectorModel, similarityCache similarity.SimilarityCache,
+	input io.Reader, c *Calibration, opts Options) (int, error) {
+
+	threshold := opts.SimilarityThreshold
+	opts.SimilarityThreshold = math.Inf(-1)
+	opts.FrequencyBands = nil
+	opts.MaxCount = 0
+	matcher := NewMatcher(queries, w2vModel, opts)
+	for _, concept := range matcher.concepts {
+		concept.cache = similarityCache
+	}
+
+	var timeFilter *timeRangeFilter
+	if opts.TimeRange != nil {
+		timeFilter = &timeRangeFilter{r: opts.TimeRange}
+	}
+
+	lines := newLineSource(input, matcher)
+	selected := 0
+	for !opts.stopped() && lines.scan() {
+		if timeFilter != nil && !timeFilter.inRange(string(lines.text)) {
+			continue
+		}
+		c.read++
+		matches := matcher.Match(lines.text)
+		c.add(matches)
+		for _, match := range matches {
+			if match.Score > threshold {
+				selected++
+				break
+			}
+		}
+	}
+	return selected, opts.scanError(lines.err())
+}
@@ -0,0 +1,214 @@
+package processor
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxCandidates is the largest number of words a candidateFilter is built
+// for. With more, as with a threshold low enough to let most of the
+// vocabulary match, few lines would be rejected.
+const maxCandidates = 1 << 14
+
+// candidateFilter rejects, without splitting them into words, the lines
+// that contain none of the words that could match a query: the words of
+// the model scoring above the threshold (see Prescored) and the queries
+// themselves. It searches for all of them at once with an Aho-Corasick
+// automaton over the bytes of the line, so its cost does not grow with the
+// number of words.
+type candidateFilter struct {
+	automaton *ahoCorasick
+	// fold compares the words with the line by Unicode case folding, for
+	// IgnoreCase
+	fold bool
+}
+
+// newCandidateFilter returns the filter of the candidate words of the
+// prescored queries, or nil when a token may match without its text being
+// one of them: with a stemmer, normalization, fuzzy matching or regular
+// expressions. It is nil too with Explain, which looks at the tokens of
+// every line, and without Prescore or with too many candidates.
+func newCandidateFilter(queries []*conceptQuery, opts Options) *candidateFilter {
+	if opts.Prescore == nil || opts.Stemmer != nil || opts.Normalize != nil || opts.Fuzzy > 0 ||
+		len(opts.Regexes) > 0 || opts.Explain != nil || opts.LineMode != "" {
+		return nil
+	}
+
+	f := &candidateFilter{fold: opts.IgnoreCase}
+	var words [][]byte
+	add := func(word string) bool {
+		if f.fold {
+			word = foldCase(word)
+		}
+		words = append(words, []byte(word))
+		return len(words) <= maxCandidates
+	}
+	for _, q := range queries {
+		// A query not in the model only matches itself
+		if q.inModel && q.prescored == nil {
+			return nil
+		}
+		if !add(q.token) {
+			return nil
+		}
+		for _, scores := range q.prescored {
+			for word := range scores {
+				if !add(word) {
+					return nil
+				}
+			}
+		}
+	}
+	f.automaton = newAhoCorasick(words)
+	return f
+}
+
+// mayMatch reports whether line contains one of the candidate words, and
+// so may have a match.
+func (f *candidateFilter) mayMatch(line []byte) bool {
+	if f.fold {
+		line = []byte(foldCase(string(line)))
+	}
+	return f.automaton.contains(line)
+}
+
+// foldCase maps every rune of s to a representative of its case folding
+// class, after lowercasing it like tokens are with IgnoreCase, so that the
+// lowercase tokens equal to a word, or equal to it by case folding, are
+// found in the folded line as the folded word.
+func foldCase(s string) string {
+	folded := true
+	for i := 0; i < len(s) && folded; i++ {
+		folded = s[i] < utf8.RuneSelf && !('A' <= s[i] && s[i] <= 'Z')
+	}
+	if folded {
+		return s
+	}
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		b = utf8.AppendRune(b, foldRune(unicode.ToLower(r)))
+	}
+	return string(b)
+}
+
+// foldRune returns the representative of the case folding orbit of r: its
+// smallest rune, or the lowercase ASCII letter of orbits having one, as
+// 'k' for 'K' and the Kelvin sign, so that ASCII text without capitals is
+// its own folding.
+func foldRune(r rune) rune {
+	smallest := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		smallest = min(smallest, f)
+	}
+	if smallest < utf8.RuneSelf {
+		return unicode.ToLower(smallest)
+	}
+	return smallest
+}
+
+// ahoCorasick finds whether a text contains any of a set of words in a
+// single pass over its bytes.
+type ahoCorasick struct {
+	// root maps each byte to the state reached from the start, which is
+	// where most of the time goes on text without the words
+	root  [256]int32
+	nodes []acNode
+}
+
+// acNode is a state of the automaton: a prefix of some of the words.
+type acNode struct {
+	edges []acEdge
+	// fail is the state of the longest proper suffix of the prefix that is
+	// a prefix too
+	fail int32
+	// final is set when the prefix, or one of its suffixes, is a word
+	final bool
+}
+
+// acEdge is a transition of the automaton on a byte.
+type acEdge struct {
+	b    byte
+	next int32
+}
+
+// newAhoCorasick builds the automaton of words. Empty words are ignored.
+func newAhoCorasick(words [][]byte) *ahoCorasick {
+	a := &ahoCorasick{nodes: []acNode{{}}}
+	for _, word := range words {
+		if len(word) == 0 {
+			continue
+		}
+		state := int32(0)
+		for _, b := range word {
+			next := a.edge(state, b)
+			if next < 0 {
+				next = int32(len(a.nodes))
+				a.nodes = append(a.nodes, acNode{})
+				a.nodes[state].edges = append(a.nodes[state].edges, acEdge{b, next})
+			}
+			state = next
+		}
+		a.nodes[state].final = true
+	}
+
+	// Failure links, breadth first so that those of shorter prefixes are
+	// known first
+	queue := make([]int32, 0, len(a.nodes))
+	for _, e := range a.nodes[0].edges {
+		queue = append(queue, e.next)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for _, e := range a.nodes[state].edges {
+			fail := a.nodes[state].fail
+			for fail != 0 && a.edge(fail, e.b) < 0 {
+				fail = a.nodes[fail].fail
+			}
+			if next := a.edge(fail, e.b); next >= 0 {
+				fail = next
+			}
+			a.nodes[e.next].fail = fail
+			a.nodes[e.next].final = a.nodes[e.next].final || a.nodes[fail].final
+			queue = append(queue, e.next)
+		}
+	}
+
+	for b := range a.root {
+		a.root[b] = max(a.edge(0, byte(b)), 0)
+	}
+	return a
+}
+
+// edge returns the state reached from state on b, or -1 when there is no
+// such transition.
+func (a *ahoCorasick) edge(state int32, b byte) int32 {
+	for _, e := range a.nodes[state].edges {
+		if e.b == b {
+			return e.next
+		}
+	}
+	return -1
+}
+
+// contains reports whether text contains one of the words.
+func (a *ahoCorasick) contains(text []byte) bool {
+	state := int32(0)
+	for _, b := range text {
+		for {
+			if state == 0 {
+				state = a.root[b]
+				break
+			}
+			if next := a.edge(state, b); next >= 0 {
+				state = next
+				break
+			}
+			state = a.nodes[state].fail
+		}
+		if a.nodes[state].final {
+			return true
+		}
+	}
+	return false
+}
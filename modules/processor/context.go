@@ -0,0 +1,76 @@
+package processor
+
+import "fmt"
+
+// MaxContext is the largest number of context lines before or after a
+// match. Lines before a match are held in memory until the match is found,
+// so the limit bounds the memory used on huge inputs.
+const MaxContext = 100000
+
+// ValidateContext checks that a number of context lines is usable.
+func ValidateContext(lines int) error {
+	if lines < 0 {
+		return fmt.Errorf("%d is negative, expected a number of lines", lines)
+	}
+	if lines > MaxContext {
+		return fmt.Errorf("%d exceeds the maximum of %d context lines", lines, MaxContext)
+	}
+	return nil
+}
+
+// contextLine is a line held for printing as context, with its line number.
+type contextLine struct {
+	text   string
+	number int
+}
+
+// contextRing holds the last lines read, up to a fixed capacity, as the
+// context printed before the next match. Its storage grows with the lines
+// actually held and is reused once full, so a large capacity only costs
+// memory when that many lines pass without a match.
+type contextRing struct {
+	lines []contextLine
+	start int // index of the oldest line once the ring is full
+	size  int
+}
+
+// newContextRing creates a ring holding up to capacity lines.
+func newContextRing(capacity int) *contextRing {
+	return &contextRing{size: capacity}
+}
+
+// push adds a line, dropping the oldest one when the ring is full.
+func (r *contextRing) push(text string, number int) {
+	if r.size == 0 {
+		return
+	}
+	if len(r.lines) < r.size {
+		r.lines = append(r.lines, contextLine{text, number})
+		return
+	}
+	r.lines[r.start] = contextLine{text, number}
+	r.start = (r.start + 1) % r.size
+}
+
+// each calls fn for the held lines, oldest first.
+func (r *contextRing) each(fn func(text string, number int)) {
+	for i := range r.lines {
+		line := r.lines[(r.start+i)%len(r.lines)]
+		fn(line.text, line.number)
+	}
+}
+
+// oldest returns the number of the oldest held line, or false when the
+// ring is empty.
+func (r *contextRing) oldest() (int, bool) {
+	if len(r.lines) == 0 {
+		return 0, false
+	}
+	return r.lines[r.start%len(r.lines)].number, true
+}
+
+// reset empties the ring, keeping its storage.
+func (r *contextRing) reset() {
+	r.lines = r.lines[:0]
+	r.start = 0
+}
@@ -0,0 +1,131 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numberedInput returns lines lines, "he died" at the numbers of matches and
+// "the cat" elsewhere.
+func numberedInput(lines int, matches ...int) string {
+	var b strings.Builder
+	for n := 1; n <= lines; n++ {
+		text := "the cat"
+		for _, m := range matches {
+			if m == n {
+				text = "he died"
+			}
+		}
+		fmt.Fprintln(&b, text)
+	}
+	return b.String()
+}
+
+// printed returns the line numbers of the lines of output, and "--" for the
+// separators, e.g. "2 3 -- 6".
+func printed(output string) string {
+	var numbers []string
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		number, _, _ := strings.Cut(line, ":")
+		numbers = append(numbers, number)
+	}
+	return strings.Join(numbers, " ")
+}
+
+// TestContextGroups checks that the groups of a match and its context are
+// merged when they overlap or touch, and separated by -- otherwise, and
+// that context is cut at the start and end of the input.
+func TestContextGroups(t *testing.T) {
+	tests := []struct {
+		name          string
+		lines         int
+		matches       []int
+		before, after int
+		maxCount      int
+		want          string
+	}{
+		{"overlapping", 10, []int{3, 5}, 1, 1, 0, "2 3 4 5 6"},
+		{"overlapping after", 10, []int{3, 4}, 0, 3, 0, "3 4 5 6 7"},
+		{"overlapping before", 10, []int{4, 5}, 3, 0, 0, "1 2 3 4 5"},
+		{"touching", 10, []int{3, 6}, 1, 1, 0, "2 3 4 5 6 7"},
+		{"one line apart", 10, []int{2, 6}, 1, 1, 0, "1 2 3 -- 5 6 7"},
+		{"apart", 10, []int{2, 8}, 1, 1, 0, "1 2 3 -- 7 8 9"},
+		{"before only", 10, []int{3, 6}, 1, 0, 0, "2 3 -- 5 6"},
+		{"after only, touching", 10, []int{3, 5}, 0, 1, 0, "3 4 5 6"},
+		{"before at the start", 10, []int{2}, 3, 0, 0, "1 2"},
+		{"after at the end", 10, []int{9}, 0, 3, 0, "9 10"},
+		{"match on the last line", 10, []int{10}, 1, 2, 0, "9 10"},
+		{"after the last counted match", 10, []int{2, 4, 8}, 0, 2, 1, "2 3 4"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := Options{
+				PrintLineNumbers: true,
+				ContextBefore:    test.before,
+				ContextAfter:     test.after,
+				MaxCount:         test.maxCount,
+			}
+			output, _ := search(t, numberedInput(test.lines, test.matches...), opts)
+			if got := printed(output); got != test.want {
+				t.Errorf("printed %s, want %s\n%s", got, test.want, output)
+			}
+		})
+	}
+}
+
+// TestContextBeyondInput checks that context larger than the input prints
+// the whole input, once.
+func TestContextBeyondInput(t *testing.T) {
+	output, _ := search(t, numberedInput(5, 3), Options{PrintLineNumbers: true, ContextBefore: MaxContext, ContextAfter: MaxContext})
+	if got := printed(output); got != "1 2 3 4 5" {
+		t.Errorf("printed %s, want 1 2 3 4 5", got)
+	}
+}
+
+// TestValidateContext checks the bounds of the number of context lines.
+func TestValidateContext(t *testing.T) {
+	for _, test := range []struct {
+		lines int
+		ok    bool
+	}{
+		{0, true},
+		{1, true},
+		{MaxContext, true},
+		{MaxContext + 1, false},
+		{-1, false},
+	} {
+		if err := ValidateContext(test.lines); (err == nil) != test.ok {
+			t.Errorf("ValidateContext(%d): %v", test.lines, err)
+		}
+	}
+}
+
+// TestContextRing checks that the ring keeps the last lines pushed, oldest
+// first, and is reusable once reset.
+func TestContextRing(t *testing.T) {
+	ring := newContextRing(3)
+	for n := 1; n <= 5; n++ {
+		ring.push(fmt.Sprint(n), n)
+	}
+	var held []string
+	ring.each(func(text string, number int) { held = append(held, text) })
+	if oldest, _ := ring.oldest(); strings.Join(held, " ") != "3 4 5" || oldest != 3 {
+		t.Errorf("held %v, oldest %d, want 3 4 5, oldest 3", held, oldest)
+	}
+
+	ring.reset()
+	if _, ok := ring.oldest(); ok {
+		t.Error("the ring holds lines after reset")
+	}
+	ring.push("6", 6)
+	if oldest, _ := ring.oldest(); oldest != 6 {
+		t.Errorf("oldest %d after reset, want 6", oldest)
+	}
+
+	empty := newContextRing(0)
+	empty.push("1", 1)
+	if _, ok := empty.oldest(); ok {
+		t.Error("a ring of no lines holds a line")
+	}
+}
@@ -0,0 +1,140 @@
+package processor
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+)
+
+// cooccurrenceLine is a line kept in the co-occurrence window along with the
+// best match found in it for each concept.
+type cooccurrenceLine struct {
+	text       string
+	lineNumber int
+	matchA     TokenSpan
+	matchB     TokenSpan
+}
+
+// ProcessCooccurrence reports passages in which two concepts co-occur. A
+// passage is a run of at most opts.Window+1 consecutive lines containing a
+// token similar to queryA and a token similar to queryB. Each passage is
+// printed with a joint score, the geometric mean of the two concept scores.
+//
+// opts.Window is the largest distance, in lines, between the lines of the
+// two concepts: 0 means the same line, 1 adjacent lines, and 2 lines that
+// one line separates. opts.OutputOnlyLines omits the joint score header and
+// opts.MaxCount limits the number of passages. It returns the number of
+// passages found and any error encountered while reading the input or
+// writing the output.
+//
+// queryA, queryB: The two concepts that must co-occur.
+// w2vModel: The Word2Vec model used for semantic matching.
+// input: The input file to process.
+// opts: Matching and output options.
+func ProcessCooccurrence(queryA, queryB string, w2vModel model.VectorModel, input io.Reader, opts Options) (int, error) {
+	conceptA := newConceptQuery(queryA, w2vModel, opts)
+	conceptB := newConceptQuery(queryB, w2vModel, opts)
+
+	var timeFilter *timeRangeFilter
+	if opts.TimeRange != nil {
+		timeFilter = &timeRangeFilter{r: opts.TimeRange}
+	}
+
+	out := newOutput(opts)
+	scanner := newScanner(input, opts)
+	lineNumber := 0
+	passages := 0
+	var passage []cooccurrenceLine
+
+	for (opts.MaxCount == 0 || passages < opts.MaxCount) && out.err == nil && !opts.stopped() && scanner.Scan() {
+		lineNumber++
+		if timeFilter != nil && !timeFilter.inRange(scanner.Text()) {
+			continue
+		}
+
+		current := cooccurrenceLine{text: scanner.Text(), lineNumber: lineNumber}
+		current.matchA = conceptA.bestMatch(scanner.Bytes(), w2vModel, opts)
+		current.matchB = conceptB.bestMatch(scanner.Bytes(), w2vModel, opts)
+
+		// Keep the lines at most Window lines before this one. Lines are
+		// told apart by number, as those out of the time range are not kept
+		passage = append(passage, current)
+		for passage[0].lineNumber < lineNumber-opts.Window {
+			passage = passage[1:]
+		}
+
+		// Pair the concept found on this line with the closest line in the
+		// window holding the other concept.
+		start := -1
+		for i := len(passage) - 1; i >= 0; i-- {
+			if (current.matchA.Token != "" && passage[i].matchB.Token != "") ||
+				(current.matchB.Token != "" && passage[i].matchA.Token != "") {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			continue
+		}
+
+		passages++
+		if !opts.CountOnly {
+			printPassage(out, passage[start:], conceptA, conceptB, opts)
+		}
+
+		// Start a fresh window so the same pair is not reported twice
+		passage = nil
+	}
+
+	if err := opts.scanError(scanner.Err()); err != nil {
+		return passages, err
+	}
+	return passages, out.err
+}
+
+// printPassage prints the lines of a co-occurrence passage, highlighting the
+// best match for each concept.
+func printPassage(out *output, passage []cooccurrenceLine, conceptA, conceptB *conceptQuery, opts Options) {
+	var bestA, bestB TokenSpan
+	for _, l := range passage {
+		if l.matchA.Score > bestA.Score {
+			bestA = l.matchA
+		}
+		if l.matchB.Score > bestB.Score {
+			bestB = l.matchB
+		}
+	}
+
+	if !opts.OutputOnlyLines {
+		out.printText(fmt.Sprintf("Joint similarity: %.4f (%s: %s %.4f, %s: %s %.4f)", math.Sqrt(bestA.Score*bestB.Score),
+			conceptA.token, bestA.Token, bestA.Score, conceptB.token, bestB.Token, bestB.Score))
+	}
+
+	styleA := opts.highlightStyle()
+	for _, l := range passage {
+		highlightedLine := l.text
+		switch {
+		case l.matchA.Token != "" && l.matchB.Token != "" && l.matchA.Start == l.matchB.Start:
+			highlightedLine = highlightSpans(l.text, []TokenSpan{l.matchA}, styleA, false)
+		case l.matchA.Token != "" && l.matchB.Token != "":
+			// Highlight the later span first so the earlier offsets stay valid
+			first, second, firstColor, secondColor := l.matchA, l.matchB, styleA, "green"
+			if first.Start > second.Start {
+				first, second, firstColor, secondColor = second, first, secondColor, firstColor
+			}
+			highlightedLine = highlightSpans(l.text, []TokenSpan{second}, secondColor, false)
+			highlightedLine = highlightSpans(highlightedLine, []TokenSpan{first}, firstColor, false)
+		case l.matchA.Token != "":
+			highlightedLine = highlightSpans(l.text, []TokenSpan{l.matchA}, styleA, false)
+		case l.matchB.Token != "":
+			highlightedLine = highlightSpans(l.text, []TokenSpan{l.matchB}, "green", false)
+		}
+		out.printLine(highlightedLine, l.lineNumber, opts.PrintLineNumbers)
+	}
+
+	if !opts.OutputOnlyLines {
+		out.printText("--")
+	}
+}
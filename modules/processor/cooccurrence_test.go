@@ -0,0 +1,53 @@
+package processor
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+)
+
+// TestCooccurrenceWindow checks that Window is the largest distance between
+// the lines of the two concepts.
+func TestCooccurrenceWindow(t *testing.T) {
+	m := writeTestModel(t, t.TempDir(), modelio.Float32)
+	input := "he died\nin the\nocean\n"
+
+	for window, want := range []int{0, 0, 1, 1} {
+		opts := Options{SimilarityThreshold: 0.9, Window: window, CountOnly: true, Output: io.Discard, Warnings: io.Discard}
+		passages, err := ProcessCooccurrence("death", "sea", m, strings.NewReader(input), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if passages != want {
+			t.Errorf("window %d: %d passage(s) for concepts 2 lines apart, want %d", window, passages, want)
+		}
+	}
+}
+
+// TestCooccurrenceWindowSkippedLines checks that the lines ignored, here
+// for being out of the time range, still count in the distance between the
+// concepts.
+func TestCooccurrenceWindowSkippedLines(t *testing.T) {
+	m := writeTestModel(t, t.TempDir(), modelio.Float32)
+	timeRange, err := NewTimeRange("2024-01-01", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := "2024-05-01 10:00:00 he died\n" +
+		"2023-05-01 10:00:00 the cat\n" +
+		"2023-05-01 10:00:00 the cat\n" +
+		"2024-05-01 10:00:01 in the ocean\n"
+
+	for window, want := range []int{0, 0, 0, 1, 1} {
+		opts := Options{SimilarityThreshold: 0.9, Window: window, TimeRange: timeRange, CountOnly: true, Output: io.Discard, Warnings: io.Discard}
+		passages, err := ProcessCooccurrence("death", "sea", m, strings.NewReader(input), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if passages != want {
+			t.Errorf("window %d: %d passage(s) for concepts 3 lines apart, want %d", window, passages, want)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"strings"
+)
+
+// Deduper remembers the lines printed during a run, across files, so that
+// identical lines, e.g. in rotated copies of the same log, are printed only
+// once. Lines are compared after collapsing runs of whitespace, and only
+// their hashes are kept, so memory grows by 32 bytes per distinct line.
+type Deduper struct {
+	seen       map[[sha256.Size]byte]struct{}
+	suppressed int
+}
+
+// NewDeduper returns a Deduper that has seen no line yet.
+func NewDeduper() *Deduper {
+	return &Deduper{seen: make(map[[sha256.Size]byte]struct{})}
+}
+
+// add records a line and reports whether it was new. A line seen before
+// counts as suppressed.
+func (d *Deduper) add(line string) bool {
+	key := sha256.Sum256([]byte(strings.Join(strings.Fields(line), " ")))
+	if _, ok := d.seen[key]; ok {
+		d.suppressed++
+		return false
+	}
+	d.seen[key] = struct{}{}
+	return true
+}
+
+// Suppressed returns the number of duplicate lines that were not printed.
+func (d *Deduper) Suppressed() int {
+	return d.suppressed
+}
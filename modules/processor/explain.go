@@ -0,0 +1,149 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+)
+
+// explainNearest is the number of tokens closest to the queries shown by
+// an Explanation.
+const explainNearest = 5
+
+// Explanation records the tokens of the input that came closest to the
+// queries without matching, to tell why a search selected nothing (see
+// Options.Explain). Only the closest few are kept, however large the input.
+type Explanation struct {
+	nearest []nearMiss
+}
+
+// nearMiss is a token that scored below its threshold, with its best score.
+type nearMiss struct {
+	token     string
+	query     string
+	score     float64
+	threshold float64
+}
+
+// NewExplanation returns an empty explanation.
+func NewExplanation() *Explanation {
+	return &Explanation{}
+}
+
+// add records that token scored score with query, below threshold.
+func (e *Explanation) add(token, query string, score, threshold float64) {
+	miss := nearMiss{token: token, query: query, score: score, threshold: threshold}
+	worst := -1
+	for i, n := range e.nearest {
+		if n.token == token {
+			if score > n.score {
+				e.nearest[i] = miss
+			}
+			return
+		}
+		if worst < 0 || n.score < e.nearest[worst].score {
+			worst = i
+		}
+	}
+	switch {
+	case len(e.nearest) < explainNearest:
+		e.nearest = append(e.nearest, miss)
+	case score > e.nearest[worst].score:
+		e.nearest[worst] = miss
+	}
+}
+
+// miss records a token of the input that matched none of the queries, with
+// its best score among them. normalized is the token as looked up in the
+// model. Query words left out by OnlySemantic are not scored.
+func (e *Explanation) miss(token, normalized string, queries []*conceptQuery, w2vModel model.VectorModel, opts Options) {
+	threshold := opts.threshold(normalized, w2vModel)
+	for _, q := range queries {
+		if opts.OnlySemantic && q.literal(normalized) {
+			continue
+		}
+		tokenToCheck := normalized
+		if q.normalize != nil {
+			tokenToCheck = q.normalize(tokenToCheck)
+		}
+		if score, ok := q.compare(tokenToCheck, w2vModel); ok {
+			e.add(token, q.token, score, threshold)
+		}
+	}
+}
+
+// Print writes why the queries matched nothing: whether each one is in the
+// model, and the tokens of the input closest to them, with how far below
+// the threshold they scored.
+func (e *Explanation) Print(w io.Writer, queries []string, w2vModel model.VectorModel, opts Options) error {
+	out := &output{w: w, eol: "\n"}
+	out.printText(fmt.Sprintf("Nothing matched at threshold %.2f.", opts.SimilarityThreshold))
+
+	opts.Warnings = io.Discard
+	inModel := false
+	for _, text := range queries {
+		q := newConceptQuery(text, w2vModel, opts)
+		inModel = inModel || q.inModel
+		out.printText(fmt.Sprintf("%q: %s", text, describeQuery(q, w2vModel, opts)))
+	}
+
+	switch {
+	case len(e.nearest) > 0:
+	case !inModel:
+		out.printText("No word of the input could be compared with the queries, as none of them is in the model.")
+		return out.err
+	default:
+		out.printText("No word of the input is in the model.")
+		return out.err
+	}
+	sort.Slice(e.nearest, func(i, j int) bool {
+		if e.nearest[i].score != e.nearest[j].score {
+			return e.nearest[i].score > e.nearest[j].score
+		}
+		return e.nearest[i].token < e.nearest[j].token
+	})
+	if opts.LineMode != "" {
+		out.printText("Closest lines of the input:")
+	} else {
+		out.printText("Closest words of the input:")
+	}
+	for _, n := range e.nearest {
+		line := fmt.Sprintf("  %-20s %.4f", shorten(n.token), n.score)
+		if len(queries) > 1 {
+			line += fmt.Sprintf(" to %q", n.query)
+		}
+		out.printText(fmt.Sprintf("%s, %.4f below the threshold of %.2f", line, n.threshold-n.score, n.threshold))
+	}
+	return out.err
+}
+
+// describeQuery tells how the query q was found in the model, suggesting
+// -i for a query the model only has in lowercase.
+func describeQuery(q *conceptQuery, w2vModel model.VectorModel, opts Options) string {
+	if q.err != nil {
+		if !errors.Is(q.err, model.ErrWordNotFound) {
+			return q.err.Error()
+		}
+		description := "not in the model, so it only matches itself"
+		lower := strings.ToLower(q.token)
+		if _, err := q.lookup(model.Route(w2vModel, lower), lower); err == nil && !opts.IgnoreCase && lower != q.token {
+			description += fmt.Sprintf("; the model has %q, try -i", lower)
+		}
+		return description
+	}
+	if q.expression {
+		return "a weighted expression, matched by the weighted sum of the vectors of its words"
+	}
+	found, _, err := q.lookupWord(model.Route(w2vModel, q.token), q.token)
+	switch {
+	case err != nil:
+		return "not in the model, matched by the centroid of the vectors of its words"
+	case found != q.token:
+		return fmt.Sprintf("in the model as its stem %q", found)
+	}
+	return "in the model"
+}
@@ -0,0 +1,50 @@
+package processor
+
+import "strings"
+
+// fuzzyScore returns the similarity of a normalized token to the query word
+// by their spelling, and whether the token is a fuzzy match: within
+// q.fuzzy edits (insertions, deletions or substitutions of characters) of
+// the query or, unless wholeWord, containing the query, as "deathbed" does
+// "death". Its score is 1 minus the edit distance between the token and the
+// query over the length of the longer, so that typos score higher than
+// longer words containing the query.
+func (q *conceptQuery) fuzzyScore(tokenToCheck string) (float64, bool) {
+	if q.fuzzy <= 0 || q.expression || q.phrase {
+		return 0, false
+	}
+	query, token := []rune(q.token), []rune(tokenToCheck)
+	// A token shorter than the edits allowed would match any short query
+	if len(token) <= q.fuzzy {
+		return 0, false
+	}
+	distance := editDistance(query, token)
+	if distance > q.fuzzy && (q.wholeWord || !strings.Contains(tokenToCheck, q.token)) {
+		return 0, false
+	}
+	return 1 - float64(distance)/float64(max(len(query), len(token))), true
+}
+
+// editDistance returns the Levenshtein distance between a and b: the least
+// number of characters to insert, delete or substitute to turn a into b.
+func editDistance(a, b []rune) int {
+	// previous[j] is the distance between the first i-1 characters of a
+	// and the first j of b
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j-1]+cost, previous[j]+1, current[j-1]+1)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
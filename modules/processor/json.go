@@ -0,0 +1,55 @@
+package processor
+
+import (
+	"encoding/json"
+	"io"
+	"unicode/utf8"
+)
+
+// jsonLine is a selected line in JSON output, written as one object per
+// line (JSON Lines).
+type jsonLine struct {
+	File       string      `json:"file,omitempty"`
+	LineNumber int         `json:"line_number"`
+	ByteOffset int64       `json:"byte_offset"`
+	Text       string      `json:"text"`
+	Matches    []jsonMatch `json:"matches"`
+}
+
+// jsonMatch is a matched token of a jsonLine. Start and End are UTF-8 byte
+// offsets within the line, RuneStart and RuneEnd are the same span counted
+// in characters, e.g. for JavaScript strings or editors. End and RuneEnd
+// are exclusive.
+type jsonMatch struct {
+	Token     string  `json:"token"`
+	Query     string  `json:"query"`
+	Score     float64 `json:"score"`
+	Start     int     `json:"start"`
+	End       int     `json:"end"`
+	RuneStart int     `json:"rune_start"`
+	RuneEnd   int     `json:"rune_end"`
+}
+
+// printJSON writes a selected line and its matches as a JSON object.
+func printJSON(out io.Writer, opts Options, byteOffset int64, lineNumber int, line string, matches []TokenSpan) error {
+	record := jsonLine{
+		File:       opts.FileName,
+		LineNumber: lineNumber,
+		ByteOffset: byteOffset,
+		Text:       line,
+		Matches:    make([]jsonMatch, len(matches)),
+	}
+	for i, match := range matches {
+		runeStart := utf8.RuneCountInString(line[:match.Start])
+		record.Matches[i] = jsonMatch{
+			Token:     match.Token,
+			Query:     match.Query,
+			Score:     match.Score,
+			Start:     match.Start,
+			End:       match.End,
+			RuneStart: runeStart,
+			RuneEnd:   runeStart + utf8.RuneCountInString(line[match.Start:match.End]),
+		}
+	}
+	return json.NewEncoder(out).Encode(record)
+}
@@ -0,0 +1,186 @@
+package processor
+
+import (
+	"math"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+)
+
+// Ways of embedding whole lines, see Options.LineMode.
+const (
+	LineMean = "mean" // the mean of the vectors of the words of the line
+	LineSIF  = "sif"  // a mean weighting rare words up, by smooth inverse frequency
+)
+
+const (
+	// sifSmoothing is the a of the smooth inverse frequency weight
+	// a/(a+p(w)) of a word of probability p(w) (Arora et al., 2017)
+	sifSmoothing = 1e-3
+	// eulerGamma is the Euler–Mascheroni constant, for H(n) ≈ ln n + γ
+	eulerGamma = 0.5772156649015329
+)
+
+// lineScorer scores whole lines against the queries, for Options.LineMode.
+// Lines and queries are embedded alike, as the mean of the vectors of their
+// words, and compared by cosine similarity. With a ScriptRouter, each model
+// embeds the words it serves, and a line scores its best in any of them.
+type lineScorer struct {
+	concepts []*conceptQuery
+	// vectors holds the embedding of each of concepts in each model
+	vectors []map[model.VectorModel][]float32
+	// harmonic holds H(n) of each model of n words, to estimate the
+	// probability of a word of rank r by Zipf's law as 1/(r·H(n))
+	harmonic map[model.VectorModel]float64
+	opts     Options
+}
+
+// newLineScorer embeds the queries of concepts as it will embed lines,
+// but for weighted expressions, which keep their vectors.
+func newLineScorer(concepts []*conceptQuery, w2vModel model.VectorModel, opts Options) *lineScorer {
+	s := &lineScorer{
+		concepts: concepts,
+		vectors:  make([]map[model.VectorModel][]float32, len(concepts)),
+		harmonic: make(map[model.VectorModel]float64),
+		opts:     opts,
+	}
+	if opts.LineMode == LineSIF {
+		for _, m := range model.Models(w2vModel) {
+			if _, ok := m.(model.Ranker); ok {
+				s.harmonic[m] = math.Log(float64(len(m.Words()))) + eulerGamma
+			}
+		}
+	}
+	// An expression already weighs its words
+	for i, q := range concepts {
+		if !q.expression {
+			s.vectors[i] = s.embed(q.token, w2vModel, nil)
+			continue
+		}
+		s.vectors[i] = make(map[model.VectorModel][]float32)
+		for m, vector := range q.vectors {
+			s.vectors[i][m] = model.Float32Vector(vector)
+		}
+	}
+	return s
+}
+
+// embed returns the embedding of text in each model serving some of its
+// words. Words are looked up like the tokens of lines, and skipped when
+// skip, if not nil, reports true for them.
+func (s *lineScorer) embed(text string, w2vModel model.VectorModel, skip func(token string) bool) map[model.VectorModel][]float32 {
+	vectors := make(map[model.VectorModel][]interface{})
+	weights := make(map[model.VectorModel][]float64)
+	for _, segment := range segmentLine([]byte(text), w2vModel, s.opts) {
+		if !isWord(segment.text) {
+			continue
+		}
+		token := s.normalize(segment.text)
+		if skip != nil && skip(token) {
+			continue
+		}
+		m := model.Route(w2vModel, token)
+		found, vector, err := lookupStemmed(m, s.opts.Stemmer, token)
+		if err != nil {
+			continue
+		}
+		values := model.Float32Vector(vector)
+		if values == nil {
+			continue
+		}
+		vectors[m] = append(vectors[m], values)
+		weights[m] = append(weights[m], s.weight(m, found))
+	}
+
+	embeddings := make(map[model.VectorModel][]float32, len(vectors))
+	for m := range vectors {
+		if mean, ok := model.Mean(vectors[m], weights[m]).([]float32); ok {
+			embeddings[m] = mean
+		}
+	}
+	return embeddings
+}
+
+// normalize returns a token as it is looked up in the model.
+func (s *lineScorer) normalize(token string) string {
+	if s.opts.IgnoreCase {
+		token = strings.ToLower(token)
+	}
+	if s.opts.Normalize != nil {
+		token = s.opts.Normalize(token)
+	}
+	return token
+}
+
+// weight returns the weight of word of m in the mean of a line: 1, or with
+// LineSIF a/(a+p(word)). Words of unknown frequency weigh as rare words.
+func (s *lineScorer) weight(m model.VectorModel, word string) float64 {
+	harmonic, ok := s.harmonic[m]
+	if !ok {
+		return 1
+	}
+	rank, ok := model.Rank(m, word)
+	if !ok {
+		return 1
+	}
+	p := 1 / (float64(rank) * harmonic)
+	return sifSmoothing / (sifSmoothing + p)
+}
+
+// match returns a span of the whole line, but for its leading and trailing
+// spaces, scored with the query most similar to the line, or nil when no
+// query scores above the similarity threshold. With OnlySemantic, the query
+// words are left out of the line; with ExcludeExact, a line containing one
+// of them does not match.
+func (s *lineScorer) match(line []byte, w2vModel model.VectorModel) []TokenSpan {
+	literal := func(token string) bool {
+		for _, q := range s.concepts {
+			if q.isLiteral(token) {
+				return true
+			}
+		}
+		return false
+	}
+	text := string(line)
+	if s.opts.ExcludeExact {
+		for _, segment := range segmentLine(line, w2vModel, s.opts) {
+			if isWord(segment.text) && literal(s.normalize(segment.text)) {
+				return nil
+			}
+		}
+	}
+	var skip func(string) bool
+	if s.opts.OnlySemantic {
+		skip = literal
+	}
+	embeddings := s.embed(text, w2vModel, skip)
+
+	best := TokenSpan{Score: math.Inf(-1)}
+	for i, q := range s.concepts {
+		for m, lineVector := range embeddings {
+			queryVector, ok := s.vectors[i][m]
+			if !ok {
+				continue
+			}
+			if score := similarity.CalculateSimilarity(queryVector, lineVector); score > best.Score {
+				best.Query = q.token
+				best.Score = score
+			}
+		}
+	}
+	// The whole line matches, without its leading and trailing spaces
+	trimmed := strings.TrimSpace(text)
+	if best.Query == "" || !(best.Score > s.opts.SimilarityThreshold) {
+		if best.Query != "" && s.opts.Explain != nil {
+			s.opts.Explain.add(trimmed, best.Query, best.Score, s.opts.SimilarityThreshold)
+		}
+		return nil
+	}
+
+	best.Start = strings.Index(text, trimmed)
+	best.End = best.Start + len(trimmed)
+	best.Token = trimmed
+	best.Index = 1
+	return []TokenSpan{best}
+}
@@ -0,0 +1,421 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/arunsupe/semantic-grep/modules/float16"
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/query"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+	"github.com/arunsupe/semantic-grep/modules/stemmer"
+	"github.com/arunsupe/semantic-grep/modules/utils"
+)
+
+// conceptQuery holds a query word together with its embeddings and the
+// similarity cache used to score tokens against it.
+type conceptQuery struct {
+	token string
+	// vectors holds the embedding of the query in each model that has it.
+	// There is more than one model when tokens are routed by script.
+	vectors map[model.VectorModel]interface{}
+	// norms holds the L2 norm of each of vectors, for models with
+	// precomputed norms of their words (see model.Norm).
+	norms   map[model.VectorModel]float64
+	inModel bool
+	// err tells why the query is not in the model, when it is not.
+	err   error
+	cache similarity.SimilarityCache
+	// stemmer, when set, is applied to the query and tokens before lookup.
+	stemmer stemmer.Stemmer
+	stem    string
+	// normalize, when set, is applied to tokens before they are compared.
+	normalize func(string) string
+	// expression tells that the query is a weighted expression of words,
+	// see package query, rather than a word or phrase.
+	expression bool
+	// phrase tells that the query has several words.
+	phrase bool
+	// ignoreCase compares tokens with the query by Unicode case folding.
+	ignoreCase bool
+	// fuzzy and wholeWord are the Fuzzy and WholeWord options, see fuzzyScore.
+	fuzzy     int
+	wholeWord bool
+	// prescored holds, with the Prescore option, the scores of the words of
+	// each model above the lowest threshold, see Prescored.
+	prescored map[model.VectorModel]map[string]float64
+}
+
+// newConceptQuery looks up the embedding for the query text in the model.
+// The query gets its own similarity cache, which callers may replace by one
+// shared with other queries, since similarities are cached by query and
+// token. Only the IgnoreCase, Stemmer, Normalize, Scorer, Fuzzy, WholeWord and
+// Prescore options, and with Prescore the thresholds, are used.
+func newConceptQuery(text string, w2vModel model.VectorModel, opts Options) *conceptQuery {
+	q := &conceptQuery{
+		token:      text,
+		vectors:    make(map[model.VectorModel]interface{}),
+		norms:      make(map[model.VectorModel]float64),
+		cache:      similarity.NewScorerCache(opts.scorer()),
+		stemmer:    opts.Stemmer,
+		normalize:  opts.Normalize,
+		fuzzy:      opts.Fuzzy,
+		wholeWord:  opts.WholeWord,
+		ignoreCase: opts.IgnoreCase,
+	}
+	if opts.IgnoreCase {
+		q.token = strings.ToLower(q.token)
+	}
+	if q.normalize != nil {
+		q.token = q.normalize(q.token)
+	}
+	if q.stemmer != nil {
+		q.stem = q.stemmer.Stem(q.token)
+	}
+
+	var lookupErr error
+	for _, m := range model.Models(w2vModel) {
+		vector, err := q.lookup(m, q.token)
+		if err != nil {
+			lookupErr = err
+			continue
+		}
+		switch vector.(type) {
+		case []float32, []int8, []float16.Float16:
+			q.vectors[m] = vector
+			q.inModel = true
+		default:
+			fmt.Fprintf(opts.warnings(), "Warning: Unsupported vector type for query: %s\n", q.token)
+		}
+	}
+	// An expression such as "+death +sea -war" stands for the weighted sum
+	// of its words, and a phrase or paragraph for the centroid of its words
+	if !q.inModel {
+		terms, isExpression, err := query.Parse(q.token)
+		switch {
+		case err != nil:
+			q.err = err
+		case isExpression:
+			q.stem = ""
+			q.expression = true
+			q.combine(terms, w2vModel, opts)
+		case strings.ContainsFunc(strings.TrimSpace(q.token), unicode.IsSpace):
+			q.stem = ""
+			q.phrase = true
+			for _, m := range model.Models(w2vModel) {
+				if vector := q.centroid(m, opts); vector != nil {
+					q.vectors[m] = vector
+					q.inModel = true
+				}
+			}
+		}
+	}
+	for m, vector := range q.vectors {
+		q.norms[m] = similarity.Norm(vector)
+	}
+	if q.err == nil && !q.inModel && lookupErr != nil {
+		q.err = fmt.Errorf("%w: %s", lookupErr, q.token)
+	}
+	if q.err != nil {
+		fmt.Fprintf(opts.warnings(), "Warning: %v\n", q.err)
+	}
+	if opts.Prescore != nil && q.inModel {
+		opts.Prescore.prescore(q, opts)
+	}
+	return q
+}
+
+// combine sets the vectors of an expression to the weighted sum of its
+// terms in each model, warning about the terms missing from the model
+// serving them. Terms are looked up like query words.
+func (q *conceptQuery) combine(terms []query.Term, w2vModel model.VectorModel, opts Options) {
+	for _, m := range model.Models(w2vModel) {
+		// The sum is scored against the words of m, so it takes the type of
+		// their vectors
+		var like interface{}
+		vector := query.Combine(terms, func(word string) []float32 {
+			if model.Route(w2vModel, word) != m {
+				return nil
+			}
+			vector, err := q.lookup(m, word)
+			if err != nil {
+				return nil
+			}
+			like = vector
+			return model.Float32Vector(vector)
+		})
+		if vector != nil {
+			q.vectors[m] = model.VectorLike(vector, like)
+			q.inModel = true
+		}
+	}
+	for _, term := range terms {
+		if _, err := q.lookup(model.Route(w2vModel, term.Word), term.Word); err != nil {
+			fmt.Fprintf(opts.warnings(), "Warning: %v: %s\n", err, term.Word)
+		}
+	}
+}
+
+// centroid returns the mean of the embeddings in m of the words of the
+// query, or nil when none of them is in m. Words are looked up like query
+// words, by their stem first when stemming.
+func (q *conceptQuery) centroid(m model.VectorModel, opts Options) interface{} {
+	var vectors []interface{}
+	for _, segment := range segmentLine([]byte(q.token), m, opts) {
+		if !isWord(segment.text) {
+			continue
+		}
+		vector, err := q.lookup(m, segment.text)
+		if err != nil || model.Float32Vector(vector) == nil {
+			continue
+		}
+		vectors = append(vectors, vector)
+	}
+	return model.Mean(vectors, nil)
+}
+
+// isLiteral reports whether a normalized token is the query word itself,
+// or one of its inflections when stemming. With IgnoreCase, the token and
+// the query are compared by Unicode case folding rather than lowercase
+// only, so that e.g. a final sigma matches a sigma, whether or not the
+// model has either.
+func (q *conceptQuery) isLiteral(tokenToCheck string) bool {
+	if tokenToCheck == q.token || (q.ignoreCase && strings.EqualFold(tokenToCheck, q.token)) {
+		return true
+	}
+	return q.stemmer != nil && q.stemmer.Stem(tokenToCheck) == q.stem
+}
+
+// literal reports whether tokenToCheck is the query word, see isLiteral.
+func (q *conceptQuery) literal(tokenToCheck string) bool {
+	if q.normalize != nil {
+		tokenToCheck = q.normalize(tokenToCheck)
+	}
+	return q.isLiteral(tokenToCheck)
+}
+
+// lookup returns the embedding of word in m. With a stemmer, the stem is
+// looked up first, falling back to the word itself when the stem is missing.
+func (q *conceptQuery) lookup(m model.VectorModel, word string) (interface{}, error) {
+	_, vector, err := q.lookupWord(m, word)
+	return vector, err
+}
+
+// lookupWord is like lookup, and also returns the word of m that was found,
+// word or its stem.
+func (q *conceptQuery) lookupWord(m model.VectorModel, word string) (string, interface{}, error) {
+	return lookupStemmed(m, q.stemmer, word)
+}
+
+// lookupStemmed returns the embedding of word in m and the word found, its
+// stem when s is not nil and m has the stem, or word itself.
+func lookupStemmed(m model.VectorModel, s stemmer.Stemmer, word string) (string, interface{}, error) {
+	if s != nil {
+		stem := s.Stem(word)
+		if vector, err := m.GetEmbedding(stem); err == nil {
+			return stem, vector, nil
+		}
+	}
+	vector, err := m.GetEmbedding(word)
+	return word, vector, err
+}
+
+// score returns the similarity of tokenToCheck to the query and whether it
+// counts as a match. Tokens equal to the query, or with the same stem when
+// stemming, always match with a score of 1.0; other tokens must be in the
+// model and score above the threshold, or be a fuzzy match (see fuzzyScore),
+// whichever scores higher. Tokens are compared with the query in the model
+// that serves them.
+func (q *conceptQuery) score(tokenToCheck string, w2vModel model.VectorModel, similarityThreshold float64) (float64, bool) {
+	if q.normalize != nil {
+		tokenToCheck = q.normalize(tokenToCheck)
+	}
+	if q.isLiteral(tokenToCheck) {
+		return 1.0, true
+	}
+	compare := q.compare
+	if q.prescored != nil {
+		compare = q.prescoredScore
+	}
+	score, ok := compare(tokenToCheck, w2vModel)
+	ok = ok && score > similarityThreshold
+	if fuzzy, isFuzzy := q.fuzzyScore(tokenToCheck); isFuzzy && (!ok || fuzzy > score) {
+		return fuzzy, true
+	}
+	return score, ok
+}
+
+// compare returns the similarity of a normalized token to the query, and
+// false when the token or the query is not in the model serving the token.
+func (q *conceptQuery) compare(tokenToCheck string, w2vModel model.VectorModel) (float64, bool) {
+	if !q.inModel {
+		return 0, false
+	}
+
+	tokenModel := model.Route(w2vModel, tokenToCheck)
+	queryVector, ok := q.vectors[tokenModel]
+	if !ok {
+		return 0, false
+	}
+	found, tokenVector, err := q.lookupWord(tokenModel, tokenToCheck)
+	if err != nil {
+		return 0, false
+	}
+	// Models that precomputed the norms of their words spare computing them
+	if cache, ok := q.cache.(similarity.NormCache); ok {
+		if tokenNorm, ok := model.Norm(tokenModel, found); ok {
+			return cache.MemoizedCalculateSimilarityNorms(q.token, tokenToCheck, queryVector, tokenVector, q.norms[tokenModel], tokenNorm), true
+		}
+	}
+	return q.cache.MemoizedCalculateSimilarity(q.token, tokenToCheck, queryVector, tokenVector), true
+}
+
+// heatmapLine colors every word of line by its best similarity to the
+// queries, from blue for unrelated words to red for the query itself (see
+// utils.HeatText). Without color, each word is followed by its score
+// instead. Words that are not in the model are left as they are.
+func heatmapLine(line string, queries []*conceptQuery, w2vModel model.VectorModel, opts Options) string {
+	var b strings.Builder
+	for _, segment := range segmentLine([]byte(line), w2vModel, opts) {
+		if !isWord(segment.text) {
+			b.WriteString(segment.text)
+			continue
+		}
+
+		tokenToCheck := segment.text
+		if opts.IgnoreCase {
+			tokenToCheck = strings.ToLower(tokenToCheck)
+		}
+		if opts.Normalize != nil {
+			tokenToCheck = opts.Normalize(tokenToCheck)
+		}
+
+		best, known := 0.0, false
+		for _, q := range queries {
+			score, ok := 1.0, true
+			if !q.isLiteral(tokenToCheck) {
+				score, ok = q.compare(tokenToCheck, w2vModel)
+			}
+			if ok && (!known || score > best) {
+				best, known = score, true
+			}
+		}
+
+		switch {
+		case !known:
+			b.WriteString(segment.text)
+		case utils.ColorEnabled():
+			b.WriteString(utils.HeatText(segment.text, best))
+		default:
+			b.WriteString(segment.text + formatScore(best))
+		}
+	}
+	return b.String()
+}
+
+// TokenSpan is a token of a line that matched a query. Index is the
+// position of the token among the words of the line; Start and End are its
+// byte offsets in the line.
+type TokenSpan struct {
+	Token string
+	Query string
+	Index int
+	Start int
+	End   int
+	Score float64
+}
+
+// matchLine returns every word of line that is similar to one of the
+// queries. When a word matches several queries, the best scoring one wins.
+// With OnlySemantic, the query words themselves are not matches; with
+// ExcludeExact, a line containing one of them has no matches at all. Only
+// the SimilarityThreshold, FrequencyBands, IgnoreCase, OnlySemantic,
+// ExcludeExact, Explain and segmentation options are used.
+func matchLine(line []byte, queries []*conceptQuery, w2vModel model.VectorModel, opts Options) []TokenSpan {
+	var spans []TokenSpan
+	index := 0
+
+	for _, segment := range segmentLine(line, w2vModel, opts) {
+		if !isWord(segment.text) {
+			continue
+		}
+		index++
+
+		tokenToCheck := segment.text
+		if opts.IgnoreCase {
+			tokenToCheck = strings.ToLower(segment.text)
+		}
+
+		best := TokenSpan{Token: segment.text, Index: index, Start: segment.start, End: segment.end}
+		for _, q := range queries {
+			if (opts.OnlySemantic || opts.ExcludeExact) && q.literal(tokenToCheck) {
+				if opts.ExcludeExact {
+					return nil
+				}
+				continue
+			}
+			if score, ok := q.score(tokenToCheck, w2vModel, opts.threshold(tokenToCheck, w2vModel)); ok && score > best.Score {
+				best.Query = q.token
+				best.Score = score
+			}
+		}
+		if best.Query != "" {
+			spans = append(spans, best)
+		} else if opts.Explain != nil {
+			opts.Explain.miss(segment.text, tokenToCheck, queries, w2vModel, opts)
+		}
+	}
+
+	return spans
+}
+
+// matchSpans returns every word of line that is similar to the query.
+func (q *conceptQuery) matchSpans(line []byte, w2vModel model.VectorModel, opts Options) []TokenSpan {
+	return matchLine(line, []*conceptQuery{q}, w2vModel, opts)
+}
+
+// bestMatch returns the match of line most similar to the query. The
+// returned span has an empty Token when nothing in the line matches.
+func (q *conceptQuery) bestMatch(line []byte, w2vModel model.VectorModel, opts Options) TokenSpan {
+	var best TokenSpan
+	for _, span := range q.matchSpans(line, w2vModel, opts) {
+		if span.Score > best.Score {
+			best = span
+		}
+	}
+	return best
+}
+
+// isWord reports whether a segment is more than whitespace or punctuation.
+func isWord(token string) bool {
+	for _, r := range token {
+		if !unicode.IsSpace(r) && !unicode.IsPunct(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// highlightSpans styles the byte ranges of spans in line, optionally
+// followed by their similarity scores. Spans must be sorted by offset and
+// must not overlap.
+func highlightSpans(line string, spans []TokenSpan, style string, withScores bool) string {
+	var b strings.Builder
+	last := 0
+	for _, span := range spans {
+		b.WriteString(line[last:span.Start])
+		b.WriteString(utils.ColorText(line[span.Start:span.End], style))
+		if withScores {
+			b.WriteString(formatScore(span.Score))
+		}
+		last = span.End
+	}
+	b.WriteString(line[last:])
+	return b.String()
+}
+
+// formatScore formats a similarity score for inline annotation, e.g. "[0.81]".
+func formatScore(score float64) string {
+	return fmt.Sprintf("[%.2f]", score)
+}
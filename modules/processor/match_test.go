@@ -0,0 +1,58 @@
+package processor
+
+import (
+	"io"
+	"testing"
+
+	"github.com/arunsupe/semantic-grep/modules/internal/testmodel"
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+)
+
+// testVectors are the embeddings of the test models, along three concepts:
+// death, the sea and cats.
+var testVectors = []testmodel.Vector{
+	{Word: "he", Values: []float32{0.05, 0.05, 0.05, 1}},
+	{Word: "in", Values: []float32{0.05, 0.05, 0.05, 1}},
+	{Word: "the", Values: []float32{0.05, 0.05, 0.05, 1}},
+	{Word: "death", Values: []float32{1, 0, 0, 0.1}},
+	{Word: "died", Values: []float32{0.95, 0.05, 0, 0.1}},
+	{Word: "sea", Values: []float32{0, 1, 0, 0.1}},
+	{Word: "ocean", Values: []float32{0.1, 0.95, 0, 0.1}},
+	{Word: "cat", Values: []float32{0, 0, 1, 0.1}},
+	{Word: "kitten", Values: []float32{0, 0.1, 0.95, 0.1}},
+}
+
+// writeTestModel writes testVectors to a model in format in dir, and loads
+// it.
+func writeTestModel(t testing.TB, dir string, format modelio.Format) model.VectorModel {
+	t.Helper()
+	m, err := model.LoadVectorModel(testmodel.Write(t, dir, format, testVectors))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// TestWeightedQueryVectorTypes checks that a weighted query is scored
+// against the vectors of half-precision and 8-bit models, in their type,
+// like against those of a float32 model.
+func TestWeightedQueryVectorTypes(t *testing.T) {
+	dir := t.TempDir()
+	line := []byte("he died in the ocean with a kitten")
+	opts := Options{SimilarityThreshold: 0.5, Warnings: io.Discard}
+
+	for _, format := range []modelio.Format{modelio.Float32, modelio.Float16, modelio.Int8} {
+		t.Run(format.String(), func(t *testing.T) {
+			m := writeTestModel(t, dir, format)
+			matcher := NewMatcher([]string{"+death -sea"}, m, opts)
+			if err := matcher.Err(); err != nil {
+				t.Fatal(err)
+			}
+			spans := matcher.Match(line)
+			if len(spans) != 1 || spans[0].Token != "died" {
+				t.Fatalf("matches of %q: %+v, want died only", line, spans)
+			}
+		})
+	}
+}
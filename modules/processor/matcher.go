@@ -0,0 +1,112 @@
+package processor
+
+import (
+	"errors"
+	"io"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+)
+
+// Matcher finds the tokens of lines that are similar to a set of queries,
+// without printing anything. It caches similarities, so a Matcher must not
+// be used by several goroutines at once; create one per goroutine instead.
+type Matcher struct {
+	concepts   []*conceptQuery
+	candidates *candidateFilter
+	near       *proximityFilter
+	lines      *lineScorer
+	model      model.VectorModel
+	opts       Options
+}
+
+// NewMatcher looks up the queries in the model. Only the matching options
+// are used: SimilarityThreshold, FrequencyBands, IgnoreCase, Stemmer,
+// Normalize, Scorer, Segmenter, CJKMaxLength, OnlySemantic, ExcludeExact,
+// LineMode, Regexes, Near, Adaptive, Fuzzy, WholeWord, Prescore and Warnings.
+func NewMatcher(queries []string, w2vModel model.VectorModel, opts Options) *Matcher {
+	m := &Matcher{
+		concepts: make([]*conceptQuery, len(queries)),
+		model:    w2vModel,
+		opts:     opts,
+	}
+	for i, query := range queries {
+		m.concepts[i] = newConceptQuery(query, w2vModel, opts)
+	}
+	m.candidates = newCandidateFilter(m.concepts, opts)
+	if opts.Near != nil {
+		m.near = newProximityFilter(opts.Near, w2vModel, opts)
+	}
+	if opts.LineMode != "" {
+		m.lines = newLineScorer(m.concepts, w2vModel, opts)
+	}
+	return m
+}
+
+// Err reports the queries that are not in the model. Such a query only
+// matches itself.
+func (m *Matcher) Err() error {
+	var errs []error
+	for _, q := range m.concepts {
+		errs = append(errs, q.err)
+	}
+	return errors.Join(errs...)
+}
+
+// Match returns the tokens of line that are similar to one of the queries,
+// and the matches of Options.Regexes, in order, or nil when the line does
+// not match. With Options.LineMode, the only match is the line itself.
+func (m *Matcher) Match(line []byte) []TokenSpan {
+	// Lines without a word that could match are not split into words
+	if m.candidates != nil && !m.candidates.mayMatch(line) {
+		return nil
+	}
+	// Lines failing the proximity constraint are treated as having no match
+	if m.near != nil && !m.near.satisfied(line, m.model, m.opts) {
+		return nil
+	}
+	if m.lines != nil {
+		return m.lines.match(line, m.model)
+	}
+	return mergeSpans(matchLine(line, m.concepts, m.model, m.opts), regexSpans(line, m.opts.Regexes))
+}
+
+// Line is a line of input with its matches.
+type Line struct {
+	// Number is the 1-based line number.
+	Number int
+	// Offset is the byte offset of the line in the input.
+	Offset int64
+	// Text is the line without its line terminator.
+	Text    string
+	Matches []TokenSpan
+}
+
+// Search reads input line by line and calls found for every line that
+// matches, until found returns false or the input ends. Options.TimeRange,
+// MaxCount, MaxLineLength, NullData, Adaptive and Done are honored.
+func (m *Matcher) Search(input io.Reader, found func(Line) bool) error {
+	var timeFilter *timeRangeFilter
+	if m.opts.TimeRange != nil {
+		timeFilter = &timeRangeFilter{r: m.opts.TimeRange}
+	}
+
+	lines := newLineSource(input, m)
+	lineNumber := 0
+	selectedLines := 0
+	for (m.opts.MaxCount == 0 || selectedLines < m.opts.MaxCount) && !m.opts.stopped() && lines.scan() {
+		lineNumber++
+		if lines.skipped || timeFilter != nil && !timeFilter.inRange(string(lines.text)) {
+			continue
+		}
+
+		matches := m.Match(lines.text)
+		if len(matches) == 0 {
+			continue
+		}
+		selectedLines++
+		if !found(Line{Number: lineNumber, Offset: lines.start, Text: string(lines.text), Matches: matches}) {
+			break
+		}
+	}
+	return m.opts.scanError(lines.err())
+}
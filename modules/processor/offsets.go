@@ -0,0 +1,51 @@
+package processor
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/utils"
+)
+
+// offsetTracker records the byte offset in the input of the line last
+// returned by a bufio.Scanner, including the line terminators that the
+// scanner strips.
+type offsetTracker struct {
+	scan      bufio.SplitFunc
+	consumed  int64
+	lineStart int64
+}
+
+// newOffsetTracker returns a tracker of the lines of opts, see Options.splitFunc.
+func newOffsetTracker(opts Options) *offsetTracker {
+	return &offsetTracker{scan: opts.splitFunc()}
+}
+
+// split is a bufio.SplitFunc that behaves like the split function of the
+// tracker while keeping track of line offsets.
+func (t *offsetTracker) split(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := t.scan(data, atEOF)
+	if token != nil {
+		t.lineStart = t.consumed
+	}
+	t.consumed += int64(advance)
+	return advance, token, err
+}
+
+// offsetPrefix returns the byte offset and column fields printed before a
+// line or token with -b/--byte-offset and --column, e.g. "1024:7:". A
+// negative column is omitted.
+func offsetPrefix(opts Options, byteOffset int64, column int) string {
+	var fields []string
+	if opts.ByteOffset {
+		fields = append(fields, fmt.Sprintf("%d:", byteOffset))
+	}
+	if opts.Column && column >= 0 {
+		fields = append(fields, fmt.Sprintf("%d:", column))
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	return utils.ColorText(strings.Join(fields, ""), "green")
+}
@@ -0,0 +1,70 @@
+package processor
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/arunsupe/semantic-grep/modules/utils"
+)
+
+// output writes the results of processing to Options.Output. It remembers
+// the first write error, e.g. a closed pipe, so that processing can stop
+// and report it.
+type output struct {
+	w             io.Writer
+	fileName      string
+	nullAfterName bool
+	eol           string
+	err           error
+}
+
+// newOutput returns the output of opts, standard output when not set.
+func newOutput(opts Options) *output {
+	w := opts.Output
+	if w == nil {
+		w = os.Stdout
+	}
+	eol := "\n"
+	if opts.NullData {
+		eol = "\x00"
+	}
+	return &output{w: w, fileName: opts.FileName, nullAfterName: opts.NullAfterName, eol: eol}
+}
+
+// Write implements io.Writer. Once a write failed, nothing more is written.
+func (o *output) Write(p []byte) (int, error) {
+	if o.err != nil {
+		return 0, o.err
+	}
+	n, err := o.w.Write(p)
+	if err != nil {
+		o.err = err
+	}
+	return n, err
+}
+
+// printLine writes a line, prefixed with the file name and line number
+// when enabled. The file name is followed by a NUL byte with
+// Options.NullAfterName, and the line ends with a NUL byte with
+// Options.NullData.
+func (o *output) printLine(line string, lineNumber int, printLineNumbers bool) {
+	prefix := ""
+	if o.fileName != "" {
+		if o.nullAfterName {
+			prefix = utils.ColorText(o.fileName, "magenta") + "\x00"
+		} else {
+			prefix = utils.ColorText(o.fileName+":", "magenta")
+		}
+	}
+	if printLineNumbers {
+		prefix += utils.ColorText(fmt.Sprintf("%d:", lineNumber), "blue") + " "
+	}
+	io.WriteString(o, prefix+line+o.eol)
+}
+
+// printText writes a line that is not from the input, such as a score or
+// a group separator, with the line terminator of the output.
+func (o *output) printText(text string) {
+	io.WriteString(o, text+o.eol)
+}
@@ -0,0 +1,135 @@
+package processor
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+)
+
+// ParityCheck is a developer mode guarding grep compatibility. For every
+// query it compares, line by line, the whole-word occurrences found by a
+// plain literal matcher (what grep -w would report) with the semantic
+// matches at threshold 1.0. It also checks that highlighting the semantic
+// matches leaves the text of the line intact. Every divergent line is
+// reported. It returns the number of divergent lines and any error
+// encountered while reading the input.
+//
+// queries: List of query words to check.
+// w2vModel: The Word2Vec model used for semantic matching.
+// input: The input file to process.
+// opts: Only IgnoreCase, FileName, MaxLineLength, Output and Done are used.
+func ParityCheck(queries []string, w2vModel model.VectorModel, input io.Reader, opts Options) (int, error) {
+	// Look up words as they are: grep neither stems nor normalizes
+	exactOpts := Options{IgnoreCase: opts.IgnoreCase, SimilarityThreshold: 1.0}
+	concepts := make([]*conceptQuery, len(queries))
+	for i, query := range queries {
+		concepts[i] = newConceptQuery(query, w2vModel, exactOpts)
+	}
+
+	out := newOutput(opts)
+	scanner := newScanner(input, opts)
+	lineNumber := 0
+	divergences := 0
+
+	for out.err == nil && !opts.stopped() && scanner.Scan() {
+		line := scanner.Text()
+		lineNumber++
+
+		for _, concept := range concepts {
+			exact := literalMatches(line, concept.token, opts.IgnoreCase)
+			spans := concept.matchSpans(scanner.Bytes(), w2vModel, exactOpts)
+
+			semantic := make([]int, len(spans))
+			for i, span := range spans {
+				semantic[i] = span.Start
+			}
+			highlighted := ansiEscape.ReplaceAllString(highlightSpans(line, spans, "red", false), "")
+
+			if equalOffsets(exact, semantic) && highlighted == line {
+				continue
+			}
+
+			divergences++
+			if opts.FileName != "" {
+				fmt.Fprintf(out, "%s:", opts.FileName)
+			}
+			fmt.Fprintf(out, "%d: query %q: exact=%v semantic=%v: %s\n", lineNumber, concept.token, exact, semantic, line)
+			if highlighted != line {
+				fmt.Fprintf(out, "\thighlighting altered the line: %s\n", highlighted)
+			}
+		}
+	}
+
+	if err := opts.scanError(scanner.Err()); err != nil {
+		return divergences, err
+	}
+	return divergences, out.err
+}
+
+// ansiEscape matches the color escape sequences added by highlighting.
+var ansiEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// literalMatches returns the byte offsets of the whole-word occurrences of
+// query in line, compared by Unicode case folding with ignoreCase.
+func literalMatches(line, query string, ignoreCase bool) []int {
+	if query == "" {
+		return nil
+	}
+
+	var offsets []int
+	for start := 0; start < len(line); {
+		if n, ok := foldedPrefix(line[start:], query, ignoreCase); ok {
+			before, _ := utf8.DecodeLastRuneInString(line[:start])
+			after, _ := utf8.DecodeRuneInString(line[start+n:])
+			if !isWordRune(before) && !isWordRune(after) {
+				offsets = append(offsets, start)
+			}
+		}
+		_, size := utf8.DecodeRuneInString(line[start:])
+		start += size
+	}
+	return offsets
+}
+
+// foldedPrefix returns the length in bytes of the start of s that is
+// prefix, and whether there is one. With ignoreCase, runes are compared by
+// Unicode case folding, rune by rune, so that the length is that of the
+// text of s even where a rune and its folding differ in size, like the
+// Kelvin sign and k.
+func foldedPrefix(s, prefix string, ignoreCase bool) (int, bool) {
+	n := 0
+	for _, p := range prefix {
+		if n >= len(s) {
+			return 0, false
+		}
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if r != p && !(ignoreCase && strings.EqualFold(string(r), string(p))) {
+			return 0, false
+		}
+		n += size
+	}
+	return n, true
+}
+
+// isWordRune reports whether r is part of a word for the literal matcher.
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+}
+
+// equalOffsets reports whether two sorted offset lists are identical.
+func equalOffsets(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
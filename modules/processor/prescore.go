@@ -0,0 +1,93 @@
+package processor
+
+import (
+	"sync"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+)
+
+// Prescored holds the words of the model scored against the queries once,
+// so that tokens are matched by looking up their score instead of
+// comparing vectors, see Options.Prescore. It is safe for concurrent use.
+type Prescored struct {
+	mu     sync.Mutex
+	scores map[prescoreKey]map[model.VectorModel]map[string]float64
+}
+
+// prescoreKey identifies the words scored for a query: the searches of a
+// run may use other thresholds, as CollectTopMatches does.
+type prescoreKey struct {
+	query     string
+	threshold float64
+}
+
+// NewPrescored returns an empty Prescored, to be shared by the searches of
+// a run. The words are scored against a query the first time it is used.
+func NewPrescored() *Prescored {
+	return &Prescored{scores: make(map[prescoreKey]map[model.VectorModel]map[string]float64)}
+}
+
+// prescore sets the scores of the query to those of p, scoring every word
+// of the models serving the query against it unless done before, and
+// keeping the words scoring above the lowest threshold of opts. Words are
+// scored like compare scores tokens, so matches and scores are the same
+// either way.
+func (p *Prescored) prescore(q *conceptQuery, opts Options) {
+	threshold := opts.SimilarityThreshold
+	for _, band := range opts.FrequencyBands {
+		threshold = min(threshold, band.Threshold)
+	}
+	if opts.Adaptive != nil {
+		threshold = min(threshold, opts.Adaptive.CoarseThreshold)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := prescoreKey{q.token, threshold}
+	if scores, ok := p.scores[key]; ok {
+		q.prescored = scores
+		return
+	}
+
+	scorer := opts.scorer()
+	normScorer, _ := scorer.(similarity.NormScorer)
+	q.prescored = make(map[model.VectorModel]map[string]float64, len(q.vectors))
+	for m, queryVector := range q.vectors {
+		scores := make(map[string]float64)
+		for _, word := range m.Words() {
+			vector, err := m.GetEmbedding(word)
+			if err != nil {
+				continue
+			}
+			var score float64
+			if norm, ok := model.Norm(m, word); ok && normScorer != nil {
+				score = normScorer.ScoreNorms(queryVector, vector, q.norms[m], norm)
+			} else {
+				score = scorer.Score(queryVector, vector)
+			}
+			if score > threshold {
+				scores[word] = score
+			}
+		}
+		q.prescored[m] = scores
+	}
+	p.scores[key] = q.prescored
+}
+
+// prescoredScore is compare for a prescored query: it returns the score of
+// a normalized token, and false when the token is not in the model serving
+// it or scores below every threshold.
+func (q *conceptQuery) prescoredScore(tokenToCheck string, w2vModel model.VectorModel) (float64, bool) {
+	tokenModel := model.Route(w2vModel, tokenToCheck)
+	scores, ok := q.prescored[tokenModel]
+	if !ok {
+		return 0, false
+	}
+	found, _, err := q.lookupWord(tokenModel, tokenToCheck)
+	if err != nil {
+		return 0, false
+	}
+	score, ok := scores[found]
+	return score, ok
+}
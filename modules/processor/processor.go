@@ -3,162 +3,395 @@
 package processor
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"os"
-	"strings"
+	"regexp"
+	"text/template"
 
 	"github.com/arunsupe/semantic-grep/modules/model"
 	"github.com/arunsupe/semantic-grep/modules/similarity"
-	"github.com/arunsupe/semantic-grep/modules/utils"
+	"github.com/arunsupe/semantic-grep/modules/stemmer"
+)
 
-	"github.com/clipperhouse/uax29/words"
+// Ways of showing similarity scores, see Options.ShowScores.
+const (
+	ScoresPrefix = "prefix" // a "Similarity: 0.8123" line before each match
+	ScoresInline = "inline" // each highlighted token followed by its score, e.g. death[0.81]
+	ScoresNone   = "none"   // no scores
 )
 
+// Options controls how the input is matched and how results are printed.
+type Options struct {
+	// SimilarityThreshold is the score above which a token is considered similar.
+	SimilarityThreshold float64
+	// FrequencyBands, sorted by MaxRank, replace SimilarityThreshold for the
+	// most frequent words of the model (see model.Rank).
+	FrequencyBands []FrequencyBand
+	// ContextBefore and ContextAfter are the number of lines to print around a
+	// match, at most MaxContext (see ValidateContext).
+	ContextBefore int
+	ContextAfter  int
+	// Window is the largest distance, in lines, between co-occurring concepts:
+	// 0 for the same line, 1 for adjacent lines.
+	Window int
+	// FileName, when set, prefixes every printed line like grep does for multiple files.
+	FileName string
+
+	PrintLineNumbers   bool
+	IgnoreCase         bool
+	OutputOnlyMatching bool
+	OutputOnlyLines    bool
+	// ByteOffset prints the byte offset in the input of each selected line,
+	// or of each token with OutputOnlyMatching, like grep -b.
+	ByteOffset bool
+	// Column prints the 1-based byte column of the first match in each
+	// selected line, or of each token with OutputOnlyMatching.
+	Column bool
+	// Stemmer, when set, looks up the stem of each token and query before
+	// the word itself, and treats tokens with the same stem as the query as
+	// exact matches.
+	Stemmer stemmer.Stemmer
+	// Segmenter is SegmenterWords (the default when empty) or SegmenterCJK.
+	Segmenter string
+	// CJKMaxLength is the longest CJK word, in characters, tried by the cjk
+	// segmenter (DefaultCJKMaxLength when 0).
+	CJKMaxLength int
+	// Normalize, when set, is applied to queries and tokens before lookup,
+	// e.g. a Unicode normalization (see utils.Normalizer). The vocabulary of
+	// the model must be normalized the same way.
+	Normalize func(string) string
+	// Scorer compares the embeddings of tokens and queries (similarity.Cosine
+	// when nil). Scores are compared with SimilarityThreshold.
+	Scorer similarity.Scorer
+	// HighlightStyle is the style of matched tokens, e.g. "bold green" (see
+	// utils.ColorText). Matches are red when it is empty.
+	HighlightStyle string
+	// JSON prints each selected line with its matches and their offsets as a
+	// JSON object instead of highlighted text. Context lines are not printed.
+	JSON bool
+	// Table prints a row for each match, in TableTSV or TableCSV, instead
+	// of highlighted text (see printTable). Context lines are not printed.
+	Table string
+	// Template prints each match with this template, executed with a
+	// Match, instead of highlighted text. Context lines are not printed.
+	Template *template.Template
+	// Heatmap colors every word of a selected line by its similarity to the
+	// query instead of highlighting the matches.
+	Heatmap bool
+	// ShowScores is one of ScoresPrefix (the default when empty), ScoresInline or ScoresNone.
+	ShowScores string
+	// OnlySemantic ignores the query words themselves, so that only tokens
+	// similar to a query, but different from it, match.
+	OnlySemantic bool
+	// ExcludeExact rejects every line containing a query word, selecting
+	// lines that express the concept in other words only.
+	ExcludeExact bool
+	// Fuzzy, when above 0, also matches the tokens spelled within Fuzzy
+	// edits of a query word, catching typos and words missing from the
+	// model. Unless WholeWord, a token containing a query word, as
+	// "deathbed" does "death", matches too. Phrases and expressions are not
+	// matched by spelling.
+	Fuzzy     int
+	WholeWord bool
+	// Prescore, when set, scores every word of the model against each
+	// query before reading the input, so that tokens are matched by looking
+	// up their score rather than by comparing vectors. It pays off on
+	// inputs with many more distinct words than the similarity cache holds,
+	// at the cost of a scan of the vocabulary per query, shared by the
+	// searches using the same Prescored. Unless a token may match by other
+	// means than its text, e.g. with a Stemmer, lines containing none of
+	// the words scored above the threshold are then rejected without being
+	// split into words.
+	Prescore *Prescored
+	// LineMode, when set to LineMean or LineSIF, matches whole lines
+	// instead of tokens: a line is embedded as the mean of the vectors of
+	// its words and selected when it scores above SimilarityThreshold with
+	// a query, embedded the same way. Its match spans the whole line.
+	// FrequencyBands, Scorer, Fuzzy, Prescore and Regexes do not apply.
+	LineMode string
+	// InvertMatch selects the lines that contain no match instead.
+	InvertMatch bool
+	// CountOnly suppresses line output; only the number of selected lines is returned.
+	CountOnly bool
+	// MaxCount stops reading the input after this many selected lines (0 means no limit).
+	MaxCount int
+	// Regexes are matched against whole lines in addition to the queries,
+	// see SplitPatterns. Their matches have a score of 1.
+	Regexes []*regexp.Regexp
+	// Near, when set, only lets lines match if its two concepts are close to each other.
+	Near *Proximity
+	// Adaptive, when set, scores only the blocks of lines whose sampled words
+	// are close to the queries, see AdaptiveScan.
+	Adaptive *AdaptiveScan
+	// NullData reads lines terminated by NUL bytes instead of newlines, and
+	// terminates printed lines with NUL, like grep -z.
+	NullData bool
+	// NullAfterName prints a NUL byte instead of the colon after file names,
+	// like grep -Z.
+	NullAfterName bool
+	// MaxLineLength is the longest line read, in bytes
+	// (DefaultMaxLineLength when 0). Longer lines stop processing with an error.
+	MaxLineLength int
+	// Dedupe, when set, prints each selected line only once per run, even
+	// when it occurs again, possibly with other whitespace, in another file.
+	// Duplicates still count as selected lines.
+	Dedupe *Deduper
+	// TimeRange, when set, ignores lines whose timestamp is outside of the range.
+	TimeRange *TimeRange
+	// Explain, when set, records the tokens closest to the queries among
+	// those that did not match, to tell why nothing matched.
+	Explain *Explanation
+	// Output receives the results (os.Stdout when nil).
+	Output io.Writer
+	// Warnings receives warnings, e.g. about queries missing from the model
+	// (os.Stderr when nil).
+	Warnings io.Writer
+	// Done, when closed, stops processing after the current line, e.g. when
+	// the user interrupts a long scan.
+	Done <-chan struct{}
+}
+
+// FrequencyBand is the similarity threshold of the tokens among the MaxRank
+// most frequent words of the model. Very common words have hub-like vectors,
+// similar to many unrelated words, and call for a stricter threshold.
+type FrequencyBand struct {
+	MaxRank   int
+	Threshold float64
+}
+
+// threshold returns the similarity threshold of a token: that of the first
+// frequency band the token falls in, or SimilarityThreshold.
+func (opts Options) threshold(token string, w2vModel model.VectorModel) float64 {
+	if len(opts.FrequencyBands) == 0 {
+		return opts.SimilarityThreshold
+	}
+	rank, ok := model.Rank(w2vModel, token)
+	if !ok {
+		return opts.SimilarityThreshold
+	}
+	for _, band := range opts.FrequencyBands {
+		if rank <= band.MaxRank {
+			return band.Threshold
+		}
+	}
+	return opts.SimilarityThreshold
+}
+
+// similarityHeader returns the line printed before a match with the best
+// score of its tokens. When regular expressions are matched too, it names
+// the query or expression of that token, e.g. "Similarity: 1.0000 (re:ERR\d+)".
+func similarityHeader(matches []TokenSpan, opts Options) string {
+	best := matches[0]
+	for _, match := range matches[1:] {
+		if match.Score > best.Score {
+			best = match
+		}
+	}
+	if len(opts.Regexes) > 0 {
+		return fmt.Sprintf("Similarity: %.4f (%s)", best.Score, best.Query)
+	}
+	return fmt.Sprintf("Similarity: %.4f", best.Score)
+}
+
+// stopped reports whether opts.Done has been closed.
+func (opts Options) stopped() bool {
+	select {
+	case <-opts.Done:
+		return true
+	default:
+		return false
+	}
+}
+
+// scorer returns the scorer comparing tokens with queries.
+func (opts Options) scorer() similarity.Scorer {
+	if opts.Scorer == nil {
+		return similarity.Cosine{}
+	}
+	return opts.Scorer
+}
+
+// warnings returns the writer of warnings.
+func (opts Options) warnings() io.Writer {
+	if opts.Warnings == nil {
+		return os.Stderr
+	}
+	return opts.Warnings
+}
+
+// highlightStyle returns the style of matched tokens.
+func (opts Options) highlightStyle() string {
+	if opts.HighlightStyle == "" {
+		return "red"
+	}
+	return opts.HighlightStyle
+}
+
 // ProcessLineByLine processes an input file line by line, performing semantic searches
 // based on the provided queries and Word2Vec model. It supports various options for
-// context lines, case sensitivity, and output formatting.
+// context lines, case sensitivity, and output formatting. It returns the number of
+// selected lines, i.e. the matching lines, or the non-matching ones with InvertMatch,
+// along with any error encountered while reading the input or writing the output.
 //
 // queries: List of query words to search for.
 // w2vModel: The Word2Vec model used for semantic matching.
 // similarityCache: Cache for storing similarity calculations.
-// similarityThreshold: Threshold above which a match is considered similar.
-// contextBefore: Number of lines to include before a matching line.
-// contextAfter: Number of lines to include after a matching line.
-// input: The input file to process.
-// printLineNumbers: Whether to print line numbers in the output.
-// ignoreCase: Whether to ignore case when matching words.
-// outputOnlyMatching: Whether to output only the matching words.
-// outputOnlyLines: Whether to output only the lines that contain matches.
+// input: The input to process.
+// opts: Matching and output options.
 func ProcessLineByLine(queries []string, w2vModel model.VectorModel, similarityCache similarity.SimilarityCache,
-	similarityThreshold float64, contextBefore, contextAfter int, input *os.File,
-	printLineNumbers, ignoreCase, outputOnlyMatching, outputOnlyLines bool) {
-
-	// Prepare query vectors
-	queryVectors := make(map[string]interface{})
-	queryInModel := make(map[string]bool)
-
-	for _, query := range queries {
-		var queryTokenToCheck string
-		if ignoreCase {
-			queryTokenToCheck = strings.ToLower(query)
-		} else {
-			queryTokenToCheck = query
-		}
+	input io.Reader, opts Options) (int, error) {
 
-		queryVector, err := w2vModel.GetEmbedding(queryTokenToCheck)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
-			queryInModel[queryTokenToCheck] = false
-		} else {
-			switch queryVector.(type) {
-			case []float32, []int8:
-				queryVectors[queryTokenToCheck] = queryVector
-				queryInModel[queryTokenToCheck] = true
-			default:
-				fmt.Fprintf(os.Stderr, "Warning: Unsupported vector type for query: %s\n", queryTokenToCheck)
-				queryInModel[queryTokenToCheck] = false
-			}
-		}
+	// Prepare query vectors. All queries share the similarity cache.
+	matcher := NewMatcher(queries, w2vModel, opts)
+	for _, concept := range matcher.concepts {
+		concept.cache = similarityCache
 	}
 
-	scanner := bufio.NewScanner(input)
+	var timeFilter *timeRangeFilter
+	if opts.TimeRange != nil {
+		timeFilter = &timeRangeFilter{r: opts.TimeRange}
+	}
+
+	out := newOutput(opts)
+	lines := newLineSource(input, matcher)
 	lineNumber := 0
-	var contextBuffer []string
-	var contextLineNumbers []int
+	selectedLines := 0
+	contextBuffer := newContextRing(min(opts.ContextBefore, MaxContext))
+	withContext := !opts.OutputOnlyMatching && !opts.OutputOnlyLines
+	// Number of lines still to print after the last match, and the number
+	// of the last line printed, to tell whether the next group adjoins it
+	afterLeft := 0
+	lastPrinted := 0
 
-	// Process each line
-	for scanner.Scan() {
-		line := scanner.Text()
+	// Process each line, stopping early once MaxCount lines were selected and
+	// the context after the last of them was printed
+	for (opts.MaxCount == 0 || selectedLines < opts.MaxCount || afterLeft > 0) && out.err == nil && !opts.stopped() && lines.scan() {
+		line := string(lines.text)
 		lineNumber++
-		matched := false
-		var highlightedLine string
-		var similarityScore float64
-		var matchSimilarityScore float64
-
-		// Tokenize and check each token
-		tokens := words.NewSegmenter(scanner.Bytes())
-		for tokens.Next() {
-			token := tokens.Text()
-			var tokenToCheck string
-			if ignoreCase {
-				tokenToCheck = strings.ToLower(token)
-			} else {
-				tokenToCheck = token
-			}
 
-			for queryTokenToCheck, queryVector := range queryVectors {
-				// Check if tokenToCheck is exactly equal to queryTokenToCheck
-				if tokenToCheck == queryTokenToCheck {
-					similarityScore = 1.0
-					matchSimilarityScore = similarityScore
-					matched = true
-					highlightedLine = strings.Replace(line, token, utils.ColorText(token, "red"), -1)
-				} else if queryInModel[queryTokenToCheck] {
-					// Only perform similarity check if query is in the model
-					tokenVector, err := w2vModel.GetEmbedding(tokenToCheck)
-					if err == nil {
-						// Calculate similarity and check threshold only if token is in model
-						similarityScore = similarityCache.MemoizedCalculateSimilarity(queryTokenToCheck, tokenToCheck, queryVector, tokenVector)
-						if similarityScore > similarityThreshold {
-							matched = true
-							highlightedLine = strings.Replace(line, token, utils.ColorText(token, "red"), -1)
-							matchSimilarityScore = similarityScore
-						}
-					}
-				}
+		// Lines outside of the time range are ignored altogether
+		if timeFilter != nil && !timeFilter.inRange(line) {
+			continue
+		}
+
+		// Past MaxCount, lines are only printed as the context after the last
+		// match, and the lines of blocks skipped by the adaptive scan do not match
+		var matches []TokenSpan
+		if (opts.MaxCount == 0 || selectedLines < opts.MaxCount) && !lines.skipped {
+			matches = matcher.Match(lines.text)
+		}
+		matched := len(matches) > 0
 
-				if matched && outputOnlyMatching {
-					fmt.Println(token)
-					matched = false // Stop after first match if -o is set
+		// With -v the lines without a match are the output; -o has nothing to print
+		if opts.InvertMatch {
+			if !matched {
+				selectedLines++
+				if opts.Dedupe != nil && !opts.CountOnly && !opts.Dedupe.add(line) {
+					continue
+				}
+				if opts.JSON && !opts.CountOnly {
+					printJSON(out, opts, lines.start, lineNumber, line, nil)
+				} else if opts.Table != "" && !opts.CountOnly {
+					printTable(out, opts, lineNumber, lines.start, line, nil)
+				} else if opts.Template != nil && !opts.CountOnly {
+					printTemplate(out, opts, lineNumber, lines.start, line, nil)
+				} else if !opts.OutputOnlyMatching && !opts.CountOnly {
+					out.printLine(offsetPrefix(opts, lines.start, -1)+line, lineNumber, opts.PrintLineNumbers)
 				}
 			}
+			continue
+		}
+
+		if matched {
+			selectedLines++
+		}
+		if opts.CountOnly {
+			continue
+		}
+		// A line printed before is only kept as context for later matches
+		if matched && opts.Dedupe != nil && !opts.Dedupe.add(line) {
+			matched = false
+		}
+		if opts.JSON {
+			if matched {
+				printJSON(out, opts, lines.start, lineNumber, line, matches)
+			}
+			continue
+		}
+		if opts.Table != "" {
+			if matched {
+				printTable(out, opts, lineNumber, lines.start, line, matches)
+			}
+			continue
+		}
+		if opts.Template != nil {
+			if matched {
+				printTemplate(out, opts, lineNumber, lines.start, line, matches)
+			}
+			continue
 		}
 
 		// Handle matched line
 		if matched {
-			if outputOnlyMatching {
-				// Already printed in the loop above
-			} else if outputOnlyLines {
-				utils.PrintLine(highlightedLine, lineNumber, printLineNumbers)
+			highlightedLine := offsetPrefix(opts, lines.start, matches[0].Start+1)
+			if opts.Heatmap {
+				highlightedLine += heatmapLine(line, matcher.concepts, w2vModel, opts)
 			} else {
-				fmt.Printf("Similarity: %.4f\n", matchSimilarityScore)
-				// Print the context lines before the match
-				for i, ctxLine := range contextBuffer {
-					utils.PrintLine(ctxLine, contextLineNumbers[i], printLineNumbers)
+				highlightedLine += highlightSpans(line, matches, opts.highlightStyle(), opts.ShowScores == ScoresInline)
+			}
+			if opts.OutputOnlyMatching {
+				for _, match := range matches {
+					token := offsetPrefix(opts, lines.start+int64(match.Start), match.Start+1) + match.Token
+					if opts.ShowScores == ScoresInline {
+						token += formatScore(match.Score)
+					}
+					out.printLine(token, lineNumber, false)
 				}
-
-				// Print the matched line with highlighted token
-				utils.PrintLine(highlightedLine, lineNumber, printLineNumbers)
-
-				// Print the context lines after the match
-				for i := 0; i < contextAfter && scanner.Scan(); i++ {
-					lineNumber++
-					utils.PrintLine(scanner.Text(), lineNumber, printLineNumbers)
+			} else if opts.OutputOnlyLines {
+				out.printLine(highlightedLine, lineNumber, opts.PrintLineNumbers)
+			} else {
+				// Separate this group from the previous one unless they touch
+				// or overlap, in which case they merge like in grep
+				firstLine := lineNumber
+				if oldest, ok := contextBuffer.oldest(); ok {
+					firstLine = oldest
+				}
+				if lastPrinted > 0 && firstLine > lastPrinted+1 {
+					out.printText("--")
 				}
+				if opts.ShowScores == "" || opts.ShowScores == ScoresPrefix {
+					out.printText(similarityHeader(matches, opts))
+				}
+				// Print the context lines before the match
+				contextBuffer.each(func(ctxLine string, ctxLineNumber int) {
+					out.printLine(ctxLine, ctxLineNumber, opts.PrintLineNumbers)
+				})
 
-				fmt.Println("--")
+				// Print the matched line with highlighted tokens; the lines
+				// after it are printed as they are read
+				out.printLine(highlightedLine, lineNumber, opts.PrintLineNumbers)
+				lastPrinted = lineNumber
+				afterLeft = opts.ContextAfter
 			}
 
 			// Clear the context buffer after printing
-			contextBuffer = nil
-			contextLineNumbers = nil
-		} else {
-			// Update the context buffer with the current line if no match is found
-			if contextBefore > 0 && !outputOnlyMatching && !outputOnlyLines {
-				contextBuffer = append(contextBuffer, line)
-				contextLineNumbers = append(contextLineNumbers, lineNumber)
-				// Ensure the context buffer does not exceed the specified number of lines
-				if len(contextBuffer) > contextBefore {
-					contextBuffer = contextBuffer[1:]
-					contextLineNumbers = contextLineNumbers[1:]
-				}
-			}
+			contextBuffer.reset()
+		} else if withContext && afterLeft > 0 {
+			// Print the context lines after the last match
+			out.printLine(line, lineNumber, opts.PrintLineNumbers)
+			lastPrinted = lineNumber
+			afterLeft--
+		} else if opts.ContextBefore > 0 && withContext {
+			// Update the context buffer with the current line if no match is found.
+			// The ring drops the oldest line beyond the specified number of lines
+			contextBuffer.push(line, lineNumber)
 		}
 	}
 
-	// Check for scanner errors
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading input: %v\n", err)
+	// Check for scanner errors, then output errors
+	if err := opts.scanError(lines.err()); err != nil {
+		return selectedLines, err
 	}
+	return selectedLines, out.err
 }
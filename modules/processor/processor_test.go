@@ -0,0 +1,102 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+	"github.com/arunsupe/semantic-grep/modules/utils"
+)
+
+// search runs ProcessLineByLine for "death" on input, without colors nor
+// scores, and returns its output and the number of lines selected.
+func search(t *testing.T, input string, opts Options) (string, int) {
+	t.Helper()
+	utils.SetColor(false)
+	var output bytes.Buffer
+	opts.SimilarityThreshold = 0.9
+	opts.ShowScores = ScoresNone
+	opts.Output = &output
+	opts.Warnings = io.Discard
+	m := writeTestModel(t, t.TempDir(), modelio.Float32)
+	count, err := ProcessLineByLine([]string{"death"}, m, similarity.NewSimilarityCache(), strings.NewReader(input), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return output.String(), count
+}
+
+// TestProcessLineByLine checks the lines selected and printed with the
+// options of grep. Groups of lines that do not touch are separated by --.
+func TestProcessLineByLine(t *testing.T) {
+	const input = "death at sea\nthe cat\nhe died\nthe ocean\ndeath again\n"
+	tests := []struct {
+		name   string
+		opts   Options
+		output string
+		count  int
+	}{
+		{"default", Options{}, "death at sea\n--\nhe died\n--\ndeath again\n", 3},
+		{"line numbers", Options{PrintLineNumbers: true}, "1: death at sea\n--\n3: he died\n--\n5: death again\n", 3},
+		{"only lines", Options{OutputOnlyLines: true}, "death at sea\nhe died\ndeath again\n", 3},
+		{"invert match", Options{InvertMatch: true}, "the cat\nthe ocean\n", 2},
+		{"count", Options{CountOnly: true}, "", 3},
+		{"count inverted", Options{CountOnly: true, InvertMatch: true}, "", 2},
+		{"max count", Options{MaxCount: 2}, "death at sea\n--\nhe died\n", 2},
+		{"max count inverted", Options{MaxCount: 1, InvertMatch: true}, "the cat\n", 1},
+		{"only matching", Options{OutputOnlyMatching: true}, "death\ndied\ndeath\n", 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, count := search(t, input, test.opts)
+			if output != test.output || count != test.count {
+				t.Errorf("output %q, %d line(s) selected, want %q, %d", output, count, test.output, test.count)
+			}
+		})
+	}
+}
+
+// TestProcessLineByLineDedupe checks that a line selected again, with
+// other whitespace, is counted but not printed again, and that the Deduper
+// remembers the lines across inputs.
+func TestProcessLineByLineDedupe(t *testing.T) {
+	dedupe := NewDeduper()
+	output, count := search(t, "death at sea\nhe died\ndeath  at\tsea\n", Options{Dedupe: dedupe})
+	if output != "death at sea\nhe died\n" || count != 3 {
+		t.Errorf("output %q, %d line(s) selected, want the first two lines, 3", output, count)
+	}
+	output, count = search(t, "he died\ndeath again\n", Options{Dedupe: dedupe})
+	if output != "death again\n" || count != 2 {
+		t.Errorf("second input: output %q, %d line(s) selected, want the new line, 2", output, count)
+	}
+	if dedupe.Suppressed() != 2 {
+		t.Errorf("%d line(s) suppressed, want 2", dedupe.Suppressed())
+	}
+}
+
+// TestProcessLineByLineJSON checks the JSON objects of the selected lines:
+// their numbers, offsets and matches.
+func TestProcessLineByLineJSON(t *testing.T) {
+	output, count := search(t, "the cat\nhe died at sea\n", Options{JSON: true, FileName: "input.txt"})
+	if count != 1 {
+		t.Fatalf("%d line(s) selected, want 1", count)
+	}
+	var record jsonLine
+	if err := json.Unmarshal([]byte(output), &record); err != nil {
+		t.Fatalf("output %q: %v", output, err)
+	}
+	if record.File != "input.txt" || record.LineNumber != 2 || record.ByteOffset != 8 || record.Text != "he died at sea" {
+		t.Errorf("line %+v, want line 2 of input.txt at offset 8", record)
+	}
+	if len(record.Matches) != 1 {
+		t.Fatalf("matches %+v, want died", record.Matches)
+	}
+	match := record.Matches[0]
+	if match.Token != "died" || match.Query != "death" || match.Start != 3 || match.End != 7 || !(match.Score > 0.9) {
+		t.Errorf("match %+v, want died at 3 to 7, scoring above 0.9", match)
+	}
+}
@@ -0,0 +1,78 @@
+package processor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+)
+
+// Proximity requires tokens similar to two concepts to appear within
+// Distance words of each other on the same line.
+type Proximity struct {
+	ConceptA string
+	ConceptB string
+	Distance int
+}
+
+// ParseProximity parses a proximity constraint of the form "death,sea:10".
+func ParseProximity(spec string) (*Proximity, error) {
+	concepts, distance, found := strings.Cut(spec, ":")
+	if !found {
+		return nil, fmt.Errorf("invalid proximity %q: expected CONCEPT,CONCEPT:DISTANCE", spec)
+	}
+
+	conceptA, conceptB, found := strings.Cut(concepts, ",")
+	conceptA = strings.TrimSpace(conceptA)
+	conceptB = strings.TrimSpace(conceptB)
+	if !found || conceptA == "" || conceptB == "" {
+		return nil, fmt.Errorf("invalid proximity %q: expected two comma separated concepts", spec)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(distance))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid proximity %q: distance must be a non-negative integer", spec)
+	}
+
+	return &Proximity{ConceptA: conceptA, ConceptB: conceptB, Distance: n}, nil
+}
+
+// proximityFilter checks a Proximity constraint against lines of input.
+type proximityFilter struct {
+	conceptA *conceptQuery
+	conceptB *conceptQuery
+	distance int
+}
+
+// newProximityFilter looks up both concepts of p in the model.
+func newProximityFilter(p *Proximity, w2vModel model.VectorModel, opts Options) *proximityFilter {
+	return &proximityFilter{
+		conceptA: newConceptQuery(p.ConceptA, w2vModel, opts),
+		conceptB: newConceptQuery(p.ConceptB, w2vModel, opts),
+		distance: p.Distance,
+	}
+}
+
+// satisfied reports whether line has a match for each concept no more than
+// the configured distance apart.
+func (f *proximityFilter) satisfied(line []byte, w2vModel model.VectorModel, opts Options) bool {
+	spansA := f.conceptA.matchSpans(line, w2vModel, opts)
+	if len(spansA) == 0 {
+		return false
+	}
+	spansB := f.conceptB.matchSpans(line, w2vModel, opts)
+
+	for _, a := range spansA {
+		for _, b := range spansB {
+			distance := a.Index - b.Index
+			if distance < 0 {
+				distance = -distance
+			}
+			if distance <= f.distance {
+				return true
+			}
+		}
+	}
+	return false
+}
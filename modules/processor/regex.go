@@ -0,0 +1,88 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RegexPrefix marks the patterns of a pattern file that are regular
+// expressions, e.g. `re:\bERR-\d+\b`, matched against whole lines like
+// grep -E instead of semantically.
+const RegexPrefix = "re:"
+
+// SplitPatterns separates the regular expressions of a pattern file from
+// the semantic queries and compiles them, case-insensitively with
+// ignoreCase, and matching whole words only with wholeWord, like grep -w.
+// Empty lines are dropped.
+func SplitPatterns(patterns []string, ignoreCase, wholeWord bool) ([]string, []*regexp.Regexp, error) {
+	var queries []string
+	var regexes []*regexp.Regexp
+	for _, pattern := range patterns {
+		source, isRegex := strings.CutPrefix(pattern, RegexPrefix)
+		if !isRegex {
+			if strings.TrimSpace(pattern) != "" {
+				queries = append(queries, pattern)
+			}
+			continue
+		}
+		if wholeWord {
+			source = `\b(?:` + source + `)\b`
+		}
+		if ignoreCase {
+			source = "(?i)" + source
+		}
+		re, err := regexp.Compile(source)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pattern %q: %v", pattern, err)
+		}
+		regexes = append(regexes, re)
+	}
+	return queries, regexes, nil
+}
+
+// regexSpans returns the non-empty matches of the regular expressions in
+// line, with a score of 1 and RegexPrefix and the expression as query.
+func regexSpans(line []byte, regexes []*regexp.Regexp) []TokenSpan {
+	var spans []TokenSpan
+	for _, re := range regexes {
+		for _, loc := range re.FindAllIndex(line, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			spans = append(spans, TokenSpan{
+				Token: string(line[loc[0]:loc[1]]),
+				Query: RegexPrefix + re.String(),
+				Start: loc[0],
+				End:   loc[1],
+				Score: 1.0,
+			})
+		}
+	}
+	return spans
+}
+
+// mergeSpans combines two lists of spans into one sorted by offset. Where
+// spans overlap, the one starting first, or the longer one, is kept.
+func mergeSpans(a, b []TokenSpan) []TokenSpan {
+	if len(b) == 0 {
+		return a
+	}
+	all := append(append([]TokenSpan(nil), a...), b...)
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].Start != all[j].Start {
+			return all[i].Start < all[j].Start
+		}
+		return all[i].End > all[j].End
+	})
+
+	merged := all[:0]
+	for _, span := range all {
+		if len(merged) > 0 && span.Start < merged[len(merged)-1].End {
+			continue
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
@@ -0,0 +1,73 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxLineLength is the longest line read when Options.MaxLineLength
+// is 0. Lines such as minified JSON or long log records easily exceed the
+// 64KB limit of bufio.Scanner; the buffer only grows as long lines are met.
+const DefaultMaxLineLength = 64 << 20
+
+// maxLineLength returns the longest line read, in bytes.
+func (opts Options) maxLineLength() int {
+	if opts.MaxLineLength <= 0 {
+		return DefaultMaxLineLength
+	}
+	return opts.MaxLineLength
+}
+
+// splitFunc returns the function splitting the input into lines: at
+// newlines, or at NUL bytes with NullData.
+func (opts Options) splitFunc() bufio.SplitFunc {
+	if opts.NullData {
+		return scanNullTerminated
+	}
+	return bufio.ScanLines
+}
+
+// scanNullTerminated is a bufio.SplitFunc returning NUL terminated records,
+// without the NUL. The last record need not be terminated.
+func scanNullTerminated(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// newScanner returns a scanner of the lines of input, accepting lines up to
+// the maximum length of opts.
+func newScanner(input io.Reader, opts Options) *bufio.Scanner {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 64*1024), opts.maxLineLength())
+	scanner.Split(opts.splitFunc())
+	return scanner
+}
+
+// LineTooLongError reports a line longer than Options.MaxLineLength, which
+// stops the processing of an input.
+type LineTooLongError struct {
+	MaxLineLength int
+}
+
+func (e *LineTooLongError) Error() string {
+	return fmt.Sprintf("a line is longer than the maximum line length of %d bytes", e.MaxLineLength)
+}
+
+// scanError explains the error of a scanner created by newScanner.
+func (opts Options) scanError(err error) error {
+	if errors.Is(err, bufio.ErrTooLong) {
+		return &LineTooLongError{MaxLineLength: opts.maxLineLength()}
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/arunsupe/semantic-grep/modules/modelio"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+)
+
+// longLine returns a line of at least size bytes of words, with word at its
+// end.
+func longLine(size int, word string) string {
+	filler := strings.Repeat("the ", size/4+1)
+	return filler + word
+}
+
+// TestMultiMegabyteLines checks that lines of several MiB, longer than the
+// buffers of bufio.Scanner, are searched whole, and that the lines after
+// them are numbered and located right.
+func TestMultiMegabyteLines(t *testing.T) {
+	m := writeTestModel(t, t.TempDir(), modelio.Float32)
+	long := longLine(5<<20, "died")
+	input := "death at first\n" + long + "\nthe ocean\n" + longLine(3<<20, "kitten") + "\nhe died again\n"
+	opts := Options{SimilarityThreshold: 0.9, MaxLineLength: 8 << 20, Warnings: io.Discard}
+
+	var numbers []int
+	var offsets []int64
+	err := NewMatcher([]string{"death"}, m, opts).Search(strings.NewReader(input), func(line Line) bool {
+		numbers = append(numbers, line.Number)
+		offsets = append(offsets, line.Offset)
+		if line.Number == 2 && len(line.Text) != len(long) {
+			t.Errorf("line 2 has %d bytes, want %d", len(line.Text), len(long))
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOffset := int64(len(input) - len("he died again\n"))
+	if len(numbers) != 3 || numbers[0] != 1 || numbers[1] != 2 || numbers[2] != 5 || offsets[2] != wantOffset {
+		t.Errorf("selected lines %v at offsets %v, want 1, 2 and 5, at offset %d", numbers, offsets, wantOffset)
+	}
+}
+
+// TestLineTooLong checks that a line longer than MaxLineLength stops the
+// search with a LineTooLongError, after the lines before it are printed.
+func TestLineTooLong(t *testing.T) {
+	m := writeTestModel(t, t.TempDir(), modelio.Float32)
+	input := "death at first\n" + longLine(2<<20, "died") + "\nhe died again\n"
+	var output bytes.Buffer
+	opts := Options{SimilarityThreshold: 0.9, MaxLineLength: 1 << 20, Output: &output, Warnings: io.Discard}
+
+	count, err := ProcessLineByLine([]string{"death"}, m, similarity.NewSimilarityCache(), strings.NewReader(input), opts)
+	var tooLong *LineTooLongError
+	if !errors.As(err, &tooLong) || tooLong.MaxLineLength != 1<<20 {
+		t.Fatalf("error %v, want a LineTooLongError of 1 MiB", err)
+	}
+	if count != 1 || !strings.Contains(output.String(), "at first") || strings.Contains(output.String(), "again") {
+		t.Errorf("%d line(s) selected, output %q, want the first line only", count, output.String())
+	}
+}
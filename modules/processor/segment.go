@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+
+	"github.com/clipperhouse/uax29/words"
+)
+
+// Ways of splitting lines into tokens, see Options.Segmenter.
+const (
+	SegmenterWords = "words" // Unicode word boundaries (UAX #29), the default
+	SegmenterCJK   = "cjk"   // word boundaries, joining CJK characters into words of the model
+)
+
+// DefaultCJKMaxLength is the default length, in characters, of the longest
+// CJK word tried by the cjk segmenter.
+const DefaultCJKMaxLength = 4
+
+// segment is a piece of a line with its byte offsets in the line.
+type segment struct {
+	text       string
+	start, end int
+}
+
+// segmentLine splits line into segments. Word boundaries put every Chinese
+// character, and every Japanese kana, in its own segment, although model
+// vocabularies hold words of several characters. With the cjk segmenter,
+// runs of such characters are split again by forward maximum matching:
+// at each position, the longest run of up to opts.CJKMaxLength characters
+// found in the model is taken as a word, falling back to a single character.
+func segmentLine(line []byte, w2vModel model.VectorModel, opts Options) []segment {
+	var segments []segment
+	var run []segment
+	end := 0
+
+	tokens := words.NewSegmenter(line)
+	for tokens.Next() {
+		s := segment{text: tokens.Text(), start: end}
+		end += len(tokens.Bytes())
+		s.end = end
+
+		if opts.Segmenter == SegmenterCJK && isCJKCharacter(s.text) {
+			run = append(run, s)
+			continue
+		}
+		segments = append(segments, joinCJK(line, run, w2vModel, opts)...)
+		run = run[:0]
+		segments = append(segments, s)
+	}
+	return append(segments, joinCJK(line, run, w2vModel, opts)...)
+}
+
+// joinCJK joins a run of single character segments into the longest words
+// known to the model.
+func joinCJK(line []byte, run []segment, w2vModel model.VectorModel, opts Options) []segment {
+	maxLength := opts.CJKMaxLength
+	if maxLength <= 0 {
+		maxLength = DefaultCJKMaxLength
+	}
+
+	var joined []segment
+	for i := 0; i < len(run); {
+		length := 1
+		for n := min(maxLength, len(run)-i); n > 1; n-- {
+			candidate := string(line[run[i].start:run[i+n-1].end])
+			if opts.Normalize != nil {
+				candidate = opts.Normalize(candidate)
+			}
+			if _, err := w2vModel.GetEmbedding(candidate); err == nil {
+				length = n
+				break
+			}
+		}
+		joined = append(joined, segment{
+			text:  string(line[run[i].start:run[i+length-1].end]),
+			start: run[i].start,
+			end:   run[i+length-1].end,
+		})
+		i += length
+	}
+	return joined
+}
+
+// isCJKCharacter reports whether s is a single Han, Hiragana or Katakana
+// character.
+func isCJKCharacter(s string) bool {
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) {
+		return false
+	}
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
@@ -0,0 +1,216 @@
+package processor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+	"github.com/arunsupe/semantic-grep/modules/utils"
+	"github.com/clipperhouse/uax29/sentences"
+)
+
+// sentence is a sentence of the input, with the line breaks inside it
+// replaced by spaces, and the numbers of its first and last lines.
+type sentence struct {
+	text        string
+	first, last int
+}
+
+// sentenceReader reads the sentences of an input whose lines may be wrapped
+// in the middle of a sentence, as in books and emails. Lines are joined with
+// spaces and split into sentences by the Unicode text segmentation rules
+// (UAX #29); a blank line ends a paragraph and so the sentence in it. Only
+// the sentence being read is held in memory.
+type sentenceReader struct {
+	scanner    *bufio.Scanner
+	lineNumber int
+	text       []byte // lines read but not yet returned as sentences
+	starts     []int  // offsets in text where lines start
+	numbers    []int  // numbers of those lines
+	ready      []sentence
+}
+
+// newSentenceReader returns a reader of the sentences of input, reading
+// lines up to the maximum length of opts.
+func newSentenceReader(input io.Reader, opts Options) *sentenceReader {
+	return &sentenceReader{scanner: newScanner(input, opts)}
+}
+
+// next returns the next sentence, or false at the end of input.
+func (r *sentenceReader) next() (sentence, bool) {
+	for len(r.ready) == 0 {
+		if !r.scanner.Scan() {
+			r.split(true)
+			if len(r.ready) == 0 {
+				return sentence{}, false
+			}
+			break
+		}
+		r.lineNumber++
+		line := r.scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			r.split(true)
+			continue
+		}
+		if len(r.text) > 0 {
+			r.text = append(r.text, ' ')
+		}
+		r.starts = append(r.starts, len(r.text))
+		r.numbers = append(r.numbers, r.lineNumber)
+		r.text = append(r.text, line...)
+		r.split(false)
+	}
+	s := r.ready[0]
+	r.ready = r.ready[1:]
+	return s, true
+}
+
+// split moves the complete sentences of the pending text to ready. The last
+// sentence may continue on the next line, so it is kept unless atEnd.
+func (r *sentenceReader) split(atEnd bool) {
+	var bounds [][2]int
+	segments := sentences.NewSegmenter(r.text)
+	for segments.Next() {
+		bounds = append(bounds, [2]int{segments.Start(), segments.End()})
+	}
+	if !atEnd && len(bounds) > 0 {
+		bounds = bounds[:len(bounds)-1]
+	}
+	if len(bounds) == 0 {
+		return
+	}
+
+	for _, b := range bounds {
+		start, end := b[0], b[1]
+		trimmed := bytes.TrimSpace(r.text[start:end])
+		if len(trimmed) == 0 {
+			continue
+		}
+		start += bytes.Index(r.text[start:end], trimmed)
+		end = start + len(trimmed)
+		r.ready = append(r.ready, sentence{
+			text:  string(trimmed),
+			first: r.lineAt(start),
+			last:  r.lineAt(end - 1),
+		})
+	}
+
+	// Drop the returned text, keeping the lines the rest starts on
+	cut := bounds[len(bounds)-1][1]
+	i := 0
+	for i+1 < len(r.starts) && r.starts[i+1] <= cut {
+		i++
+	}
+	r.text = append(r.text[:0], r.text[cut:]...)
+	r.starts = r.starts[i:]
+	r.numbers = r.numbers[i:]
+	for j := range r.starts {
+		r.starts[j] = max(r.starts[j]-cut, 0)
+	}
+	if len(r.text) == 0 {
+		r.starts = r.starts[:0]
+		r.numbers = r.numbers[:0]
+	}
+}
+
+// lineAt returns the number of the line holding the byte at offset in the
+// pending text.
+func (r *sentenceReader) lineAt(offset int) int {
+	i := 0
+	for i+1 < len(r.starts) && r.starts[i+1] <= offset {
+		i++
+	}
+	return r.numbers[i]
+}
+
+// lineRange returns the line number prefix of a sentence, e.g. "12-14:".
+func (s sentence) lineRange() string {
+	if s.first == s.last {
+		return fmt.Sprintf("%d:", s.first)
+	}
+	return fmt.Sprintf("%d-%d:", s.first, s.last)
+}
+
+// ProcessSentences is like ProcessLineByLine but matches sentences instead of
+// lines: sentences that span several lines are reassembled, printed on one
+// line and, with opts.PrintLineNumbers, prefixed with the range of lines they
+// came from. Context lines are not printed, and byte offsets, time ranges
+// and JSON output are not supported. It returns the number of selected sentences and any error
+// encountered while reading the input or writing the output.
+//
+// queries: List of query words to search for.
+// w2vModel: The Word2Vec model used for semantic matching.
+// similarityCache: Cache for storing similarity calculations.
+// input: The input to process.
+// opts: Matching and output options.
+func ProcessSentences(queries []string, w2vModel model.VectorModel, similarityCache similarity.SimilarityCache,
+	input io.Reader, opts Options) (int, error) {
+
+	matcher := NewMatcher(queries, w2vModel, opts)
+	for _, concept := range matcher.concepts {
+		concept.cache = similarityCache
+	}
+
+	out := newOutput(opts)
+	reader := newSentenceReader(input, opts)
+	selected := 0
+
+	// printSentence writes a sentence on one line, after its line range
+	printSentence := func(s sentence, text string) {
+		if opts.PrintLineNumbers {
+			text = utils.ColorText(s.lineRange(), "blue") + " " + text
+		}
+		out.printLine(text, 0, false)
+	}
+
+	for (opts.MaxCount == 0 || selected < opts.MaxCount) && out.err == nil && !opts.stopped() {
+		s, ok := reader.next()
+		if !ok {
+			break
+		}
+		matches := matcher.Match([]byte(s.text))
+		if (len(matches) > 0) == opts.InvertMatch {
+			continue
+		}
+		selected++
+		if opts.CountOnly {
+			continue
+		}
+		if opts.Dedupe != nil && !opts.Dedupe.add(s.text) {
+			continue
+		}
+
+		switch {
+		case opts.InvertMatch:
+			if !opts.OutputOnlyMatching {
+				printSentence(s, s.text)
+			}
+		case opts.OutputOnlyMatching:
+			for _, match := range matches {
+				token := match.Token
+				if opts.ShowScores == ScoresInline {
+					token += formatScore(match.Score)
+				}
+				out.printLine(token, 0, false)
+			}
+		default:
+			if !opts.OutputOnlyLines && (opts.ShowScores == "" || opts.ShowScores == ScoresPrefix) {
+				out.printText(similarityHeader(matches, opts))
+			}
+			if opts.Heatmap {
+				printSentence(s, heatmapLine(s.text, matcher.concepts, w2vModel, opts))
+			} else {
+				printSentence(s, highlightSpans(s.text, matches, opts.highlightStyle(), opts.ShowScores == ScoresInline))
+			}
+		}
+	}
+
+	// Check for scanner errors, then output errors
+	if err := opts.scanError(reader.scanner.Err()); err != nil {
+		return selected, err
+	}
+	return selected, out.err
+}
@@ -0,0 +1,127 @@
+package processor
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+)
+
+// Summary counts, for each distinct token matching the queries, the lines
+// it matches in and its mean similarity, to show which words related to the
+// queries a corpus uses rather than where. It collects the matches of all
+// inputs until printed.
+type Summary struct {
+	tokens map[string]*tokenSummary
+	// foldCase counts the tokens differing only by case as one, in lowercase
+	foldCase bool
+}
+
+// tokenSummary is what a Summary knows of a token.
+type tokenSummary struct {
+	token string
+	// query is the query the token was most similar to
+	query string
+	lines int
+	// matches and total are the number and sum of the scores of its matches
+	matches int
+	total   float64
+	best    float64
+}
+
+// NewSummary returns an empty summary, counting the tokens differing only
+// by case as one when foldCase is set.
+func NewSummary(foldCase bool) *Summary {
+	return &Summary{tokens: make(map[string]*tokenSummary), foldCase: foldCase}
+}
+
+// add records the matches of a line.
+func (s *Summary) add(matches []TokenSpan) {
+	seen := make(map[string]bool, len(matches))
+	for _, match := range matches {
+		token := match.Token
+		if s.foldCase {
+			token = strings.ToLower(token)
+		}
+		t, ok := s.tokens[token]
+		if !ok {
+			t = &tokenSummary{token: token}
+			s.tokens[token] = t
+		}
+		if !seen[token] {
+			seen[token] = true
+			t.lines++
+		}
+		t.matches++
+		t.total += match.Score
+		if t.matches == 1 || match.Score > t.best {
+			t.best = match.Score
+			t.query = match.Query
+		}
+	}
+}
+
+// Print writes a line for each token, those matching in the most lines
+// first, with its number of lines and mean similarity, e.g.
+// "perished: 14 line(s), avg 0.73". With several queries, the query each
+// token is most similar to is named.
+func (s *Summary) Print(w io.Writer, queries int) error {
+	tokens := make([]*tokenSummary, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		tokens = append(tokens, t)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].lines != tokens[j].lines {
+			return tokens[i].lines > tokens[j].lines
+		}
+		if mi, mj := tokens[i].mean(), tokens[j].mean(); mi != mj {
+			return mi > mj
+		}
+		return tokens[i].token < tokens[j].token
+	})
+
+	out := &output{w: w, eol: "\n"}
+	for _, t := range tokens {
+		line := fmt.Sprintf("%s: %d line(s), avg %.2f", shorten(t.token), t.lines, t.mean())
+		if queries > 1 {
+			line += fmt.Sprintf(" (%s)", t.query)
+		}
+		out.printText(line)
+	}
+	return out.err
+}
+
+// mean returns the mean similarity of the matches of the token.
+func (t *tokenSummary) mean() float64 {
+	return t.total / float64(t.matches)
+}
+
+// CollectSummary matches the lines of input against the queries and adds
+// their matching tokens to s. It returns the number of lines with a match
+// and any error encountered while reading the input.
+//
+// queries: List of query words to search for.
+// w2vModel: The Word2Vec model used for semantic matching.
+// similarityCache: Cache for storing similarity calculations.
+// input: The input to process.
+// s: The summary so far, across files.
+// opts: Matching options.
+func CollectSummary(queries []string, w2vModel model.VectorModel, similarityCache similarity.SimilarityCache,
+	input io.Reader, s *Summary, opts Options) (int, error) {
+
+	matcher := NewMatcher(queries, w2vModel, opts)
+	for _, concept := range matcher.concepts {
+		concept.cache = similarityCache
+	}
+
+	lines := 0
+	err := matcher.Search(input, func(line Line) bool {
+		lines++
+		s.add(line.Matches)
+		return true
+	})
+	return lines, err
+}
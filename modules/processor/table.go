@@ -0,0 +1,139 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Columnar output formats, see Options.Table.
+const (
+	TableTSV = "tsv" // tab separated values, with tabs and newlines escaped
+	TableCSV = "csv" // comma separated values, quoted as by RFC 4180
+)
+
+// Match is a match of a selected line, as printed by Options.Table and
+// Options.Template.
+type Match struct {
+	// File is the name of the file, "(standard input)" for standard input
+	File string
+	// Line is the 1-based line number, and Offset the byte offset of the
+	// line in the input
+	Line   int
+	Offset int64
+	// Column is the 1-based byte column of the token in the line; Start and
+	// End are its 0-based byte offsets in the line, End exclusive. They are
+	// 0 for a line selected without a match, as with InvertMatch.
+	Column     int
+	Start, End int
+	// Token is the matched text, Query the query it is similar to, and
+	// Score their similarity; all empty or 0 without a match.
+	Token string
+	Query string
+	Score float64
+	// Text is the whole line
+	Text string
+}
+
+// matchesOf returns the matches of a selected line, or a Match of the line
+// alone when it has none.
+func matchesOf(opts Options, lineNumber int, byteOffset int64, line string, spans []TokenSpan) []Match {
+	match := Match{File: opts.FileName, Line: lineNumber, Offset: byteOffset, Text: line}
+	if len(spans) == 0 {
+		return []Match{match}
+	}
+	matches := make([]Match, len(spans))
+	for i, span := range spans {
+		matches[i] = match
+		matches[i].Column = span.Start + 1
+		matches[i].Start, matches[i].End = span.Start, span.End
+		matches[i].Token, matches[i].Query, matches[i].Score = span.Token, span.Query, span.Score
+	}
+	return matches
+}
+
+// tableColumns are the columns of a table, one row per match.
+var tableColumns = []string{"file", "line", "column", "token", "query", "score", "text"}
+
+// tsvEscaper escapes the characters that would break a row of TSV.
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// PrintTableHeader writes the header row of the table format, naming the
+// columns: file, line, column, token, query, score and text.
+func PrintTableHeader(w io.Writer, format string) error {
+	return writeRow(w, format, tableColumns)
+}
+
+// printTable writes a row for each match of a selected line: the file, the
+// line number, the column and the token of the match, the query it is
+// similar to, its score and the text of the line. A line selected without
+// a match, as with InvertMatch, has a row with the match columns empty.
+func printTable(out io.Writer, opts Options, lineNumber int, byteOffset int64, line string, spans []TokenSpan) error {
+	for _, match := range matchesOf(opts, lineNumber, byteOffset, line, spans) {
+		row := []string{match.File, strconv.Itoa(match.Line), "", "", "", "", match.Text}
+		if match.Column > 0 {
+			row[2] = strconv.Itoa(match.Column)
+			row[3], row[4] = match.Token, match.Query
+			row[5] = strconv.FormatFloat(match.Score, 'f', 4, 64)
+		}
+		if err := writeRow(out, opts.Table, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRow writes the fields of a row in the table format.
+func writeRow(w io.Writer, format string, fields []string) error {
+	switch format {
+	case TableTSV:
+		escaped := make([]string, len(fields))
+		for i, field := range fields {
+			escaped[i] = tsvEscaper.Replace(field)
+		}
+		_, err := io.WriteString(w, strings.Join(escaped, "\t")+"\n")
+		return err
+	case TableCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(fields)
+		writer.Flush()
+		return writer.Error()
+	}
+	return fmt.Errorf("unknown table format %q", format)
+}
+
+// ParseTemplate parses the template of Options.Template, e.g.
+// "{{.File}}:{{.Line}} {{.Token}}", executed with a Match. It reports
+// fields and methods that Match does not have.
+func ParseTemplate(text string) (*template.Template, error) {
+	t, err := template.New("format").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(io.Discard, Match{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// printTemplate writes each match of a selected line with the template of
+// opts, each followed by a line terminator.
+func printTemplate(out io.Writer, opts Options, lineNumber int, byteOffset int64, line string, spans []TokenSpan) error {
+	eol := "\n"
+	if opts.NullData {
+		eol = "\x00"
+	}
+	var b bytes.Buffer
+	for _, match := range matchesOf(opts, lineNumber, byteOffset, line, spans) {
+		if err := opts.Template.Execute(&b, match); err != nil {
+			return err
+		}
+		b.WriteString(eol)
+	}
+	_, err := out.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,128 @@
+package processor
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// DefaultTimestampPattern finds ISO 8601 style timestamps such as
+// "2024-05-01 10:32:07", "2024-05-01T10:32:07.123Z" or "2024-05-01 10:32:07,123+0200".
+const DefaultTimestampPattern = `\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?`
+
+// timestampLayouts are tried in order when no explicit layout is configured.
+// Fractional seconds are accepted by time.Parse without being in the layout.
+var timestampLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// TimeRange restricts matching to log lines whose timestamp is not before
+// After and is before Before. Zero bounds are open. Lines without a
+// timestamp inherit the one of the closest preceding line, so multi-line
+// records such as stack traces stay together.
+type TimeRange struct {
+	After   time.Time
+	Before  time.Time
+	Pattern *regexp.Regexp
+	// Layout is the time.Parse layout of extracted timestamps. When empty,
+	// a list of common layouts is tried.
+	Layout string
+}
+
+// NewTimeRange builds a TimeRange from the --after/--before bounds, an
+// optional timestamp pattern and an optional layout. If pattern contains a
+// capturing group, the first group is used as the timestamp.
+func NewTimeRange(after, before, pattern, layout string) (*TimeRange, error) {
+	if pattern == "" {
+		pattern = DefaultTimestampPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp pattern: %v", err)
+	}
+
+	r := &TimeRange{Pattern: re, Layout: layout}
+	if after != "" {
+		if r.After, err = r.parse(after); err != nil {
+			return nil, fmt.Errorf("invalid --after time %q: %v", after, err)
+		}
+	}
+	if before != "" {
+		if r.Before, err = r.parse(before); err != nil {
+			return nil, fmt.Errorf("invalid --before time %q: %v", before, err)
+		}
+	}
+	if !r.After.IsZero() && !r.Before.IsZero() && !r.After.Before(r.Before) {
+		return nil, fmt.Errorf("--after must be earlier than --before")
+	}
+
+	return r, nil
+}
+
+// parse parses a timestamp with the configured layout, falling back to the
+// common layouts.
+func (r *TimeRange) parse(value string) (time.Time, error) {
+	if r.Layout != "" {
+		if t, err := time.Parse(r.Layout, value); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp format")
+}
+
+// extract returns the timestamp found in line, if any.
+func (r *TimeRange) extract(line string) (time.Time, bool) {
+	match := r.Pattern.FindStringSubmatch(line)
+	if match == nil {
+		return time.Time{}, false
+	}
+	value := match[0]
+	if len(match) > 1 {
+		value = match[1]
+	}
+
+	t, err := r.parse(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// contains reports whether t falls inside the range.
+func (r *TimeRange) contains(t time.Time) bool {
+	if !r.After.IsZero() && t.Before(r.After) {
+		return false
+	}
+	if !r.Before.IsZero() && !t.Before(r.Before) {
+		return false
+	}
+	return true
+}
+
+// timeRangeFilter tracks the current timestamp while reading an input.
+type timeRangeFilter struct {
+	r       *TimeRange
+	current time.Time
+	seen    bool
+}
+
+// inRange reports whether line belongs to the time range. Lines before the
+// first timestamp of the input are outside of any range.
+func (f *timeRangeFilter) inRange(line string) bool {
+	if t, ok := f.r.extract(line); ok {
+		f.current = t
+		f.seen = true
+	}
+	return f.seen && f.r.contains(f.current)
+}
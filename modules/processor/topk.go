@@ -0,0 +1,168 @@
+package processor
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+)
+
+// RankedMatch is a token match along with the line and file it was found
+// in. When lines are ranked, Span is the best match of the line.
+type RankedMatch struct {
+	FileName string
+	Line     Line
+	Span     TokenSpan
+	seq      int // order in which the match was found, to rank ties
+}
+
+// TopMatches keeps the K best scoring token matches seen so far, for
+// exploring a corpus without choosing a threshold first, or with Lines,
+// the K best matching lines. Only K matches are held in memory, however
+// large the input; a K of 0 keeps every match.
+type TopMatches struct {
+	K int
+	// Lines ranks lines by their best match, keeping the similarity
+	// threshold, instead of ranking tokens regardless of it.
+	Lines   bool
+	matches rankedHeap
+	seen    int
+}
+
+// NewTopMatches returns a TopMatches keeping the k best tokens, or the k
+// best lines with lines.
+func NewTopMatches(k int, lines bool) *TopMatches {
+	return &TopMatches{K: k, Lines: lines}
+}
+
+// add records the matches of line, keeping only the best K overall.
+func (t *TopMatches) add(fileName string, line Line) {
+	spans := line.Matches
+	if t.Lines {
+		best := spans[0]
+		for _, span := range spans[1:] {
+			if span.Score > best.Score {
+				best = span
+			}
+		}
+		spans = []TokenSpan{best}
+	}
+	for _, span := range spans {
+		t.seen++
+		if t.K > 0 && len(t.matches) == t.K {
+			if span.Score <= t.matches[0].Span.Score {
+				continue
+			}
+			heap.Pop(&t.matches)
+		}
+		heap.Push(&t.matches, RankedMatch{FileName: fileName, Line: line, Span: span, seq: t.seen})
+	}
+}
+
+// Sorted returns the matches kept, best first and in input order among
+// equal scores, and empties t.
+func (t *TopMatches) Sorted() []RankedMatch {
+	matches := []RankedMatch(t.matches)
+	t.matches = nil
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[j].worse(matches[i])
+	})
+	return matches
+}
+
+// worse reports whether m ranks below other: it scores lower, or the same
+// and was found later.
+func (m RankedMatch) worse(other RankedMatch) bool {
+	if m.Span.Score != other.Span.Score {
+		return m.Span.Score < other.Span.Score
+	}
+	return m.seq > other.seq
+}
+
+// rankedHeap is a min-heap of matches by rank, so that the worst kept
+// match is the first to go.
+type rankedHeap []RankedMatch
+
+func (h rankedHeap) Len() int            { return len(h) }
+func (h rankedHeap) Less(i, j int) bool  { return h[i].worse(h[j]) }
+func (h rankedHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *rankedHeap) Push(x interface{}) { *h = append(*h, x.(RankedMatch)) }
+func (h *rankedHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// CollectTopMatches matches the lines of input against the queries and
+// adds the matches to top. Unless top ranks lines, every token in the model
+// is scored, ignoring the similarity threshold. It returns the number of
+// lines with a match and any error encountered while reading the input.
+//
+// queries: List of query words to search for.
+// w2vModel: The Word2Vec model used for semantic matching.
+// similarityCache: Cache for storing similarity calculations.
+// input: The input to process.
+// top: The best matches so far, across files.
+// opts: Matching options; opts.FileName is recorded with the matches.
+func CollectTopMatches(queries []string, w2vModel model.VectorModel, similarityCache similarity.SimilarityCache,
+	input io.Reader, top *TopMatches, opts Options) (int, error) {
+
+	if !top.Lines {
+		opts.SimilarityThreshold = math.Inf(-1)
+		opts.FrequencyBands = nil
+	}
+	matcher := NewMatcher(queries, w2vModel, opts)
+	for _, concept := range matcher.concepts {
+		concept.cache = similarityCache
+	}
+
+	lines := 0
+	err := matcher.Search(input, func(line Line) bool {
+		lines++
+		top.add(opts.FileName, line)
+		return true
+	})
+	return lines, err
+}
+
+// PrintTopMatches prints ranked matches like ProcessLineByLine prints
+// matching lines. Ranked tokens are highlighted alone in their line, while
+// ranked lines are highlighted in full. It returns the first error writing
+// the output.
+func PrintTopMatches(matches []RankedMatch, lines bool, opts Options) error {
+	for _, match := range matches {
+		opts.FileName = match.FileName
+		out := newOutput(opts)
+		span := match.Span
+		spans := []TokenSpan{span}
+		if lines {
+			spans = match.Line.Matches
+		}
+		switch {
+		case opts.OutputOnlyMatching:
+			for _, span := range spans {
+				token := span.Token
+				if opts.ShowScores == ScoresInline {
+					token += formatScore(span.Score)
+				}
+				out.printLine(token, match.Line.Number, false)
+			}
+		default:
+			if !opts.OutputOnlyLines && (opts.ShowScores == "" || opts.ShowScores == ScoresPrefix) {
+				out.printText(fmt.Sprintf("Similarity: %.4f", span.Score))
+			}
+			line := offsetPrefix(opts, match.Line.Offset, spans[0].Start+1) +
+				highlightSpans(match.Line.Text, spans, opts.highlightStyle(), opts.ShowScores == ScoresInline)
+			out.printLine(line, match.Line.Number, opts.PrintLineNumbers)
+		}
+		if out.err != nil {
+			return out.err
+		}
+	}
+	return nil
+}
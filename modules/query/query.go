@@ -0,0 +1,89 @@
+// Package query parses weighted query expressions, such as "+death +sea -war"
+// or "death:1.0 ocean:0.5", which combine the vectors of several words into
+// one query vector, so that a search can be steered toward some meanings and
+// away from others.
+package query
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Term is a word of an expression with its weight. A negative weight
+// subtracts the vector of the word.
+type Term struct {
+	Word   string
+	Weight float64
+}
+
+// Parse parses an expression of space separated terms. A term is a word
+// optionally preceded by + (weight 1) or - (weight -1), and optionally
+// followed by a colon and a weight, e.g. "ocean:0.5" or "-war:0.3", the
+// weight then being multiplied by the sign. Words without either have
+// weight 1.
+//
+// Parse reports false when query has no + or - prefix and no weight, as it
+// is then a plain word or phrase; a lone + or - is a word, e.g. a dash in a
+// phrase. A weight without a word, e.g. "+:0.5", is an error.
+func Parse(query string) ([]Term, bool, error) {
+	var terms []Term
+	weighted := false
+	for _, field := range strings.Fields(query) {
+		term := Term{Word: field, Weight: 1}
+		if len(field) > 1 && (field[0] == '+' || field[0] == '-') {
+			if field[0] == '-' {
+				term.Weight = -1
+			}
+			term.Word = field[1:]
+			weighted = true
+		}
+		if i := strings.LastIndexByte(term.Word, ':'); i >= 0 {
+			if weight, err := strconv.ParseFloat(term.Word[i+1:], 64); err == nil && !math.IsInf(weight, 0) && !math.IsNaN(weight) {
+				term.Word = term.Word[:i]
+				term.Weight *= weight
+				weighted = true
+			}
+		}
+		if term.Word == "" {
+			return nil, false, fmt.Errorf("invalid query %q: %q has no word", query, field)
+		}
+		terms = append(terms, term)
+	}
+	if !weighted {
+		return nil, false, nil
+	}
+	return terms, true, nil
+}
+
+// Combine returns the sum of the vectors of the terms multiplied by their
+// weights. vector returns nil for the words missing from the model, which
+// are left out. The sum is nil when no word is found or the weights cancel
+// out. Vectors must all have the same length.
+func Combine(terms []Term, vector func(word string) []float32) []float32 {
+	var sum []float64
+	for _, term := range terms {
+		values := vector(term.Word)
+		if values == nil {
+			continue
+		}
+		if sum == nil {
+			sum = make([]float64, len(values))
+		}
+		for i, x := range values {
+			sum[i] += term.Weight * float64(x)
+		}
+	}
+
+	nonZero := false
+	combined := make([]float32, len(sum))
+	for i, x := range sum {
+		combined[i] = float32(x)
+		nonZero = nonZero || x != 0
+	}
+	if !nonZero {
+		return nil
+	}
+	return combined
+}
@@ -0,0 +1,98 @@
+package similarity
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/arunsupe/semantic-grep/modules/float16"
+)
+
+// benchVectors returns pairs of random vectors of a typical size, of each
+// type of vector a model may have.
+func benchVectors() map[string][2]interface{} {
+	const dimensions = 300
+	random := rand.New(rand.NewSource(1))
+	var f32 [2][]float32
+	var i8 [2][]int8
+	for k := range f32 {
+		f32[k] = make([]float32, dimensions)
+		i8[k] = make([]int8, dimensions)
+		for i := 0; i < dimensions; i++ {
+			f32[k][i] = random.Float32()*2 - 1
+			i8[k][i] = int8(random.Intn(255) - 127)
+		}
+	}
+	return map[string][2]interface{}{
+		"float32": {f32[0], f32[1]},
+		"float16": {float16.Vector(f32[0]), float16.Vector(f32[1])},
+		"int8":    {i8[0], i8[1]},
+	}
+}
+
+// BenchmarkSimilarity measures the kernels of every registered scorer, with
+// and without --fast-math, on each type of vector.
+func BenchmarkSimilarity(b *testing.B) {
+	vectors := benchVectors()
+	for _, name := range Names() {
+		scorer, err := NewScorer(name, nil)
+		if err != nil {
+			continue
+		}
+		benchmarkScorer(b, name, scorer, vectors)
+		if fast, ok := FastMath(scorer); ok {
+			benchmarkScorer(b, name+"-fast-math", fast, vectors)
+		}
+	}
+}
+
+// benchmarkScorer runs the benchmarks of scorer, named after name, on the
+// pairs of vectors of each type, and with the norms precomputed by models
+// when scorer can use them.
+func benchmarkScorer(b *testing.B, name string, scorer Scorer, vectors map[string][2]interface{}) {
+	for _, vectorType := range []string{"float32", "float16", "int8"} {
+		pair := vectors[vectorType]
+		b.Run(name+"/"+vectorType, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				scorer.Score(pair[0], pair[1])
+			}
+		})
+		if normScorer, ok := scorer.(NormScorer); ok {
+			queryNorm, tokenNorm := Norm(pair[0]), Norm(pair[1])
+			b.Run(name+"-norms/"+vectorType, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					normScorer.ScoreNorms(pair[0], pair[1], queryNorm, tokenNorm)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkCache measures scoring through the similarity cache, as a search
+// does: a hit, for the tokens seen before, and a miss, which scores and
+// evicts an older score from the full cache.
+func BenchmarkCache(b *testing.B) {
+	pair := benchVectors()["float32"]
+	b.Run("hit", func(b *testing.B) {
+		cache := NewSimilarityCache()
+		cache.MemoizedCalculateSimilarity("query", "token", pair[0], pair[1])
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			cache.MemoizedCalculateSimilarity("query", "token", pair[0], pair[1])
+		}
+	})
+	b.Run("miss", func(b *testing.B) {
+		const tokens = 1 << 12
+		names := make([]string, tokens)
+		for i := range names {
+			names[i] = "token" + strconv.Itoa(i)
+		}
+		cache := NewBoundedCache(Cosine{}, tokens/2)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			cache.MemoizedCalculateSimilarity("query", names[i%tokens], pair[0], pair[1])
+		}
+	})
+}
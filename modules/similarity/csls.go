@@ -0,0 +1,152 @@
+package similarity
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/arunsupe/semantic-grep/modules/float16"
+)
+
+// VocabularyScorer is implemented by scorers that compare vectors with the
+// vocabulary of the model, such as CSLS. SetVocabulary must be called with
+// vectors of the model before the scorer is used.
+type VocabularyScorer interface {
+	Scorer
+	// VocabularySize is the number of vectors wanted, those of the most
+	// frequent words.
+	VocabularySize() int
+	SetVocabulary(vectors []interface{})
+}
+
+func init() {
+	Register("csls", func(options json.RawMessage) (Scorer, error) {
+		scorer := &CSLS{K: 10, Vocabulary: 20000}
+		if err := decodeOptions(options, scorer); err != nil {
+			return nil, err
+		}
+		if scorer.K < 1 || scorer.Vocabulary < scorer.K {
+			return nil, fmt.Errorf("k must be positive and vocabulary at least k")
+		}
+		return scorer, nil
+	})
+}
+
+// CSLS scores vectors by cross-domain similarity local scaling, which
+// reduces hubness: in high dimensions, some words, the hubs, have a high
+// cosine with many unrelated words. The score is 2cos(q, t) - r(q) - r(t),
+// where r(v) is the mean cosine of v with its K nearest neighbors among the
+// Vocabulary most frequent words, so words close to everything are
+// penalized. Scores are lower than cosines; thresholds around 0.2 to 0.4
+// are typical.
+type CSLS struct {
+	K          int `json:"k"`
+	Vocabulary int `json:"vocabulary"`
+
+	vocabulary []interface{}
+	mu         sync.Mutex
+	// density caches r(v) by the address of the first value of v, as
+	// vectors are shared with the model
+	density map[interface{}]float64
+}
+
+// VocabularySize implements VocabularyScorer.
+func (c *CSLS) VocabularySize() int {
+	return c.Vocabulary
+}
+
+// SetVocabulary implements VocabularyScorer.
+func (c *CSLS) SetVocabulary(vectors []interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.vocabulary = vectors
+	c.density = make(map[interface{}]float64)
+}
+
+// Score implements Scorer.
+func (c *CSLS) Score(queryVector, tokenVector interface{}) float64 {
+	return 2*CalculateSimilarity(queryVector, tokenVector) - c.neighborhood(queryVector) - c.neighborhood(tokenVector)
+}
+
+// neighborhood returns r(v), the mean cosine of v with its K nearest
+// vocabulary vectors of the same type and size, other than v itself.
+func (c *CSLS) neighborhood(v interface{}) float64 {
+	key := vectorKey(v)
+	if key == nil {
+		return 0
+	}
+	c.mu.Lock()
+	r, ok := c.density[key]
+	c.mu.Unlock()
+	if ok {
+		return r
+	}
+
+	var nearest []float64
+	for _, w := range c.vocabulary {
+		if !comparable(v, w) || vectorKey(w) == key {
+			continue
+		}
+		score := CalculateSimilarity(v, w)
+		if len(nearest) == c.K && score <= nearest[c.K-1] {
+			continue
+		}
+		i := sort.Search(len(nearest), func(i int) bool { return nearest[i] < score })
+		if len(nearest) < c.K {
+			nearest = append(nearest, 0)
+		}
+		copy(nearest[i+1:], nearest[i:])
+		nearest[i] = score
+	}
+	r = 0
+	for _, score := range nearest {
+		r += score
+	}
+	if len(nearest) > 0 {
+		r /= float64(len(nearest))
+	}
+
+	c.mu.Lock()
+	if c.density != nil {
+		c.density[key] = r
+	}
+	c.mu.Unlock()
+	return r
+}
+
+// vectorKey identifies a vector by the address of its first value, or nil
+// for an empty or unsupported vector.
+func vectorKey(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []float32:
+		if len(v) > 0 {
+			return &v[0]
+		}
+	case []int8:
+		if len(v) > 0 {
+			return &v[0]
+		}
+	case []float16.Float16:
+		if len(v) > 0 {
+			return &v[0]
+		}
+	}
+	return nil
+}
+
+// comparable reports whether two vectors have the same type and size.
+func comparable(a, b interface{}) bool {
+	switch a := a.(type) {
+	case []float32:
+		b, ok := b.([]float32)
+		return ok && len(a) == len(b)
+	case []int8:
+		b, ok := b.([]int8)
+		return ok && len(a) == len(b)
+	case []float16.Float16:
+		b, ok := b.([]float16.Float16)
+		return ok && len(a) == len(b)
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package similarity
+
+import "math"
+
+// FastMath returns the fast math variant of scorer, and false when it has
+// none. Fast math variants accumulate []float32 vectors in float32 instead
+// of float64, in a single loop with four independent sums that the CPU can
+// compute in parallel. []int8 vectors, whose integer sums are exact, and
+// float16 vectors are scored as before.
+func FastMath(scorer Scorer) (Scorer, bool) {
+	switch s := scorer.(type) {
+	case Cosine:
+		return FastCosine{}, true
+	case Dot:
+		return FastDot{Dot: s}, true
+	}
+	return nil, false
+}
+
+// FastCosine is Cosine with float32 arithmetic. Summing n products of
+// float32 values in k float32 sums is off by at most (n/k + 2) * 2^-24
+// times the sum of the absolute products, which bounds the error of the
+// cosine of vectors of n dimensions: (n/4 + 2) * 2^-24 with precomputed
+// norms (ScoreNorms, 5e-6 for 300 dimensions), and (n + 4) * 2^-24 without,
+// as the norms are summed too (2e-5 for 300 dimensions, 6e-5 for 1000).
+type FastCosine struct{}
+
+// Score implements Scorer.
+func (FastCosine) Score(queryVector, tokenVector interface{}) float64 {
+	qv, ok := queryVector.([]float32)
+	if !ok {
+		return CalculateSimilarity(queryVector, tokenVector)
+	}
+	dot, queryNorm, tokenNorm := dotAndNorms32(qv, tokenVector.([]float32))
+	return float64(dot) / math.Sqrt(float64(queryNorm)*float64(tokenNorm))
+}
+
+// ScoreNorms implements NormScorer.
+func (FastCosine) ScoreNorms(queryVector, tokenVector interface{}, queryNorm, tokenNorm float64) float64 {
+	qv, ok := queryVector.([]float32)
+	if !ok {
+		return dotProduct(queryVector, tokenVector) / (queryNorm * tokenNorm)
+	}
+	return float64(dot32(qv, tokenVector.([]float32))) / (queryNorm * tokenNorm)
+}
+
+// FastDot is Dot with float32 arithmetic. The product is off by at most
+// (n/4 + 2) * 2^-24 times the sum of the absolute products of the values,
+// times Scale.
+type FastDot struct {
+	Dot
+}
+
+// Score implements Scorer.
+func (d FastDot) Score(queryVector, tokenVector interface{}) float64 {
+	qv, ok := queryVector.([]float32)
+	if !ok {
+		return d.Dot.Score(queryVector, tokenVector)
+	}
+	return d.Scale * float64(dot32(qv, tokenVector.([]float32)))
+}
+
+// dot32 returns the dot product of two []float32 vectors of the same length,
+// summed in float32.
+func dot32(a, b []float32) float32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		s0 += a[i] * b[i]
+		s1 += a[i+1] * b[i+1]
+		s2 += a[i+2] * b[i+2]
+		s3 += a[i+3] * b[i+3]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+// dotAndNorms32 returns the dot product of two []float32 vectors of the same
+// length and their squared norms, summed in float32 in one pass.
+func dotAndNorms32(a, b []float32) (dot, normA, normB float32) {
+	b = b[:len(a)]
+	var d0, d1, a0, a1, b0, b1 float32
+	i := 0
+	for ; i+2 <= len(a); i += 2 {
+		d0 += a[i] * b[i]
+		d1 += a[i+1] * b[i+1]
+		a0 += a[i] * a[i]
+		a1 += a[i+1] * a[i+1]
+		b0 += b[i] * b[i]
+		b1 += b[i+1] * b[i+1]
+	}
+	for ; i < len(a); i++ {
+		d0 += a[i] * b[i]
+		a0 += a[i] * a[i]
+		b0 += b[i] * b[i]
+	}
+	return d0 + d1, a0 + a1, b0 + b1
+}
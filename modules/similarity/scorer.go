@@ -0,0 +1,207 @@
+package similarity
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/arunsupe/semantic-grep/modules/float16"
+)
+
+// Scorer scores how similar a token is to a query from their embeddings.
+// Both vectors have the same type, []float32, []int8 or []float16.Float16,
+// and length. Higher
+// scores mean more similar; the similarity threshold is compared with them.
+type Scorer interface {
+	Score(queryVector, tokenVector interface{}) float64
+}
+
+// NormScorer is a Scorer that can use the L2 norms of the vectors, when
+// they are known in advance (see model.Norm), instead of computing them on
+// every call.
+type NormScorer interface {
+	Scorer
+	ScoreNorms(queryVector, tokenVector interface{}, queryNorm, tokenNorm float64) float64
+}
+
+// Factory creates a Scorer from its options, a JSON object decoded into the
+// scorer's configuration struct. Options are empty when not configured.
+type Factory func(options json.RawMessage) (Scorer, error)
+
+// DefaultScorer is the name of the scorer used when none is chosen.
+const DefaultScorer = "cosine"
+
+var factories = map[string]Factory{}
+
+// Register makes a scorer available by name, e.g. for --scorer. It is
+// meant to be called from init functions, and panics if the name is taken.
+func Register(name string, factory Factory) {
+	if _, exists := factories[name]; exists {
+		panic("similarity: scorer registered twice: " + name)
+	}
+	factories[name] = factory
+}
+
+// Names returns the names of the registered scorers, sorted.
+func Names() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewScorer creates the scorer registered under name with the given options.
+func NewScorer(name string, options json.RawMessage) (Scorer, error) {
+	factory, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scorer %q, expected one of %v", name, Names())
+	}
+	scorer, err := factory(options)
+	if err != nil {
+		return nil, fmt.Errorf("scorer %s: %v", name, err)
+	}
+	return scorer, nil
+}
+
+// decodeOptions decodes scorer options into config, rejecting unknown keys.
+func decodeOptions(options json.RawMessage, config interface{}) error {
+	if len(bytes.TrimSpace(options)) == 0 {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(options))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(config)
+}
+
+func init() {
+	Register("cosine", func(options json.RawMessage) (Scorer, error) {
+		var scorer Cosine
+		return scorer, decodeOptions(options, &scorer)
+	})
+	Register("dot", func(options json.RawMessage) (Scorer, error) {
+		scorer := Dot{Scale: 1}
+		return scorer, decodeOptions(options, &scorer)
+	})
+	Register("euclidean", func(options json.RawMessage) (Scorer, error) {
+		scorer := Euclidean{Normalize: true}
+		return scorer, decodeOptions(options, &scorer)
+	})
+}
+
+// Cosine scores vectors by the cosine of their angle, between -1 and 1.
+// It has no options.
+type Cosine struct{}
+
+// Score implements Scorer.
+func (Cosine) Score(queryVector, tokenVector interface{}) float64 {
+	return CalculateSimilarity(queryVector, tokenVector)
+}
+
+// ScoreNorms implements NormScorer: with the norms given, the cosine is a
+// dot product divided by them, a third of the arithmetic of Score.
+func (Cosine) ScoreNorms(queryVector, tokenVector interface{}, queryNorm, tokenNorm float64) float64 {
+	return dotProduct(queryVector, tokenVector) / (queryNorm * tokenNorm)
+}
+
+// Dot scores vectors by their dot product, which unlike the cosine grows
+// with the vector norms, favoring frequent, well-trained words in some
+// models. Scale multiplies the product, to bring it into the range of the
+// threshold; quantized vectors have large products.
+type Dot struct {
+	Scale float64 `json:"scale"`
+}
+
+// Score implements Scorer.
+func (d Dot) Score(queryVector, tokenVector interface{}) float64 {
+	var dot float64
+	switch qv := queryVector.(type) {
+	case []float32:
+		tv := tokenVector.([]float32)
+		for i := range qv {
+			dot += float64(qv[i]) * float64(tv[i])
+		}
+	case []int8:
+		tv := tokenVector.([]int8)
+		var sum int32
+		for i := range qv {
+			sum += int32(qv[i]) * int32(tv[i])
+		}
+		dot = float64(sum)
+	case []float16.Float16:
+		tv := tokenVector.([]float16.Float16)
+		for i := range qv {
+			dot += float64(qv[i].Float32()) * float64(tv[i].Float32())
+		}
+	default:
+		panic("Unsupported vector type")
+	}
+	return d.Scale * dot
+}
+
+// Euclidean scores vectors by their distance d as 1/(1+d), between 0 and 1.
+// With Normalize (the default), vectors are scaled to unit length first, so
+// that the ranking is the same as the cosine's; see WMD for the distance of
+// the vectors as they are.
+type Euclidean struct {
+	Normalize bool `json:"normalize"`
+}
+
+// Score implements Scorer.
+func (e Euclidean) Score(queryVector, tokenVector interface{}) float64 {
+	q, t := float64Vector(queryVector), float64Vector(tokenVector)
+	if e.Normalize {
+		normalize(q)
+		normalize(t)
+	}
+	var sum float64
+	for i := range q {
+		diff := q[i] - t[i]
+		sum += diff * diff
+	}
+	return 1 / (1 + math.Sqrt(sum))
+}
+
+// float64Vector converts an embedding to []float64.
+func float64Vector(vector interface{}) []float64 {
+	switch v := vector.(type) {
+	case []float32:
+		out := make([]float64, len(v))
+		for i, x := range v {
+			out[i] = float64(x)
+		}
+		return out
+	case []int8:
+		out := make([]float64, len(v))
+		for i, x := range v {
+			out[i] = float64(x)
+		}
+		return out
+	case []float16.Float16:
+		out := make([]float64, len(v))
+		for i, x := range v {
+			out[i] = float64(x.Float32())
+		}
+		return out
+	default:
+		panic("Unsupported vector type")
+	}
+}
+
+// normalize scales v to unit length in place.
+func normalize(v []float64) {
+	var sum float64
+	for _, x := range v {
+		sum += x * x
+	}
+	if sum == 0 {
+		return
+	}
+	norm := math.Sqrt(sum)
+	for i := range v {
+		v[i] /= norm
+	}
+}
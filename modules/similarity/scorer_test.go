@@ -0,0 +1,57 @@
+package similarity
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+// TestTextMeasures checks the scores of soft-cosine and wmd between single
+// words, with their default options and with options set.
+func TestTextMeasures(t *testing.T) {
+	query := []float32{1, 0}
+	tests := []struct {
+		scorer  string
+		options string
+		token   []float32
+		want    float64
+	}{
+		{"soft-cosine", "", []float32{0.6, 0.8}, 0.36},
+		{"soft-cosine", "", []float32{-1, 0}, 0},
+		{"soft-cosine", `{"exponent": 1}`, []float32{0.6, 0.8}, 0.6},
+		{"soft-cosine", `{"min_similarity": 0.7}`, []float32{0.6, 0.8}, 0},
+		{"soft-cosine", `{"min_similarity": 0.5}`, []float32{3, 4}, 0.36},
+		{"wmd", "", []float32{1, 0}, 1},
+		{"wmd", "", []float32{4, 4}, 1.0 / 6},
+		{"wmd", `{"normalize": true}`, []float32{2, 0}, 1},
+	}
+	for _, test := range tests {
+		scorer, err := NewScorer(test.scorer, json.RawMessage(test.options))
+		if err != nil {
+			t.Fatalf("%s %s: %v", test.scorer, test.options, err)
+		}
+		if got := scorer.Score(query, test.token); math.Abs(got-test.want) > 1e-6 {
+			t.Errorf("%s %s: score of %v is %v, want %v", test.scorer, test.options, test.token, got, test.want)
+		}
+		if normScorer, ok := scorer.(NormScorer); ok {
+			got := normScorer.ScoreNorms(query, test.token, Norm(query), Norm(test.token))
+			if math.Abs(got-test.want) > 1e-6 {
+				t.Errorf("%s %s: score of %v with norms is %v, want %v", test.scorer, test.options, test.token, got, test.want)
+			}
+		}
+	}
+}
+
+// TestScorerOptionsRejected checks that invalid options of the scorers are
+// errors.
+func TestScorerOptionsRejected(t *testing.T) {
+	for _, test := range []struct{ scorer, options string }{
+		{"soft-cosine", `{"exponent": 0}`},
+		{"soft-cosine", `{"min_similarity": 2}`},
+		{"wmd", `{"scale": 1}`},
+	} {
+		if _, err := NewScorer(test.scorer, json.RawMessage(test.options)); err == nil {
+			t.Errorf("%s %s: no error", test.scorer, test.options)
+		}
+	}
+}
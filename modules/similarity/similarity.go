@@ -1,9 +1,14 @@
 // Package similarity provides functions and types for calculating and caching
-// the similarity between word vectors using cosine similarity.
+// the similarity between word vectors, using cosine similarity or another
+// registered Scorer.
 package similarity
 
 import (
+	"container/list"
 	"math"
+	"sync"
+
+	"github.com/arunsupe/semantic-grep/modules/float16"
 )
 
 // SimilarityCache is an interface for caching and calculating the similarity
@@ -14,39 +19,205 @@ type SimilarityCache interface {
 	MemoizedCalculateSimilarity(queryToken, token string, queryVector, tokenVector interface{}) float64
 }
 
-// Cache implements the SimilarityCache interface and provides a simple in-memory cache.
+// NormCache is a SimilarityCache that can score with the L2 norms of the
+// vectors known in advance, see NormScorer.
+type NormCache interface {
+	SimilarityCache
+	// MemoizedCalculateSimilarityNorms is MemoizedCalculateSimilarity with
+	// the norms of both vectors given.
+	MemoizedCalculateSimilarityNorms(queryToken, token string, queryVector, tokenVector interface{}, queryNorm, tokenNorm float64) float64
+}
+
+// DefaultCacheSize is the number of similarities a Cache holds by default.
+// At about 100 bytes an entry, this bounds a cache to some 100 MB, however
+// many distinct tokens a huge corpus has.
+const DefaultCacheSize = 1 << 20
+
+// Cache implements the SimilarityCache interface with an in-memory cache
+// of the similarities of (query, token) pairs. When full, the least
+// recently used similarity is dropped. It is safe for concurrent use.
 type Cache struct {
-	cache map[string]float64
+	mu         sync.Mutex
+	entries    map[cacheKey]*list.Element
+	recent     *list.List // of *cacheEntry, most recently used first
+	maxEntries int
+	scorer     Scorer
+	// hits and misses count the lookups of the cache, see Stats
+	hits, misses int64
+}
+
+// cacheKey identifies a similarity by its query and token.
+type cacheKey struct {
+	query, token string
 }
 
-// NewSimilarityCache creates a new Cache instance for storing similarity calculations.
+// cacheEntry is a cached similarity.
+type cacheEntry struct {
+	key        cacheKey
+	similarity float64
+}
+
+// NewSimilarityCache creates a new Cache instance for storing cosine similarity calculations.
 func NewSimilarityCache() *Cache {
+	return NewScorerCache(Cosine{})
+}
+
+// NewScorerCache creates a new Cache instance for storing the scores of
+// scorer, holding up to DefaultCacheSize of them.
+func NewScorerCache(scorer Scorer) *Cache {
+	return NewBoundedCache(scorer, DefaultCacheSize)
+}
+
+// NewBoundedCache creates a new Cache instance for storing up to
+// maxEntries scores of scorer.
+func NewBoundedCache(scorer Scorer, maxEntries int) *Cache {
 	return &Cache{
-		cache: make(map[string]float64),
+		entries:    make(map[cacheKey]*list.Element),
+		recent:     list.New(),
+		maxEntries: max(maxEntries, 1),
+		scorer:     scorer,
 	}
 }
 
+// Len returns the number of cached similarities.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.recent.Len()
+}
+
+// Stats returns the number of similarities found in the cache, and of
+// those that were computed, since the cache was created.
+func (c *Cache) Stats() (hits, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
 // MemoizedCalculateSimilarity calculates the similarity between two word vectors
-// and caches the result. It supports both []float32 and []int8 vector types.
+// with the cache's scorer and caches the result. It supports both []float32 and
+// []int8 vector types, and float16 vectors.
 func (c *Cache) MemoizedCalculateSimilarity(queryToken, token string, queryVector, tokenVector interface{}) float64 {
-	key := token
+	return c.memoize(cacheKey{queryToken, token}, func() float64 {
+		return c.scorer.Score(queryVector, tokenVector)
+	})
+}
+
+// MemoizedCalculateSimilarityNorms implements NormCache. Scorers that do not
+// implement NormScorer ignore the norms.
+func (c *Cache) MemoizedCalculateSimilarityNorms(queryToken, token string, queryVector, tokenVector interface{}, queryNorm, tokenNorm float64) float64 {
+	return c.memoize(cacheKey{queryToken, token}, func() float64 {
+		if scorer, ok := c.scorer.(NormScorer); ok {
+			return scorer.ScoreNorms(queryVector, tokenVector, queryNorm, tokenNorm)
+		}
+		return c.scorer.Score(queryVector, tokenVector)
+	})
+}
+
+// memoize returns the similarity cached under key, or computes it with
+// score and caches it. Scoring happens outside of the lock, so goroutines
+// missing the same key at once may both compute it.
+func (c *Cache) memoize(key cacheKey, score func() float64) float64 {
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		c.hits++
+		c.recent.MoveToFront(element)
+		similarity := element.Value.(*cacheEntry).similarity
+		c.mu.Unlock()
+		return similarity
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	similarity := score()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.recent.MoveToFront(element)
+		return similarity
+	}
+	c.entries[key] = c.recent.PushFront(&cacheEntry{key: key, similarity: similarity})
+	if c.recent.Len() > c.maxEntries {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+	return similarity
+}
 
-	if cachedValue, exists := c.cache[key]; exists {
-		return cachedValue
+// Norm returns the L2 norm of a []float32, []int8 or float16 vector, computed like
+// the cosine computes it, so that cosines from precomputed norms are the
+// same.
+func Norm(vector interface{}) float64 {
+	switch v := vector.(type) {
+	case []float32:
+		sum := float64(0)
+		for _, x := range v {
+			sum += float64(x * x)
+		}
+		return math.Sqrt(sum)
+	case []int8:
+		var sum int32
+		for _, x := range v {
+			sum += int32(x) * int32(x)
+		}
+		return math.Sqrt(float64(sum))
+	case []float16.Float16:
+		sum := float64(0)
+		for _, h := range v {
+			x := h.Float32()
+			sum += float64(x * x)
+		}
+		return math.Sqrt(sum)
+	default:
+		panic("Unsupported vector type")
 	}
+}
 
-	var similarity float64
+// dotProduct returns the dot product of two []float32, []int8 or float16 vectors,
+// computed like the cosine computes it.
+func dotProduct(queryVector, tokenVector interface{}) float64 {
 	switch qv := queryVector.(type) {
 	case []float32:
-		similarity = calculateSimilarity32bit(qv, tokenVector.([]float32))
+		tv := tokenVector.([]float32)
+		dot := float64(0)
+		for i := range qv {
+			dot += float64(qv[i] * tv[i])
+		}
+		return dot
 	case []int8:
-		similarity = calculateSimilarity8bit(qv, tokenVector.([]int8))
+		tv := tokenVector.([]int8)
+		var dot int32
+		for i := range qv {
+			dot += int32(qv[i]) * int32(tv[i])
+		}
+		return float64(dot)
+	case []float16.Float16:
+		tv := tokenVector.([]float16.Float16)
+		dot := float64(0)
+		for i := range qv {
+			dot += float64(qv[i].Float32() * tv[i].Float32())
+		}
+		return dot
 	default:
 		panic("Unsupported vector type")
 	}
+}
 
-	c.cache[key] = similarity
-	return similarity
+// CalculateSimilarity calculates the cosine similarity between two word vectors
+// without caching. It supports []float32, []int8 and float16 vectors.
+func CalculateSimilarity(queryVector, tokenVector interface{}) float64 {
+	switch qv := queryVector.(type) {
+	case []float32:
+		return calculateSimilarity32bit(qv, tokenVector.([]float32))
+	case []int8:
+		return calculateSimilarity8bit(qv, tokenVector.([]int8))
+	case []float16.Float16:
+		return calculateSimilarity16bit(qv, tokenVector.([]float16.Float16))
+	default:
+		panic("Unsupported vector type")
+	}
 }
 
 // calculateSimilarity calculates the cosine similarity between two []float32 vectors
@@ -75,3 +246,18 @@ func calculateSimilarity8bit(vec1, vec2 []int8) float64 {
 
 	return float64(dotProduct) / (math.Sqrt(float64(norm1)) * math.Sqrt(float64(norm2)))
 }
+
+// calculateSimilarity16bit calculates the cosine similarity between two
+// float16 vectors, converting their values to float32 on the fly
+func calculateSimilarity16bit(vec1, vec2 []float16.Float16) float64 {
+	dotProduct := float64(0)
+	norm1 := float64(0)
+	norm2 := float64(0)
+	for i := range vec1 {
+		x, y := vec1[i].Float32(), vec2[i].Float32()
+		dotProduct += float64(x * y)
+		norm1 += float64(x * x)
+		norm2 += float64(y * y)
+	}
+	return dotProduct / (math.Sqrt(norm1) * math.Sqrt(norm2))
+}
@@ -0,0 +1,68 @@
+package similarity
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+func init() {
+	Register("soft-cosine", func(options json.RawMessage) (Scorer, error) {
+		scorer := SoftCosine{Exponent: 2}
+		if err := decodeOptions(options, &scorer); err != nil {
+			return nil, err
+		}
+		if scorer.Exponent <= 0 || scorer.MinSimilarity < 0 || scorer.MinSimilarity > 1 {
+			return nil, fmt.Errorf("exponent must be positive and min_similarity between 0 and 1")
+		}
+		return scorer, nil
+	})
+	Register("wmd", func(options json.RawMessage) (Scorer, error) {
+		var scorer WMD
+		return scorer, decodeOptions(options, &scorer)
+	})
+}
+
+// SoftCosine scores vectors by the soft cosine measure (Sidorov et al.,
+// 2014), which compares two texts through the similarities of their words.
+// The similarity of two words is max(0, cos)^Exponent, or 0 below
+// MinSimilarity (Charlet and Damnati, 2017), and the soft cosine of a query
+// word and a token reduces to it. Scores are between 0 and 1; with the
+// default exponent 2, a cosine of 0.7 scores 0.49.
+type SoftCosine struct {
+	Exponent      float64 `json:"exponent"`
+	MinSimilarity float64 `json:"min_similarity"`
+}
+
+// Score implements Scorer.
+func (s SoftCosine) Score(queryVector, tokenVector interface{}) float64 {
+	return s.soften(CalculateSimilarity(queryVector, tokenVector))
+}
+
+// ScoreNorms implements NormScorer.
+func (s SoftCosine) ScoreNorms(queryVector, tokenVector interface{}, queryNorm, tokenNorm float64) float64 {
+	return s.soften(Cosine{}.ScoreNorms(queryVector, tokenVector, queryNorm, tokenNorm))
+}
+
+// soften turns a cosine into the similarity of two words.
+func (s SoftCosine) soften(cosine float64) float64 {
+	if !(cosine > 0) || cosine < s.MinSimilarity {
+		return 0
+	}
+	return math.Pow(cosine, s.Exponent)
+}
+
+// WMD scores vectors by the Word Mover's Distance (Kusner et al., 2015) of
+// the query and the token as 1/(1+d), between 0 and 1. The WMD of two texts
+// is the least total distance their words travel to turn one text into the
+// other; from one word to another, it is the distance d between their
+// vectors. Unlike euclidean, vectors are compared as they are in the model,
+// as the WMD was defined, unless Normalize scales them to unit length.
+type WMD struct {
+	Normalize bool `json:"normalize"`
+}
+
+// Score implements Scorer.
+func (w WMD) Score(queryVector, tokenVector interface{}) float64 {
+	return Euclidean{Normalize: w.Normalize}.Score(queryVector, tokenVector)
+}
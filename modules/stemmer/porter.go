@@ -0,0 +1,262 @@
+package stemmer
+
+// porter is the English stemmer of M.F. Porter, "An algorithm for suffix
+// stripping", Program 14(3), 1980, following the reference C implementation.
+// Only lowercase ASCII words are stemmed; any other word is returned
+// unchanged, so capitalized words keep their case unless lowercased first.
+type porter struct{}
+
+// Stem returns the Porter stem of word.
+func (porter) Stem(word string) string {
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+
+	p := &porterState{b: []byte(word), k: len(word) - 1}
+	p.step1ab()
+	if p.k > 0 {
+		p.step1c()
+		p.step2()
+		p.step3()
+		p.step4()
+		p.step5()
+	}
+	return string(p.b[:p.k+1])
+}
+
+// porterState is a word being stemmed: b[0..k] is the current stem and j
+// marks the end of the stem left by the last suffix matched with ends.
+type porterState struct {
+	b    []byte
+	k, j int
+}
+
+// cons reports whether b[i] is a consonant.
+func (p *porterState) cons(i int) bool {
+	switch p.b[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !p.cons(i-1)
+	}
+	return true
+}
+
+// m measures the number of consonant sequences in b[0..j]: for
+// <c><v> gives 0, <c>vc<v> gives 1, <c>vcvc<v> gives 2, and so on.
+func (p *porterState) m() int {
+	n, i := 0, 0
+	for {
+		if i > p.j {
+			return n
+		}
+		if !p.cons(i) {
+			break
+		}
+		i++
+	}
+	i++
+	for {
+		for {
+			if i > p.j {
+				return n
+			}
+			if p.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+		n++
+		for {
+			if i > p.j {
+				return n
+			}
+			if !p.cons(i) {
+				break
+			}
+			i++
+		}
+		i++
+	}
+}
+
+// vowelInStem reports whether b[0..j] contains a vowel.
+func (p *porterState) vowelInStem() bool {
+	for i := 0; i <= p.j; i++ {
+		if !p.cons(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doubleC reports whether b[j-1..j] is a double consonant.
+func (p *porterState) doubleC(j int) bool {
+	return j >= 1 && p.b[j] == p.b[j-1] && p.cons(j)
+}
+
+// cvc reports whether b[i-2..i] is consonant-vowel-consonant and the second
+// consonant is not w, x or y, as in "hop" but not "snow".
+func (p *porterState) cvc(i int) bool {
+	if i < 2 || !p.cons(i) || p.cons(i-1) || !p.cons(i-2) {
+		return false
+	}
+	switch p.b[i] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+// ends reports whether b[0..k] ends with s, and sets j before the suffix.
+func (p *porterState) ends(s string) bool {
+	length := len(s)
+	if length > p.k+1 || string(p.b[p.k+1-length:p.k+1]) != s {
+		return false
+	}
+	p.j = p.k - length
+	return true
+}
+
+// setTo replaces b[j+1..k] with s.
+func (p *porterState) setTo(s string) {
+	p.b = append(p.b[:p.j+1], s...)
+	p.k = p.j + len(s)
+}
+
+// r replaces the suffix with s when the stem has a non-zero measure.
+func (p *porterState) r(s string) {
+	if p.m() > 0 {
+		p.setTo(s)
+	}
+}
+
+// step1ab removes plurals and -ed or -ing, e.g. caresses -> caress,
+// ponies -> poni, meetings -> meet, hopping -> hop.
+func (p *porterState) step1ab() {
+	if p.b[p.k] == 's' {
+		switch {
+		case p.ends("sses"):
+			p.k -= 2
+		case p.ends("ies"):
+			p.setTo("i")
+		case p.b[p.k-1] != 's':
+			p.k--
+		}
+	}
+
+	if p.ends("eed") {
+		if p.m() > 0 {
+			p.k--
+		}
+	} else if (p.ends("ed") || p.ends("ing")) && p.vowelInStem() {
+		p.k = p.j
+		switch {
+		case p.ends("at"):
+			p.setTo("ate")
+		case p.ends("bl"):
+			p.setTo("ble")
+		case p.ends("iz"):
+			p.setTo("ize")
+		case p.doubleC(p.k):
+			p.k--
+			switch p.b[p.k] {
+			case 'l', 's', 'z':
+				p.k++
+			}
+		default:
+			p.j = p.k
+			if p.m() == 1 && p.cvc(p.k) {
+				p.setTo("e")
+			}
+		}
+	}
+}
+
+// step1c turns a terminal y into i when there is another vowel in the stem.
+func (p *porterState) step1c() {
+	if p.ends("y") && p.vowelInStem() {
+		p.b[p.k] = 'i'
+	}
+}
+
+// replaceFirst applies r to the replacement of the first suffix of rules
+// that b ends with. rules alternates suffixes and their replacements.
+func (p *porterState) replaceFirst(rules []string) {
+	for i := 0; i < len(rules); i += 2 {
+		if p.ends(rules[i]) {
+			p.r(rules[i+1])
+			return
+		}
+	}
+}
+
+// step2Rules map double suffixes to single ones, e.g. -ization -> -ize.
+var step2Rules = []string{
+	"ational", "ate", "tional", "tion", "enci", "ence", "anci", "ance",
+	"izer", "ize", "bli", "ble", "alli", "al", "entli", "ent", "eli", "e",
+	"ousli", "ous", "ization", "ize", "ation", "ate", "ator", "ate",
+	"alism", "al", "iveness", "ive", "fulness", "ful", "ousness", "ous",
+	"aliti", "al", "iviti", "ive", "biliti", "ble", "logi", "log",
+}
+
+func (p *porterState) step2() {
+	if p.k > 0 {
+		p.replaceFirst(step2Rules)
+	}
+}
+
+// step3Rules handle -ic-, -full, -ness etc.
+var step3Rules = []string{
+	"icate", "ic", "ative", "", "alize", "al", "iciti", "ic",
+	"ical", "ic", "ful", "", "ness", "",
+}
+
+func (p *porterState) step3() {
+	p.replaceFirst(step3Rules)
+}
+
+// step4Suffixes are removed in a context where the measure is above 1.
+var step4Suffixes = []string{
+	"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement",
+	"ment", "ent", "ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+}
+
+func (p *porterState) step4() {
+	if p.k == 0 {
+		return
+	}
+	for _, suffix := range step4Suffixes {
+		if !p.ends(suffix) {
+			continue
+		}
+		// -ion is only removed after s or t
+		if suffix == "ion" && (p.j < 0 || (p.b[p.j] != 's' && p.b[p.j] != 't')) {
+			return
+		}
+		if p.m() > 1 {
+			p.k = p.j
+		}
+		return
+	}
+}
+
+// step5 removes a final -e and turns -ll into -l when the measure is above 1.
+func (p *porterState) step5() {
+	p.j = p.k
+	if p.b[p.k] == 'e' {
+		a := p.m()
+		if a > 1 || (a == 1 && !p.cvc(p.k-1)) {
+			p.k--
+		}
+	}
+	if p.b[p.k] == 'l' && p.doubleC(p.k) && p.m() > 1 {
+		p.k--
+	}
+}
@@ -0,0 +1,39 @@
+// Package stemmer reduces inflected words to their stem, so that "running"
+// and "runs" can be looked up as "run" in models that lack the inflected
+// forms.
+package stemmer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Stemmer reduces a word to its stem.
+type Stemmer interface {
+	Stem(word string) string
+}
+
+// stemmers holds the supported languages.
+var stemmers = map[string]Stemmer{
+	"english": porter{},
+}
+
+// New returns the stemmer for language, e.g. "english".
+func New(language string) (Stemmer, error) {
+	s, ok := stemmers[strings.ToLower(language)]
+	if !ok {
+		return nil, fmt.Errorf("no stemmer for language %q, supported: %s", language, strings.Join(Languages(), ", "))
+	}
+	return s, nil
+}
+
+// Languages returns the supported languages in alphabetical order.
+func Languages() []string {
+	languages := make([]string, 0, len(stemmers))
+	for language := range stemmers {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
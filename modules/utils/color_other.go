@@ -0,0 +1,11 @@
+//go:build !windows
+
+package utils
+
+import "os"
+
+// enableVirtualTerminal reports whether the terminal f is attached to
+// interprets ANSI escape codes, which all supported non-Windows terminals do.
+func enableVirtualTerminal(f *os.File) bool {
+	return true
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalizer returns a function applying the Unicode normalization form
+// named by form: "nfc" composes accents ("e" + U+0301 becomes "é"), "nfkc"
+// also folds compatibility variants such as full-width letters ("ｄｅａｔｈ"
+// becomes "death"). It returns nil for "none" or an empty form.
+func Normalizer(form string) (func(string) string, error) {
+	switch strings.ToLower(form) {
+	case "", "none":
+		return nil, nil
+	case "nfc":
+		return norm.NFC.String, nil
+	case "nfkc":
+		return norm.NFKC.String, nil
+	case "nfd":
+		return norm.NFD.String, nil
+	case "nfkd":
+		return norm.NFKD.String, nil
+	}
+	return nil, fmt.Errorf("unknown normalization form %q, expected none, nfc, nfkc, nfd or nfkd", form)
+}
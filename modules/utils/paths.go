@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// windowsEnvVar matches %NAME% references to environment variables.
+var windowsEnvVar = regexp.MustCompile(`%([A-Za-z_][A-Za-z0-9_()]*)%`)
+
+// ExpandPath makes a user supplied path usable on the current platform: a
+// leading ~ is replaced by the home directory, $NAME and ${NAME} (and
+// %NAME% on Windows) by the value of the environment variable, and forward
+// slashes by the platform's separator. Drive letters and UNC paths are
+// left to the path/filepath package.
+func ExpandPath(path string) string {
+	if path == "" || path == "-" {
+		return path
+	}
+
+	if path == "~" || strings.HasPrefix(path, "~/") || strings.HasPrefix(path, `~\`) {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = home + path[1:]
+		}
+	}
+
+	if runtime.GOOS == "windows" {
+		path = windowsEnvVar.ReplaceAllStringFunc(path, func(ref string) string {
+			if value, ok := os.LookupEnv(ref[1 : len(ref)-1]); ok {
+				return value
+			}
+			return ref
+		})
+	}
+	path = os.ExpandEnv(path)
+
+	return filepath.Clean(filepath.FromSlash(path))
+}
+
+// ExpandGlob returns the files matching pattern. Besides the wildcards of
+// filepath.Match, a "**" path element matches any number of directories,
+// e.g. "logs/**/*.log". Shells usually expand wildcards before w2vgrep
+// sees them, but the Windows command prompt does not. A pattern without
+// wildcards, or naming an existing file, is returned as is; so is a pattern
+// matching nothing, which lets opening it report the error.
+func ExpandGlob(pattern string) []string {
+	if !hasMeta(pattern) {
+		return []string{pattern}
+	}
+	if _, err := os.Stat(pattern); err == nil {
+		return []string{pattern}
+	}
+
+	var matches []string
+	if strings.Contains(pattern, "**") {
+		matches = globRecursive(pattern)
+	} else {
+		matches, _ = filepath.Glob(pattern)
+	}
+	if len(matches) == 0 {
+		return []string{pattern}
+	}
+	return matches
+}
+
+// MatchGlob reports whether path, with slash separators, matches pattern,
+// with the wildcards of ExpandGlob, "**" included.
+func MatchGlob(pattern, path string) bool {
+	return matchElements(strings.Split(pattern, "/"), strings.Split(path, "/"))
+}
+
+// hasMeta reports whether pattern contains a wildcard. On Windows, the
+// backslash is a separator, not an escape.
+func hasMeta(pattern string) bool {
+	meta := `*?[`
+	if runtime.GOOS != "windows" {
+		meta = `*?[\`
+	}
+	return strings.ContainsAny(pattern, meta)
+}
+
+// globRecursive walks the directory that precedes the first wildcard of
+// pattern and returns the regular files matching it, in lexical order.
+func globRecursive(pattern string) []string {
+	elements := strings.Split(filepath.ToSlash(filepath.Clean(pattern)), "/")
+
+	rootElements := 0
+	for rootElements < len(elements) && !hasMeta(elements[rootElements]) {
+		rootElements++
+	}
+	root := filepath.FromSlash(strings.Join(elements[:rootElements], "/"))
+	if rootElements == 0 {
+		root = "."
+	} else if root == filepath.VolumeName(root) {
+		// The pattern starts at "/" or at the root of a drive such as "C:"
+		root += string(filepath.Separator)
+	}
+
+	var matches []string
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		if matchElements(elements[rootElements:], strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	sort.Strings(matches)
+	return matches
+}
+
+// matchElements matches path elements against pattern elements, where a
+// "**" pattern element matches zero or more path elements.
+func matchElements(pattern, path []string) bool {
+	if len(pattern) == 0 {
+		return len(path) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if matchElements(pattern[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+		return false
+	}
+	return matchElements(pattern[1:], path[1:])
+}
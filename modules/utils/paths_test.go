@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// forPlatform reports whether a case for goos, "windows", "other" or "" for
+// every platform, runs on the current one.
+func forPlatform(goos string) bool {
+	switch goos {
+	case "windows":
+		return runtime.GOOS == "windows"
+	case "other":
+		return runtime.GOOS != "windows"
+	}
+	return true
+}
+
+func TestExpandPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	t.Setenv("W2VGREP_MODELS", filepath.Join(home, "models"))
+
+	tests := []struct {
+		goos string
+		path string
+		want string
+	}{
+		{"", "", ""},
+		{"", "-", "-"},
+		{"", "~", home},
+		{"", "~/model.bin", filepath.Join(home, "model.bin")},
+		{"", "$W2VGREP_MODELS/model.bin", filepath.Join(home, "models", "model.bin")},
+		{"", "${W2VGREP_MODELS}/model.bin", filepath.Join(home, "models", "model.bin")},
+		{"", "models/./glove/../model.bin", filepath.Join("models", "model.bin")},
+
+		// Drive letters, UNC paths and backslashes
+		{"windows", `C:/Users/me/model.bin`, `C:\Users\me\model.bin`},
+		{"windows", `c:\models\..\model.bin`, `c:\model.bin`},
+		{"windows", `\\server\share\models\model.bin`, `\\server\share\models\model.bin`},
+		{"windows", `//server/share/model.bin`, `\\server\share\model.bin`},
+		{"windows", `models\glove/model.bin`, `models\glove\model.bin`},
+		{"other", `C:/Users/me/model.bin`, `C:/Users/me/model.bin`},
+		{"other", `models\model.bin`, `models\model.bin`},
+
+		// ~\ is the home directory on Windows, where \ is a separator
+		{"windows", `~\models\model.bin`, filepath.Join(home, "models", "model.bin")},
+		{"other", `~\model.bin`, home + `\model.bin`},
+
+		// %NAME% only refers to a variable on Windows
+		{"windows", `%W2VGREP_MODELS%\model.bin`, filepath.Join(home, "models", "model.bin")},
+		{"windows", `%W2VGREP_UNSET%\model.bin`, `%W2VGREP_UNSET%\model.bin`},
+		{"other", `%W2VGREP_MODELS%/model.bin`, `%W2VGREP_MODELS%/model.bin`},
+	}
+	for _, tt := range tests {
+		if !forPlatform(tt.goos) {
+			continue
+		}
+		if got := ExpandPath(tt.path); got != tt.want {
+			t.Errorf("ExpandPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestExpandGlob(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b.txt", "sub/c.log", "sub/deep/d.log", "sub/deep/e.txt", "[x].log"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	join := func(names ...string) []string {
+		var paths []string
+		for _, name := range names {
+			paths = append(paths, filepath.Join(dir, filepath.FromSlash(name)))
+		}
+		return paths
+	}
+
+	tests := []struct {
+		goos    string
+		pattern string
+		want    []string
+	}{
+		{"", filepath.Join(dir, "b.txt"), join("b.txt")},
+		{"", filepath.Join(dir, "*.log"), join("[x].log", "a.log")},
+		{"", filepath.Join(dir, "**", "*.log"), join("[x].log", "a.log", "sub/c.log", "sub/deep/d.log")},
+		{"", filepath.Join(dir, "sub", "**", "*.txt"), join("sub/deep/e.txt")},
+		{"", filepath.Join(dir, "**", "deep", "*"), join("sub/deep/d.log", "sub/deep/e.txt")},
+		// A pattern matching nothing is returned as is, for opening it to
+		// report the error
+		{"", filepath.Join(dir, "*.pdf"), []string{filepath.Join(dir, "*.pdf")}},
+		// So is an existing file named like a pattern
+		{"", filepath.Join(dir, "[x].log"), join("[x].log")},
+		// Backslashes separate the elements of a "**" pattern on Windows
+		{"windows", dir + `\**\*.log`, join("[x].log", "a.log", "sub/c.log", "sub/deep/d.log")},
+	}
+	for _, tt := range tests {
+		if !forPlatform(tt.goos) {
+			continue
+		}
+		if got := ExpandGlob(tt.pattern); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ExpandGlob(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"*.log", "app.log", true},
+		{"*.log", "logs/app.log", false},
+		{"**/*.log", "app.log", true},
+		{"**/*.log", "logs/2024/app.log", true},
+		{"logs/**/app.log", "logs/app.log", true},
+		{"logs/**/app.log", "logs/a/b/app.log", true},
+		{"logs/**/app.log", "other/a/app.log", false},
+		{"logs/**", "logs", true},
+		{"logs/**", "logs/a/b.txt", true},
+		{"C:/logs/**/*.log", "C:/logs/x/app.log", true},
+		{"//server/share/**/*.log", "//server/share/x/app.log", true},
+		{"[ab]?.txt", "b1.txt", true},
+		{"[ab]?.txt", "c1.txt", false},
+	}
+	for _, tt := range tests {
+		if got := MatchGlob(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestHasMeta(t *testing.T) {
+	tests := []struct {
+		goos    string
+		pattern string
+		want    bool
+	}{
+		{"", "model.bin", false},
+		{"", "logs/*.log", true},
+		{"", "logs/**/app.log", true},
+		{"", "app?.log", true},
+		{"", "[ab].log", true},
+		{"", "C:/logs/app.log", false},
+		// The backslash is a separator on Windows, and an escape elsewhere
+		{"windows", `C:\logs\app.log`, false},
+		{"windows", `\\server\share\app.log`, false},
+		{"windows", `C:\logs\*.log`, true},
+		{"other", `logs\*.log`, true},
+		{"other", `app\.log`, true},
+	}
+	for _, tt := range tests {
+		if !forPlatform(tt.goos) {
+			continue
+		}
+		if got := hasMeta(tt.pattern); got != tt.want {
+			t.Errorf("hasMeta(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -2,28 +2,121 @@ package utils
 
 import (
 	"fmt"
+	"math"
+	"os"
+	"strings"
 )
 
-// ColorText colors the given text with the specified color.
-func ColorText(text, color string) string {
-	colors := map[string]string{
-		"red":     "\033[31m",
-		"green":   "\033[32m",
-		"yellow":  "\033[33m",
-		"blue":    "\033[34m",
-		"magenta": "\033[35m",
-		"cyan":    "\033[36m",
-		"reset":   "\033[0m",
+// colorEnabled controls whether ColorText emits ANSI escape codes.
+var colorEnabled = true
+
+// SetColor enables or disables colored output.
+func SetColor(enabled bool) {
+	colorEnabled = enabled
+}
+
+// ShouldColor resolves a --color mode ("auto", "always" or "never") to
+// whether output should be colored. In auto mode, output is colored only
+// when stdout is a terminal that understands ANSI escape codes and the
+// NO_COLOR environment variable is unset or empty (https://no-color.org).
+func ShouldColor(mode string) bool {
+	switch mode {
+	case "always":
+		enableVirtualTerminal(os.Stdout)
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return IsTerminal(os.Stdout) && enableVirtualTerminal(os.Stdout)
+}
+
+// IsTerminal reports whether f is a character device such as a terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// styleCodes maps the words of a style to ANSI SGR parameters. Colors can
+// be prefixed with "bright-" and "on-" (background), e.g. "bold on-yellow".
+var styleCodes = map[string]string{
+	"bold":      "1",
+	"dim":       "2",
+	"italic":    "3",
+	"underline": "4",
+	"blink":     "5",
+	"reverse":   "7",
+}
+
+func init() {
+	colors := []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+	for i, color := range colors {
+		styleCodes[color] = fmt.Sprint(30 + i)
+		styleCodes["bright-"+color] = fmt.Sprint(90 + i)
+		styleCodes["on-"+color] = fmt.Sprint(40 + i)
+		styleCodes["on-bright-"+color] = fmt.Sprint(100 + i)
+	}
+}
+
+// styleWords splits a style such as "bold green" or "bold,underline".
+func styleWords(style string) []string {
+	return strings.FieldsFunc(strings.ToLower(style), func(r rune) bool {
+		return r == ' ' || r == ',' || r == '+'
+	})
+}
+
+// ValidateStyle returns an error if style contains an unknown word.
+func ValidateStyle(style string) error {
+	words := styleWords(style)
+	if len(words) == 0 {
+		return fmt.Errorf("empty style")
 	}
-	return colors[color] + text + colors["reset"]
+	for _, word := range words {
+		if _, ok := styleCodes[word]; !ok {
+			return fmt.Errorf("unknown style %q", word)
+		}
+	}
+	return nil
 }
 
-// PrintLine prints a line with an optional line number.
-func PrintLine(line string, lineNumber int, printLineNumbers bool) {
-	if printLineNumbers {
-		lineNumberStr := ColorText(fmt.Sprintf("%d:", lineNumber), "blue")
-		fmt.Printf("%s %s\n", lineNumberStr, line)
-	} else {
-		fmt.Println(line)
+// ColorText colors the given text with the specified style, unless colored
+// output is disabled. A style is a color name such as "red", or a list of
+// colors and attributes such as "bold green" or "underline".
+func ColorText(text, style string) string {
+	if !colorEnabled {
+		return text
+	}
+
+	var codes []string
+	for _, word := range styleWords(style) {
+		if code, ok := styleCodes[word]; ok {
+			codes = append(codes, code)
+		}
 	}
+	return "\033[" + strings.Join(codes, ";") + "m" + text + "\033[0m"
+}
+
+// heatColors are xterm 256-color codes from cold (blue) to hot (red),
+// through cyan, green and yellow.
+var heatColors = []int{21, 27, 33, 39, 45, 51, 49, 47, 46, 82, 118, 154, 190, 226, 220, 214, 208, 202, 196}
+
+// HeatText colors text on a gradient from blue for a heat of 0 or less to
+// red for a heat of 1, unless colored output is disabled.
+func HeatText(text string, heat float64) string {
+	if !colorEnabled {
+		return text
+	}
+	heat = max(0, min(heat, 1))
+	code := heatColors[int(math.Round(heat*float64(len(heatColors)-1)))]
+	return fmt.Sprintf("\033[38;5;%dm%s\033[0m", code, text)
+}
+
+// ColorEnabled reports whether colored output is enabled, see SetColor.
+func ColorEnabled() bool {
+	return colorEnabled
 }
@@ -0,0 +1,110 @@
+// Package walker lists the files searched by w2vgrep -r: the regular files
+// under directories, skipping hidden directories such as ".git", filtered
+// by glob patterns as by the --include, --exclude and --exclude-dir options
+// of grep and ripgrep.
+package walker
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/utils"
+)
+
+// Filter selects files and directories by glob patterns. A pattern without
+// a slash matches the base name of a file or directory, e.g. "*.md" or
+// "node_modules"; a pattern with one matches its path relative to the
+// directory searched, with "**" matching any number of directories, e.g.
+// "docs/**/*.md". The zero Filter selects everything.
+type Filter struct {
+	// Include lists the patterns of the files searched, if not empty
+	Include []string
+	// Exclude lists the patterns of the files skipped
+	Exclude []string
+	// ExcludeDirs lists the patterns of the directories skipped
+	ExcludeDirs []string
+}
+
+// Validate reports the first malformed pattern of f.
+func (f Filter) Validate() error {
+	for _, patterns := range [][]string{f.Include, f.Exclude, f.ExcludeDirs} {
+		for _, pattern := range patterns {
+			for _, element := range strings.Split(filepath.ToSlash(pattern), "/") {
+				if _, err := filepath.Match(element, ""); err != nil {
+					return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// file reports whether the file of relative path rel is selected.
+func (f Filter) file(rel string) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, rel) {
+		return false
+	}
+	return !matchAny(f.Exclude, rel)
+}
+
+// matchAny reports whether the relative path rel, with slash separators,
+// matches one of patterns.
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := filepath.Match(pattern, filepath.Base(rel)); ok {
+				return true
+			}
+		} else if utils.MatchGlob(strings.TrimPrefix(pattern, "./"), rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk returns the regular files under path selected by filter, in lexical
+// order, along with the errors met, e.g. on directories that cannot be
+// read, which are skipped too. Hidden directories and those matching
+// filter.ExcludeDirs are not entered. A path that is not a directory is
+// returned as is, which lets opening it report any error, unless filter
+// skips its name.
+func Walk(path string, filter Filter) ([]string, []error) {
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		if !filter.file(filepath.Base(path)) {
+			return nil, nil
+		}
+		return []string{path}, nil
+	}
+
+	var files []string
+	var errs []error
+	filepath.WalkDir(path, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if name == path {
+			return nil
+		}
+		rel, err := filepath.Rel(path, name)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), ".") || matchAny(filter.ExcludeDirs, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() && filter.file(rel) {
+			files = append(files, name)
+		}
+		return nil
+	})
+	return files, errs
+}
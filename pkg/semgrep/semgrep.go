@@ -0,0 +1,151 @@
+/*
+Package semgrep searches text for words that are semantically similar to a
+query, like the w2vgrep command, for use from other Go programs.
+
+Load a model once and search as many inputs as needed:
+
+	m, err := semgrep.LoadModel("models/glove/glove.6B.300d.bin")
+	if err != nil {
+		log.Fatal(err)
+	}
+	matches, err := m.Search(file, "death", semgrep.Options{Threshold: semgrep.DefaultThreshold})
+	for _, match := range matches {
+		fmt.Println(match.LineNumber, match.Line)
+	}
+
+A Model is immutable, so Search may be called from several goroutines.
+*/
+package semgrep
+
+import (
+	"io"
+	"sync"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/processor"
+	"github.com/arunsupe/semantic-grep/modules/stemmer"
+)
+
+// DefaultThreshold is the similarity threshold of w2vgrep.
+const DefaultThreshold = 0.7
+
+// Model is a word embedding model.
+type Model struct {
+	vectors model.VectorModel
+	// folded is vectors with the vocabulary folded to lowercase, built by
+	// the first search with IgnoreCase
+	foldOnce sync.Once
+	folded   model.VectorModel
+}
+
+// LoadModel loads a model in a binary format of w2vgrep (32-bit, half
+// precision or 8-bit), detected from the content of the file.
+func LoadModel(path string) (*Model, error) {
+	vectors, err := model.LoadVectorModel(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Model{vectors: vectors}, nil
+}
+
+// NewModel wraps a model loaded by the modules of semantic-grep, e.g. a
+// model.ScriptRouter combining models of several languages.
+func NewModel(vectors model.VectorModel) *Model {
+	return &Model{vectors: vectors}
+}
+
+// Options controls how lines are matched. The zero value matches every
+// token with a positive similarity; set Threshold, e.g. to DefaultThreshold.
+type Options struct {
+	// Threshold is the similarity above which a token matches.
+	Threshold float64
+	// IgnoreCase looks up the query and tokens in lowercase, in the
+	// vocabulary folded to lowercase like w2vgrep -i.
+	IgnoreCase bool
+	// Stem, e.g. "english", looks up the stem of words first and lets
+	// inflections of the query match as the query itself.
+	Stem string
+	// Segmenter is "words" (the default when empty) or "cjk", to join
+	// Chinese and Japanese characters into words of the model.
+	Segmenter string
+	// OnlySemantic ignores the query word itself, so that only similar
+	// words match.
+	OnlySemantic bool
+	// ExcludeExact skips lines that contain the query word itself.
+	ExcludeExact bool
+	// MaxCount stops the search after this many matching lines (0 means no limit).
+	MaxCount int
+}
+
+// Match is a line that contains tokens similar to the query.
+type Match struct {
+	// LineNumber is the 1-based number of the line in the input.
+	LineNumber int
+	// ByteOffset is the offset of the line in the input.
+	ByteOffset int64
+	// Line is the text of the line, without line terminator.
+	Line   string
+	Tokens []Token
+}
+
+// Token is a word of a line that is similar to the query.
+type Token struct {
+	Text string
+	// Query is the query the token is similar to.
+	Query string
+	// Score is the similarity of the token to the query; the query word
+	// itself scores 1.
+	Score float64
+	// Start and End are the byte offsets of the token in the line.
+	Start, End int
+}
+
+// Search returns the lines of r that contain a token similar to query, in
+// order. A query of several words that is not in the model is matched by
+// the centroid of its words. It returns an error when the query is not in
+// the model at all, or when reading fails.
+func (m *Model) Search(r io.Reader, query string, opts Options) ([]Match, error) {
+	procOpts := processor.Options{
+		SimilarityThreshold: opts.Threshold,
+		IgnoreCase:          opts.IgnoreCase,
+		Segmenter:           opts.Segmenter,
+		OnlySemantic:        opts.OnlySemantic,
+		ExcludeExact:        opts.ExcludeExact,
+		MaxCount:            opts.MaxCount,
+		Warnings:            io.Discard,
+	}
+	if opts.Stem != "" {
+		s, err := stemmer.New(opts.Stem)
+		if err != nil {
+			return nil, err
+		}
+		procOpts.Stemmer = s
+	}
+
+	vectors := m.vectors
+	if opts.IgnoreCase {
+		m.foldOnce.Do(func() { m.folded = model.CaseFolded(m.vectors, false) })
+		vectors = m.folded
+	}
+	matcher := processor.NewMatcher([]string{query}, vectors, procOpts)
+	if err := matcher.Err(); err != nil {
+		return nil, err
+	}
+
+	var matches []Match
+	err := matcher.Search(r, func(line processor.Line) bool {
+		match := Match{LineNumber: line.Number, ByteOffset: line.Offset, Line: line.Text}
+		for _, span := range line.Matches {
+			match.Tokens = append(match.Tokens, Token{
+				Text:  span.Token,
+				Query: span.Query,
+				Score: span.Score,
+				Start: span.Start,
+				End:   span.End,
+			})
+		}
+		matches = append(matches, match)
+		return true
+	})
+	return matches, err
+}
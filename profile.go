@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// profiler writes the CPU and heap profiles of a search, asked for with the
+// hidden --cpuprofile and --memprofile options, for go tool pprof. As main
+// exits with os.Exit, which runs no deferred call, it exits through exit to
+// write them.
+type profiler struct {
+	cpu     *os.File
+	memPath string
+}
+
+// profiling is the profiler of the search, if any.
+var profiling *profiler
+
+// startProfiling starts profiling the CPU into cpuPath, if set, and records
+// memPath to write the heap profile to when stopped.
+func startProfiling(cpuPath, memPath string) (*profiler, error) {
+	p := &profiler{memPath: memPath}
+	if cpuPath != "" {
+		f, err := os.Create(cpuPath)
+		if err != nil {
+			return nil, fmt.Errorf("creating CPU profile: %v", err)
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("starting CPU profile: %v", err)
+		}
+		p.cpu = f
+	}
+	return p, nil
+}
+
+// stop stops the CPU profile and writes the heap profile. Stopping again,
+// or a nil profiler, does nothing.
+func (p *profiler) stop() {
+	if p == nil {
+		return
+	}
+	if p.cpu != nil {
+		pprof.StopCPUProfile()
+		if err := p.cpu.Close(); err != nil {
+			warnf("writing CPU profile: %v", err)
+		}
+		p.cpu = nil
+	}
+	if p.memPath != "" {
+		if err := writeHeapProfile(p.memPath); err != nil {
+			warnf("writing memory profile: %v", err)
+		}
+		p.memPath = ""
+	}
+}
+
+// writeHeapProfile writes the profile of the memory in use to path.
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// Collect garbage first, so that the profile shows the live memory
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// exit writes the output buffered for standard output, stops profiling,
+// if started, and exits with status code.
+func exit(code int) {
+	if stdout != nil {
+		stdout.Flush()
+	}
+	profiling.stop()
+	os.Exit(code)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+	"github.com/arunsupe/semantic-grep/modules/utils"
+)
+
+const (
+	// progressInterval is how often --progress redraws its line
+	progressInterval = 200 * time.Millisecond
+	// progressBarWidth is the number of characters of the progress bar
+	progressBarWidth = 30
+)
+
+// scanMeter counts the lines and bytes searched, for --stats, and with
+// --progress draws the progress of the file being searched on standard
+// error: a bar and percentage for files of known size, the lines read and
+// the lines read per second. Files may be searched concurrently.
+type scanMeter struct {
+	progress bool
+	eol      byte
+	start    time.Time
+
+	mu sync.Mutex
+	// Totals of the files searched, and of those answered by the result cache
+	files, cachedFiles int
+	lines, bytes       int64
+	lastDraw           time.Time
+	drawn              int // length of the line drawn, to erase it
+}
+
+// newScanMeter returns a meter drawing the progress when progress is set
+// and standard error is a terminal. Lines end with eol.
+func newScanMeter(progress bool, eol byte) *scanMeter {
+	return &scanMeter{progress: progress && utils.IsTerminal(os.Stderr), eol: eol, start: time.Now()}
+}
+
+// begin starts the search of file, named name, and returns reader, its
+// text, counted as it is read.
+func (m *scanMeter) begin(file *os.File, name string, reader io.Reader) io.Reader {
+	m.mu.Lock()
+	m.files++
+	m.mu.Unlock()
+	r := &meteredReader{r: reader, meter: m, file: file, name: name, start: time.Now()}
+	if info, err := file.Stat(); err == nil && info.Mode().IsRegular() {
+		r.size = info.Size()
+	}
+	return r
+}
+
+// cached counts a file answered by the result cache.
+func (m *scanMeter) cached() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files++
+	m.cachedFiles++
+}
+
+// meteredReader counts the lines and bytes read through it, of file, named
+// name, and of size bytes when known.
+type meteredReader struct {
+	r     io.Reader
+	meter *scanMeter
+	file  *os.File
+	name  string
+	size  int64
+	start time.Time
+	lines int64
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	m := r.meter
+	lines := int64(bytes.Count(p[:n], []byte{m.eol}))
+	r.lines += lines
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytes += int64(n)
+	m.lines += lines
+	if m.progress && time.Since(m.lastDraw) >= progressInterval {
+		m.draw(r)
+	}
+	return n, err
+}
+
+// draw redraws the progress line of the file read by r.
+func (m *scanMeter) draw(r *meteredReader) {
+	m.lastDraw = time.Now()
+	var b strings.Builder
+	b.WriteString("\r")
+	// The offset of the file tells how much of it was read, even when it is
+	// converted to text before being searched
+	if offset, err := r.file.Seek(0, io.SeekCurrent); err == nil && r.size > 0 {
+		done := min(float64(offset)/float64(r.size), 1)
+		filled := int(done * progressBarWidth)
+		fmt.Fprintf(&b, "[%s%s] %3.0f%%  ", strings.Repeat("#", filled), strings.Repeat(".", progressBarWidth-filled), done*100)
+	}
+	fmt.Fprintf(&b, "%d lines, %.0f lines/s  %s", r.lines, float64(r.lines)/time.Since(r.start).Seconds(), r.name)
+	line := b.String()
+	// Pad with spaces over the end of a longer line drawn before
+	if len(line) < m.drawn {
+		line += strings.Repeat(" ", m.drawn-len(line))
+	}
+	m.drawn = len(line)
+	os.Stderr.WriteString(line)
+}
+
+// erase erases the progress line, if drawn.
+func (m *scanMeter) erase() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.drawn > 0 {
+		os.Stderr.WriteString("\r" + strings.Repeat(" ", m.drawn-1) + "\r")
+		m.drawn = 0
+	}
+}
+
+// output returns w, erasing the progress line before each write, so that
+// it does not get mixed with the results printed on the same terminal. It
+// is redrawn on the next read.
+func (m *scanMeter) output(w io.Writer) io.Writer {
+	if !m.progress {
+		return w
+	}
+	return writerFunc(func(p []byte) (int, error) {
+		m.erase()
+		return w.Write(p)
+	})
+}
+
+// writerFunc is an io.Writer calling a function.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// printStats logs the totals of the search at the normal level: the files,
+// lines and bytes searched, the tokens scored against the queries, per
+// second, and how many of their scores came from the similarity cache, and
+// the wall time.
+func (m *scanMeter) printStats(similarityCache similarity.SimilarityCache) {
+	elapsed := time.Since(m.start)
+	files := fmt.Sprintf("%d file(s) searched", m.files)
+	if m.cachedFiles > 0 {
+		files += fmt.Sprintf(", %d answered from the result cache", m.cachedFiles)
+	}
+	infof("%s", files)
+	infof("%d line(s), %.1f MB scanned", m.lines, float64(m.bytes)/(1<<20))
+	if cache, ok := similarityCache.(interface{ Stats() (int64, int64) }); ok {
+		hits, misses := cache.Stats()
+		if scored := hits + misses; scored > 0 {
+			infof("%d token(s) scored, %.0f tokens/s, %.1f%% from the similarity cache",
+				scored, float64(scored)/elapsed.Seconds(), 100*float64(hits)/float64(scored))
+		} else {
+			infof("0 tokens scored")
+		}
+	}
+	infof("%v wall time, %.0f lines/s", elapsed.Round(time.Millisecond), float64(m.lines)/elapsed.Seconds())
+}
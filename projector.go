@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+)
+
+// projectorCommand implements "w2vgrep --model projector". It writes the
+// vectors.tsv and metadata.tsv files loaded by the TensorFlow Embedding
+// Projector (https://projector.tensorflow.org), so the neighborhood of a
+// query, or the members of clusters, can be inspected visually.
+type projectorCommand struct {
+	ModelPath  string   `short:"m" long:"model_path" description:"Path to the Word2Vec model file"`
+	Queries    []string `short:"q" long:"query" description:"Export this word and its neighbors above the threshold (repeatable)"`
+	Threshold  float64  `short:"t" long:"threshold" default:"0.7" description:"Similarity threshold for query neighbors"`
+	Top        int      `long:"top" description:"Export at most this many neighbors per query (0 means no limit)"`
+	WordsFile  string   `long:"words" description:"File of words to export: one per line, or one cluster of '|' separated words per line as written by cluster.go"`
+	OutputDir  string   `short:"o" long:"output-dir" default:"." description:"Directory to write vectors.tsv and metadata.tsv to"`
+	IgnoreCase bool     `short:"i" long:"ignore-case" description:"Look up queries in lowercase"`
+}
+
+// projectorPoint is a word to export with the group it belongs to.
+type projectorPoint struct {
+	word       string
+	group      string
+	similarity string
+}
+
+// Execute writes the projector files.
+func (c *projectorCommand) Execute(args []string) error {
+	if len(c.Queries) == 0 && c.WordsFile == "" {
+		return fmt.Errorf("nothing to export: use --query or --words")
+	}
+
+	w2vModel, err := loadModel(c.ModelPath)
+	if err != nil {
+		return err
+	}
+
+	var points []projectorPoint
+	for _, query := range c.Queries {
+		if c.IgnoreCase {
+			query = strings.ToLower(query)
+		}
+		neighbors, err := model.Neighbors(w2vModel, query, c.Threshold)
+		if err != nil {
+			warnf("%v", err)
+			continue
+		}
+		if c.Top > 0 && len(neighbors) > c.Top {
+			neighbors = neighbors[:c.Top]
+		}
+
+		points = append(points, projectorPoint{word: query, group: query, similarity: "1.0000"})
+		for _, neighbor := range neighbors {
+			points = append(points, projectorPoint{
+				word:       neighbor.Word,
+				group:      query,
+				similarity: strconv.FormatFloat(neighbor.Similarity, 'f', 4, 64),
+			})
+		}
+	}
+
+	if c.WordsFile != "" {
+		filePoints, err := readProjectorWords(c.WordsFile)
+		if err != nil {
+			return err
+		}
+		points = append(points, filePoints...)
+	}
+
+	return writeProjectorFiles(c.OutputDir, points, w2vModel)
+}
+
+// readProjectorWords reads a word list or a cluster file. Each line is a
+// group; its words are separated by '|'.
+func readProjectorWords(fileName string) ([]projectorPoint, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var points []projectorPoint
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024) // cluster lines can be very long
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		group := fmt.Sprintf("%s:%d", filepath.Base(fileName), lineNumber)
+		for _, word := range strings.Split(scanner.Text(), "|") {
+			word = strings.TrimSpace(word)
+			if word != "" {
+				points = append(points, projectorPoint{word: word, group: group})
+			}
+		}
+	}
+	return points, scanner.Err()
+}
+
+// writeProjectorFiles writes the vectors of points to vectors.tsv and their
+// labels to metadata.tsv in dir. Words missing from the model are skipped.
+func writeProjectorFiles(dir string, points []projectorPoint, w2vModel model.VectorModel) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	vectorsFile, err := os.Create(filepath.Join(dir, "vectors.tsv"))
+	if err != nil {
+		return err
+	}
+	defer vectorsFile.Close()
+	metadataFile, err := os.Create(filepath.Join(dir, "metadata.tsv"))
+	if err != nil {
+		return err
+	}
+	defer metadataFile.Close()
+
+	vectors := bufio.NewWriter(vectorsFile)
+	metadata := bufio.NewWriter(metadataFile)
+	fmt.Fprintln(metadata, "word\tgroup\tsimilarity")
+
+	written := 0
+	for _, point := range points {
+		embedding, err := w2vModel.GetEmbedding(point.word)
+		if err != nil {
+			warnf("%v: %s", err, point.word)
+			continue
+		}
+
+		vector := model.Float32Vector(embedding)
+		fields := make([]string, len(vector))
+		for i, x := range vector {
+			fields[i] = strconv.FormatFloat(float64(x), 'g', -1, 32)
+		}
+		fmt.Fprintln(vectors, strings.Join(fields, "\t"))
+		fmt.Fprintf(metadata, "%s\t%s\t%s\n", tsvField(point.word), tsvField(point.group), point.similarity)
+		written++
+	}
+
+	if err := vectors.Flush(); err != nil {
+		return err
+	}
+	if err := metadata.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Wrote %d words to %s and %s\n", written,
+		filepath.Join(dir, "vectors.tsv"), filepath.Join(dir, "metadata.tsv"))
+	return nil
+}
+
+// tsvField replaces the characters that would break a TSV row.
+func tsvField(s string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(s)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/config"
+	"github.com/arunsupe/semantic-grep/modules/utils"
+)
+
+// resultCacheVersion is part of every key, so that a change of the output
+// format can discard the results cached by older versions.
+const resultCacheVersion = 1
+
+// maxCachedOutput is the largest output of a file that is cached. Larger
+// outputs are cheap to recompute compared to the space they would take.
+const maxCachedOutput = 16 << 20
+
+// resultCache stores the output and selected line count of each search of
+// a file, keyed by the content of the file and by everything else that
+// decides the output: options, queries, configuration and model files. A
+// search repeated over unchanged files is answered from the cache without
+// loading the model.
+type resultCache struct {
+	dir      string
+	settings []byte // hash of the search settings
+	// readOnly caches answer searches from existing entries but store none
+	readOnly bool
+}
+
+// modelStamp identifies a model file by its path, size and time of last
+// modification, which is much cheaper than hashing gigabytes of vectors.
+type modelStamp struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+}
+
+// cacheRoot returns the directory of the result cache and of downloaded
+// models: dir when set, e.g. by --cache-dir, or semantic-grep in the user
+// cache directory. Nothing else is written by searches, so a writable dir
+// is all w2vgrep needs when the model and the home directory are read-only.
+func cacheRoot(dir string) (string, error) {
+	if dir != "" {
+		return utils.ExpandPath(dir), nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, "semantic-grep"), nil
+}
+
+// newResultCache returns the result cache in root for searches with
+// settings, a value that is encoded as JSON into the key, and the model
+// files at modelPaths.
+func newResultCache(root string, settings interface{}, modelPaths []string) (*resultCache, error) {
+	var stamps []modelStamp
+	for _, path := range modelPaths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		absolute, _ := filepath.Abs(path)
+		stamps = append(stamps, modelStamp{Path: absolute, Size: info.Size(), ModTime: info.ModTime().UnixNano()})
+	}
+
+	encoded, err := json.Marshal(struct {
+		Version  int          `json:"version"`
+		Settings interface{}  `json:"settings"`
+		Models   []modelStamp `json:"models"`
+	}{resultCacheVersion, settings, stamps})
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(encoded)
+	return &resultCache{dir: filepath.Join(root, "results"), settings: hash[:]}, nil
+}
+
+// searchResultCache returns the result cache of a search with opts for
+// queries and regexes, using the configuration conf and the model at
+// modelPath (or the configured one when empty), along with any script
+// models. With --read-only, the cache stores no entries.
+func searchResultCache(opts Options, queries []string, regexes []*regexp.Regexp, conf *config.Config, modelPath string) (*resultCache, error) {
+	root, err := cacheRoot(opts.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	if modelPath == "" {
+		modelPath = conf.ModelPath
+	} else {
+		modelPath = utils.ExpandPath(modelPath)
+	}
+	if modelPath == "" {
+		return nil, errNoModelPath
+	}
+	modelPaths := []string{modelPath}
+	for _, spec := range opts.ScriptModels {
+		if _, path, found := strings.Cut(spec, ":"); found {
+			modelPaths = append(modelPaths, utils.ExpandPath(path))
+		}
+	}
+
+	var expressions []string
+	for _, regex := range regexes {
+		expressions = append(expressions, regex.String())
+	}
+	// Whether and where to use the cache does not change the results
+	readOnly := opts.ReadOnly
+	opts.Cache, opts.NoCache, opts.CacheDir, opts.ReadOnly = false, false, "", false
+	settings := *conf
+	settings.Cache, settings.CacheDir, settings.ReadOnly = nil, "", nil
+	cache, err := newResultCache(root, struct {
+		Options Options        `json:"options"`
+		Queries []string       `json:"queries"`
+		Regexes []string       `json:"regexes"`
+		Config  *config.Config `json:"config"`
+		Color   bool           `json:"color"`
+	}{opts, queries, expressions, &settings, utils.ColorEnabled()}, modelPaths)
+	if err != nil {
+		return nil, err
+	}
+	cache.readOnly = readOnly
+	return cache, nil
+}
+
+// key returns the key of the search of input, whose name is printed as
+// fileName (empty when no name is printed). input is read to the end.
+func (c *resultCache) key(input io.Reader, fileName string) (string, error) {
+	hash := sha256.New()
+	hash.Write(c.settings)
+	fmt.Fprintf(hash, "%q\n", fileName)
+	if _, err := io.Copy(hash, input); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// isRegular reports whether file is a regular file, which key can read to
+// the end and be read again from the start.
+func isRegular(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode().IsRegular()
+}
+
+// path returns the file of the cache entry of key.
+func (c *resultCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// load returns the selected line count and the output cached under key,
+// and false when there is no such entry.
+func (c *resultCache) load(key string) (int, []byte, bool) {
+	entry, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return 0, nil, false
+	}
+	header, output, found := bytes.Cut(entry, []byte("\n"))
+	if !found {
+		return 0, nil, false
+	}
+	count, err := strconv.Atoi(string(header))
+	if err != nil {
+		return 0, nil, false
+	}
+	return count, output, true
+}
+
+// store caches the selected line count and output of a search under key.
+// The entry is written to a temporary file first, so that a concurrent
+// search never reads half an entry. Failures are ignored: the cache only
+// saves time.
+func (c *resultCache) store(key string, count int, output []byte) {
+	if c.readOnly || len(output) > maxCachedOutput {
+		return
+	}
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = fmt.Fprintf(tmp, "%d\n%s", count, output)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || os.Rename(tmp.Name(), path) != nil {
+		os.Remove(tmp.Name())
+	}
+}
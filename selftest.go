@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+)
+
+// fixtures is a small corpus in several scripts, with emoji, numbers and
+// dates, CRLF line endings and a missing final newline, and a 4-dimensional
+// model in text format whose words cover it in English, Chinese, Arabic and
+// emoji.
+//
+//go:embed fixtures
+var fixtures embed.FS
+
+// selftestCommand implements "w2vgrep --selftest". It searches the fixtures
+// with this w2vgrep binary, as a user would, and compares the output and
+// exit status with the expected ones, so that tokenization and matching can
+// be checked on any platform without downloading a model.
+type selftestCommand struct {
+	Run     string `long:"run" description:"Run only the cases whose name matches this regular expression"`
+	Verbose bool   `short:"v" long:"verbose" description:"Print the command line of every case"`
+	Keep    bool   `long:"keep" description:"Keep the directory holding the fixtures and the model, and print its path"`
+}
+
+// selftestCase is a search of the fixtures and its expected result. The
+// arguments follow "w2vgrep --color never", in the fixtures directory.
+type selftestCase struct {
+	name   string
+	args   []string
+	want   string
+	status int
+}
+
+// longLine is the content of long.txt, written next to the fixtures: a line
+// of over 2 MiB, beyond the default buffer sizes, ending with a match.
+var longLine = strings.Repeat("lorem ipsum ", 200000) + "death\n"
+
+var selftestCases = []selftestCase{
+	{"english/lines", []string{"-n", "-t", "0.85", "death", "english.txt"},
+		"Similarity: 0.9939\n2: Nobody knew how the soldier was killed.\n--\nSimilarity: 1.0000\n4: Her death was mourned by the whole village.\n", exitMatch},
+	{"english/byte-offsets", []string{"-o", "-b", "-t", "0.85", "death", "english.txt"},
+		"74:killed\n121:death\n", exitMatch},
+	{"english/no-match", []string{"-t", "0.85", "cat", "numbers.txt"},
+		"", exitNoMatch},
+	{"chinese/cjk-segmenter", []string{"-n", "-t", "0.85", "--segmenter", "cjk", "死亡", "chinese.txt"},
+		"Similarity: 1.0000\n3: 战争带来了死亡和痛苦。\n", exitMatch},
+	{"chinese/compound", []string{"-n", "-o", "-t", "0.85", "--segmenter", "cjk", "海", "chinese.txt"},
+		"海洋\n", exitMatch},
+	{"arabic/prefixed", []string{"-n", "-t", "0.85", "موت", "arabic.txt"},
+		"Similarity: 0.9995\n3: لا أحد يخاف الموت.\n", exitMatch},
+	{"arabic/column", []string{"-n", "--column", "-t", "0.85", "بحر", "arabic.txt"},
+		"Similarity: 0.9998\n1: 26:نظر الرجل إلى البحر طويلا.\n", exitMatch},
+	{"emoji/single", []string{"-o", "-t", "0.85", "sea", "emoji.txt"},
+		"🌊\n", exitMatch},
+	{"emoji/zwj-sequence", []string{"-o", "-t", "0.85", "cat", "emoji.txt"},
+		"🐈\u200d⬛\n", exitMatch},
+	{"numbers/dates", []string{"-n", "--column", "-i", "-t", "0.85", "death", "numbers.txt"},
+		"Similarity: 0.9998\n2: 30:Version 2.0 was released; no deaths reported.\nSimilarity: 0.9986\n3: 34:On 05/01/2024 at 10:30 the fever died down.\n", exitMatch},
+	{"crlf/line-endings", []string{"-n", "-t", "0.85", "cat", "crlf.txt"},
+		"Similarity: 1.0000\n2: The cat came back\n", exitMatch},
+	{"crlf/no-final-newline", []string{"-n", "-t", "0.85", "sea", "crlf.txt"},
+		"Similarity: 1.0000\n3: Last line has no sea terminator\n", exitMatch},
+	{"long/count", []string{"-c", "-t", "0.85", "death", "long.txt"},
+		"1\n", exitMatch},
+	{"long/max-line-length", []string{"--max-line-length", "1", "-t", "0.85", "death", "long.txt"},
+		"", exitError},
+}
+
+// Execute writes the fixtures to a temporary directory, runs the cases and
+// prints one result line per case.
+func (c *selftestCommand) Execute(args []string) error {
+	filter, err := regexp.Compile(c.Run)
+	if err != nil {
+		return fmt.Errorf("invalid --run: %v", err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "w2vgrep-selftest-")
+	if err != nil {
+		return err
+	}
+	if c.Keep {
+		fmt.Fprintf(os.Stderr, "Fixtures in %s\n", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+	if err := writeFixtures(dir); err != nil {
+		return err
+	}
+
+	passed, failed := 0, 0
+	for _, tc := range selftestCases {
+		if !filter.MatchString(tc.name) {
+			continue
+		}
+		args := append([]string{"--color", "never"}, tc.args...)
+		if c.Verbose {
+			fmt.Printf("=== %s: w2vgrep %s\n", tc.name, strings.Join(args, " "))
+		}
+
+		cmd := exec.Command(executable, args...)
+		cmd.Dir = dir
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		status := exitMatch
+		if err := cmd.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				return err
+			}
+			status = exitErr.ExitCode()
+		}
+
+		if stdout.String() == tc.want && status == tc.status {
+			passed++
+			fmt.Printf("ok    %s\n", tc.name)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL  %s\n", tc.name)
+		if status != tc.status {
+			fmt.Printf("      exit status %d, expected %d\n", status, tc.status)
+		}
+		if stdout.String() != tc.want {
+			fmt.Printf("      got:\n%s      expected:\n%s", indent(stdout.String()), indent(tc.want))
+		}
+		if stderr.Len() > 0 {
+			fmt.Printf("      stderr:\n%s", indent(stderr.String()))
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d cases failed", failed, passed+failed)
+	}
+	return nil
+}
+
+// writeFixtures writes the fixtures to dir, with the model converted to
+// model.bin, long.txt, and a config.json that selects the model, so that
+// the user's configuration does not change the results.
+func writeFixtures(dir string) error {
+	entries, err := fixtures.ReadDir("fixtures")
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		content, err := fixtures.ReadFile("fixtures/" + entry.Name())
+		if err != nil {
+			return err
+		}
+		if entry.Name() == "model.txt" {
+			if err := model.ConvertText(bytes.NewReader(content), filepath.Join(dir, "model.bin")); err != nil {
+				return fmt.Errorf("converting the fixture model: %v", err)
+			}
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, entry.Name()), content, 0644); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "long.txt"), []byte(longLine), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"model_path": "model.bin"}`+"\n"), 0644)
+}
+
+// indent indents the lines of text for the failure report.
+func indent(text string) string {
+	if text == "" {
+		return "        (nothing)\n"
+	}
+	return "        " + strings.ReplaceAll(strings.TrimSuffix(text, "\n"), "\n", "\n        ") + "\n"
+}
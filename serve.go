@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/processor"
+	"github.com/arunsupe/semantic-grep/modules/query"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+	"github.com/arunsupe/semantic-grep/modules/stemmer"
+)
+
+// serveCommand implements "w2vgrep --serve". It loads the model once and
+// answers searches, neighbor lookups and embedding requests over HTTP, so
+// that many queries, possibly from programs in other languages, do not each
+// pay for loading the model.
+type serveCommand struct {
+	ModelPath string  `short:"m" long:"model_path" description:"Path to the Word2Vec model file"`
+	Listen    string  `short:"l" long:"listen" default:"localhost:8080" description:"Address to listen on, host:port"`
+	Threshold float64 `short:"t" long:"threshold" default:"0.7" description:"Similarity threshold of requests that do not set one"`
+}
+
+// server holds the model shared by all requests, and the vectors named
+// with /let.
+type server struct {
+	model     model.VectorModel
+	threshold float64
+
+	// mu guards the named vectors and the similarity caches. The caches
+	// hold the similarities of queries by name, so they are replaced when a
+	// vector is named; the maps of vectors are replaced, not modified, as
+	// requests in progress use them.
+	mu      sync.Mutex
+	vectors map[string]interface{}
+	cache   similarity.SimilarityCache
+
+	// folded is model with the vocabulary folded to lowercase, built by the
+	// first request with ignore_case, and cached with its own similarities
+	// and the named vectors folded to lowercase too
+	folded        model.VectorModel
+	foldedVectors map[string]interface{}
+	foldedCache   similarity.SimilarityCache
+}
+
+// Execute loads the model and serves requests until interrupted.
+func (c *serveCommand) Execute(args []string) error {
+	w2vModel, err := loadModel(c.ModelPath)
+	if err != nil {
+		return err
+	}
+	s := &server{model: w2vModel, cache: similarity.NewSimilarityCache(), threshold: c.Threshold}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", s.search)
+	mux.HandleFunc("/similar", s.similar)
+	mux.HandleFunc("/embedding", s.embedding)
+	mux.HandleFunc("/let", s.let)
+	httpServer := &http.Server{Addr: c.Listen, Handler: mux}
+
+	// Requests in progress are finished on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdown)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Serving %d words on http://%s\n", len(w2vModel.Words()), c.Listen)
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// search implements POST /search: the request body is searched for the q
+// parameters, and the selected lines are written as JSON Lines, in the
+// format of w2vgrep --json, as they are found.
+func (s *server) search(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("POST the text to search"))
+		return
+	}
+	params := r.URL.Query()
+	queries := params["q"]
+	if len(queries) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("the q parameter is required"))
+		return
+	}
+	opts := processor.Options{
+		JSON:         true,
+		IgnoreCase:   boolParam(params, "ignore_case"),
+		OnlySemantic: boolParam(params, "only_semantic"),
+		ExcludeExact: boolParam(params, "exclude_exact"),
+		Segmenter:    params.Get("segmenter"),
+		Output:       w,
+		Warnings:     io.Discard,
+		Done:         r.Context().Done(),
+	}
+	var err error
+	if opts.SimilarityThreshold, err = s.thresholdParam(params.Get("threshold")); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if value := params.Get("max_count"); value != "" {
+		if opts.MaxCount, err = strconv.Atoi(value); err != nil || opts.MaxCount < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_count %q", value))
+			return
+		}
+	}
+	if opts.Segmenter != "" && opts.Segmenter != processor.SegmenterWords && opts.Segmenter != processor.SegmenterCJK {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid segmenter %q: must be words or cjk", opts.Segmenter))
+		return
+	}
+	if language := params.Get("stem"); language != "" {
+		if opts.Stemmer, err = stemmer.New(language); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	w2vModel, cache := s.session(opts.IgnoreCase)
+	// Queries missing from the model are reported rather than matched
+	// literally, which a client would not notice
+	if err := processor.NewMatcher(queries, w2vModel, opts).Err(); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	// The response has started, so an error can only end it early
+	processor.ProcessLineByLine(queries, w2vModel, cache, r.Body, opts)
+}
+
+// similar implements GET /similar: the words of the model most similar to
+// the q parameter, above the threshold, at most n of them.
+func (s *server) similar(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := params.Get("q")
+	if query == "" {
+		writeError(w, http.StatusBadRequest, errors.New("the q parameter is required"))
+		return
+	}
+	threshold, err := s.thresholdParam(params.Get("threshold"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	n := 10
+	if value := params.Get("n"); value != "" {
+		if n, err = strconv.Atoi(value); err != nil || n < 1 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid n %q", value))
+			return
+		}
+	}
+
+	w2vModel, _ := s.session(false)
+	neighbors, err := model.Neighbors(w2vModel, query, threshold)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if len(neighbors) > n {
+		neighbors = neighbors[:n]
+	}
+	type neighbor struct {
+		Word       string  `json:"word"`
+		Similarity float64 `json:"similarity"`
+	}
+	response := struct {
+		Query     string     `json:"query"`
+		Neighbors []neighbor `json:"neighbors"`
+	}{Query: query, Neighbors: []neighbor{}}
+	for _, nb := range neighbors {
+		response.Neighbors = append(response.Neighbors, neighbor{Word: nb.Word, Similarity: nb.Similarity})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// embedding implements GET /embedding: the vector of the word parameter,
+// as float32 values whatever the format of the model.
+func (s *server) embedding(w http.ResponseWriter, r *http.Request) {
+	word := r.URL.Query().Get("word")
+	if word == "" {
+		writeError(w, http.StatusBadRequest, errors.New("the word parameter is required"))
+		return
+	}
+	w2vModel, _ := s.session(false)
+	vector, err := w2vModel.GetEmbedding(word)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s", err, word))
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Word   string    `json:"word"`
+		Vector []float32 `json:"vector"`
+	}{word, model.Float32Vector(vector)})
+}
+
+// let implements POST /let: the request body, such as "let royalty = king
+// + queen", names the sum of the vectors of the words, which later requests
+// take as a word of the model, e.g. /search?q=royalty or
+// /similar?q=royalty, without combining the words again. The expression is
+// that of weighted queries, where + and - may also stand apart, and may use
+// vectors named before. Naming a vector again replaces it.
+func (s *server) let(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("POST the definition, e.g. let royalty = king + queen"))
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDefinitionSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	name, terms, err := parseDefinition(string(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	w2vModel, _ := s.session(false)
+	var like interface{}
+	var missing error
+	vector := query.Combine(terms, func(word string) []float32 {
+		vector, err := w2vModel.GetEmbedding(word)
+		if err != nil {
+			missing = fmt.Errorf("%w: %s", err, word)
+			return nil
+		}
+		like = vector
+		return model.Float32Vector(vector)
+	})
+	if missing != nil {
+		writeError(w, http.StatusNotFound, missing)
+		return
+	}
+	if vector == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("the terms of %s cancel out", name))
+		return
+	}
+	s.define(name, model.VectorLike(vector, like))
+
+	type term struct {
+		Word   string  `json:"word"`
+		Weight float64 `json:"weight"`
+	}
+	response := struct {
+		Name  string `json:"name"`
+		Terms []term `json:"terms"`
+	}{Name: name}
+	for _, t := range terms {
+		response.Terms = append(response.Terms, term{Word: t.Word, Weight: t.Weight})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// maxDefinitionSize is the longest body of a /let request.
+const maxDefinitionSize = 64 << 10
+
+// parseDefinition parses "let NAME = EXPRESSION", "let" being optional,
+// into the name and the terms of the expression: a weighted query, e.g.
+// "+king +queen -man", in which + and - may also stand apart, as in "king
+// + queen - man", and a word without either is added.
+func parseDefinition(definition string) (string, []query.Term, error) {
+	definition = strings.TrimSpace(definition)
+	if rest, found := strings.CutPrefix(definition, "let "); found {
+		definition = rest
+	}
+	name, expression, found := strings.Cut(definition, "=")
+	name = strings.TrimSpace(name)
+	if !found || name == "" || strings.ContainsFunc(name, unicode.IsSpace) || strings.ContainsAny(name, "+-:") {
+		return "", nil, fmt.Errorf("invalid definition %q: expected let NAME = EXPRESSION, NAME being a single word", definition)
+	}
+
+	var fields []string
+	sign := "+"
+	for _, field := range strings.Fields(expression) {
+		switch {
+		case field == "+" || field == "-":
+			sign = field
+			continue
+		case field[0] != '+' && field[0] != '-':
+			field = sign + field
+		}
+		fields = append(fields, field)
+		sign = "+"
+	}
+	if len(fields) == 0 {
+		return "", nil, fmt.Errorf("invalid definition %q: no words", definition)
+	}
+	terms, _, err := query.Parse(strings.Join(fields, " "))
+	if err != nil {
+		return "", nil, err
+	}
+	return name, terms, nil
+}
+
+// define names vector, replacing the vectors and the similarity caches.
+func (s *server) define(name string, vector interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vectors = withVector(s.vectors, name, vector)
+	s.cache = similarity.NewSimilarityCache()
+	if s.folded != nil {
+		s.foldedVectors = withVector(s.foldedVectors, strings.ToLower(name), vector)
+		s.foldedCache = similarity.NewSimilarityCache()
+	}
+}
+
+// withVector returns a copy of vectors with name set to vector.
+func withVector(vectors map[string]interface{}, name string, vector interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(vectors)+1)
+	for n, v := range vectors {
+		copied[n] = v
+	}
+	copied[name] = vector
+	return copied
+}
+
+// session returns the model, extended with the named vectors, and its
+// similarity cache. With ignoreCase, the model has its vocabulary folded to
+// lowercase, and is built on first use.
+func (s *server) session(ignoreCase bool) (model.VectorModel, similarity.SimilarityCache) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w2vModel, vectors, cache := s.model, s.vectors, s.cache
+	if ignoreCase {
+		if s.folded == nil {
+			s.folded = model.CaseFolded(s.model, false)
+			s.foldedCache = similarity.NewSimilarityCache()
+			for name, vector := range s.vectors {
+				s.foldedVectors = withVector(s.foldedVectors, strings.ToLower(name), vector)
+			}
+		}
+		w2vModel, vectors, cache = s.folded, s.foldedVectors, s.foldedCache
+	}
+	if len(vectors) > 0 {
+		w2vModel = model.WithVectors(w2vModel, vectors)
+	}
+	return w2vModel, cache
+}
+
+// thresholdParam parses a threshold parameter, the server's threshold when
+// empty.
+func (s *server) thresholdParam(value string) (float64, error) {
+	if value == "" {
+		return s.threshold, nil
+	}
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid threshold %q", value)
+	}
+	return threshold, nil
+}
+
+// boolParam reports whether the flag parameter name is set: present with
+// no value, or with a true value such as 1 or true.
+func boolParam(params url.Values, name string) bool {
+	if !params.Has(name) {
+		return false
+	}
+	value := params.Get(name)
+	set, _ := strconv.ParseBool(value)
+	return set || value == ""
+}
+
+// writeJSON writes v as the JSON response with the status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as a JSON error response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// interruption stops a search cleanly on SIGINT or SIGTERM: the line being
+// processed is finished, so output is never cut mid-line, and w2vgrep can
+// report what it found so far. A second signal terminates immediately.
+type interruption struct {
+	done   chan struct{}
+	mu     sync.Mutex
+	signal os.Signal
+}
+
+// catchInterrupts starts watching for termination signals.
+func catchInterrupts() *interruption {
+	i := &interruption{done: make(chan struct{})}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		// Restore the default behavior, so that a second signal kills w2vgrep
+		signal.Stop(signals)
+
+		i.mu.Lock()
+		i.signal = sig
+		i.mu.Unlock()
+		close(i.done)
+	}()
+
+	return i
+}
+
+// caught returns the signal received, or nil.
+func (i *interruption) caught() os.Signal {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.signal
+}
+
+// exitStatus returns the conventional exit status of a process stopped by
+// the caught signal, 128 plus the signal number.
+func (i *interruption) exitStatus() int {
+	if sig, ok := i.caught().(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return exitError
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/similarity"
+	"github.com/arunsupe/semantic-grep/modules/utils"
+
+	"github.com/clipperhouse/uax29/words"
+)
+
+// similarCommand implements "w2vgrep --similar". It embeds the example
+// document and every corpus file as the centroid of their words, and ranks
+// the files by the cosine similarity of their centroid to the example's.
+type similarCommand struct {
+	ModelPath  string `short:"m" long:"model_path" description:"Path to the Word2Vec model file"`
+	Doc        string `long:"doc" required:"true" description:"Example document to find similar files to"`
+	Top        int    `long:"top" default:"10" description:"Print at most this many files (0 means no limit)"`
+	IDF        bool   `long:"idf" description:"Weigh words by their inverse document frequency in the corpus, so that common words count less"`
+	IgnoreCase bool   `short:"i" long:"ignore-case" description:"Look up words in lowercase"`
+	Args       struct {
+		Paths []string `positional-arg-name:"PATH" description:"Files, or directories searched recursively"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// documentWords counts the occurrences of each word of a document.
+type documentWords map[string]int
+
+// scoredFile is a corpus file with its similarity to the example document.
+type scoredFile struct {
+	path  string
+	score float64
+}
+
+// Execute ranks the corpus files.
+func (c *similarCommand) Execute(args []string) error {
+	if c.Top < 0 {
+		return fmt.Errorf("--top must not be negative")
+	}
+
+	w2vModel, err := loadModel(c.ModelPath)
+	if err != nil {
+		return err
+	}
+
+	example, err := c.readWords(utils.ExpandPath(c.Doc))
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for _, arg := range c.Args.Paths {
+		for _, root := range utils.ExpandGlob(arg) {
+			err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if entry.Type().IsRegular() {
+					paths = append(paths, path)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	corpus := make(map[string]documentWords, len(paths))
+	for _, path := range paths {
+		counts, err := c.readWords(path)
+		if err != nil {
+			warnf("%v", err)
+			continue
+		}
+		corpus[path] = counts
+	}
+
+	var weight func(word string) float64
+	if c.IDF {
+		weight = inverseDocumentFrequency(corpus)
+	}
+
+	exampleVector := embedDocument(w2vModel, example, weight)
+	if exampleVector == nil {
+		return fmt.Errorf("none of the words of %s is in the model", c.Doc)
+	}
+
+	var results []scoredFile
+	for path, counts := range corpus {
+		vector := embedDocument(w2vModel, counts, weight)
+		if vector == nil {
+			continue
+		}
+		score := similarity.CalculateSimilarity(exampleVector, vector)
+		if !math.IsNaN(score) {
+			results = append(results, scoredFile{path, score})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].path < results[j].path
+	})
+	if c.Top > 0 && len(results) > c.Top {
+		results = results[:c.Top]
+	}
+	for _, result := range results {
+		fmt.Printf("%.4f\t%s\n", result.score, result.path)
+	}
+	return nil
+}
+
+// readWords counts the words of a file.
+func (c *similarCommand) readWords(path string) (documentWords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	counts := documentWords{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		tokens := words.NewSegmenter(scanner.Bytes())
+		for tokens.Next() {
+			word := tokens.Text()
+			if !strings.ContainsFunc(word, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) {
+				continue
+			}
+			if c.IgnoreCase {
+				word = strings.ToLower(word)
+			}
+			counts[word]++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %v", path, err)
+	}
+	return counts, nil
+}
+
+// embedDocument returns the centroid of the words of a document, each word
+// counted as often as it occurs and weighted by weight when not nil.
+func embedDocument(m model.VectorModel, counts documentWords, weight func(word string) float64) interface{} {
+	var vectors []interface{}
+	var weights []float64
+	for word, count := range counts {
+		vector, err := m.GetEmbedding(word)
+		if err != nil || model.Float32Vector(vector) == nil {
+			continue
+		}
+		w := float64(count)
+		if weight != nil {
+			w *= weight(word)
+		}
+		vectors = append(vectors, vector)
+		weights = append(weights, w)
+	}
+	return model.Mean(vectors, weights)
+}
+
+// inverseDocumentFrequency returns the smoothed inverse document frequency
+// of words in the corpus, log((1+N)/(1+df)) + 1.
+func inverseDocumentFrequency(corpus map[string]documentWords) func(word string) float64 {
+	frequency := map[string]int{}
+	for _, counts := range corpus {
+		for word := range counts {
+			frequency[word]++
+		}
+	}
+	n := float64(len(corpus))
+	return func(word string) float64 {
+		return math.Log((1+n)/(1+float64(frequency[word]))) + 1
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/arunsupe/semantic-grep/modules/utils"
+)
+
+// stdout is the standard output of a search, see stdoutWriter. It is nil
+// for commands.
+var stdout *stdoutWriter
+
+// stdoutWriter is the standard output of a search. It is buffered, which
+// saves a write per line on large outputs, and flushed at the end of each
+// line when lines are wanted as soon as they are found: with
+// --line-buffered, or when standard output is a terminal. Once the reader
+// of a pipe is gone, as head is once it has its lines, it reports it with
+// closed, so that the search stops quietly. It is safe for concurrent use.
+type stdoutWriter struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	eol byte
+	// lineBuffered flushes the lines as they are printed
+	lineBuffered bool
+	// err is the first write error
+	err error
+}
+
+// newStdout returns the standard output of a search, flushed at the end of
+// each line, terminated by eol, with lineBuffered. SIGPIPE is ignored, so
+// that writing to a pipe whose reader is gone fails with EPIPE instead of
+// killing the process.
+func newStdout(lineBuffered bool, eol byte) *stdoutWriter {
+	signal.Ignore(syscall.SIGPIPE)
+	return &stdoutWriter{
+		w:            bufio.NewWriter(os.Stdout),
+		eol:          eol,
+		lineBuffered: lineBuffered || utils.IsTerminal(os.Stdout),
+	}
+}
+
+// Write implements io.Writer. Once a write failed, nothing more is written.
+func (s *stdoutWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.w.Write(p)
+	if err == nil && s.lineBuffered && bytes.IndexByte(p, s.eol) >= 0 {
+		err = s.w.Flush()
+	}
+	s.err = err
+	return n, err
+}
+
+// Flush writes the buffered output.
+func (s *stdoutWriter) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = s.w.Flush()
+	}
+	return s.err
+}
+
+// closed reports whether the reader of the pipe of standard output is gone.
+func (s *stdoutWriter) closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return errors.Is(s.err, syscall.EPIPE)
+}
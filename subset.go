@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/arunsupe/semantic-grep/modules/model"
+)
+
+// modelSubsetCommand implements "w2vgrep --model subset". It extracts the
+// words of a pattern file and their semantic neighborhoods into a small
+// model, which loads in a fraction of the time of the full model and finds
+// the same matches for those patterns, as long as the search threshold is
+// not below the radius.
+type modelSubsetCommand struct {
+	ModelPath  string  `short:"m" long:"model_path" description:"Path to the Word2Vec model file"`
+	WordsFile  string  `long:"words" required:"true" description:"Pattern file of the words to keep: one per line, or one cluster of '|' separated words per line"`
+	Radius     float64 `long:"radius" default:"0.6" description:"Keep the words whose similarity to a listed word is above this threshold"`
+	Top        int     `long:"top" default:"0" description:"Keep at most this many neighbors per listed word (0 means no limit)"`
+	Output     string  `short:"o" long:"output" required:"true" description:"Binary model file to write (.bin)"`
+	IgnoreCase bool    `short:"i" long:"ignore-case" description:"Look up listed words in lowercase"`
+}
+
+// Execute writes the subset model.
+func (c *modelSubsetCommand) Execute(args []string) error {
+	if c.Top < 0 {
+		return fmt.Errorf("--top must not be negative")
+	}
+	if strings.HasSuffix(c.Output, ".8int.bin") || !strings.HasSuffix(c.Output, ".bin") {
+		return fmt.Errorf("%s: the subset is written as a 32-bit model, so its name must end in .bin", c.Output)
+	}
+
+	points, err := readProjectorWords(c.WordsFile)
+	if err != nil {
+		return err
+	}
+
+	w2vModel, err := loadModel(c.ModelPath)
+	if err != nil {
+		return err
+	}
+
+	var words []string
+	kept := make(map[string]bool)
+	keep := func(word string) {
+		if !kept[word] {
+			kept[word] = true
+			words = append(words, word)
+		}
+	}
+
+	missing := 0
+	for _, point := range points {
+		word := point.word
+		if c.IgnoreCase {
+			word = strings.ToLower(word)
+		}
+		neighbors, err := model.Neighbors(w2vModel, word, c.Radius)
+		if err != nil {
+			warnf("%v", err)
+			missing++
+			continue
+		}
+		keep(word)
+		if c.Top > 0 && len(neighbors) > c.Top {
+			neighbors = neighbors[:c.Top]
+		}
+		for _, neighbor := range neighbors {
+			keep(neighbor.Word)
+		}
+	}
+	if len(words) == 0 {
+		return fmt.Errorf("none of the words of %s is in the model", c.WordsFile)
+	}
+
+	if err := model.WriteBinary(c.Output, w2vModel, words); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d words for %d listed words (%d not in the model) to %s\n",
+		len(words), len(points), missing, c.Output)
+	return nil
+}
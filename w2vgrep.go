@@ -5,54 +5,399 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/arunsupe/semantic-grep/modules/config"
 	"github.com/arunsupe/semantic-grep/modules/model"
+	"github.com/arunsupe/semantic-grep/modules/pipeline"
 	"github.com/arunsupe/semantic-grep/modules/processor"
+	"github.com/arunsupe/semantic-grep/modules/query"
 	"github.com/arunsupe/semantic-grep/modules/similarity"
+	"github.com/arunsupe/semantic-grep/modules/stemmer"
+	"github.com/arunsupe/semantic-grep/modules/utils"
+	"github.com/arunsupe/semantic-grep/modules/walker"
 
 	"github.com/jessevdk/go-flags"
 )
 
 // Options defines the command-line options for the semantic-grep tool.
 type Options struct {
-	ModelPath           string  `short:"m" long:"model_path" description:"Path to the Word2Vec model file"`
-	SimilarityThreshold float64 `short:"t" long:"threshold" default:"0.7" description:"Similarity threshold for matching"`
-	ContextBefore       int     `short:"A" long:"before-context" description:"Number of lines before matching line"`
-	ContextAfter        int     `short:"B" long:"after-context" description:"Number of lines after matching line"`
-	ContextBoth         int     `short:"C" long:"context" description:"Number of lines before and after matching line"`
-	PrintLineNumbers    bool    `short:"n" long:"line-number" description:"Print line numbers"`
-	IgnoreCase          bool    `short:"i" long:"ignore-case" description:"Ignore case. Note: word2vec is case-sensitive. Ignoring case may lead to unexpected results"`
-	OutputOnlyMatching  bool    `short:"o" long:"only-matching" description:"Output only matching words"`
-	OutputOnlyLines     bool    `short:"l" long:"only-lines" description:"Output only matched lines without similarity scores"`
-	PatternFile         string  `short:"f" long:"file" description:"File with patterns to match"`
+	ModelPath           string   `short:"m" long:"model_path" description:"Path to the Word2Vec model file"`
+	SimilarityThreshold float64  `short:"t" long:"threshold" default:"0.7" description:"Similarity threshold for matching"`
+	ContextBefore       int      `short:"A" long:"before-context" description:"Number of lines before matching line"`
+	ContextAfter        int      `short:"B" long:"after-context" description:"Number of lines after matching line"`
+	ContextBoth         int      `short:"C" long:"context" description:"Number of lines before and after matching line"`
+	PrintLineNumbers    bool     `short:"n" long:"line-number" description:"Print line numbers"`
+	IgnoreCase          bool     `short:"i" long:"ignore-case" description:"Ignore case: look up queries and tokens in lowercase in the vocabulary folded to lowercase (see --case-fold)"`
+	CaseFold            string   `long:"case-fold" default:"frequent" choice:"frequent" choice:"mean" description:"With -i, vector of a word the model has in several casings: that of the most frequent casing, or the mean of them all"`
+	OutputOnlyMatching  bool     `short:"o" long:"only-matching" description:"Output only matching words"`
+	OutputOnlyLines     bool     `short:"l" long:"only-lines" description:"Output only matched lines without similarity scores"`
+	ByteOffset          bool     `short:"b" long:"byte-offset" description:"Print the byte offset in the input of each selected line, or of each token with -o"`
+	Column              bool     `long:"column" description:"Print the 1-based byte column of the first match in each line, or of each token with -o"`
+	Unit                string   `long:"unit" default:"line" choice:"line" choice:"sentence" description:"Unit of text matched and printed: line, or sentence to join sentences wrapped across lines"`
+	LineMode            string   `long:"line-mode" optional:"yes" optional-value:"mean" choice:"mean" choice:"sif" description:"Match whole lines by meaning: embed each line as the mean of its word vectors, or with sif weighting rare words up, and select the lines similar to the query"`
+	Segmenter           string   `long:"segmenter" default:"words" choice:"words" choice:"cjk" description:"How lines are split into tokens: words, or cjk to also join Chinese and Japanese characters into the longest words of the model"`
+	CJKMaxLength        int      `long:"cjk-max-length" default:"4" description:"Longest CJK word, in characters, tried by --segmenter=cjk"`
+	Normalize           string   `long:"normalize" default:"none" choice:"none" choice:"nfc" choice:"nfkc" choice:"nfd" choice:"nfkd" description:"Unicode normalization of the vocabulary, queries and input tokens, e.g. nfkc to match full-width characters"`
+	Stem                string   `long:"stem" optional:"yes" optional-value:"english" description:"Look up the stem of each word first, e.g. 'running' as 'run' (language: english)"`
+	Lang                string   `long:"lang" description:"Use the model configured for this language in the config file, e.g. 'fr', or 'auto' to detect the language from the first lines of input"`
+	DetectLines         int      `long:"detect-lines" default:"20" description:"Number of input lines read by --lang=auto"`
+	ScriptModels        []string `long:"script-model" description:"Look up words written in a Unicode script in another model, e.g. 'Han:models/cc.zh.300.bin' (repeatable)"`
+	Scorer              string   `long:"scorer" default:"cosine" description:"How token and query vectors are compared: cosine, dot, euclidean, soft-cosine, wmd or csls"`
+	ScorerOptions       string   `long:"scorer-options" description:"Options of the scorer as a JSON object, e.g. '{\"scale\": 0.1}' for dot"`
+	FastMath            bool     `long:"fast-math" description:"Compute cosine and dot scores in float32, faster on large inputs; scores may be off by up to about 1e-4"`
+	HighlightStyle      string   `long:"highlight-style" description:"Style of matched words, e.g. 'bold green' or 'underline' (default: red, or highlight_style from the config file)"`
+	Color               string   `long:"color" default:"auto" choice:"auto" choice:"always" choice:"never" description:"Color the output: auto colors only when writing to a terminal and NO_COLOR is not set"`
+	Heatmap             bool     `long:"heatmap" description:"Color every word of matched lines by its similarity to the query, from blue (unrelated) to red"`
+	JSON                bool     `long:"json" description:"Print each selected line as a JSON object with the byte and character offsets of its matches"`
+	Format              string   `long:"format" default:"text" description:"Output format: text, json like --json, tsv or csv for a row per match with the file, line, column, token, query, score and text, after a header row, or a Go template printed for each match, e.g. '{{.File}}:{{.Line}} {{.Token}}'"`
+	ShowScores          string   `long:"show-scores" default:"prefix" choice:"prefix" choice:"inline" choice:"none" description:"Show similarity scores on a line before each match (prefix), after each highlighted token (inline), or not at all (none)"`
+	PatternFile         string   `short:"f" long:"file" description:"File with patterns to match"`
+	QueryFrom           string   `long:"query-from" description:"Read the query from this file, or from standard input with '-'; a phrase or paragraph is matched by the mean of its word vectors"`
+	OnlySemantic        bool     `long:"only-semantic" description:"Do not count the query word itself as a match, only similar words"`
+	ExcludeExact        bool     `long:"exclude-exact" description:"Skip lines containing the query word itself, leaving lines that express it in other words"`
+	Fuzzy               int      `long:"fuzzy" description:"Also match tokens spelled within N edits of a query word, e.g. typos and words missing from the model, and tokens containing a query word unless -w"`
+	Prescore            bool     `long:"prescore" description:"Score every word of the model against the queries before searching, then match tokens by lookup; faster on large inputs"`
+	WholeWord           bool     `short:"w" long:"word-regexp" description:"Match by spelling only whole tokens: no token containing a query word with --fuzzy, and re: patterns only as whole words"`
+	InvertMatch         bool     `short:"v" long:"invert-match" description:"Select lines with no token similar to the query"`
+	Count               bool     `short:"c" long:"count" description:"Print only a count of selected lines per file"`
+	FilesWithMatches    bool     `long:"files-with-matches" description:"Print only names of files with selected lines"`
+	FilesWithoutMatch   bool     `short:"L" long:"files-without-match" description:"Print only names of files with no selected lines"`
+	MaxCount            int      `long:"max-count" description:"Stop reading a file after NUM selected lines"`
+	NullData            bool     `short:"z" long:"null-data" description:"Lines of input and output end with a NUL byte instead of a newline"`
+	Null                bool     `short:"Z" long:"null" description:"Print a NUL byte after file names instead of a colon or newline, e.g. for xargs -0"`
+	BinaryFiles         string   `long:"binary-files" choice:"binary" choice:"text" choice:"without-match" description:"How files containing a NUL byte are searched: binary to print 'Binary file ... matches' instead of their lines, text to search them as text, or without-match to skip them (default: binary, or without-match with -r)"`
+	Pre                 string   `long:"pre" description:"Convert files to text by their type: auto for every type, none to search files as they are, or a type, e.g. pdf, html or docx, for all files and standard input (default: documents such as PDF, DOCX and HTML, or every type with -r)"`
+	CodeComments        bool     `long:"code-comments" description:"In source files, recognized by their extension, match only comments and string literals, not the code"`
+	Label               string   `long:"label" description:"Name standard input NAME in the output, as if it were a file, e.g. to tell where matches piped in came from"`
+	Recursive           bool     `short:"r" long:"recursive" description:"Search the files in directories, and convert Markdown, source code, subtitles, JSON lines and PDF files to text by their type (see pipelines in the config file)"`
+	Include             []string `long:"include" description:"With -r, search only files whose name matches this glob, e.g. '*.md', or whose path does if it has a slash (repeatable)"`
+	Exclude             []string `long:"exclude" description:"With -r, skip files whose name matches this glob, e.g. '*.log', or whose path does if it has a slash (repeatable)"`
+	ExcludeDir          []string `long:"exclude-dir" description:"With -r, skip directories whose name matches this glob, e.g. 'node_modules', or whose path does if it has a slash (repeatable)"`
+	TopK                int      `long:"top-k" description:"Instead of using the threshold, print the N best scoring token matches of all files, best first"`
+	SortBySimilarity    bool     `long:"sort-by-similarity" description:"Print the matching lines of all files sorted by their best score, best first"`
+	Top                 int      `long:"top" description:"With --sort-by-similarity, print only the N best lines, holding only those in memory"`
+	TopKPerFile         bool     `long:"top-k-per-file" description:"With --top-k, print the best matches of each file separately"`
+	Explain             bool     `long:"explain" description:"When nothing matches, tell whether the query is in the model and which words of the input came closest, on standard error"`
+	Calibrate           bool     `long:"calibrate" description:"Instead of the matches, print how many lines and tokens of all files match at thresholds from 0.95 down to 0.05, with samples, to choose a threshold"`
+	Summary             bool     `long:"summary" description:"Instead of the matches, print each distinct matching token of all files with the number of lines it matches in and its average similarity, most frequent first"`
+	DedupeLines         bool     `long:"dedupe-lines" description:"Print each selected line only once, even when it occurs again, possibly with other whitespace, in later files"`
+	Adaptive            bool     `long:"adaptive" description:"Score only blocks of lines where a sample of the words comes close to the query; much faster on large inputs with few matches, but may miss some"`
+	BlockLines          int      `long:"block-lines" default:"32" description:"With --adaptive, number of lines of a block"`
+	SampleEvery         int      `long:"sample-every" default:"4" description:"With --adaptive, score one word in N of a block in the coarse pass"`
+	CoarseMargin        float64  `long:"coarse-margin" default:"0.1" description:"With --adaptive, how far below the threshold a sampled word may score for its block to be searched"`
+	Cache               bool     `long:"cache" description:"Answer searches already made of unchanged files from the result cache, without loading the model"`
+	NoCache             bool     `long:"no-cache" description:"Neither read nor write the result cache, even if enabled in the config file"`
+	CacheDir            string   `long:"cache-dir" description:"Directory of the result cache and of downloaded models (default: semantic-grep in the user cache directory, e.g. ~/.cache)"`
+	ReadOnly            bool     `long:"read-only" description:"Write no file, e.g. on a read-only filesystem: the result cache answers searches but stores none"`
+	NoDaemon            bool     `long:"no-daemon" description:"Load the model even when a daemon started with \"w2vgrep --daemon\" serves it"`
+	LineBuffered        bool     `long:"line-buffered" description:"Write each line as soon as it is found, even when the output is not a terminal, e.g. in tail -f | w2vgrep | ..."`
+	ShareModel          bool     `long:"share-model" description:"Unless a daemon serves the model, start one that stops once idle for a minute, so that searches running at once, e.g. with xargs -P, share one copy of the model"`
+	MaxLineLength       int      `long:"max-line-length" default:"64" description:"Longest line read, in MiB; longer lines stop the search of a file with an error"`
+	Jobs                int      `short:"j" long:"jobs" default:"1" description:"Search up to N files at once, printing the results of each file in order"`
+	Progress            bool     `long:"progress" description:"Show the progress of the file being searched on standard error, when a terminal: percent complete, lines read and lines per second"`
+	Stats               bool     `long:"stats" description:"At the end, print on standard error the files, lines and bytes searched, the tokens scored and how many came from the similarity cache, and the wall time"`
+	Quiet               bool     `short:"q" long:"quiet" description:"Print nothing; exit with status 0 on the first match, 1 otherwise. Warnings are not printed either, only errors"`
+	Verbose             bool     `long:"verbose" description:"Tell on standard error what is done, e.g. the configuration file and the model used"`
+	Debug               bool     `long:"debug" description:"Like --verbose, adding timings, e.g. of loading the model, and at the end the statistics of --stats"`
+	After               string   `long:"after" description:"Only search log lines timestamped at or after this time, e.g. '2024-05-01 10:00:00'"`
+	Before              string   `long:"before" description:"Only search log lines timestamped before this time"`
+	TimestampPattern    string   `long:"timestamp-pattern" description:"Regular expression extracting the timestamp of a line (first group, or whole match)"`
+	TimestampFormat     string   `long:"timestamp-format" description:"Go time layout of extracted timestamps, e.g. 'Jan _2 15:04:05'"`
+	ParityCheck         bool     `long:"parity-check" description:"Developer mode: compare exact semantic matches at threshold 1.0 with a literal whole-word matcher and report divergences"`
+	Cooccur             string   `long:"cooccur" description:"Report passages where QUERY co-occurs with this second concept"`
+	Window              int      `long:"window" default:"0" description:"Largest distance, in lines, between co-occurring concepts: 0 for the same line, 1 for adjacent lines (used with --cooccur)"`
+	Near                string   `long:"near" description:"Only match lines where two concepts occur within N words of each other, e.g. 'death,sea:10'"`
+	EmitGrepPattern     string   `long:"emit-grep-pattern" optional:"yes" optional-value:"regex" choice:"regex" choice:"list" description:"Print the query expanded to similar words as a grep -E regex or a grep -f word list, then exit"`
+	CPUProfile          string   `long:"cpuprofile" hidden:"yes" description:"Write a CPU profile of the search to this file, for go tool pprof"`
+	MemProfile          string   `long:"memprofile" hidden:"yes" description:"Write a profile of the memory in use at the end of the search to this file, for go tool pprof"`
 }
 
+// Exit statuses follow grep conventions.
+const (
+	exitMatch      = 0 // at least one line was selected
+	exitNoMatch    = 1 // no line was selected
+	exitError      = 2 // an error occurred
+	exitFileErrors = 3 // some files could not be searched, others were
+)
+
 // main is the entry point for the semantic-grep tool. It parses command-line
 // options, loads the Word2Vec model, and processes the input text file or
 // standard input for semantic matches.
 func main() {
+	if c, ok := programCommand(os.Args[0]); ok {
+		os.Exit(runCommand(daemonProgram, c, os.Args[1:]))
+	}
+	if len(os.Args) > 1 {
+		if c, ok := findCommand(os.Args[1]); ok {
+			os.Exit(runCommand("w2vgrep --"+c.name, c, os.Args[2:]))
+		}
+	}
+
 	var opts Options
 	var parser = flags.NewParser(&opts, flags.Default)
-	parser.Usage = "[OPTIONS] QUERY [FILE]"
+	parser.Usage = "[OPTIONS] QUERY [FILE...]"
 
 	args, err := parser.Parse()
 	if err != nil {
 		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
-			os.Exit(0)
+			exit(exitMatch)
 		} else {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			errorf("%v", err)
 			parser.WriteHelp(os.Stderr)
-			os.Exit(1)
+			exit(exitError)
+		}
+	}
+
+	switch {
+	case opts.Quiet && (opts.Verbose || opts.Debug):
+		errorf("--quiet cannot be combined with --verbose or --debug")
+		exit(exitError)
+	case opts.Debug:
+		verbosity = logDebug
+	case opts.Verbose:
+		verbosity = logVerbose
+	case opts.Quiet:
+		verbosity = logQuiet
+	}
+
+	if opts.CPUProfile != "" || opts.MemProfile != "" {
+		profiling, err = startProfiling(opts.CPUProfile, opts.MemProfile)
+		if err != nil {
+			errorf("%v", err)
+			os.Exit(exitError)
 		}
+		defer profiling.stop()
+	}
+
+	conf, err := loadConfig()
+	if err != nil {
+		errorf("%v", err)
+		exit(exitError)
+	}
+	applyConfig(parser, &opts, conf)
+
+	utils.SetColor(utils.ShouldColor(opts.Color))
+
+	if len(args) < 1 && opts.PatternFile == "" && opts.Near == "" && opts.QueryFrom == "" {
+		errorf("query or pattern file is required")
+		parser.WriteHelp(os.Stderr)
+		exit(exitError)
+	}
+
+	if opts.QueryFrom != "" && (opts.PatternFile != "" || opts.Near != "") {
+		errorf("--query-from cannot be combined with a pattern file or --near")
+		exit(exitError)
+	}
+
+	if opts.QueryFrom == "-" && len(args) == 0 {
+		errorf("--query-from - reads the query from standard input, so files to search are required")
+		exit(exitError)
 	}
 
-	if len(args) < 1 && opts.PatternFile == "" {
-		fmt.Fprintln(os.Stderr, "Error: query or pattern file is required")
+	if opts.Cooccur != "" && (opts.PatternFile != "" || opts.Near != "") {
+		errorf("--cooccur cannot be combined with a pattern file or --near")
 		parser.WriteHelp(os.Stderr)
-		os.Exit(1)
+		exit(exitError)
+	}
+
+	if opts.Cooccur != "" && opts.InvertMatch {
+		errorf("--invert-match cannot be combined with --cooccur")
+		exit(exitError)
+	}
+
+	// --format json is --json; tsv and csv print a table, and a template
+	// each match
+	table := ""
+	var formatTemplate *template.Template
+	switch {
+	case opts.Format == "text":
+	case opts.Format == "json":
+		opts.JSON = true
+	case opts.Format == "tsv" || opts.Format == "csv":
+		table = opts.Format
+	case strings.Contains(opts.Format, "{{"):
+		formatTemplate, err = processor.ParseTemplate(opts.Format)
+		if err != nil {
+			errorf("invalid --format template: %v", err)
+			exit(exitError)
+		}
+	default:
+		errorf("unknown --format %q, expected text, json, tsv, csv or a template such as '{{.File}}:{{.Line}} {{.Token}}'", opts.Format)
+		exit(exitError)
+	}
+	if (table != "" || formatTemplate != nil) && (opts.JSON || opts.Cooccur != "" || opts.ParityCheck || opts.OutputOnlyMatching ||
+		opts.Unit == "sentence" || opts.TopK > 0 || opts.SortBySimilarity || opts.Calibrate || opts.Summary) {
+		errorf("--format tsv, csv or a template cannot be combined with --json, --cooccur, --parity-check, -o, --unit sentence, --top-k, --sort-by-similarity, --calibrate or --summary")
+		exit(exitError)
+	}
+
+	if opts.JSON && (opts.Cooccur != "" || opts.ParityCheck || opts.OutputOnlyMatching) {
+		errorf("--json cannot be combined with --cooccur, --parity-check or --only-matching")
+		exit(exitError)
+	}
+
+	if opts.Unit == "sentence" && (opts.JSON || opts.ByteOffset || opts.Column || opts.Cooccur != "" ||
+		opts.ParityCheck || opts.After != "" || opts.Before != "") {
+		errorf("--unit sentence cannot be combined with --json, --byte-offset, --column, --cooccur, --parity-check or a time range")
+		exit(exitError)
+	}
+
+	if opts.LineMode != "" && (opts.TopK > 0 || opts.Near != "" || opts.Adaptive || opts.Cooccur != "" ||
+		opts.ParityCheck || opts.EmitGrepPattern != "") {
+		errorf("--line-mode cannot be combined with --top-k, --near, --adaptive, --cooccur, --parity-check or --emit-grep-pattern")
+		exit(exitError)
+	}
+
+	if opts.Fuzzy < 0 {
+		errorf("--fuzzy must not be negative")
+		exit(exitError)
+	}
+
+	if opts.ShareModel && (opts.NoDaemon || opts.Normalize != "none" || opts.ReadOnly) {
+		errorf("--share-model cannot be combined with --no-daemon, --normalize or --read-only")
+		exit(exitError)
+	}
+
+	if opts.Prescore && opts.LineMode != "" {
+		errorf("--prescore cannot be combined with --line-mode, which does not score tokens")
+		exit(exitError)
+	}
+
+	if opts.Fuzzy > 0 && (opts.LineMode != "" || opts.Adaptive) {
+		errorf("--fuzzy cannot be combined with --line-mode or --adaptive")
+		exit(exitError)
+	}
+
+	if opts.LineMode != "" && opts.Scorer != "cosine" {
+		errorf("--line-mode compares lines by cosine similarity and cannot be combined with another --scorer")
+		exit(exitError)
+	}
+
+	if opts.Lang != "" && opts.ModelPath != "" {
+		errorf("--lang cannot be combined with --model_path")
+		exit(exitError)
+	}
+
+	if opts.DetectLines < 1 {
+		errorf("--detect-lines must be at least 1")
+		exit(exitError)
+	}
+
+	if opts.TopK < 0 {
+		errorf("--top-k must not be negative")
+		exit(exitError)
+	}
+
+	if opts.SortBySimilarity && opts.TopK > 0 {
+		errorf("--sort-by-similarity cannot be combined with --top-k")
+		exit(exitError)
+	}
+
+	if opts.Top < 0 || (opts.Top > 0 && !opts.SortBySimilarity) {
+		errorf("--top requires --sort-by-similarity and a positive number of lines")
+		exit(exitError)
+	}
+
+	if (opts.TopK > 0 || opts.SortBySimilarity) && (opts.InvertMatch || opts.Count || opts.FilesWithMatches || opts.FilesWithoutMatch ||
+		opts.Quiet || opts.JSON || opts.Cooccur != "" || opts.ParityCheck || opts.Unit == "sentence") {
+		errorf("--top-k and --sort-by-similarity cannot be combined with -v, -c, -L, -q, --files-with-matches, --json, --cooccur, --parity-check or --unit sentence")
+		exit(exitError)
+	}
+
+	if opts.Calibrate && (opts.TopK > 0 || opts.SortBySimilarity || opts.InvertMatch || opts.Count || opts.FilesWithMatches ||
+		opts.FilesWithoutMatch || opts.Quiet || opts.JSON || opts.Cooccur != "" || opts.ParityCheck || opts.Unit == "sentence" ||
+		opts.Adaptive || opts.EmitGrepPattern != "") {
+		errorf("--calibrate cannot be combined with --top-k, --sort-by-similarity, -v, -c, -L, -q, --files-with-matches, --json, --cooccur, --parity-check, --unit sentence, --adaptive or --emit-grep-pattern")
+		exit(exitError)
+	}
+
+	if opts.Summary && (opts.TopK > 0 || opts.SortBySimilarity || opts.Calibrate || opts.InvertMatch || opts.Count ||
+		opts.FilesWithMatches || opts.FilesWithoutMatch || opts.Quiet || opts.JSON || opts.Cooccur != "" || opts.ParityCheck ||
+		opts.Unit == "sentence" || opts.DedupeLines || opts.Jobs > 1 || opts.EmitGrepPattern != "") {
+		errorf("--summary cannot be combined with --top-k, --sort-by-similarity, --calibrate, -v, -c, -L, -q, --files-with-matches, --json, --cooccur, --parity-check, --unit sentence, --dedupe-lines, --jobs or --emit-grep-pattern")
+		exit(exitError)
+	}
+
+	if opts.Explain && (opts.TopK > 0 || opts.Calibrate || opts.InvertMatch || opts.Cooccur != "" || opts.ParityCheck ||
+		opts.EmitGrepPattern != "") {
+		errorf("--explain cannot be combined with --top-k, --calibrate, -v, --cooccur, --parity-check or --emit-grep-pattern")
+		exit(exitError)
+	}
+
+	// Binary files are skipped by -r unless asked otherwise
+	if opts.BinaryFiles == "" {
+		opts.BinaryFiles = "binary"
+		if opts.Recursive {
+			opts.BinaryFiles = "without-match"
+		}
+	}
+
+	if (len(opts.Include) > 0 || len(opts.Exclude) > 0 || len(opts.ExcludeDir) > 0) && !opts.Recursive {
+		errorf("--include, --exclude and --exclude-dir require -r")
+		exit(exitError)
+	}
+
+	if opts.Jobs < 1 {
+		errorf("--jobs must be at least 1")
+		exit(exitError)
+	}
+
+	if opts.Jobs > 1 && (opts.TopK > 0 || opts.SortBySimilarity || opts.Calibrate || opts.DedupeLines || opts.Explain || opts.Progress) {
+		errorf("--jobs cannot be combined with --top-k, --sort-by-similarity, --calibrate, --dedupe-lines, --explain or --progress")
+		exit(exitError)
+	}
+
+	if opts.TopKPerFile && opts.TopK == 0 {
+		errorf("--top-k-per-file requires --top-k")
+		exit(exitError)
+	}
+
+	if opts.Adaptive && (opts.TopK > 0 || opts.Cooccur != "" || opts.ParityCheck || opts.Unit == "sentence") {
+		errorf("--adaptive cannot be combined with --top-k, --cooccur, --parity-check or --unit sentence")
+		exit(exitError)
+	}
+
+	if opts.BlockLines < 1 || opts.SampleEvery < 1 || opts.CoarseMargin < 0 {
+		errorf("--block-lines and --sample-every must be at least 1, and --coarse-margin must not be negative")
+		exit(exitError)
+	}
+
+	if opts.MaxLineLength < 1 {
+		errorf("--max-line-length must be at least 1 MiB")
+		exit(exitError)
+	}
+
+	if opts.MaxCount < 0 {
+		errorf("--max-count must not be negative")
+		exit(exitError)
+	}
+
+	if opts.Window < 0 {
+		errorf("--window must not be negative")
+		exit(exitError)
+	}
+
+	for name, lines := range map[string]int{
+		"--before-context": opts.ContextBefore,
+		"--after-context":  opts.ContextAfter,
+		"--context":        opts.ContextBoth,
+	} {
+		if err := processor.ValidateContext(lines); err != nil {
+			errorf("%s: %v", name, err)
+			exit(exitError)
+		}
 	}
 
 	if opts.ContextBoth > 0 {
@@ -61,11 +406,12 @@ func main() {
 	}
 
 	var patterns []string
+	var regexes []*regexp.Regexp
 	if opts.PatternFile != "" {
-		file, err := os.Open(opts.PatternFile)
+		file, err := os.Open(utils.ExpandPath(opts.PatternFile))
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error opening pattern file: %v\n", err)
-			os.Exit(1)
+			errorf("opening pattern file: %v", err)
+			exit(exitError)
 		}
 		defer file.Close()
 
@@ -74,66 +420,891 @@ func main() {
 			patterns = append(patterns, scanner.Text())
 		}
 		if err := scanner.Err(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error reading pattern file: %v\n", err)
-			os.Exit(1)
+			errorf("reading pattern file: %v", err)
+			exit(exitError)
+		}
+
+		// Patterns starting with "re:" are regular expressions
+		patterns, regexes, err = processor.SplitPatterns(patterns, opts.IgnoreCase, opts.WholeWord)
+		if err != nil {
+			errorf("pattern file: %v", err)
+			exit(exitError)
+		}
+		if len(regexes) > 0 && (opts.Adaptive || opts.LineMode != "") {
+			errorf("re: patterns cannot be combined with --adaptive or --line-mode")
+			exit(exitError)
+		}
+		if len(regexes) > 0 && (opts.ParityCheck || opts.EmitGrepPattern != "") {
+			errorf("regular expression patterns cannot be combined with --parity-check or --emit-grep-pattern")
+			exit(exitError)
 		}
 	}
 
-	query := ""
-	if len(args) > 0 {
-		query = args[0]
+	var queryText string
+	if opts.QueryFrom != "" {
+		var content []byte
+		if opts.QueryFrom == "-" {
+			content, err = io.ReadAll(os.Stdin)
+		} else {
+			content, err = os.ReadFile(utils.ExpandPath(opts.QueryFrom))
+		}
+		if err != nil {
+			errorf("reading query: %v", err)
+			exit(exitError)
+		}
+		// Line breaks of a paragraph are just spaces between its words
+		queryText = strings.Join(strings.Fields(string(content)), " ")
+		if queryText == "" {
+			errorf("the query read with --query-from is empty")
+			exit(exitError)
+		}
 	}
 
-	var input *os.File
-	if len(args) > 1 {
-		input, err = os.Open(args[1])
+	var near *processor.Proximity
+	if opts.Near != "" {
+		near, err = processor.ParseProximity(opts.Near)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error opening file: %v\n", err)
-			os.Exit(1)
+			errorf("%v", err)
+			exit(exitError)
 		}
-		defer input.Close()
-	} else {
-		input = os.Stdin
 	}
 
-	configPath := config.FindConfigFile()
-	if configPath != "" {
-		conf, err := config.LoadConfig(configPath)
+	var timeRange *processor.TimeRange
+	if opts.After != "" || opts.Before != "" {
+		timeRange, err = processor.NewTimeRange(opts.After, opts.Before, opts.TimestampPattern, opts.TimestampFormat)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading config from %s: %v\n", configPath, err)
-			os.Exit(1)
+			errorf("%v", err)
+			exit(exitError)
 		}
-		fmt.Fprintf(os.Stderr, "Using configuration file: %s\n", configPath)
+	}
 
-		if opts.ModelPath == "" {
-			opts.ModelPath = conf.ModelPath
+	var stem stemmer.Stemmer
+	if opts.Stem != "" {
+		stem, err = stemmer.New(opts.Stem)
+		if err != nil {
+			errorf("%v", err)
+			exit(exitError)
 		}
 	}
 
-	if opts.ModelPath == "" {
-		fmt.Fprintln(os.Stderr, "Error: Model path is required. Please provide it via config file or -m/--model_path flag.")
-		parser.WriteHelp(os.Stderr)
-		os.Exit(1)
+	scorer, err := similarity.NewScorer(opts.Scorer, json.RawMessage(opts.ScorerOptions))
+	if err != nil {
+		errorf("%v", err)
+		exit(exitError)
+	}
+	if opts.FastMath {
+		fast, ok := similarity.FastMath(scorer)
+		if !ok {
+			errorf("--fast-math only applies to the cosine and dot scorers, not %s", opts.Scorer)
+			exit(exitError)
+		}
+		scorer = fast
+	}
+
+	normalize, err := utils.Normalizer(opts.Normalize)
+	if err != nil {
+		errorf("%v", err)
+		exit(exitError)
+	}
+
+	// As with grep -f, all positional arguments are files when patterns come
+	// from a file, or when --near or --query-from supplies the query
+	queries := patterns
+	files := args
+	if opts.PatternFile == "" {
+		if near != nil {
+			queries = []string{near.ConceptA, near.ConceptB}
+		} else if queryText != "" {
+			queries = []string{queryText}
+		} else {
+			queries = []string{args[0]}
+			files = args[1:]
+		}
+	}
+	// A malformed weighted expression, such as "+:0.5", is an error rather
+	// than a query missing from the model
+	for _, q := range queries {
+		if _, _, err := query.Parse(q); err != nil {
+			errorf("%v", err)
+			exit(exitError)
+		}
+	}
+
+	// Expand wildcards the shell left alone, as the Windows command prompt does
+	var expanded []string
+	for _, fileName := range files {
+		expanded = append(expanded, utils.ExpandGlob(fileName)...)
+	}
+	files = expanded
+
+	// Like grep -r, search the working directory when no file is given
+	var fileErrs fileErrors
+	if opts.Recursive {
+		if len(files) == 0 {
+			files = []string{"."}
+		}
+		filter := walker.Filter{Include: opts.Include, Exclude: opts.Exclude, ExcludeDirs: opts.ExcludeDir}
+		if err := filter.Validate(); err != nil {
+			errorf("%v", err)
+			exit(exitError)
+		}
+		expanded = nil
+		for _, fileName := range files {
+			found, errs := walker.Walk(fileName, filter)
+			expanded = append(expanded, found...)
+			for _, err := range errs {
+				fileErrs.add(fileName, err)
+			}
+		}
+		files = expanded
+	}
+
+	// Files are converted to text by their type, see --pre
+	router, err := pipeline.NewRouter(conf.Pipelines)
+	if err != nil {
+		errorf("%v", err)
+		exit(exitError)
+	}
+	if opts.Pre != "" && opts.Pre != "auto" && opts.Pre != "none" && !router.Has(opts.Pre) {
+		errorf("unknown --pre type %q, expected auto, none or one of %s", opts.Pre, strings.Join(router.Types(), ", "))
+		exit(exitError)
+	}
+
+	if len(files) == 0 {
+		files = []string{"-"}
 	}
 
 	var w2vModel model.VectorModel
 	var similarityCache similarity.SimilarityCache
 
-	w2vModel, err = model.LoadVectorModel(opts.ModelPath)
+	if opts.HighlightStyle != "" {
+		if err := utils.ValidateStyle(opts.HighlightStyle); err != nil {
+			errorf("invalid highlight style: %v", err)
+			exit(exitError)
+		}
+	}
+
+	// Lines read from standard input to detect its language are replayed
+	var stdin io.Reader
+	modelPath := opts.ModelPath
+	if opts.Lang != "" {
+		modelPath, stdin, err = languageModelPath(opts.Lang, files, opts.DetectLines, conf)
+		if err != nil {
+			errorf("%v", err)
+			exit(exitError)
+		}
+	}
+
+	// Searches of unchanged files are answered from the result cache. Results
+	// that depend on other files, such as rankings, are not cached.
+	var results *resultCache
+	if opts.Cache && !opts.NoCache && opts.TopK == 0 && !opts.SortBySimilarity && !opts.Calibrate && !opts.Summary && !opts.Explain && !opts.DedupeLines &&
+		!opts.ParityCheck && opts.EmitGrepPattern == "" {
+		results, err = searchResultCache(opts, queries, regexes, conf, modelPath)
+		if err != nil {
+			warnf("result cache disabled: %v", err)
+		}
+	}
+
+	// With the result cache, the model is only loaded once a file has to be searched
+	loadSearchModel := func() {
+		// A daemon serving the model spares loading it, unless the
+		// vocabulary must be normalized, which the daemon does not do
+		w2vModel = nil
+		if !opts.NoDaemon && normalize == nil {
+			w2vModel = daemonModel(modelPath, conf)
+			if w2vModel == nil && opts.ShareModel {
+				if err := shareModel(modelPath, conf); err != nil {
+					warnf("model not shared: %v", err)
+				}
+				w2vModel = daemonModel(modelPath, conf)
+			}
+			if w2vModel != nil {
+				verbosef("Using the model served by the daemon: %s", configuredModelPath(modelPath, conf))
+			}
+		}
+		if w2vModel == nil {
+			start := time.Now()
+			w2vModel, err = loadConfiguredModel(modelPath, conf)
+			if err != nil {
+				errorf("%v", err)
+				if errors.Is(err, errNoModelPath) {
+					parser.WriteHelp(os.Stderr)
+				}
+				exit(exitError)
+			}
+			verbosef("Loaded model %s", configuredModelPath(modelPath, conf))
+			debugf("Model loaded in %v", time.Since(start).Round(time.Millisecond))
+		}
+		if len(opts.ScriptModels) > 0 {
+			w2vModel, err = routeScripts(w2vModel, opts.ScriptModels)
+			if err != nil {
+				errorf("%v", err)
+				exit(exitError)
+			}
+		}
+		if normalize != nil {
+			w2vModel = model.Normalized(w2vModel, normalize)
+		}
+		if opts.IgnoreCase {
+			w2vModel = model.CaseFolded(w2vModel, opts.CaseFold == "mean")
+		}
+		if v, ok := scorer.(similarity.VocabularyScorer); ok {
+			var vectors []interface{}
+			for _, word := range model.FrequentWords(w2vModel, v.VocabularySize()) {
+				vector, _ := w2vModel.GetEmbedding(word)
+				vectors = append(vectors, vector)
+			}
+			v.SetVocabulary(vectors)
+		}
+		similarityCache = similarity.NewScorerCache(scorer)
+	}
+	if results == nil {
+		loadSearchModel()
+	}
+
+	if opts.EmitGrepPattern != "" {
+		stdout = newStdout(opts.LineBuffered, '\n')
+		err := emitGrepPattern(stdout, queries, w2vModel, opts)
+		if flushErr := stdout.Flush(); err == nil {
+			err = flushErr
+		}
+		if err != nil && !stdout.closed() {
+			errorf("%v", err)
+			exit(exitError)
+		}
+		return
+	}
+
+	var bands []processor.FrequencyBand
+	for _, band := range conf.FrequencyThresholds {
+		bands = append(bands, processor.FrequencyBand{MaxRank: band.MaxRank, Threshold: band.Threshold})
+	}
+
+	procOpts := processor.Options{
+		SimilarityThreshold: opts.SimilarityThreshold,
+		FrequencyBands:      bands,
+		Regexes:             regexes,
+		ContextBefore:       opts.ContextBefore,
+		ContextAfter:        opts.ContextAfter,
+		Window:              opts.Window,
+		PrintLineNumbers:    opts.PrintLineNumbers,
+		IgnoreCase:          opts.IgnoreCase,
+		OutputOnlyMatching:  opts.OutputOnlyMatching,
+		OutputOnlyLines:     opts.OutputOnlyLines,
+		ShowScores:          opts.ShowScores,
+		JSON:                opts.JSON,
+		Table:               table,
+		Template:            formatTemplate,
+		Heatmap:             opts.Heatmap,
+		HighlightStyle:      opts.HighlightStyle,
+		Scorer:              scorer,
+		Stemmer:             stem,
+		Normalize:           normalize,
+		Segmenter:           opts.Segmenter,
+		CJKMaxLength:        opts.CJKMaxLength,
+		ByteOffset:          opts.ByteOffset,
+		Column:              opts.Column,
+		InvertMatch:         opts.InvertMatch,
+		OnlySemantic:        opts.OnlySemantic,
+		ExcludeExact:        opts.ExcludeExact,
+		Fuzzy:               opts.Fuzzy,
+		WholeWord:           opts.WholeWord,
+		LineMode:            opts.LineMode,
+		Near:                near,
+		TimeRange:           timeRange,
+		CountOnly:           opts.Count || opts.FilesWithMatches || opts.FilesWithoutMatch || opts.Quiet,
+		MaxCount:            opts.MaxCount,
+		MaxLineLength:       opts.MaxLineLength << 20,
+		NullData:            opts.NullData,
+		NullAfterName:       opts.Null,
+	}
+
+	if opts.DedupeLines {
+		procOpts.Dedupe = processor.NewDeduper()
+	}
+
+	if opts.Explain {
+		procOpts.Explain = processor.NewExplanation()
+	}
+
+	if opts.Prescore {
+		procOpts.Prescore = processor.NewPrescored()
+	}
+
+	if opts.Adaptive {
+		procOpts.Adaptive = &processor.AdaptiveScan{
+			BlockLines:      opts.BlockLines,
+			SampleEvery:     opts.SampleEvery,
+			CoarseThreshold: opts.SimilarityThreshold - opts.CoarseMargin,
+		}
+	}
+
+	// One selected line is enough to decide whether a file is listed
+	if opts.FilesWithMatches || opts.FilesWithoutMatch || opts.Quiet {
+		procOpts.MaxCount = 1
+	}
+
+	interrupt := catchInterrupts()
+	procOpts.Done = interrupt.done
+
+	// With -Z, file names are followed by a NUL byte instead of a colon or newline
+	nameSep, nameEnd := ":", "\n"
+	if opts.Null {
+		nameSep, nameEnd = "\x00", "\x00"
+	}
+
+	hadError := false
+	var top *processor.TopMatches
+	if opts.TopK > 0 {
+		top = processor.NewTopMatches(opts.TopK, false)
+	} else if opts.SortBySimilarity {
+		top = processor.NewTopMatches(opts.Top, true)
+	}
+	var calibration *processor.Calibration
+	if opts.Calibrate {
+		calibration = processor.NewCalibration(opts.SimilarityThreshold)
+	}
+	var summary *processor.Summary
+	if opts.Summary {
+		summary = processor.NewSummary(opts.IgnoreCase)
+	}
+	printTop := func() {
+		if err := processor.PrintTopMatches(top.Sorted(), top.Lines, procOpts); err != nil {
+			errorf("%v", err)
+			hadError = true
+		}
+	}
+
+	// reportFile prints what -l, -L and -c print for the file name with
+	// count selected lines, and reports whether -q has found a match. Like
+	// grep, files are only named when more than one is searched; JSON
+	// records, table rows and templates always carry the name, and so does
+	// standard input labeled with --label.
+	nameFiles := len(files) > 1 || opts.JSON || table != "" || formatTemplate != nil || opts.Recursive || opts.Label != ""
+	reportFile := func(name string, count int) bool {
+		switch {
+		case opts.Quiet:
+			return count > 0
+		case opts.FilesWithMatches:
+			if count > 0 {
+				fmt.Fprint(stdout, name+nameEnd)
+			}
+		case opts.FilesWithoutMatch:
+			if count == 0 {
+				fmt.Fprint(stdout, name+nameEnd)
+			}
+		case opts.Count:
+			if nameFiles {
+				fmt.Fprintf(stdout, "%s%s%d\n", name, nameSep, count)
+			} else {
+				fmt.Fprintln(stdout, count)
+			}
+		}
+		return false
+	}
+
+	// Lines end with a NUL byte with -z
+	eol := byte('\n')
+	if opts.NullData {
+		eol = 0
+	}
+	meter := newScanMeter(opts.Progress, eol)
+	logOutput = meter.output(os.Stderr)
+	stdout = newStdout(opts.LineBuffered, eol)
+	procOpts.Output = stdout
+	procOpts.Warnings = warnings()
+
+	// With --jobs, files are searched concurrently, and the model loaded by
+	// the first that needs it
+	var modelMu sync.Mutex
+	searchModel := func() (model.VectorModel, similarity.SimilarityCache) {
+		modelMu.Lock()
+		defer modelMu.Unlock()
+		if w2vModel == nil {
+			loadSearchModel()
+		}
+		return w2vModel, similarityCache
+	}
+
+	// search searches the i-th file, printing its results, or keeping them
+	// in the output of the outcome with --jobs
+	search := func(i int) fileSearch {
+		fileName := files[i]
+		if interrupt.caught() != nil {
+			return fileSearch{name: fileName}
+		}
+
+		var input *os.File
+		var err error
+		if fileName == "-" {
+			input = os.Stdin
+			fileName = "(standard input)"
+			if opts.Label != "" {
+				fileName = opts.Label
+			}
+		} else {
+			input, err = os.Open(fileName)
+			if err != nil {
+				return fileSearch{name: fileName, err: err}
+			}
+		}
+		var reader io.Reader = input
+		if input == os.Stdin && stdin != nil {
+			reader = stdin
+		}
+
+		procOpts := procOpts
+		procOpts.FileName = ""
+		if nameFiles {
+			procOpts.FileName = fileName
+		}
+		outcome := fileSearch{name: fileName}
+		var fileOutput io.Writer = stdout
+		if opts.Jobs > 1 {
+			outcome.output = new(bytes.Buffer)
+			fileOutput = outcome.output
+		}
+
+		// Look the search up in the result cache by the content of the file.
+		// Only regular files are hashed: a pipe or FIFO read to the end
+		// cannot be read again to search it.
+		var cacheKey string
+		if results != nil && input != os.Stdin && isRegular(input) {
+			cacheKey, err = results.key(input, procOpts.FileName)
+			if _, seekErr := input.Seek(0, io.SeekStart); err != nil || seekErr != nil {
+				cacheKey = ""
+			}
+		}
+		if cacheKey != "" {
+			if count, output, ok := results.load(cacheKey); ok {
+				input.Close()
+				fileOutput.Write(output)
+				meter.cached()
+				debugf("%s answered from the result cache", fileName)
+				outcome.count, outcome.searched, outcome.clean = count, true, true
+				return outcome
+			}
+		}
+		w2vModel, similarityCache := searchModel()
+
+		// Files are converted to text by their type: with -r or --pre=auto
+		// every type, otherwise only documents, such as PDF. Files with a
+		// NUL byte in their first bytes are binary, unless NUL ends lines.
+		// Only the bytes of the first read are sniffed: waiting for more
+		// would hold up the lines of a pipe, as from tail -f, until then
+		buffered := bufio.NewReader(reader)
+		buffered.Peek(1)
+		head, _ := buffered.Peek(min(pipeline.SniffSize, buffered.Buffered()))
+		isBinary := !opts.NullData && bytes.IndexByte(head, 0) >= 0
+		fileType := ""
+		switch opts.Pre {
+		case "none":
+		case "", "auto":
+			if input != os.Stdin {
+				fileType = router.Detect(fileName, head)
+				isBinary = fileType == pipeline.Binary && !opts.NullData
+				if opts.Pre == "" && !opts.Recursive && !router.IsDocument(fileType) {
+					fileType = ""
+				}
+			}
+		default:
+			fileType, isBinary = opts.Pre, false
+		}
+		// With --code-comments, only the comments and string literals of
+		// source files are searched
+		var closePipeline func() error
+		if syntax := pipeline.CommentSyntax(fileName); opts.CodeComments && syntax != nil && !isBinary {
+			reader, closePipeline = pipeline.Extract("comments", syntax.Comments, buffered)
+		} else {
+			reader, closePipeline, err = router.Open(fileType, buffered)
+			if err != nil {
+				input.Close()
+				outcome.err = err
+				return outcome
+			}
+		}
+
+		// The lines of binary files are not printed. With --binary-files=binary,
+		// a message tells that the file matches instead, and counts and names
+		// are printed as for text; where a message cannot stand for its lines,
+		// as in rankings, the file is skipped.
+		skipBinary, binaryMatches := false, false
+		if isBinary {
+			switch opts.BinaryFiles {
+			case "without-match":
+				skipBinary = true
+			case "binary":
+				listing := opts.Count || opts.FilesWithMatches || opts.FilesWithoutMatch || opts.Quiet
+				ranked := opts.JSON || table != "" || formatTemplate != nil || top != nil || calibration != nil || summary != nil || opts.Cooccur != "" || opts.ParityCheck
+				skipBinary = !listing && ranked
+				binaryMatches = !listing && !ranked
+			}
+		}
+		if skipBinary {
+			closePipeline()
+			if input != os.Stdin {
+				input.Close()
+			}
+			outcome.clean = true
+			return outcome
+		}
+
+		reader = meter.begin(input, fileName, reader)
+
+		// The output of a search to cache is also kept
+		var captured bytes.Buffer
+		output := meter.output(fileOutput)
+		if cacheKey != "" {
+			output = io.MultiWriter(output, &captured)
+		}
+		procOpts.Output = output
+		if binaryMatches {
+			procOpts.Output, procOpts.MaxCount = io.Discard, 1
+		}
+
+		var count int
+		if opts.ParityCheck {
+			count, err = processor.ParityCheck(queries, w2vModel, reader, procOpts)
+		} else if opts.Cooccur != "" {
+			count, err = processor.ProcessCooccurrence(queries[0], opts.Cooccur, w2vModel, reader, procOpts)
+		} else if calibration != nil {
+			count, err = processor.CollectCalibration(queries, w2vModel, similarityCache, reader, calibration, procOpts)
+		} else if summary != nil {
+			count, err = processor.CollectSummary(queries, w2vModel, similarityCache, reader, summary, procOpts)
+		} else if top != nil {
+			count, err = processor.CollectTopMatches(queries, w2vModel, similarityCache, reader, top, procOpts)
+		} else if opts.Unit == "sentence" {
+			count, err = processor.ProcessSentences(queries, w2vModel, similarityCache, reader, procOpts)
+		} else {
+			count, err = processor.ProcessLineByLine(queries, w2vModel, similarityCache, reader, procOpts)
+		}
+		if pipelineErr := closePipeline(); err == nil {
+			err = pipelineErr
+		}
+		if binaryMatches && count > 0 {
+			fmt.Fprintf(output, "Binary file %s matches\n", fileName)
+		}
+		if input != os.Stdin {
+			input.Close()
+		}
+		meter.erase()
+		if err == nil {
+			outcome.clean = true
+			if cacheKey != "" && interrupt.caught() == nil {
+				results.store(cacheKey, count, captured.Bytes())
+			}
+		}
+		outcome.count, outcome.err, outcome.searched = count, err, true
+		return outcome
+	}
+
+	// Tables start with a header row naming their columns
+	if table != "" && !opts.Count && !opts.FilesWithMatches && !opts.FilesWithoutMatch && !opts.Quiet {
+		if err := processor.PrintTableHeader(stdout, table); err != nil {
+			errorf("%v", err)
+			exit(exitError)
+		}
+	}
+
+	divergences := 0
+	selected := 0
+	searched := 0
+	searchedCleanly := false
+	searchInOrder(len(files), opts.Jobs, search, func(outcome fileSearch) {
+		// Once the reader of the output is gone, as head is once it has its
+		// lines, there is nothing left to do
+		if stdout.closed() {
+			exit(exitMatch)
+		}
+		if outcome.err != nil {
+			fileErrs.add(outcome.name, outcome.err)
+		}
+		searchedCleanly = searchedCleanly || outcome.clean
+		if !outcome.searched {
+			return
+		}
+		if outcome.output != nil {
+			stdout.Write(outcome.output.Bytes())
+		}
+		if opts.ParityCheck {
+			divergences += outcome.count
+		}
+		selected += outcome.count
+		searched++
+		if top != nil && opts.TopKPerFile {
+			printTop()
+		}
+
+		if reportFile(outcome.name, outcome.count) {
+			exit(exitMatch)
+		}
+	})
+
+	if top != nil && !opts.TopKPerFile {
+		printTop()
+	}
+	if calibration != nil {
+		if err := calibration.Print(stdout); err != nil {
+			errorf("%v", err)
+			hadError = true
+		}
+	}
+	if summary != nil {
+		if err := summary.Print(stdout, len(queries)); err != nil {
+			errorf("%v", err)
+			hadError = true
+		}
+	}
+
+	// The results go out before the messages about them on stderr, which
+	// may be the same terminal or file
+	if err := stdout.Flush(); stdout.closed() {
+		exit(exitMatch)
+	} else if err != nil {
+		errorf("%v", err)
+		hadError = true
+	}
+
+	if procOpts.Dedupe != nil && procOpts.Dedupe.Suppressed() > 0 {
+		infof("%d duplicate line(s) suppressed", procOpts.Dedupe.Suppressed())
+	}
+
+	// Errors of single files did not stop the search, and are summed up last
+	if fileErrs.count() > 0 && !(opts.Quiet && selected > 0) {
+		fileErrs.report(logWriter(logQuiet), len(files))
+	}
+
+	if opts.ParityCheck {
+		infof("Parity check: %d divergent line(s)", divergences)
+	}
+
+	// With --explain, a search that selected nothing tells why
+	if procOpts.Explain != nil && selected == 0 && w2vModel != nil && interrupt.caught() == nil {
+		if err := procOpts.Explain.Print(logWriter(logNormal), queries, w2vModel, procOpts); err != nil {
+			hadError = true
+		}
+	}
+
+	if opts.Stats || verbosity >= logDebug {
+		meter.printStats(similarityCache)
+	}
+
+	if sig := interrupt.caught(); sig != nil {
+		infof("Interrupted by %v: %d line(s) selected in %d of %d file(s) searched",
+			sig, selected, searched, len(files))
+		exit(interrupt.exitStatus())
+	}
+
+	if opts.ParityCheck {
+		if divergences > 0 {
+			exit(exitNoMatch)
+		}
+		return
+	}
+
+	switch {
+	case opts.Quiet && selected > 0:
+	case hadError || fileErrs.count() > 0 && !searchedCleanly:
+		exit(exitError)
+	case fileErrs.count() > 0:
+		exit(exitFileErrors)
+	case selected == 0:
+		exit(exitNoMatch)
+	}
+}
+
+// applyConfig sets the options that were not given on the command line to
+// their values from the configuration file, if any.
+func applyConfig(parser *flags.Parser, opts *Options, conf *config.Config) {
+	onCommandLine := func(longName string) bool {
+		option := parser.FindOptionByLongName(longName)
+		return option != nil && option.IsSet() && !option.IsSetDefault()
+	}
+
+	if conf.Threshold != nil && !onCommandLine("threshold") {
+		opts.SimilarityThreshold = *conf.Threshold
+	}
+	// -C overrides -A and -B, so context settings only apply as a whole
+	if !onCommandLine("before-context") && !onCommandLine("after-context") && !onCommandLine("context") {
+		if conf.ContextBefore != nil {
+			opts.ContextBefore = *conf.ContextBefore
+		}
+		if conf.ContextAfter != nil {
+			opts.ContextAfter = *conf.ContextAfter
+		}
+		if conf.Context != nil {
+			opts.ContextBoth = *conf.Context
+		}
+	}
+	if conf.LineNumbers != nil && !onCommandLine("line-number") {
+		opts.PrintLineNumbers = *conf.LineNumbers
+	}
+	if conf.IgnoreCase != nil && !onCommandLine("ignore-case") {
+		opts.IgnoreCase = *conf.IgnoreCase
+	}
+	if conf.CJKMaxLength != nil && !onCommandLine("cjk-max-length") {
+		opts.CJKMaxLength = *conf.CJKMaxLength
+	}
+	if conf.Cache != nil && !onCommandLine("cache") {
+		opts.Cache = *conf.Cache
+	}
+	if conf.FastMath != nil && !onCommandLine("fast-math") {
+		opts.FastMath = *conf.FastMath
+	}
+	if conf.ReadOnly != nil && !onCommandLine("read-only") {
+		opts.ReadOnly = *conf.ReadOnly
+	}
+	if conf.Daemon != nil && !onCommandLine("no-daemon") {
+		opts.NoDaemon = !*conf.Daemon
+	}
+
+	for longName, setting := range map[string]struct {
+		option *string
+		value  string
+	}{
+		"highlight-style": {&opts.HighlightStyle, conf.HighlightStyle},
+		"color":           {&opts.Color, conf.Color},
+		"show-scores":     {&opts.ShowScores, conf.ShowScores},
+		"segmenter":       {&opts.Segmenter, conf.Segmenter},
+		"normalize":       {&opts.Normalize, conf.Normalize},
+		"case-fold":       {&opts.CaseFold, conf.CaseFold},
+		"stem":            {&opts.Stem, conf.Stem},
+		"scorer":          {&opts.Scorer, conf.Scorer},
+		"scorer-options":  {&opts.ScorerOptions, string(conf.ScorerOptions)},
+		"cache-dir":       {&opts.CacheDir, conf.CacheDir},
+	} {
+		if setting.value != "" && !onCommandLine(longName) {
+			*setting.option = setting.value
+		}
+	}
+
+	if len(conf.ScriptModels) > 0 && !onCommandLine("script-model") {
+		opts.ScriptModels = nil
+		for script, path := range conf.ScriptModels {
+			opts.ScriptModels = append(opts.ScriptModels, script+":"+path)
+		}
+		sort.Strings(opts.ScriptModels)
+	}
+}
+
+// errNoModelPath is returned by loadModel when no model is configured.
+var errNoModelPath = errors.New("Model path is required. Please provide it via config file or -m/--model_path flag.")
+
+// loadConfig loads the configuration file, if one is found. Without a
+// configuration file, the configuration is empty.
+func loadConfig() (*config.Config, error) {
+	configPath := config.FindConfigFile()
+	if configPath == "" {
+		return &config.Config{}, nil
+	}
+
+	conf, err := config.LoadConfig(configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading full model: %v\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("loading config from %s: %v", configPath, err)
 	}
-	similarityCache = similarity.NewSimilarityCache()
+	verbosef("Using configuration file: %s", configPath)
+	return conf, nil
+}
 
-	if opts.PatternFile != "" {
-		patterns = append(patterns, query)
-		processor.ProcessLineByLine(patterns, w2vModel, similarityCache, opts.SimilarityThreshold,
-			opts.ContextBefore, opts.ContextAfter, input, opts.PrintLineNumbers, opts.IgnoreCase,
-			opts.OutputOnlyMatching, opts.OutputOnlyLines)
-	} else {
-		processor.ProcessLineByLine([]string{query}, w2vModel, similarityCache, opts.SimilarityThreshold,
-			opts.ContextBefore, opts.ContextAfter, input, opts.PrintLineNumbers, opts.IgnoreCase,
-			opts.OutputOnlyMatching, opts.OutputOnlyLines)
+// loadModel loads the model at modelPath. When modelPath is empty, the path
+// from the configuration file is used.
+func loadModel(modelPath string) (model.VectorModel, error) {
+	conf, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return loadConfiguredModel(modelPath, conf)
+}
+
+// loadConfiguredModel loads the model at modelPath, or at the path from
+// conf when modelPath is empty.
+func loadConfiguredModel(modelPath string, conf *config.Config) (model.VectorModel, error) {
+	modelPath = configuredModelPath(modelPath, conf)
+	if modelPath == "" {
+		return nil, errNoModelPath
+	}
+
+	w2vModel, err := model.LoadVectorModel(modelPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading full model: %v", err)
+	}
+	return w2vModel, nil
+}
+
+// configuredModelPath returns modelPath, expanded, or the path from conf
+// when modelPath is empty.
+func configuredModelPath(modelPath string, conf *config.Config) string {
+	if modelPath == "" {
+		return conf.ModelPath
+	}
+	return utils.ExpandPath(modelPath)
+}
+
+// routeScripts combines defaultModel with the models of --script-model
+// specs of the form SCRIPT:PATH, so that each token is looked up in the
+// model for the script it is written in.
+func routeScripts(defaultModel model.VectorModel, specs []string) (model.VectorModel, error) {
+	router := model.NewScriptRouter(defaultModel)
+	for _, spec := range specs {
+		script, path, found := strings.Cut(spec, ":")
+		if !found || script == "" || path == "" {
+			return nil, fmt.Errorf("invalid script model %q: expected SCRIPT:PATH", spec)
+		}
+
+		scriptModel, err := model.LoadVectorModel(utils.ExpandPath(path))
+		if err != nil {
+			return nil, fmt.Errorf("loading %s model: %v", script, err)
+		}
+		if err := router.Add(script, scriptModel); err != nil {
+			return nil, err
+		}
+	}
+	return router, nil
+}
+
+// emitGrepPattern writes the queries and their neighbors above the
+// threshold to w in a form plain grep understands: an extended regular
+// expression matching any of the words, or a word list for grep -w -F -f.
+func emitGrepPattern(w io.Writer, queries []string, w2vModel model.VectorModel, opts Options) error {
+	var words []string
+	seen := make(map[string]bool)
+	add := func(word string) {
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+
+	for _, query := range queries {
+		if opts.IgnoreCase {
+			query = strings.ToLower(query)
+		}
+		add(query)
+
+		neighbors, err := model.Neighbors(w2vModel, query, opts.SimilarityThreshold)
+		if err != nil {
+			warnf("%v", err)
+			continue
+		}
+		for _, neighbor := range neighbors {
+			add(neighbor.Word)
+		}
+	}
+
+	if opts.EmitGrepPattern == "list" {
+		for _, word := range words {
+			if _, err := fmt.Fprintln(w, word); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// regexp.QuoteMeta escapes a superset of the ERE metacharacters
+	for i, word := range words {
+		words[i] = regexp.QuoteMeta(word)
 	}
+	_, err := fmt.Fprintf(w, "\\b(%s)\\b\n", strings.Join(words, "|"))
+	return err
 }